@@ -0,0 +1,199 @@
+package todo
+
+import (
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"regexp"
+	"time"
+
+	"golang.org/x/crypto/bcrypt"
+)
+
+// UserStorage stores the accounts that can log in to the web server.
+type UserStorage interface {
+	// AddUser adds a user. The first user to be added takes ownership of the
+	// tasks and lists that were created before there were any users.
+	AddUser(*User) (UserId, error)
+	GetUser(UserId) (*User, error)
+	// GetUserByName finds a user by their username, ignoring case.
+	GetUserByName(string) (*User, error)
+	AddSession(*Session) error
+	GetSession(tokenHash string) (*Session, error)
+	DeleteSession(tokenHash string) error
+	AddAPIToken(*APIToken) (APITokenId, error)
+	// GetAPITokens returns a user's API tokens, oldest first.
+	GetAPITokens(UserId) ([]APIToken, error)
+	GetAPIToken(tokenHash string) (*APIToken, error)
+	// DeleteAPIToken deletes one of a user's API tokens, returning
+	// ErrNotFound if they have no token with that id.
+	DeleteAPIToken(UserId, APITokenId) error
+}
+
+type UserId int64
+
+// User is someone who can log in to the web server, they only see their own
+// tasks and lists.
+type User struct {
+	Id           UserId    `json:"id"`
+	Username     string    `json:"username" validate:"required,min=3,max=32,username"`
+	PasswordHash []byte    `json:"-"`
+	CreatedAt    time.Time `json:"created_at"`
+}
+
+var usernamePattern = regexp.MustCompile(`^[A-Za-z0-9._-]+$`)
+
+func (u *User) Validate() error {
+	return validateStruct(u)
+}
+
+// Session is a login, identified by a token given to the client. Only a hash
+// of the token is stored so that the stored sessions can't be used to log
+// in.
+type Session struct {
+	TokenHash string
+	UserId    UserId
+	ExpiresAt time.Time
+}
+
+// SessionDuration is how long a login lasts.
+const SessionDuration = 30 * 24 * time.Hour
+
+// ErrUnauthorized is returned when a request doesn't come from a logged in
+// user.
+var ErrUnauthorized = errors.New("unauthorized")
+
+var (
+	ErrUsernameTaken  = fmt.Errorf("%w: the username is taken", ErrConflict)
+	ErrInvalidLogin   = fmt.Errorf("%w: the username or password is incorrect", ErrUnauthorized)
+	ErrInvalidSession = fmt.Errorf("%w: the session has expired or been logged out", ErrUnauthorized)
+)
+
+// Accounts registers users and logs them in and out.
+type Accounts struct {
+	storage UserStorage
+	now     func() time.Time
+}
+
+func CreateAccounts(storage UserStorage) *Accounts {
+	return &Accounts{storage: storage, now: time.Now}
+}
+
+// SetClock replaces the function used to get the current time, for deciding
+// when sessions expire.
+func (a *Accounts) SetClock(now func() time.Time) {
+	a.now = now
+}
+
+// Register adds a user with a password, which is stored as a bcrypt hash.
+func (a *Accounts) Register(username, password string) (User, error) {
+	user := User{Username: username, CreatedAt: a.now()}
+	err := user.Validate()
+	if err != nil {
+		return User{}, err
+	}
+	// bcrypt ignores anything after 72 bytes.
+	if len(password) < 8 || len(password) > 72 {
+		return User{}, fieldError("password", "must be between 8 and 72 characters")
+	}
+
+	user.PasswordHash, err = bcrypt.GenerateFromPassword([]byte(password), bcrypt.DefaultCost)
+	if err != nil {
+		return User{}, err
+	}
+	user.Id, err = a.storage.AddUser(&user)
+	if err != nil {
+		return User{}, err
+	}
+	return user, nil
+}
+
+// missingUserHash is compared against when logging in as a user that doesn't
+// exist, so that it takes as long as a wrong password.
+var missingUserHash, _ = bcrypt.GenerateFromPassword([]byte("missing user"), bcrypt.DefaultCost)
+
+// Login checks a user's password and starts a session, returning the token
+// that identifies it.
+func (a *Accounts) Login(username, password string) (string, Session, error) {
+	user, err := a.storage.GetUserByName(username)
+	if errors.Is(err, ErrNotFound) {
+		bcrypt.CompareHashAndPassword(missingUserHash, []byte(password))
+		return "", Session{}, ErrInvalidLogin
+	}
+	if err != nil {
+		return "", Session{}, err
+	}
+	if bcrypt.CompareHashAndPassword(user.PasswordHash, []byte(password)) != nil {
+		return "", Session{}, ErrInvalidLogin
+	}
+
+	token, err := newToken()
+	if err != nil {
+		return "", Session{}, err
+	}
+	session := Session{TokenHash: hashToken(token), UserId: user.Id, ExpiresAt: a.now().Add(SessionDuration)}
+	err = a.storage.AddSession(&session)
+	if err != nil {
+		return "", Session{}, err
+	}
+	return token, session, nil
+}
+
+// Logout ends the session identified by token.
+func (a *Accounts) Logout(token string) error {
+	err := a.storage.DeleteSession(hashToken(token))
+	if errors.Is(err, ErrNotFound) {
+		return ErrInvalidSession
+	}
+	return err
+}
+
+// Authenticate returns the user whose session is identified by token.
+func (a *Accounts) Authenticate(token string) (User, error) {
+	session, err := a.storage.GetSession(hashToken(token))
+	if errors.Is(err, ErrNotFound) {
+		return User{}, ErrInvalidSession
+	}
+	if err != nil {
+		return User{}, err
+	}
+	if !a.now().Before(session.ExpiresAt) {
+		a.storage.DeleteSession(session.TokenHash)
+		return User{}, ErrInvalidSession
+	}
+
+	user, err := a.storage.GetUser(session.UserId)
+	if errors.Is(err, ErrNotFound) {
+		return User{}, ErrInvalidSession
+	}
+	if err != nil {
+		return User{}, err
+	}
+	return *user, nil
+}
+
+// GetUserByName finds a user by their username, ignoring case.
+func (a *Accounts) GetUserByName(username string) (User, error) {
+	user, err := a.storage.GetUserByName(username)
+	if err != nil {
+		return User{}, err
+	}
+	return *user, nil
+}
+
+func newToken() (string, error) {
+	b := make([]byte, 32)
+	_, err := rand.Read(b)
+	if err != nil {
+		return "", err
+	}
+	return base64.RawURLEncoding.EncodeToString(b), nil
+}
+
+func hashToken(token string) string {
+	sum := sha256.Sum256([]byte(token))
+	return hex.EncodeToString(sum[:])
+}
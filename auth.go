@@ -0,0 +1,190 @@
+package todo
+
+import (
+	"context"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/go-chi/chi/v5"
+)
+
+type contextKey int
+
+const (
+	userKey contextKey = iota
+	apiTokenKey
+)
+
+// authenticate only lets requests with the token of a session or an API
+// token through, given as "Authorization: Bearer <token>". API tokens need
+// the read scope to GET and the write scope for anything else.
+func (p *TaskServer) authenticate(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		token, found := bearerToken(r)
+		if !found {
+			writeError(w, r, ErrUnauthorized)
+			return
+		}
+
+		if !strings.HasPrefix(token, APITokenPrefix) {
+			user, err := p.accounts.Authenticate(token)
+			if err != nil {
+				writeError(w, r, err)
+				return
+			}
+			next.ServeHTTP(w, r.WithContext(context.WithValue(r.Context(), userKey, user)))
+			return
+		}
+
+		user, apiToken, err := p.accounts.AuthenticateAPIToken(token)
+		if err != nil {
+			writeError(w, r, err)
+			return
+		}
+		scope := ScopeWrite
+		if r.Method == http.MethodGet || r.Method == http.MethodHead {
+			scope = ScopeRead
+		}
+		if !apiToken.HasScope(scope) {
+			writeError(w, r, errMissingScope(scope))
+			return
+		}
+		ctx := context.WithValue(r.Context(), userKey, user)
+		next.ServeHTTP(w, r.WithContext(context.WithValue(ctx, apiTokenKey, apiToken)))
+	})
+}
+
+// requireSession stops requests authenticated with an API token rather than
+// by logging in.
+func requireSession(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if _, ok := r.Context().Value(apiTokenKey).(APIToken); ok {
+			writeError(w, r, ErrSessionRequired)
+			return
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+func bearerToken(r *http.Request) (string, bool) {
+	scheme, token, found := strings.Cut(r.Header.Get("Authorization"), " ")
+	if !found || !strings.EqualFold(scheme, "Bearer") || token == "" {
+		return "", false
+	}
+	return token, true
+}
+
+// requestUser returns the user who made an authenticated request.
+func requestUser(r *http.Request) User {
+	user, _ := r.Context().Value(userKey).(User)
+	return user
+}
+
+// tasks returns the task list of the user who made a request.
+func (p *TaskServer) tasks(r *http.Request) *TaskList {
+	return p.taskList.ForUser(requestUser(r).Id)
+}
+
+type credentials struct {
+	Username string `json:"username"`
+	Password string `json:"password"`
+}
+
+func (p *TaskServer) registerHandler(w http.ResponseWriter, r *http.Request) {
+	var c credentials
+	if !decodeJSON(w, r, &c) {
+		return
+	}
+
+	user, err := p.accounts.Register(c.Username, c.Password)
+	if err != nil {
+		writeError(w, r, err)
+		return
+	}
+	w.WriteHeader(http.StatusCreated)
+	writeJSON(w, user)
+}
+
+func (p *TaskServer) currentUserHandler(w http.ResponseWriter, r *http.Request) {
+	writeJSON(w, requestUser(r))
+}
+
+// loginHandler starts a session, returning the token to authorize requests
+// with.
+func (p *TaskServer) loginHandler(w http.ResponseWriter, r *http.Request) {
+	var c credentials
+	if !decodeJSON(w, r, &c) {
+		return
+	}
+
+	token, session, err := p.accounts.Login(c.Username, c.Password)
+	if err != nil {
+		writeError(w, r, err)
+		return
+	}
+	writeJSON(w, struct {
+		Token     string    `json:"token"`
+		ExpiresAt time.Time `json:"expires_at"`
+	}{token, session.ExpiresAt})
+}
+
+// logoutHandler ends the session whose token authorized the request.
+func (p *TaskServer) logoutHandler(w http.ResponseWriter, r *http.Request) {
+	token, _ := bearerToken(r)
+	err := p.accounts.Logout(token)
+	if err != nil {
+		writeError(w, r, err)
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func (p *TaskServer) apiTokensHandler(w http.ResponseWriter, r *http.Request) {
+	tokens, err := p.accounts.GetAPITokens(requestUser(r).Id)
+	if err != nil {
+		writeError(w, r, err)
+		return
+	}
+	writeJSON(w, tokens)
+}
+
+// newAPITokenHandler creates an API token. The response is the only time
+// the token itself is shown.
+func (p *TaskServer) newAPITokenHandler(w http.ResponseWriter, r *http.Request) {
+	var body struct {
+		Name   string  `json:"name"`
+		Scopes []Scope `json:"scopes"`
+	}
+	if !decodeJSON(w, r, &body) {
+		return
+	}
+
+	token, apiToken, err := p.accounts.CreateAPIToken(requestUser(r).Id, body.Name, body.Scopes)
+	if err != nil {
+		writeError(w, r, err)
+		return
+	}
+	w.WriteHeader(http.StatusCreated)
+	writeJSON(w, struct {
+		APIToken
+		Token string `json:"token"`
+	}{apiToken, token})
+}
+
+func (p *TaskServer) revokeAPITokenHandler(w http.ResponseWriter, r *http.Request) {
+	idParam := chi.URLParam(r, "tokenID")
+	id, err := strconv.ParseInt(idParam, 10, 64)
+	if err != nil {
+		writeProblem(w, newProblem(r, http.StatusNotFound, "API token "+idParam+": "+ErrNotFound.Error()))
+		return
+	}
+
+	err = p.accounts.RevokeAPIToken(requestUser(r).Id, APITokenId(id))
+	if err != nil {
+		writeError(w, r, err)
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
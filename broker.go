@@ -0,0 +1,60 @@
+package todo
+
+import (
+	"sync"
+)
+
+// subscriberBuffer is how many events a subscriber can fall behind by before
+// it starts missing them.
+const subscriberBuffer = 64
+
+// Broker passes the events recorded by task lists on to subscribers as they
+// happen, such as clients streaming /events. It only knows about changes
+// made in this process.
+type Broker struct {
+	mu          sync.Mutex
+	subscribers map[chan Event]bool
+}
+
+func NewBroker() *Broker {
+	return &Broker{subscribers: map[chan Event]bool{}}
+}
+
+// Subscribe returns a channel of the events published from now on, and a
+// function to call once they are no longer wanted. Subscribers that fall
+// behind miss events rather than holding up the change being made, so
+// should catch up from storage using the id of the last event they saw.
+func (b *Broker) Subscribe() (<-chan Event, func()) {
+	events := make(chan Event, subscriberBuffer)
+	b.mu.Lock()
+	b.subscribers[events] = true
+	b.mu.Unlock()
+
+	return events, func() {
+		b.mu.Lock()
+		defer b.mu.Unlock()
+		if b.subscribers[events] {
+			delete(b.subscribers, events)
+			close(events)
+		}
+	}
+}
+
+// Publish sends an event to every subscriber that has room for it.
+func (b *Broker) Publish(event Event) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	for events := range b.subscribers {
+		select {
+		case events <- event:
+		default:
+		}
+	}
+}
+
+// Subscribe returns a channel of the events recorded by this task list and
+// every other one for the same storage, whether or not the user can see
+// them. See Broker.Subscribe.
+func (t *TaskList) Subscribe() (<-chan Event, func()) {
+	return t.broker.Subscribe()
+}
@@ -0,0 +1,53 @@
+package main
+
+import (
+	"fmt"
+
+	todo "github.com/rosswf/go-todo"
+)
+
+// historySize is the number of events shown in the history pane.
+const historySize = 5
+
+// loadHistory fetches the latest events of the selected task for the
+// history pane.
+func (m *model) loadHistory() {
+	m.events = nil
+	if !m.showHistory || len(m.tasks) == 0 {
+		return
+	}
+	page, err := m.taskStorage.GetHistory(m.tasks[m.cursor].Id, historySize, "")
+	if err != nil {
+		fmt.Println(err)
+		return
+	}
+	m.events = page.Events
+}
+
+func (m model) historyView() string {
+	if len(m.tasks) == 0 {
+		return ""
+	}
+	s := fmt.Sprintf("\nHistory of %s:\n", m.tasks[m.cursor].Name)
+	if len(m.events) == 0 {
+		s += colour("  No changes recorded", faint) + "\n"
+	}
+	for _, event := range m.events {
+		s += fmt.Sprintf("  %s %s\n", colour(event.At.Local().Format("Mon 2 Jan 15:04"), faint),
+			describeEvent(event))
+	}
+	return s
+}
+
+// describeEvent says what changed and, if it was made by a user rather than
+// from the CLI, who by.
+func describeEvent(event todo.Event) string {
+	s := string(event.Type)
+	if event.Type == todo.EventRenamed {
+		s = fmt.Sprintf("renamed from %q", event.Previous)
+	}
+	if event.Actor != "" {
+		s += " by " + event.Actor
+	}
+	return s
+}
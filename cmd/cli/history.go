@@ -0,0 +1,186 @@
+package main
+
+import (
+	todo "github.com/rosswf/go-todo"
+)
+
+// command is a change to the tasks that can be undone. Commands refer to
+// tasks by id, so undoing an add trashes the task and redoing it restores
+// the same task rather than adding a new one.
+type command interface {
+	do(tasks *todo.TaskList) error
+	undo(tasks *todo.TaskList) error
+}
+
+// history records the commands that have been run so they can be undone,
+// and those that have been undone so they can be redone.
+type history struct {
+	done   []command
+	undone []command
+}
+
+// run does a command and adds it to the history. Doing something new can't
+// be mixed with redoing what was undone before it, so that is forgotten.
+func (h *history) run(tasks *todo.TaskList, c command) error {
+	err := c.do(tasks)
+	if err != nil {
+		return err
+	}
+	h.done = append(h.done, c)
+	h.undone = nil
+	return nil
+}
+
+// undo reverses the last command that was done, if there is one.
+func (h *history) undo(tasks *todo.TaskList) error {
+	if len(h.done) == 0 {
+		return nil
+	}
+	c := h.done[len(h.done)-1]
+	err := c.undo(tasks)
+	if err != nil {
+		return err
+	}
+	h.done = h.done[:len(h.done)-1]
+	h.undone = append(h.undone, c)
+	return nil
+}
+
+// redo does the last command that was undone again, if there is one.
+func (h *history) redo(tasks *todo.TaskList) error {
+	if len(h.undone) == 0 {
+		return nil
+	}
+	c := h.undone[len(h.undone)-1]
+	err := c.do(tasks)
+	if err != nil {
+		return err
+	}
+	h.undone = h.undone[:len(h.undone)-1]
+	h.done = append(h.done, c)
+	return nil
+}
+
+type addCommand struct {
+	task todo.Task
+}
+
+func (c *addCommand) do(tasks *todo.TaskList) error {
+	if c.task.Id != 0 {
+		_, err := tasks.Restore(c.task.Id)
+		return err
+	}
+	_, err := tasks.AddTask(&c.task)
+	return err
+}
+
+// undo trashes the task along with any subtasks added to it since, which
+// redoing restores with it.
+func (c *addCommand) undo(tasks *todo.TaskList) error {
+	return tasks.DeleteWithSubtasks(&c.task)
+}
+
+// toggleCommand completes or reopens a task. Completing a recurring task
+// adds its next occurrence, which is trashed again when the completion is
+// reversed so that undoing and redoing doesn't leave extra occurrences.
+type toggleCommand struct {
+	id       todo.TaskId
+	complete bool
+	// next is the occurrence added by the last completion, if any.
+	next todo.TaskId
+}
+
+func (c *toggleCommand) do(tasks *todo.TaskList) error {
+	return c.setComplete(tasks, c.complete)
+}
+
+func (c *toggleCommand) undo(tasks *todo.TaskList) error {
+	return c.setComplete(tasks, !c.complete)
+}
+
+func (c *toggleCommand) setComplete(tasks *todo.TaskList, complete bool) error {
+	task, err := tasks.GetOne(c.id)
+	if err != nil {
+		return err
+	}
+	if !complete {
+		err = tasks.Reopen(&task)
+		if err != nil || c.next == 0 {
+			return err
+		}
+		next, err := tasks.GetOne(c.next)
+		if err != nil {
+			return err
+		}
+		c.next = 0
+		return tasks.DeleteWithSubtasks(&next)
+	}
+
+	before, err := tasks.GetAll()
+	if err != nil {
+		return err
+	}
+	err = tasks.Complete(&task)
+	if err != nil || task.Recurrence == "" || task.Complete {
+		return err
+	}
+	c.next, err = nextOccurrence(tasks, task, before)
+	return err
+}
+
+// nextOccurrence finds the occurrence of a recurring task that completing it
+// added, which is the task like it that wasn't among those before.
+func nextOccurrence(tasks *todo.TaskList, task todo.Task, before []todo.Task) (todo.TaskId, error) {
+	existed := map[todo.TaskId]bool{}
+	for _, t := range before {
+		existed[t.Id] = true
+	}
+	after, err := tasks.GetAll()
+	if err != nil {
+		return 0, err
+	}
+	for _, t := range after {
+		if !existed[t.Id] && t.Name == task.Name && t.Recurrence == task.Recurrence {
+			return t.Id, nil
+		}
+	}
+	return 0, nil
+}
+
+// deleteCommand moves a task and its subtasks to the trash.
+type deleteCommand struct {
+	task todo.Task
+}
+
+func (c *deleteCommand) do(tasks *todo.TaskList) error {
+	return tasks.DeleteWithSubtasks(&c.task)
+}
+
+func (c *deleteCommand) undo(tasks *todo.TaskList) error {
+	_, err := tasks.Restore(c.task.Id)
+	return err
+}
+
+// editCommand replaces a task with an edited copy, such as renaming it or
+// changing its priority. Whether the task is complete is left as it is,
+// since toggleCommand keeps track of that.
+type editCommand struct {
+	before, after todo.Task
+}
+
+func (c *editCommand) do(tasks *todo.TaskList) error {
+	return replaceTask(tasks, c.after)
+}
+
+func (c *editCommand) undo(tasks *todo.TaskList) error {
+	return replaceTask(tasks, c.before)
+}
+
+func replaceTask(tasks *todo.TaskList, task todo.Task) error {
+	current, err := tasks.GetOne(task.Id)
+	if err != nil {
+		return err
+	}
+	task.Complete, task.CompletedAt = current.Complete, current.CompletedAt
+	return tasks.Update(&task)
+}
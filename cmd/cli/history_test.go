@@ -0,0 +1,243 @@
+package main
+
+import (
+	"reflect"
+	"testing"
+	"time"
+
+	todo "github.com/rosswf/go-todo"
+	storage "github.com/rosswf/go-todo/storage"
+)
+
+func TestCommands(t *testing.T) {
+	now := time.Date(2022, 10, 1, 12, 0, 0, 0, time.UTC)
+
+	cases := []struct {
+		name string
+		// command sets up the tasks and returns the command to run, after
+		// which then, if any, changes them further.
+		command func(*todo.TaskList) command
+		then    func(*todo.TaskList)
+		// done and undone are the tasks after the command is done, or redone,
+		// and after it is undone.
+		done, undone []string
+	}{
+		{
+			name: "add",
+			command: func(*todo.TaskList) command {
+				return &addCommand{task: todo.Task{Name: "Task"}}
+			},
+			done:   []string{"Task"},
+			undone: []string{},
+		},
+		{
+			name: "add a task that has since had subtasks added",
+			command: func(*todo.TaskList) command {
+				return &addCommand{task: todo.Task{Name: "Parent"}}
+			},
+			then: func(taskList *todo.TaskList) {
+				taskList.AddTask(&todo.Task{Name: "Child", ParentId: 1})
+			},
+			done:   []string{"Parent", "Child"},
+			undone: []string{},
+		},
+		{
+			name: "complete",
+			command: func(taskList *todo.TaskList) command {
+				id, _ := taskList.Add("Task")
+				return &toggleCommand{id: id, complete: true}
+			},
+			done:   []string{"Task ✓"},
+			undone: []string{"Task"},
+		},
+		{
+			name: "reopen",
+			command: func(taskList *todo.TaskList) command {
+				taskList.AddTask(&todo.Task{Name: "Task", Complete: true})
+				return &toggleCommand{id: 1, complete: false}
+			},
+			done:   []string{"Task"},
+			undone: []string{"Task ✓"},
+		},
+		{
+			name: "delete with subtasks",
+			command: func(taskList *todo.TaskList) command {
+				taskList.Add("Parent")
+				taskList.AddTask(&todo.Task{Name: "Child", ParentId: 1})
+				taskList.Add("Other")
+				task, _ := taskList.GetOne(1)
+				return &deleteCommand{task: task}
+			},
+			done:   []string{"Other"},
+			undone: []string{"Parent", "Child", "Other"},
+		},
+		{
+			name: "edit",
+			command: func(taskList *todo.TaskList) command {
+				taskList.Add("Old name")
+				before, _ := taskList.GetOne(1)
+				after := before
+				after.Name = "New name"
+				return &editCommand{before: before, after: after}
+			},
+			done:   []string{"New name"},
+			undone: []string{"Old name"},
+		},
+	}
+
+	for _, c := range cases {
+		t.Run(c.name+" is done, undone and redone", func(t *testing.T) {
+			taskList := createTaskList(now)
+			h := history{}
+
+			AssertNoError(t, h.run(taskList, c.command(taskList)))
+			if c.then != nil {
+				c.then(taskList)
+			}
+			assertTasks(t, taskList, c.done)
+
+			AssertNoError(t, h.undo(taskList))
+			assertTasks(t, taskList, c.undone)
+
+			AssertNoError(t, h.redo(taskList))
+			assertTasks(t, taskList, c.done)
+		})
+	}
+}
+
+func TestToggleRecurringTask(t *testing.T) {
+	now := time.Date(2022, 10, 1, 12, 0, 0, 0, time.UTC)
+	taskList := createTaskList(now)
+	id, _ := taskList.AddTask(&todo.Task{Name: "Water plants", Recurrence: "freq=daily"})
+
+	h := history{}
+	AssertNoError(t, h.run(taskList, &toggleCommand{id: id, complete: true}))
+	assertOutstanding(t, taskList, 2)
+
+	t.Run("Undoing the completion trashes the next occurrence", func(t *testing.T) {
+		AssertNoError(t, h.undo(taskList))
+		assertOutstanding(t, taskList, id)
+	})
+
+	t.Run("Redoing the completion adds only one next occurrence", func(t *testing.T) {
+		AssertNoError(t, h.redo(taskList))
+		assertOutstanding(t, taskList, 3)
+		AssertNoError(t, h.undo(taskList))
+		AssertNoError(t, h.redo(taskList))
+		assertOutstanding(t, taskList, 4)
+	})
+
+	t.Run("Undoing a reopen that is redone leaves no occurrence behind", func(t *testing.T) {
+		AssertNoError(t, h.run(taskList, &toggleCommand{id: id, complete: false}))
+		assertOutstanding(t, taskList, id, 4)
+		AssertNoError(t, h.undo(taskList))
+		assertOutstanding(t, taskList, 5, 4)
+		AssertNoError(t, h.redo(taskList))
+		assertOutstanding(t, taskList, id, 4)
+	})
+}
+
+func createTaskList(now time.Time) *todo.TaskList {
+	taskList := todo.CreateTaskList(storage.CreateMemoryTaskStorage([]todo.Task{}))
+	taskList.SetClock(func() time.Time { return now })
+	return taskList
+}
+
+func TestMoveSelected(t *testing.T) {
+	now := time.Date(2022, 10, 1, 12, 0, 0, 0, time.UTC)
+	taskList := createTaskList(now)
+	for _, task := range []todo.Task{
+		{Name: "Low", Priority: todo.P2},
+		{Name: "First", Priority: todo.P1},
+		{Name: "Second", Priority: todo.P1},
+	} {
+		_, err := taskList.AddTask(&task)
+		AssertNoError(t, err)
+	}
+	m := initialModel(taskList)
+	names := func() []string {
+		got := []string{}
+		for _, task := range m.tasks {
+			got = append(got, task.Name)
+		}
+		return got
+	}
+
+	// The outstanding list is ranked by priority first.
+	m.cursor = 1
+	m.moveSelected(-1)
+	if want := []string{"Second", "First", "Low"}; !reflect.DeepEqual(names(), want) || m.cursor != 0 {
+		t.Errorf("got %q with the cursor at %d, want %q with it at 0", names(), m.cursor, want)
+	}
+	m.cursor = 2
+	m.moveSelected(-1)
+	if want := []string{"Second", "First", "Low"}; !reflect.DeepEqual(names(), want) || m.message == "" {
+		t.Errorf("got %q and message %q moving past another priority, want %q and a message",
+			names(), m.message, want)
+	}
+
+	// The full list is in the order the user arranged it.
+	m.toggle = true
+	m.message = ""
+	m.loadTasks()
+	m.cursor = 0
+	m.moveSelected(1)
+	if want := []string{"Second", "Low", "First"}; !reflect.DeepEqual(names(), want) || m.message != "" {
+		t.Errorf("got %q and message %q, want %q", names(), m.message, want)
+	}
+}
+
+func TestDescribeEvent(t *testing.T) {
+	cases := []struct {
+		event todo.Event
+		want  string
+	}{
+		{todo.Event{Type: todo.EventCompleted}, "completed"},
+		{todo.Event{Type: todo.EventRenamed, Previous: "Milk"}, `renamed from "Milk"`},
+		{todo.Event{Type: todo.EventCreated, ActorId: 2, Actor: "alice"}, "created by alice"},
+	}
+	for _, c := range cases {
+		if got := describeEvent(c.event); got != c.want {
+			t.Errorf("got %q, want %q", got, c.want)
+		}
+	}
+}
+
+// assertTasks checks the names of the tasks in order, with a tick after
+// those that are complete.
+func assertTasks(t testing.TB, taskList *todo.TaskList, want []string) {
+	t.Helper()
+	tasks, err := taskList.GetAll()
+	AssertNoError(t, err)
+	got := []string{}
+	for _, task := range tasks {
+		if task.Complete {
+			task.Name += " ✓"
+		}
+		got = append(got, task.Name)
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("got tasks %q, want %q", got, want)
+	}
+}
+
+// assertOutstanding checks the ids of the outstanding tasks, in order.
+func assertOutstanding(t testing.TB, taskList *todo.TaskList, want ...todo.TaskId) {
+	t.Helper()
+	tasks, err := taskList.GetOutstanding()
+	AssertNoError(t, err)
+	got := []todo.TaskId{}
+	for _, task := range tasks {
+		got = append(got, task.Id)
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("got outstanding tasks %v, want %v", got, want)
+	}
+}
+
+func AssertNoError(t testing.TB, err error) {
+	t.Helper()
+	if err != nil {
+		t.Fatal(err)
+	}
+}
@@ -3,6 +3,7 @@ package main
 import (
 	"fmt"
 	"os"
+	"strings"
 
 	tea "github.com/charmbracelet/bubbletea"
 	todo "github.com/rosswf/go-todo"
@@ -10,19 +11,165 @@ import (
 )
 
 type model struct {
+	// tasks are the visible tasks in tree order, depths gives how far each
+	// is nested.
 	tasks       []todo.Task
+	depths      []int
+	lists       []todo.List
 	taskStorage todo.TaskList
 	cursor      int
+	listCursor  int
 	taskInput   string
 	toggle      bool
+	renaming    bool
+	// renamed is the task being renamed, as it was when renaming started,
+	// so that the cursor moving doesn't change which task it is.
+	renamed todo.Task
+	// hasSubtasks marks the tasks shown with subtasks, collapsed those
+	// whose subtasks are hidden.
+	hasSubtasks map[todo.TaskId]bool
+	collapsed   map[todo.TaskId]bool
+	// searching replaces the task list with the results of searching for
+	// the input.
+	searching    bool
+	results      []todo.SearchResult
+	searchCursor int
+	history      history
+	// showHistory adds a pane with the events of the selected task.
+	showHistory bool
+	events      []todo.Event
+	// message says why the last key didn't do anything, until the next one.
+	message string
 }
 
 func initialModel(taskList *todo.TaskList) model {
-	tasks, _ := taskList.GetAll()
-	return model{
-		tasks:       tasks,
+	lists, _ := taskList.GetLists()
+	m := model{
+		lists:       lists,
 		taskStorage: *taskList,
+		collapsed:   map[todo.TaskId]bool{},
 	}
+	m.loadTasks()
+	return m
+}
+
+// loadTasks fetches the tasks in the current list and lays them out as a
+// tree, skipping the subtasks of collapsed tasks.
+func (m *model) loadTasks() {
+	var tasks []todo.Task
+	if m.toggle {
+		tasks, _ = m.taskStorage.GetListTasks(m.currentList().Id)
+	} else {
+		tasks, _ = m.taskStorage.GetOutstandingRanked(m.currentList().Id)
+	}
+
+	m.tasks = []todo.Task{}
+	m.depths = []int{}
+	m.hasSubtasks = map[todo.TaskId]bool{}
+	var walk func(nodes []todo.TaskNode, depth int)
+	walk = func(nodes []todo.TaskNode, depth int) {
+		for _, node := range nodes {
+			m.tasks = append(m.tasks, node.Task)
+			m.depths = append(m.depths, depth)
+			m.hasSubtasks[node.Id] = len(node.Subtasks) > 0
+			if !m.collapsed[node.Id] {
+				walk(node.Subtasks, depth+1)
+			}
+		}
+	}
+	walk(todo.BuildTree(tasks), 0)
+
+	if m.cursor > len(m.tasks)-1 {
+		m.cursor = 0
+	}
+}
+
+func (m model) currentList() todo.List {
+	if len(m.lists) == 0 {
+		return todo.List{Id: todo.DefaultListId}
+	}
+	return m.lists[m.listCursor]
+}
+
+// run does a command, keeping it in the history so it can be undone.
+func (m *model) run(c command) {
+	if err := m.history.run(&m.taskStorage, c); err != nil {
+		fmt.Println(err)
+	}
+}
+
+// undo reverses the last command in the history.
+func (m *model) undo() {
+	if err := m.history.undo(&m.taskStorage); err != nil {
+		fmt.Println(err)
+	}
+}
+
+// moveSelected moves the selected task past its previous sibling for a step
+// of -1 or its next sibling for 1, keeping it selected. The outstanding list
+// is ranked by priority and due date before position, so there it can only
+// move past tasks with the same priority and due date.
+func (m *model) moveSelected(step int) {
+	if len(m.tasks) == 0 {
+		return
+	}
+	selected := m.tasks[m.cursor]
+	depth := m.depths[m.cursor]
+
+	siblings := []todo.Task{}
+	index := 0
+	for i, task := range m.tasks {
+		if m.depths[i] != depth || depth > 0 && task.ParentId != selected.ParentId {
+			continue
+		}
+		if task.Id == selected.Id {
+			index = len(siblings)
+		}
+		siblings = append(siblings, task)
+	}
+
+	target := index + step
+	if target < 0 || target >= len(siblings) {
+		return
+	}
+	ranked := !m.toggle
+	if ranked && !sameRank(siblings[target], selected) {
+		m.message = "Only tasks with the same priority and due date can be reordered here, tab for the full list."
+		return
+	}
+	// The task on the far side is only a neighbour once the selected task
+	// has moved if they are ranked the same.
+	var before, after todo.TaskId
+	if step < 0 {
+		before = siblings[target].Id
+		if target > 0 && (!ranked || sameRank(siblings[target-1], selected)) {
+			after = siblings[target-1].Id
+		}
+	} else {
+		after = siblings[target].Id
+		if target < len(siblings)-1 && (!ranked || sameRank(siblings[target+1], selected)) {
+			before = siblings[target+1].Id
+		}
+	}
+	if err := m.taskStorage.Move(selected.Id, before, after); err != nil {
+		fmt.Println(err)
+	}
+
+	m.loadTasks()
+	for i, task := range m.tasks {
+		if task.Id == selected.Id {
+			m.cursor = i
+		}
+	}
+}
+
+// sameRank reports whether the outstanding list orders a and b by position,
+// as they have the same priority and due date.
+func sameRank(a, b todo.Task) bool {
+	if a.Priority != b.Priority || (a.Due == nil) != (b.Due == nil) {
+		return false
+	}
+	return a.Due == nil || a.Due.Equal(*b.Due)
 }
 
 func (m model) Init() tea.Cmd {
@@ -32,33 +179,160 @@ func (m model) Init() tea.Cmd {
 func (m model) Update(msg tea.Msg) (tea.Model, tea.Cmd) {
 	switch msg := msg.(type) {
 	case tea.KeyMsg:
+		m.message = ""
+		if m.searching && msg.String() != "ctrl+c" {
+			m = m.updateSearch(msg)
+			m.loadHistory()
+			return m, nil
+		}
+
 		switch msg.String() {
 		case "ctrl+c":
 			return m, tea.Quit
 
+		case "/":
+			if m.taskInput == "" && !m.renaming {
+				m.searching = true
+			} else {
+				m.taskInput += "/"
+			}
+
+		case "ctrl+f":
+			if !m.renaming {
+				m.searching = true
+				m.search()
+			}
+
+		case "u":
+			if m.taskInput == "" && !m.renaming {
+				m.undo()
+			} else {
+				m.taskInput += "u"
+			}
+
+		case "ctrl+z":
+			if !m.renaming {
+				m.undo()
+			}
+
+		case "ctrl+r", "ctrl+y":
+			if !m.renaming {
+				if err := m.history.redo(&m.taskStorage); err != nil {
+					fmt.Println(err)
+				}
+			}
+
 		case "enter":
+			if m.renaming {
+				task := m.renamed
+				task.Name = m.taskInput
+				m.run(&editCommand{before: m.renamed, after: task})
+				m.renaming = false
+				m.taskInput = ""
+			} else if m.taskInput != "" {
+				name, tags := todo.ParseTags(m.taskInput)
+				m.run(&addCommand{task: todo.Task{Name: name, ListId: m.currentList().Id, Tags: tags}})
+				m.taskInput = ""
+			}
+
+		case "ctrl+s":
+			if len(m.tasks) > 0 && m.taskInput != "" && !m.renaming {
+				name, tags := todo.ParseTags(m.taskInput)
+				parentId := m.tasks[m.cursor].Id
+				m.run(&addCommand{task: todo.Task{Name: name, ParentId: parentId, Tags: tags}})
+				m.taskInput = ""
+				delete(m.collapsed, parentId)
+			}
+
+		case "shift+up":
+			if !m.renaming {
+				m.moveSelected(-1)
+			}
+
+		case "shift+down":
+			if !m.renaming {
+				m.moveSelected(1)
+			}
+
+		case "shift+left":
+			if len(m.tasks) > 0 && m.hasSubtasks[m.tasks[m.cursor].Id] {
+				m.collapsed[m.tasks[m.cursor].Id] = true
+			}
+
+		case "shift+right":
+			if len(m.tasks) > 0 {
+				delete(m.collapsed, m.tasks[m.cursor].Id)
+			}
+
+		case "ctrl+p":
+			if len(m.tasks) > 0 && !m.renaming {
+				task := m.tasks[m.cursor]
+				task.Priority = task.Priority.Next()
+				m.run(&editCommand{before: m.tasks[m.cursor], after: task})
+			}
+
+		case "ctrl+d":
+			if len(m.tasks) > 0 && !m.renaming {
+				m.run(&deleteCommand{task: m.tasks[m.cursor]})
+			}
+
+		case "ctrl+l":
+			m.showHistory = !m.showHistory
+
+		case "ctrl+e":
+			if len(m.tasks) > 0 && !m.renaming {
+				m.renaming = true
+				m.renamed = m.tasks[m.cursor]
+				m.taskInput = m.renamed.Name
+			}
+
+		case "esc":
+			if m.renaming {
+				m.renaming = false
+				m.taskInput = ""
+			}
+
+		case "ctrl+n":
 			if m.taskInput != "" {
-				m.taskStorage.Add(m.taskInput)
+				if _, err := m.taskStorage.AddList(m.taskInput); err != nil {
+					fmt.Println(err)
+				}
 				m.taskInput = ""
+				m.lists, _ = m.taskStorage.GetLists()
+				m.listCursor = len(m.lists) - 1
+			}
+
+		case "pgup":
+			if m.listCursor > 0 && !m.renaming {
+				m.listCursor--
+				m.cursor = 0
+			}
+
+		case "pgdown":
+			if m.listCursor < len(m.lists)-1 && !m.renaming {
+				m.listCursor++
+				m.cursor = 0
 			}
 
 		case "up":
-			if m.cursor > 0 {
+			if m.cursor > 0 && !m.renaming {
 				m.cursor--
 			}
 
 		case "down":
-			if m.cursor < len(m.tasks)-1 {
+			if m.cursor < len(m.tasks)-1 && !m.renaming {
 				m.cursor++
 			}
 		case "left", "right":
-			if len(m.tasks) == 0 {
+			if len(m.tasks) == 0 || m.renaming {
 				break
 			}
-			if err := m.taskStorage.ToggleStatus(&m.tasks[m.cursor]); err != nil {
-				fmt.Println(err)
-			}
+			task := m.tasks[m.cursor]
+			m.run(&toggleCommand{id: task.Id, complete: !task.Complete})
 		case "tab":
+			if m.renaming {
+				break
+			}
 			if m.toggle {
 				m.toggle = false
 			} else {
@@ -70,25 +344,36 @@ func (m model) Update(msg tea.Msg) (tea.Model, tea.Cmd) {
 				m.taskInput = m.taskInput[:len(m.taskInput)-1]
 			}
 		default:
-			m.taskInput += msg.String()
+			if msg.Type == tea.KeyRunes || msg.Type == tea.KeySpace {
+				m.taskInput += msg.String()
+			}
 		}
 
 	}
-	if m.toggle {
-		m.tasks, _ = m.taskStorage.GetAll()
-	} else {
-		m.tasks, _ = m.taskStorage.GetOutstanding()
-	}
-	if m.cursor > len(m.tasks)-1 {
-		m.cursor = 0
-	}
+	m.loadTasks()
+	m.loadHistory()
 	return m, nil
 }
 
 func (m model) View() string {
+	if m.searching {
+		return m.searchView()
+	}
+
 	status := map[bool]string{true: "full", false: "outstanding"}
+	now := m.taskStorage.Now()
+
+	s := ""
+	for i, list := range m.lists {
+		if i == m.listCursor {
+			s += fmt.Sprintf("[%s] ", list.Name)
+		} else {
+			s += fmt.Sprintf(" %s  ", list.Name)
+		}
+	}
+	s += "\n\n"
 
-	s := fmt.Sprintf("Here is your task %s list:\n\n", status[m.toggle])
+	s += fmt.Sprintf("Here is your task %s list:\n\n", status[m.toggle])
 
 	if len(m.tasks) == 0 {
 		s += "Congratulations! You have no tasks!\n"
@@ -106,20 +391,107 @@ func (m model) View() string {
 				complete = "✓"
 			}
 
-			s += fmt.Sprintf("%s %s %s\n", cursor, complete, choice.Name)
+			expander := " "
+			if m.collapsed[choice.Id] && m.hasSubtasks[choice.Id] {
+				expander = "▸"
+			} else if m.hasSubtasks[choice.Id] {
+				expander = "▾"
+			}
+
+			indent := strings.Repeat("  ", m.depths[i])
+			line := fmt.Sprintf("%s %s%s %s %s", cursor, indent, expander, complete, choice.Name)
+			if choice.Priority != "" {
+				line += fmt.Sprintf(" [%s]", choice.Priority)
+			}
+			if choice.Due != nil {
+				line += fmt.Sprintf(" (due %s)", choice.Due.Local().Format("Mon 2 Jan 15:04"))
+			}
+			if rule, err := todo.ParseRecurrence(choice.Recurrence); err == nil {
+				line += " ↻ " + rule.Describe()
+			}
+			if choice.ReminderDue(now) {
+				line += " 🔔"
+			}
+			if choice.IsOverdue(now) {
+				line = colour(line, red)
+			} else if code, ok := priorityColours[choice.Priority]; ok {
+				line = colour(line, code)
+			}
+			for _, tag := range choice.Tags {
+				line += " " + colour("#"+tag, cyan)
+			}
+			s += line + "\n"
 		}
 	}
 
-	s += fmt.Sprintf("\nAdd a new task > %s█\n\n", m.taskInput)
-	s += `Navigation: ^ v. Mark Complete < >.
-Tab to toggle full list and outstanding.
+	if m.showHistory {
+		s += m.historyView()
+	}
+	if m.message != "" {
+		s += "\n" + colour(m.message, faint) + "\n"
+	}
+
+	if m.renaming {
+		s += fmt.Sprintf("\nRename task > %s█\n\n", m.taskInput)
+	} else {
+		s += fmt.Sprintf("\nAdd a new task > %s█\n\n", m.taskInput)
+	}
+	s += `Navigation: ^ v. Mark Complete < >. Reorder with shift+^ shift+v.
+Tab to toggle full list and outstanding, which is ordered by priority and due date.
+Switch list PgUp PgDn. ctrl+n to add the input as a new list.
+ctrl+e to rename the selected task, esc to cancel.
+Add #words to a new task to tag it.
+ctrl+s to add the input as a subtask of the selected task.
+Collapse and expand subtasks with shift+< shift+>.
+/ to search when the input is empty, or ctrl+f to search for the input. ctrl+p to change the selected task's priority.
+ctrl+d to delete the selected task and its subtasks.
+u to undo when the input is empty, or ctrl+z, ctrl+r to redo. ctrl+l to show the selected task's history.
 Press ctrl+c to quit.`
 
 	return s
 }
 
+const (
+	red     = "31"
+	yellow  = "33"
+	blue    = "34"
+	magenta = "35"
+	cyan    = "36"
+	faint   = "2"
+)
+
+// priorityColours are used for rows that aren't overdue, which are red.
+var priorityColours = map[todo.Priority]string{
+	todo.P0: magenta,
+	todo.P1: yellow,
+	todo.P2: blue,
+	todo.P3: faint,
+}
+
+// colour wraps s in an ANSI escape sequence for the given SGR colour code.
+func colour(s, code string) string {
+	return "\x1b[" + code + "m" + s + "\x1b[0m"
+}
+
 func main() {
-	storage, _ := storage.CreateSqlite3TaskStorage("tasks.db")
+	location := os.Getenv(storage.DatabaseEnv)
+	if location == "" {
+		location = storage.DefaultLocation
+	}
+	storage, err := storage.Open(location)
+	if err != nil {
+		fmt.Printf("Could not open task storage: %v", err)
+		os.Exit(1)
+	}
+
+	if len(os.Args) > 1 && os.Args[1] == "token" {
+		err := createToken(todo.CreateAccounts(storage), storage, os.Args[2:])
+		if err != nil {
+			fmt.Printf("Could not create token: %v\n", err)
+			os.Exit(1)
+		}
+		return
+	}
 
 	taskList := todo.CreateTaskList(storage)
 
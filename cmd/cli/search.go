@@ -0,0 +1,123 @@
+package main
+
+import (
+	"fmt"
+	"html"
+	"strings"
+
+	tea "github.com/charmbracelet/bubbletea"
+	todo "github.com/rosswf/go-todo"
+)
+
+// highlighter shows the matching words of search snippets in bold. Snippets
+// are HTML, so they are unescaped once the markers are replaced.
+var highlighter = strings.NewReplacer(todo.HighlightStart, "\x1b[1m", todo.HighlightEnd, "\x1b[22m")
+
+// updateSearch handles keys in search mode, where the input is the search
+// query and the results are refreshed as it is typed.
+func (m model) updateSearch(msg tea.KeyMsg) model {
+	switch msg.String() {
+	case "esc":
+		m.searching = false
+		m.taskInput = ""
+		m.results = nil
+		return m
+
+	case "enter":
+		if len(m.results) > 0 {
+			m.showTask(m.results[m.searchCursor].Task)
+		}
+		m.searching = false
+		m.taskInput = ""
+		m.results = nil
+		return m
+
+	case "up":
+		if m.searchCursor > 0 {
+			m.searchCursor--
+		}
+		return m
+
+	case "down":
+		if m.searchCursor < len(m.results)-1 {
+			m.searchCursor++
+		}
+		return m
+
+	case "backspace":
+		if m.taskInput != "" {
+			m.taskInput = m.taskInput[:len(m.taskInput)-1]
+		}
+
+	default:
+		if msg.Type == tea.KeyRunes || msg.Type == tea.KeySpace {
+			m.taskInput += msg.String()
+		}
+	}
+
+	m.search()
+	return m
+}
+
+// search refreshes the results for the input.
+func (m *model) search() {
+	m.results = nil
+	m.searchCursor = 0
+	if len(todo.SearchTerms(m.taskInput)) > 0 {
+		m.results, _ = m.taskStorage.Search(m.taskInput)
+	}
+}
+
+// showTask moves the cursor to a task, switching to its list and expanding
+// any collapsed tasks it is nested under.
+func (m *model) showTask(task todo.Task) {
+	for i, list := range m.lists {
+		if list.Id == task.ListId {
+			m.listCursor = i
+		}
+	}
+	if task.Complete {
+		m.toggle = true
+	}
+	for parentId := task.ParentId; parentId != 0; {
+		delete(m.collapsed, parentId)
+		parent, err := m.taskStorage.GetOne(parentId)
+		if err != nil {
+			break
+		}
+		parentId = parent.ParentId
+	}
+
+	m.loadTasks()
+	for i := range m.tasks {
+		if m.tasks[i].Id == task.Id {
+			m.cursor = i
+		}
+	}
+}
+
+func (m model) searchView() string {
+	s := "Search results:\n\n"
+
+	if len(m.results) == 0 {
+		s += "No matching tasks.\n"
+	}
+	for i, result := range m.results {
+		cursor := " "
+		if m.searchCursor == i {
+			cursor = ">"
+		}
+
+		complete := "✖"
+		if result.Complete {
+			complete = "✓"
+		}
+
+		s += fmt.Sprintf("%s %s %s\n", cursor, complete, html.UnescapeString(highlighter.Replace(result.Snippet)))
+	}
+
+	s += fmt.Sprintf("\nSearch > %s█\n\n", m.taskInput)
+	s += `Navigation: ^ v. Enter to go to the selected task, esc to cancel.`
+
+	return s
+}
@@ -0,0 +1,48 @@
+package main
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"strings"
+
+	todo "github.com/rosswf/go-todo"
+)
+
+// createToken mints an API token for a user straight from the database, for
+// setting up scripts without going through the web API:
+//
+//	cli token -user alice -name ci -scopes read,write
+func createToken(accounts *todo.Accounts, users todo.UserStorage, args []string) error {
+	flags := flag.NewFlagSet("token", flag.ContinueOnError)
+	username := flags.String("user", "", "the user the token acts as")
+	name := flags.String("name", "", "what the token is for")
+	scopes := flags.String("scopes", string(todo.ScopeRead), "comma separated scopes, read and/or write")
+	err := flags.Parse(args)
+	if errors.Is(err, flag.ErrHelp) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	if *username == "" {
+		return errors.New("-user is required")
+	}
+
+	user, err := users.GetUserByName(*username)
+	if err != nil {
+		return err
+	}
+	tokenScopes := []todo.Scope{}
+	for _, scope := range strings.Split(*scopes, ",") {
+		tokenScopes = append(tokenScopes, todo.Scope(scope))
+	}
+
+	token, apiToken, err := accounts.CreateAPIToken(user.Id, *name, tokenScopes)
+	if err != nil {
+		return err
+	}
+	fmt.Printf("Created API token %d for %s, it won't be shown again:\n%s\n",
+		apiToken.Id, user.Username, token)
+	return nil
+}
@@ -3,19 +3,42 @@ package main
 import (
 	"log"
 	"net/http"
+	"os"
+	"time"
 
 	"github.com/rosswf/go-todo"
 	storage "github.com/rosswf/go-todo/storage"
 )
 
 func main() {
-	storage, _ := storage.CreateSqlite3TaskStorage("tasks.db")
+	location := os.Getenv(storage.DatabaseEnv)
+	if location == "" {
+		location = storage.DefaultLocation
+	}
+	storage, err := storage.Open(location)
+	if err != nil {
+		log.Fatalf("could not open task storage %v", err)
+	}
 	taskList := todo.CreateTaskList(storage)
+	go purgeTrash(taskList)
 
-	server := todo.NewTaskServer(taskList)
+	server := todo.NewTaskServer(taskList, todo.CreateAccounts(storage))
 
 	log.Println("Listening on port 5000...")
 	if err := http.ListenAndServe(":5000", server); err != nil {
 		log.Fatalf("could not listen on port 5000 %v", err)
 	}
 }
+
+// purgeTrash periodically deletes tasks that have been in the trash for
+// longer than todo.TrashRetention.
+func purgeTrash(taskList *todo.TaskList) {
+	for ; ; time.Sleep(time.Hour) {
+		purged, err := taskList.Purge(todo.TrashRetention)
+		if err != nil {
+			log.Printf("could not purge trash %v", err)
+		} else if purged > 0 {
+			log.Printf("Purged %d tasks from the trash", purged)
+		}
+	}
+}
@@ -0,0 +1,378 @@
+package todo
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gorilla/websocket"
+)
+
+const (
+	// socketPingInterval is how often connections are pinged, and one that
+	// hasn't answered within socketPongWait is closed.
+	socketPingInterval = 30 * time.Second
+	socketPongWait     = 2 * socketPingInterval
+	socketWriteWait    = 10 * time.Second
+	socketMessageLimit = 4096
+	// socketProtocol is the WebSocket subprotocol spoken on /lists/{id}/socket.
+	// Browsers can't set the Authorization header when connecting, so they
+	// can ask for a "bearer.<token>" subprotocol alongside it instead.
+	socketProtocol     = "todo"
+	socketBearerPrefix = "bearer."
+)
+
+// MessageType identifies what a Message sent over a list's WebSocket is for.
+type MessageType string
+
+const (
+	// MessageEditing is sent by a client with the task they have started
+	// editing, or zero when they stop.
+	MessageEditing MessageType = "editing"
+	// MessagePing is sent by a client to check the connection, and is
+	// answered with MessagePong.
+	MessagePing MessageType = "ping"
+
+	// MessageTasks is sent when a client connects, with the list's tasks.
+	MessageTasks MessageType = "tasks"
+	// MessageChange is sent with each change to a task in the list, named as
+	// on /events. A task moved out of the list is sent as task.deleted and
+	// one moved in as task.created.
+	MessageChange MessageType = "change"
+	// MessagePresence is sent with everyone connected to the list whenever
+	// someone connects, disconnects or starts or stops editing.
+	MessagePresence MessageType = "presence"
+	MessagePong     MessageType = "pong"
+	// MessageError is sent in reply to a message that can't be handled.
+	MessageError MessageType = "error"
+)
+
+// Message is sent either way over a list's WebSocket, as JSON.
+type Message struct {
+	Type     MessageType `json:"type"`
+	TaskId   TaskId      `json:"task_id,omitempty"`
+	Tasks    []Task      `json:"tasks,omitempty"`
+	Name     string      `json:"name,omitempty"`
+	Change   *Change     `json:"change,omitempty"`
+	Presence []Presence  `json:"presence,omitempty"`
+	Error    string      `json:"error,omitempty"`
+}
+
+// Presence is someone connected to a list.
+type Presence struct {
+	UserId   UserId `json:"user_id"`
+	Username string `json:"username"`
+	// Editing is the task they are editing, or zero.
+	Editing TaskId `json:"editing,omitempty"`
+}
+
+// presenceHub keeps track of who is connected to each list. Like the broker
+// it only knows about this process.
+type presenceHub struct {
+	mu    sync.Mutex
+	lists map[ListId]map[*collaborator]bool
+}
+
+// collaborator is one connection to a list.
+type collaborator struct {
+	user    User
+	editing TaskId
+	// changed is signalled when the presence of the list changes.
+	changed chan struct{}
+}
+
+func newPresenceHub() *presenceHub {
+	return &presenceHub{lists: map[ListId]map[*collaborator]bool{}}
+}
+
+func (h *presenceHub) join(id ListId, c *collaborator) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	if h.lists[id] == nil {
+		h.lists[id] = map[*collaborator]bool{}
+	}
+	h.lists[id][c] = true
+	h.notify(id)
+}
+
+func (h *presenceHub) leave(id ListId, c *collaborator) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	delete(h.lists[id], c)
+	if len(h.lists[id]) == 0 {
+		delete(h.lists, id)
+	}
+	h.notify(id)
+}
+
+func (h *presenceHub) setEditing(id ListId, c *collaborator, task TaskId) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	c.editing = task
+	h.notify(id)
+}
+
+// notify signals everyone connected to a list, skipping those who already
+// have a signal waiting. It must be called with mu held.
+func (h *presenceHub) notify(id ListId) {
+	for c := range h.lists[id] {
+		select {
+		case c.changed <- struct{}{}:
+		default:
+		}
+	}
+}
+
+// presence returns everyone connected to a list by username. Someone
+// connected more than once is only included once, editing whichever task
+// they are editing on any connection.
+func (h *presenceHub) presence(id ListId) []Presence {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	users := map[UserId]*Presence{}
+	for c := range h.lists[id] {
+		p, found := users[c.user.Id]
+		if !found {
+			p = &Presence{UserId: c.user.Id, Username: c.user.Username}
+			users[c.user.Id] = p
+		}
+		if c.editing != 0 {
+			p.Editing = c.editing
+		}
+	}
+
+	presence := []Presence{}
+	for _, p := range users {
+		presence = append(presence, *p)
+	}
+	sort.Slice(presence, func(i, j int) bool {
+		return strings.ToLower(presence[i].Username) < strings.ToLower(presence[j].Username)
+	})
+	return presence
+}
+
+var upgrader = websocket.Upgrader{
+	Subprotocols: []string{socketProtocol},
+	// Any origin is allowed for the same reason as CORS in setHeaders.
+	CheckOrigin: func(r *http.Request) bool { return true },
+}
+
+// socketToken lets WebSocket connections from browsers authenticate with the
+// token in a "bearer.<token>" subprotocol.
+func socketToken(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("Authorization") == "" {
+			for _, protocol := range websocket.Subprotocols(r) {
+				if token := strings.TrimPrefix(protocol, socketBearerPrefix); token != protocol {
+					r.Header.Set("Authorization", "Bearer "+token)
+				}
+			}
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+// listSocket is a WebSocket connection to a list.
+type listSocket struct {
+	conn  *websocket.Conn
+	tasks *TaskList
+	list  ListId
+	// editError is why the user can't edit the list's tasks, if they can't.
+	editError error
+	// inList is the tasks in the list, for telling when one leaves it.
+	inList map[TaskId]bool
+	last   EventId
+}
+
+// incomingMessage is a message read from a client, or why it couldn't be.
+type incomingMessage struct {
+	message Message
+	err     error
+}
+
+// listSocketHandler lets a user collaborate on a list over a WebSocket, see
+// Message for what is sent each way.
+func (p *TaskServer) listSocketHandler(w http.ResponseWriter, r *http.Request) {
+	list, ok := p.getListFromRequest(w, r)
+	if !ok {
+		return
+	}
+	s := &listSocket{tasks: p.tasks(r), list: list.Id, inList: map[TaskId]bool{}}
+	s.editError = s.tasks.requireRole(list.Id, RoleEditor)
+	if apiToken, ok := r.Context().Value(apiTokenKey).(APIToken); ok && !apiToken.HasScope(ScopeWrite) {
+		s.editError = errMissingScope(ScopeWrite)
+	}
+
+	// Subscribe before finding where to start so no changes are missed.
+	events, unsubscribe := s.tasks.Subscribe()
+	defer unsubscribe()
+	var err error
+	s.last, err = latestEventId(s.tasks)
+	if err != nil {
+		writeError(w, r, err)
+		return
+	}
+	tasks, err := s.tasks.GetListTasks(list.Id)
+	if err != nil {
+		writeError(w, r, err)
+		return
+	}
+	for _, task := range tasks {
+		s.inList[task.Id] = true
+	}
+
+	s.conn, err = upgrader.Upgrade(w, r, nil)
+	if err != nil {
+		// Upgrade has already replied with the error.
+		return
+	}
+	defer s.conn.Close()
+
+	c := &collaborator{user: requestUser(r), changed: make(chan struct{}, 1)}
+	p.presence.join(list.Id, c)
+	defer p.presence.leave(list.Id, c)
+
+	// done stops the reader if this returns while it is passing on a message.
+	done := make(chan struct{})
+	defer close(done)
+	incoming := make(chan incomingMessage)
+	go s.read(incoming, done)
+	ping := time.NewTicker(socketPingInterval)
+	defer ping.Stop()
+
+	err = s.send(Message{Type: MessageTasks, Tasks: tasks})
+	for err == nil {
+		select {
+		case in, ok := <-incoming:
+			if !ok {
+				return
+			}
+			err = s.handle(in, func(task TaskId) { p.presence.setEditing(list.Id, c, task) })
+		case event := <-events:
+			err = s.sendChanges(event)
+		case <-c.changed:
+			err = s.send(Message{Type: MessagePresence, Presence: p.presence.presence(list.Id)})
+		case <-ping.C:
+			err = s.conn.WriteControl(websocket.PingMessage, nil, time.Now().Add(socketWriteWait))
+		}
+	}
+	log.Printf("%s %s failed, %v", r.Method, r.URL.Path, err)
+}
+
+// read passes on the messages from the client until the connection closes,
+// stops answering pings or done is closed, then closes incoming.
+func (s *listSocket) read(incoming chan<- incomingMessage, done <-chan struct{}) {
+	defer close(incoming)
+	s.conn.SetReadLimit(socketMessageLimit)
+	s.conn.SetReadDeadline(time.Now().Add(socketPongWait))
+	s.conn.SetPongHandler(func(string) error {
+		return s.conn.SetReadDeadline(time.Now().Add(socketPongWait))
+	})
+
+	for {
+		_, data, err := s.conn.ReadMessage()
+		if err != nil {
+			return
+		}
+		var in incomingMessage
+		err = json.Unmarshal(data, &in.message)
+		if err != nil {
+			in.err = fmt.Errorf("the message is not valid JSON, %v", err)
+		}
+		select {
+		case incoming <- in:
+		case <-done:
+			return
+		}
+	}
+}
+
+// handle replies to a message from the client, calling setEditing when they
+// start or stop editing a task.
+func (s *listSocket) handle(in incomingMessage, setEditing func(TaskId)) error {
+	if in.err != nil {
+		return s.sendError(in.err)
+	}
+
+	switch in.message.Type {
+	case MessagePing:
+		return s.send(Message{Type: MessagePong})
+	case MessageEditing:
+		if s.editError != nil {
+			return s.sendError(s.editError)
+		}
+		if in.message.TaskId != 0 && !s.inList[in.message.TaskId] {
+			return s.sendError(fmt.Errorf("task %d is not in the list", in.message.TaskId))
+		}
+		setEditing(in.message.TaskId)
+		return nil
+	default:
+		return s.sendError(fmt.Errorf("%q is not a message type", in.message.Type))
+	}
+}
+
+// sendChanges sends the changes to the list's tasks since the last event
+// that was checked, after published has been published. Moving a task out of
+// the list can also take it out of the user's sight, so published is checked
+// too in case it wasn't among the events they can see.
+func (s *listSocket) sendChanges(published Event) error {
+	events, err := s.tasks.GetEventsAfter(s.last)
+	if err != nil {
+		return err
+	}
+	if s.inList[published.TaskId] && !containsEvent(events, published.Id) {
+		events = append(events, published)
+	}
+
+	for _, event := range events {
+		if event.Id > s.last {
+			s.last = event.Id
+		}
+		change := Change{Event: event}
+		var name string
+		task, err := s.tasks.GetOne(event.TaskId)
+		switch {
+		case event.Type != EventDeleted && err == nil && task.ListId == s.list:
+			change.Task = &task
+			name = changeName(event.Type)
+			if !s.inList[task.Id] {
+				name = changeName(EventCreated)
+			}
+			s.inList[task.Id] = true
+		case s.inList[event.TaskId]:
+			name = changeName(EventDeleted)
+			delete(s.inList, event.TaskId)
+		default:
+			continue
+		}
+
+		err = s.send(Message{Type: MessageChange, Name: name, Change: &change})
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func containsEvent(events []Event, id EventId) bool {
+	for _, event := range events {
+		if event.Id == id {
+			return true
+		}
+	}
+	return false
+}
+
+func (s *listSocket) sendError(err error) error {
+	return s.send(Message{Type: MessageError, Error: err.Error()})
+}
+
+func (s *listSocket) send(m Message) error {
+	s.conn.SetWriteDeadline(time.Now().Add(socketWriteWait))
+	return s.conn.WriteJSON(m)
+}
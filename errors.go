@@ -0,0 +1,109 @@
+package todo
+
+import (
+	"errors"
+	"fmt"
+	"reflect"
+	"strings"
+
+	"github.com/go-playground/validator/v10"
+)
+
+// Errors returned by TaskList and TaskStorage implementations, which may wrap
+// them with more detail. Check for them with errors.Is.
+var (
+	ErrNotFound   = errors.New("not found")
+	ErrValidation = errors.New("validation failed")
+	ErrConflict   = errors.New("conflict")
+)
+
+// FieldError describes why a single field failed validation.
+type FieldError struct {
+	Field  string `json:"field"`
+	Reason string `json:"reason"`
+}
+
+// ValidationError lists every field that failed validation. It matches
+// ErrValidation.
+type ValidationError struct {
+	Fields []FieldError
+}
+
+func (e *ValidationError) Error() string {
+	reasons := []string{}
+	for _, field := range e.Fields {
+		reasons = append(reasons, field.Field+" "+field.Reason)
+	}
+	return fmt.Sprintf("%v: %s", ErrValidation, strings.Join(reasons, ", "))
+}
+
+func (e *ValidationError) Is(target error) bool {
+	return target == ErrValidation
+}
+
+func fieldError(field, reason string) error {
+	return &ValidationError{Fields: []FieldError{{Field: field, Reason: reason}}}
+}
+
+// validateStruct validates v using its validate tags, reporting failures
+// against the JSON names of the fields.
+func validateStruct(v any) error {
+	validate := validator.New()
+	validate.RegisterTagNameFunc(func(field reflect.StructField) string {
+		name, _, _ := strings.Cut(field.Tag.Get("json"), ",")
+		if name == "" || name == "-" {
+			return field.Name
+		}
+		return name
+	})
+	validate.RegisterValidation("tag", func(fl validator.FieldLevel) bool {
+		return tagPattern.MatchString(fl.Field().String())
+	})
+	validate.RegisterValidation("username", func(fl validator.FieldLevel) bool {
+		return usernamePattern.MatchString(fl.Field().String())
+	})
+	validate.RegisterValidation("rrule", func(fl validator.FieldLevel) bool {
+		_, err := ParseRecurrence(fl.Field().String())
+		return err == nil
+	})
+
+	err := validate.Struct(v)
+	var validationErrors validator.ValidationErrors
+	if !errors.As(err, &validationErrors) {
+		return err
+	}
+
+	fields := []FieldError{}
+	for _, fe := range validationErrors {
+		fields = append(fields, FieldError{Field: fe.Field(), Reason: reason(fe)})
+	}
+	return &ValidationError{Fields: fields}
+}
+
+func reason(fe validator.FieldError) string {
+	switch fe.Tag() {
+	case "required":
+		return "is required"
+	case "oneof":
+		return "must be one of " + fe.Param()
+	case "min":
+		if fe.Kind() == reflect.Slice {
+			return "must have at least " + fe.Param() + " items"
+		}
+		return "must be at least " + fe.Param() + " characters"
+	case "max":
+		if fe.Kind() == reflect.Slice {
+			return "must have at most " + fe.Param() + " items"
+		}
+		return "must be at most " + fe.Param() + " characters"
+	case "tag":
+		return "must only contain lowercase letters, numbers, - and _"
+	case "username":
+		return "must only contain letters, numbers, ., - and _"
+	case "rrule":
+		_, err := ParseRecurrence(fe.Value().(string))
+		return "is not a valid recurrence rule: " + err.Error()
+	default:
+		return "failed the " + fe.Tag() + " check"
+	}
+}
@@ -0,0 +1,203 @@
+package todo
+
+import (
+	"time"
+)
+
+type EventId int64
+
+type EventType string
+
+const (
+	EventCreated   EventType = "created"
+	EventRenamed   EventType = "renamed"
+	EventUpdated   EventType = "updated"
+	EventCompleted EventType = "completed"
+	EventReopened  EventType = "reopened"
+	EventMoved     EventType = "moved"
+	EventDeleted   EventType = "deleted"
+	EventRestored  EventType = "restored"
+)
+
+// Event records a change to a task. Events are only ever added, so they
+// outlive the tasks they describe.
+type Event struct {
+	Id     EventId   `json:"id"`
+	TaskId TaskId    `json:"task_id"`
+	Type   EventType `json:"type"`
+	// Name is the name of the task after the change.
+	Name string `json:"name"`
+	// Previous is the name a renamed task had before.
+	Previous string    `json:"previous,omitempty"`
+	At       time.Time `json:"at"`
+	// ActorId is the user who made the change, or zero when it wasn't made
+	// by a user, such as from the CLI.
+	ActorId UserId `json:"actor_id,omitempty"`
+	// Actor is the username of the user who made the change, which is
+	// looked up when the events are read rather than stored.
+	Actor string `json:"actor,omitempty"`
+}
+
+// EventQuery selects a page of events, most recent first unless Ascending is
+// set.
+type EventQuery struct {
+	// TaskId restricts the results to the events of one task when non-zero.
+	TaskId TaskId
+	// After restricts the results to the events added after the one with
+	// this id when non-zero.
+	After EventId
+	// Ascending returns the events oldest first, for replaying them in order.
+	Ascending bool
+
+	// Limit is the maximum number of events to return, zero means no limit.
+	Limit  int
+	Offset int
+}
+
+// EventPage is one page of events. The cursors are empty when there is no
+// next or previous page.
+type EventPage struct {
+	Events     []Event
+	NextCursor string
+	PrevCursor string
+}
+
+// QueryEvents filters and pages a slice of events in the order they were
+// added, for storage that doesn't have a query engine of its own.
+func QueryEvents(events []Event, q EventQuery) []Event {
+	results := []Event{}
+	for i := range events {
+		event := events[len(events)-1-i]
+		if q.Ascending {
+			event = events[i]
+		}
+		if (q.TaskId == 0 || event.TaskId == q.TaskId) && event.Id > q.After {
+			results = append(results, event)
+		}
+	}
+
+	if q.Offset >= len(results) {
+		return []Event{}
+	}
+	results = results[q.Offset:]
+	if q.Limit > 0 && q.Limit < len(results) {
+		results = results[:q.Limit]
+	}
+	return results
+}
+
+// record adds an event for a change that the task list's user has just made
+// to task.
+func (t *TaskList) record(eventType EventType, task *Task, previous string) error {
+	event := Event{TaskId: task.Id, Type: eventType, Name: task.Name, Previous: previous, At: t.now(),
+		ActorId: t.user}
+	id, err := t.storage.AddEvent(&event)
+	if err != nil {
+		return err
+	}
+	event.Id = id
+	t.broker.Publish(event)
+	return nil
+}
+
+// recordUpdate adds the events for replacing before with after: a rename,
+// a change in whether it is complete and an update for anything else.
+func (t *TaskList) recordUpdate(before, after *Task) error {
+	if before.Name != after.Name {
+		err := t.record(EventRenamed, after, before.Name)
+		if err != nil {
+			return err
+		}
+	}
+	if before.Complete != after.Complete {
+		eventType := EventCompleted
+		if !after.Complete {
+			eventType = EventReopened
+		}
+		err := t.record(eventType, after, "")
+		if err != nil {
+			return err
+		}
+	}
+
+	unchanged := *before
+	unchanged.Name, unchanged.Complete, unchanged.CompletedAt = after.Name, after.Complete, after.CompletedAt
+	if !tasksEqual(&unchanged, after) {
+		return t.record(EventUpdated, after, "")
+	}
+	return nil
+}
+
+// tasksEqual compares the fields of tasks that can be updated.
+func tasksEqual(a, b *Task) bool {
+	return a.Name == b.Name && a.Complete == b.Complete && a.ListId == b.ListId &&
+		timesEqual(a.Due, b.Due) && timesEqual(a.RemindAt, b.RemindAt) &&
+		timesEqual(a.CompletedAt, b.CompletedAt) && tagsEqual(a.Tags, b.Tags) &&
+		a.ParentId == b.ParentId && a.Priority == b.Priority && a.Recurrence == b.Recurrence
+}
+
+func timesEqual(a, b *time.Time) bool {
+	return compareTimes(a, b) == 0
+}
+
+func tagsEqual(a, b []string) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for i := range a {
+		if a[i] != b[i] {
+			return false
+		}
+	}
+	return true
+}
+
+// GetHistory returns the page of a task's events starting at cursor, most
+// recent first. The history of a task is kept after it is deleted.
+func (t *TaskList) GetHistory(id TaskId, limit int, cursor string) (EventPage, error) {
+	page, err := t.queryEvents(EventQuery{TaskId: id, Limit: limit}, cursor)
+	if err != nil || len(page.Events) > 0 || page.PrevCursor != "" {
+		return page, err
+	}
+	// A task with no events might not exist, or might be older than the
+	// history.
+	_, err = t.storage.GetTask(id)
+	return page, err
+}
+
+// GetActivity returns the page of events for every task starting at cursor,
+// most recent first.
+func (t *TaskList) GetActivity(limit int, cursor string) (EventPage, error) {
+	return t.queryEvents(EventQuery{Limit: limit}, cursor)
+}
+
+// GetEventsAfter returns the events added after the one with id, oldest
+// first, for catching up with changes.
+func (t *TaskList) GetEventsAfter(id EventId) ([]Event, error) {
+	return t.storage.GetEvents(EventQuery{After: id, Ascending: true})
+}
+
+func (t *TaskList) queryEvents(q EventQuery, cursor string) (EventPage, error) {
+	offset, err := decodeCursor(cursor)
+	if err != nil {
+		return EventPage{}, err
+	}
+	q.Offset = offset
+
+	// Fetch one extra event to find out if there is another page.
+	limit := q.Limit
+	if limit > 0 {
+		q.Limit++
+	}
+	events, err := t.storage.GetEvents(q)
+	if err != nil {
+		return EventPage{}, err
+	}
+
+	page := EventPage{Events: events}
+	page.NextCursor, page.PrevCursor = pageCursors(offset, limit, len(events))
+	if page.NextCursor != "" {
+		page.Events = events[:limit]
+	}
+	return page, nil
+}
@@ -0,0 +1,35 @@
+package todo
+
+import "encoding/json"
+
+// mergePatch applies a JSON merge patch (RFC 7386) to a JSON document.
+func mergePatch(doc, patch []byte) ([]byte, error) {
+	var target, changes any
+	if err := json.Unmarshal(doc, &target); err != nil {
+		return nil, err
+	}
+	if err := json.Unmarshal(patch, &changes); err != nil {
+		return nil, err
+	}
+	return json.Marshal(applyMergePatch(target, changes))
+}
+
+func applyMergePatch(target, patch any) any {
+	changes, ok := patch.(map[string]any)
+	if !ok {
+		return patch
+	}
+
+	result, ok := target.(map[string]any)
+	if !ok {
+		result = map[string]any{}
+	}
+	for key, value := range changes {
+		if value == nil {
+			delete(result, key)
+		} else {
+			result[key] = applyMergePatch(result[key], value)
+		}
+	}
+	return result
+}
@@ -0,0 +1,191 @@
+package todo
+
+import (
+	"errors"
+	"strings"
+)
+
+// positionDigits are the digits of position keys, in byte order so that keys
+// compare correctly as plain strings.
+const positionDigits = "0123456789ABCDEFGHIJKLMNOPQRSTUVWXYZabcdefghijklmnopqrstuvwxyz"
+
+// PositionBetween returns a position key that sorts after lower and before
+// upper, where an empty key leaves that side open. Keys never end in the
+// first digit, which guarantees there is always room between two of them,
+// so a task can be moved without renumbering any others.
+func PositionBetween(lower, upper string) string {
+	if upper == "" {
+		return positionAfter(lower)
+	}
+
+	// Skip the common prefix, treating lower as padded with the first digit.
+	n := 0
+	for n < len(upper) && positionDigit(lower, n) == strings.IndexByte(positionDigits, upper[n]) {
+		n++
+	}
+	if n > 0 {
+		rest := ""
+		if n < len(lower) {
+			rest = lower[n:]
+		}
+		return upper[:n] + PositionBetween(rest, upper[n:])
+	}
+
+	low := positionDigit(lower, 0)
+	high := strings.IndexByte(positionDigits, upper[0])
+	if high-low > 1 {
+		return string(positionDigits[(low+high+1)/2])
+	}
+	if len(upper) > 1 {
+		return upper[:1]
+	}
+	// The first digits are consecutive, so keep the lower one and find a key
+	// after the rest of lower.
+	rest := ""
+	if len(lower) > 1 {
+		rest = lower[1:]
+	}
+	return string(positionDigits[low]) + positionAfter(rest)
+}
+
+// positionAfter returns a short key after lower. Appended keys are the
+// single digits from the middle up, then the last digit followed by a length
+// digit and a number with that many digits, such as "z3" + "1A4", so they
+// grow with the logarithm of the number of tasks rather than linearly. Other
+// keys, such as those older versions appended, are followed by incrementing
+// their first digit, or by the same form after their leading last digits.
+func positionAfter(lower string) string {
+	if lower == "" {
+		return string(positionDigits[len(positionDigits)/2])
+	}
+	if lower[0] != positionDigits[len(positionDigits)-1] {
+		return string(positionDigits[positionDigit(lower, 0)+1])
+	}
+	return lower[:1] + positionAfterNumber(lower[1:])
+}
+
+// positionAfterNumber returns the key after rest, the part of a key after a
+// leading last digit: a length digit from 1 to maxPositionLength followed
+// by up to that many digits of a number, without any trailing zeros.
+func positionAfterNumber(rest string) string {
+	length := positionDigit(rest, 0)
+	switch {
+	case length == 0:
+		return string(positionDigits[1])
+	case length > maxPositionLength:
+		// An older key, which only needs its first digit incremented or,
+		// after another last digit, the same again.
+		return positionAfter(rest)
+	}
+
+	number := []byte(rest[1:])
+	if len(number) > length {
+		number = number[:length]
+	}
+	for len(number) < length {
+		number = append(number, positionDigits[0])
+	}
+	for i := length - 1; i >= 0; i-- {
+		digit := positionDigit(string(number), i)
+		if digit < len(positionDigits)-1 {
+			number[i] = positionDigits[digit+1]
+			return rest[:1] + strings.TrimRight(string(number), positionDigits[:1])
+		}
+		number[i] = positionDigits[0]
+	}
+	// Every number of this length is used, so start on the next length with
+	// zero, which is left out.
+	return string(positionDigits[length+1])
+}
+
+// maxPositionLength is the longest number in appended keys, which leaves the
+// length digits from the middle up to the older keys that went beyond the
+// last single digit.
+const maxPositionLength = len(positionDigits)/2 - 1
+
+func positionDigit(key string, i int) int {
+	if i >= len(key) {
+		return 0
+	}
+	return strings.IndexByte(positionDigits, key[i])
+}
+
+// MovePosition returns the position that places task id after the task
+// after and before the task before, for storage that doesn't have a query
+// engine of its own. When only one of them is given the task is placed next
+// to it.
+func MovePosition(tasks []Task, id, before, after TaskId) (string, error) {
+	var lower, upper string
+	for _, task := range tasks {
+		if task.Id == after {
+			lower = task.Position
+		}
+		if task.Id == before {
+			upper = task.Position
+		}
+	}
+
+	for _, task := range tasks {
+		if task.Id == id || task.Position == "" {
+			continue
+		}
+		if before == 0 && task.Position > lower && (upper == "" || task.Position < upper) {
+			upper = task.Position
+		}
+		if after == 0 && task.Position < upper && task.Position > lower {
+			lower = task.Position
+		}
+	}
+	if upper != "" && lower >= upper {
+		return "", ErrMoveOutOfOrder
+	}
+	return PositionBetween(lower, upper), nil
+}
+
+// ErrMoveOutOfOrder is returned by TaskStorage.Move when the task to move
+// after doesn't come before the task to move before.
+var ErrMoveOutOfOrder = &ValidationError{Fields: []FieldError{
+	{Field: "after", Reason: "must come before the task given in before"},
+}}
+
+// Move places a task after the task after and before the task before, either
+// of which may be zero to place it next to the other.
+func (t *TaskList) Move(id, before, after TaskId) error {
+	task, err := t.storage.GetTask(id)
+	if err != nil {
+		return err
+	}
+	moved := *task
+	err = t.requireRole(moved.ListId, RoleEditor)
+	if err != nil {
+		return err
+	}
+	if before == 0 && after == 0 {
+		return fieldError("before", "is required when after isn't given")
+	}
+
+	neighbours := []struct {
+		field string
+		id    TaskId
+	}{{"before", before}, {"after", after}}
+	for _, neighbour := range neighbours {
+		if neighbour.id == 0 {
+			continue
+		}
+		if neighbour.id == id {
+			return fieldError(neighbour.field, "must be a different task")
+		}
+		_, err = t.storage.GetTask(neighbour.id)
+		if errors.Is(err, ErrNotFound) {
+			return fieldError(neighbour.field, "does not exist")
+		}
+		if err != nil {
+			return err
+		}
+	}
+	err = t.storage.Move(id, before, after)
+	if err != nil {
+		return err
+	}
+	return t.record(EventMoved, &moved, "")
+}
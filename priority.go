@@ -0,0 +1,37 @@
+package todo
+
+// Priority ranks how urgent a task is, from P0 for the most urgent to P3.
+// Tasks without a priority rank below P3.
+type Priority string
+
+const (
+	P0 Priority = "P0"
+	P1 Priority = "P1"
+	P2 Priority = "P2"
+	P3 Priority = "P3"
+)
+
+// Next returns the priority after p, cycling from no priority through P0 to
+// P3 and back to none.
+func (p Priority) Next() Priority {
+	switch p {
+	case "":
+		return P0
+	case P0:
+		return P1
+	case P1:
+		return P2
+	case P2:
+		return P3
+	default:
+		return ""
+	}
+}
+
+// GetOutstandingRanked returns the outstanding tasks in a list, or in every
+// list if listId is zero, most important first: by priority then due date,
+// with ties left in the order the user arranged them.
+func (t *TaskList) GetOutstandingRanked(listId ListId) ([]Task, error) {
+	complete := false
+	return t.storage.Query(TaskQuery{ListId: listId, Complete: &complete, Sort: SortByPriority})
+}
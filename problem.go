@@ -0,0 +1,83 @@
+package todo
+
+import (
+	"encoding/json"
+	"errors"
+	"log"
+	"net/http"
+)
+
+// Problem is an RFC 7807 problem details response body.
+type Problem struct {
+	Type     string       `json:"type"`
+	Title    string       `json:"title"`
+	Status   int          `json:"status"`
+	Detail   string       `json:"detail,omitempty"`
+	Instance string       `json:"instance,omitempty"`
+	Errors   []FieldError `json:"errors,omitempty"`
+}
+
+func newProblem(r *http.Request, status int, detail string) Problem {
+	return Problem{
+		Type:     "about:blank",
+		Title:    http.StatusText(status),
+		Status:   status,
+		Detail:   detail,
+		Instance: r.URL.Path,
+	}
+}
+
+func writeProblem(w http.ResponseWriter, problem Problem) {
+	w.Header().Set("content-type", "application/problem+json")
+	w.WriteHeader(problem.Status)
+
+	err := json.NewEncoder(w).Encode(problem)
+	if err != nil {
+		log.Printf("Could not encode json %v", err)
+	}
+}
+
+// writeError responds with the problem matching a domain error. Errors that
+// aren't the client's fault are logged and hidden behind a generic 500.
+func writeError(w http.ResponseWriter, r *http.Request, err error) {
+	var validationError *ValidationError
+
+	switch {
+	case errors.As(err, &validationError):
+		problem := newProblem(r, http.StatusBadRequest, "The request failed validation")
+		problem.Errors = validationError.Fields
+		writeProblem(w, problem)
+	case errors.Is(err, ErrValidation):
+		writeProblem(w, newProblem(r, http.StatusBadRequest, err.Error()))
+	case errors.Is(err, ErrNotFound):
+		writeProblem(w, newProblem(r, http.StatusNotFound, err.Error()))
+	case errors.Is(err, ErrConflict):
+		writeProblem(w, newProblem(r, http.StatusConflict, err.Error()))
+	case errors.Is(err, ErrUnauthorized):
+		w.Header().Set("WWW-Authenticate", "Bearer")
+		writeProblem(w, newProblem(r, http.StatusUnauthorized, err.Error()))
+	case errors.Is(err, ErrForbidden):
+		writeProblem(w, newProblem(r, http.StatusForbidden, err.Error()))
+	default:
+		log.Printf("%s %s failed, %v", r.Method, r.URL.Path, err)
+		writeProblem(w, newProblem(r, http.StatusInternalServerError, "An unexpected error occurred"))
+	}
+}
+
+// decodeJSON decodes a request body, writing a 400 problem if it isn't valid.
+func decodeJSON(w http.ResponseWriter, r *http.Request, v any) bool {
+	err := json.NewDecoder(r.Body).Decode(v)
+	if err != nil {
+		writeProblem(w, newProblem(r, http.StatusBadRequest, "The request body is not valid JSON: "+err.Error()))
+		return false
+	}
+	return true
+}
+
+func notFoundHandler(w http.ResponseWriter, r *http.Request) {
+	writeProblem(w, newProblem(r, http.StatusNotFound, "No resource exists at this path"))
+}
+
+func methodNotAllowedHandler(w http.ResponseWriter, r *http.Request) {
+	writeProblem(w, newProblem(r, http.StatusMethodNotAllowed, r.Method+" is not supported at this path"))
+}
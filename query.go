@@ -0,0 +1,214 @@
+package todo
+
+import (
+	"encoding/base64"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+type SortField string
+
+const (
+	SortById       SortField = "id"
+	SortByName     SortField = "name"
+	SortByCreated  SortField = "created"
+	SortByDue      SortField = "due"
+	SortByPriority SortField = "priority"
+	SortByPosition SortField = "position"
+)
+
+// TaskQuery selects a page of tasks. The zero value returns every task in
+// id order.
+type TaskQuery struct {
+	// ListId restricts the results to a single list when non-zero.
+	ListId ListId
+	// Complete restricts the results to complete or incomplete tasks when set.
+	Complete *bool
+	// NameContains restricts the results to tasks whose name contains it,
+	// ignoring case.
+	NameContains string
+	// Tag restricts the results to tasks labelled with it when set.
+	Tag string
+
+	Sort       SortField
+	Descending bool
+
+	// Limit is the maximum number of tasks to return, zero means no limit.
+	Limit  int
+	Offset int
+}
+
+// TaskPage is one page of the results of a query. The cursors are empty when
+// there is no next or previous page.
+type TaskPage struct {
+	Tasks      []Task
+	NextCursor string
+	PrevCursor string
+}
+
+// ParseSort parses a sort parameter such as "due" or "-name", where a leading
+// "-" sorts in descending order.
+func ParseSort(param string) (SortField, bool, error) {
+	descending := strings.HasPrefix(param, "-")
+	field := SortField(strings.TrimPrefix(param, "-"))
+
+	switch field {
+	case SortById, SortByName, SortByCreated, SortByDue, SortByPriority, SortByPosition:
+		return field, descending, nil
+	case "":
+		return SortById, descending, nil
+	default:
+		return "", false, fieldError("sort", "must be one of id name created due priority position")
+	}
+}
+
+func encodeCursor(offset int) string {
+	return base64.RawURLEncoding.EncodeToString([]byte(strconv.Itoa(offset)))
+}
+
+func decodeCursor(cursor string) (int, error) {
+	if cursor == "" {
+		return 0, nil
+	}
+	decoded, err := base64.RawURLEncoding.DecodeString(cursor)
+	if err != nil {
+		return 0, fieldError("cursor", "is not valid")
+	}
+	offset, err := strconv.Atoi(string(decoded))
+	if err != nil || offset < 0 {
+		return 0, fieldError("cursor", "is not valid")
+	}
+	return offset, nil
+}
+
+// pageCursors returns the cursors for the pages either side of the one at
+// offset, given how many results were fetched when asking for one more than
+// limit.
+func pageCursors(offset, limit, fetched int) (next, prev string) {
+	if limit > 0 && fetched > limit {
+		next = encodeCursor(offset + limit)
+	}
+	if offset > 0 && limit > 0 {
+		start := offset - limit
+		if start < 0 {
+			start = 0
+		}
+		prev = encodeCursor(start)
+	}
+	return next, prev
+}
+
+// Matches reports whether a task passes the query's filters.
+func (q *TaskQuery) Matches(task *Task) bool {
+	if q.ListId != 0 && task.ListId != q.ListId {
+		return false
+	}
+	if q.Complete != nil && task.Complete != *q.Complete {
+		return false
+	}
+	if q.NameContains != "" &&
+		!strings.Contains(strings.ToLower(task.Name), strings.ToLower(q.NameContains)) {
+		return false
+	}
+	if q.Tag != "" && !task.HasTag(q.Tag) {
+		return false
+	}
+	return true
+}
+
+// QueryTasks filters, sorts and pages a slice of tasks, for storage that
+// doesn't have a query engine of its own. It follows the same ordering as
+// the SQL implementations: names sort ignoring case, tasks without a due
+// date or priority come last, tasks without a creation time come first and
+// ties are broken by id.
+func QueryTasks(tasks []Task, q TaskQuery) []Task {
+	results := []Task{}
+	for _, task := range tasks {
+		if q.Matches(&task) {
+			results = append(results, task)
+		}
+	}
+
+	sort.SliceStable(results, func(i, j int) bool {
+		a, b := &results[i], &results[j]
+		if q.Sort == SortByPriority && (a.Priority == "") != (b.Priority == "") {
+			return b.Priority == ""
+		}
+		if (q.Sort == SortByDue || q.Sort == SortByPriority && a.Priority == b.Priority) &&
+			(a.Due == nil) != (b.Due == nil) {
+			return b.Due == nil
+		}
+
+		c := compareTasks(a, b, q.Sort)
+		if c == 0 {
+			c = compareInts(int64(a.Id), int64(b.Id))
+		}
+		if q.Descending {
+			return c > 0
+		}
+		return c < 0
+	})
+
+	if q.Offset >= len(results) {
+		return []Task{}
+	}
+	results = results[q.Offset:]
+	if q.Limit > 0 && q.Limit < len(results) {
+		results = results[:q.Limit]
+	}
+	return results
+}
+
+func compareTasks(a, b *Task, field SortField) int {
+	switch field {
+	case SortByName:
+		return strings.Compare(strings.ToLower(a.Name), strings.ToLower(b.Name))
+	case SortByCreated:
+		return compareTimes(a.CreatedAt, b.CreatedAt)
+	case SortByDue:
+		return compareTimes(a.Due, b.Due)
+	case SortByPriority:
+		if c := strings.Compare(string(a.Priority), string(b.Priority)); c != 0 {
+			return c
+		}
+		if c := compareTimes(a.Due, b.Due); c != 0 {
+			return c
+		}
+		return strings.Compare(a.Position, b.Position)
+	case SortByPosition:
+		return strings.Compare(a.Position, b.Position)
+	default:
+		return 0
+	}
+}
+
+// compareTimes orders missing times first.
+func compareTimes(a, b *time.Time) int {
+	switch {
+	case a == nil && b == nil:
+		return 0
+	case a == nil:
+		return -1
+	case b == nil:
+		return 1
+	case a.Before(*b):
+		return -1
+	case a.After(*b):
+		return 1
+	default:
+		return 0
+	}
+}
+
+func compareInts(a, b int64) int {
+	switch {
+	case a < b:
+		return -1
+	case a > b:
+		return 1
+	default:
+		return 0
+	}
+}
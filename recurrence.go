@@ -0,0 +1,223 @@
+package todo
+
+import (
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Frequency is the unit of time a recurrence repeats in.
+type Frequency string
+
+const (
+	Daily   Frequency = "DAILY"
+	Weekly  Frequency = "WEEKLY"
+	Monthly Frequency = "MONTHLY"
+)
+
+// Recurrence is a schedule for a repeating task, written as a subset of an
+// iCalendar RRULE such as "FREQ=WEEKLY;INTERVAL=2;BYDAY=MO,TH".
+type Recurrence struct {
+	Frequency Frequency
+	// Interval is the number of days, weeks or months between occurrences.
+	Interval int
+	// Weekdays restricts weekly recurrences to the given days, in the order
+	// they fall in a week starting on Monday.
+	Weekdays []time.Weekday
+}
+
+var weekdayCodes = []struct {
+	code string
+	day  time.Weekday
+}{
+	{"MO", time.Monday}, {"TU", time.Tuesday}, {"WE", time.Wednesday},
+	{"TH", time.Thursday}, {"FR", time.Friday}, {"SA", time.Saturday},
+	{"SU", time.Sunday},
+}
+
+// ParseRecurrence parses a recurrence rule. FREQ is required and may be
+// DAILY, WEEKLY or MONTHLY, INTERVAL defaults to 1 and BYDAY is a list of
+// two letter weekdays that only applies to weekly rules.
+func ParseRecurrence(rule string) (Recurrence, error) {
+	r := Recurrence{Interval: 1}
+	rule = strings.TrimPrefix(strings.ToUpper(strings.TrimSpace(rule)), "RRULE:")
+
+	for _, part := range strings.Split(rule, ";") {
+		key, value, found := strings.Cut(part, "=")
+		if !found {
+			return Recurrence{}, fmt.Errorf("%q is not a KEY=VALUE pair", part)
+		}
+
+		switch key {
+		case "FREQ":
+			r.Frequency = Frequency(value)
+			if r.Frequency != Daily && r.Frequency != Weekly && r.Frequency != Monthly {
+				return Recurrence{}, fmt.Errorf("FREQ must be DAILY, WEEKLY or MONTHLY")
+			}
+		case "INTERVAL":
+			interval, err := strconv.Atoi(value)
+			if err != nil || interval < 1 || interval > 366 {
+				return Recurrence{}, fmt.Errorf("INTERVAL must be between 1 and 366")
+			}
+			r.Interval = interval
+		case "BYDAY":
+			days := map[string]bool{}
+			for _, code := range strings.Split(value, ",") {
+				days[code] = true
+			}
+			for _, weekday := range weekdayCodes {
+				if days[weekday.code] {
+					r.Weekdays = append(r.Weekdays, weekday.day)
+					delete(days, weekday.code)
+				}
+			}
+			if len(days) > 0 {
+				return Recurrence{}, fmt.Errorf("BYDAY must be a list of MO, TU, WE, TH, FR, SA or SU")
+			}
+		default:
+			return Recurrence{}, fmt.Errorf("%s is not supported", key)
+		}
+	}
+
+	if r.Frequency == "" {
+		return Recurrence{}, errors.New("FREQ is required")
+	}
+	if len(r.Weekdays) > 0 && r.Frequency != Weekly {
+		return Recurrence{}, errors.New("BYDAY is only supported with FREQ=WEEKLY")
+	}
+	return r, nil
+}
+
+// String formats the rule in the form accepted by ParseRecurrence.
+func (r Recurrence) String() string {
+	rule := "FREQ=" + string(r.Frequency)
+	if r.Interval > 1 {
+		rule += ";INTERVAL=" + strconv.Itoa(r.Interval)
+	}
+	if len(r.Weekdays) > 0 {
+		codes := []string{}
+		for _, day := range r.Weekdays {
+			codes = append(codes, weekdayCode(day))
+		}
+		rule += ";BYDAY=" + strings.Join(codes, ",")
+	}
+	return rule
+}
+
+func weekdayCode(day time.Weekday) string {
+	for _, weekday := range weekdayCodes {
+		if weekday.day == day {
+			return weekday.code
+		}
+	}
+	return ""
+}
+
+// Describe explains the rule in words, such as "every 2 weeks on Mon, Thu".
+func (r Recurrence) Describe() string {
+	units := map[Frequency]string{Daily: "day", Weekly: "week", Monthly: "month"}
+	description := "every " + units[r.Frequency]
+	if r.Interval > 1 {
+		description = fmt.Sprintf("every %d %ss", r.Interval, units[r.Frequency])
+	}
+
+	if len(r.Weekdays) > 0 {
+		days := []string{}
+		for _, day := range r.Weekdays {
+			days = append(days, day.String()[:3])
+		}
+		description += " on " + strings.Join(days, ", ")
+	}
+	return description
+}
+
+// Next returns the first occurrence after from, at the same time of day.
+// Monthly occurrences fall on the last day of shorter months rather than
+// overflowing into the next.
+func (r Recurrence) Next(from time.Time) time.Time {
+	switch {
+	case r.Frequency == Daily:
+		return from.AddDate(0, 0, r.Interval)
+	case r.Frequency == Weekly && len(r.Weekdays) == 0:
+		return from.AddDate(0, 0, 7*r.Interval)
+	case r.Frequency == Weekly:
+		return r.nextWeekday(from)
+	default:
+		year, month, day := from.Date()
+		first := time.Date(year, month+time.Month(r.Interval), 1, from.Hour(), from.Minute(),
+			from.Second(), from.Nanosecond(), from.Location())
+		if last := first.AddDate(0, 1, -1).Day(); day > last {
+			day = last
+		}
+		return first.AddDate(0, 0, day-1)
+	}
+}
+
+// nextWeekday finds the next of the rule's weekdays, only counting weeks that
+// are a multiple of the interval after the week of from.
+func (r Recurrence) nextWeekday(from time.Time) time.Time {
+	week := 0
+	next := from
+	for {
+		next = next.AddDate(0, 0, 1)
+		if next.Weekday() == time.Monday {
+			week++
+		}
+		if week%r.Interval != 0 {
+			continue
+		}
+		for _, day := range r.Weekdays {
+			if next.Weekday() == day {
+				return next
+			}
+		}
+	}
+}
+
+// spawnNext adds the next occurrence of a recurring task that has just been
+// completed at now. It is due at the first occurrence after both its
+// previous due date, or now if it had none, and now, so that completing a
+// task late doesn't create one that is already overdue. Any reminder moves
+// with the due date.
+func (t *TaskList) spawnNext(task *Task, now time.Time) error {
+	if task.Recurrence == "" {
+		return nil
+	}
+	rule, err := ParseRecurrence(task.Recurrence)
+	if err != nil {
+		return err
+	}
+
+	previous := now
+	if task.Due != nil {
+		previous = *task.Due
+	}
+	due := rule.Next(previous)
+	for !due.After(now) {
+		due = rule.Next(due)
+	}
+
+	next := Task{
+		Name:       task.Name,
+		ListId:     task.ListId,
+		ParentId:   task.ParentId,
+		Tags:       task.Tags,
+		Priority:   task.Priority,
+		Recurrence: task.Recurrence,
+		Due:        &due,
+		OwnerId:    task.OwnerId,
+	}
+	if task.RemindAt != nil && task.Due != nil {
+		remindAt := task.RemindAt.Add(due.Sub(*task.Due))
+		next.RemindAt = &remindAt
+	}
+
+	_, err = t.AddTask(&next)
+	if err != nil {
+		return err
+	}
+	// Keep the next occurrence where the user had placed the task.
+	return t.storage.Move(next.Id, 0, task.Id)
+}
@@ -0,0 +1,100 @@
+package todo
+
+import (
+	"html"
+	"strings"
+	"unicode"
+)
+
+// Markers wrapped around the matching words in search snippets.
+const (
+	HighlightStart = "<mark>"
+	HighlightEnd   = "</mark>"
+)
+
+// SearchResult is a task matching a search, with a snippet of its name in
+// which the matching words are highlighted. The snippet is HTML, so the rest
+// of the name is escaped.
+type SearchResult struct {
+	Task
+	Snippet string `json:"snippet"`
+}
+
+// SearchTerms splits a search query into lowercase terms. A task matches
+// when every term is the start of a word in its name.
+func SearchTerms(query string) []string {
+	return strings.FieldsFunc(strings.ToLower(query), isSeparator)
+}
+
+func isSeparator(r rune) bool {
+	return !unicode.IsLetter(r) && !unicode.IsNumber(r)
+}
+
+// MatchSearch reports whether name matches every term, returning an HTML
+// snippet of it with the matching words highlighted.
+func MatchSearch(name string, terms []string) (string, bool) {
+	matched := make([]bool, len(terms))
+	snippet := strings.Builder{}
+
+	// Walk the name a word at a time, copying the separators between words
+	// unchanged.
+	rest := name
+	for rest != "" {
+		start := strings.IndexFunc(rest, func(r rune) bool { return !isSeparator(r) })
+		if start < 0 {
+			snippet.WriteString(html.EscapeString(rest))
+			break
+		}
+		snippet.WriteString(html.EscapeString(rest[:start]))
+		rest = rest[start:]
+
+		end := strings.IndexFunc(rest, isSeparator)
+		if end < 0 {
+			end = len(rest)
+		}
+		word := rest[:end]
+		rest = rest[end:]
+		escaped := html.EscapeString(word)
+
+		highlight := false
+		for i, term := range terms {
+			if strings.HasPrefix(strings.ToLower(word), term) {
+				matched[i] = true
+				highlight = true
+			}
+		}
+		if highlight {
+			escaped = HighlightStart + escaped + HighlightEnd
+		}
+		snippet.WriteString(escaped)
+	}
+
+	for _, m := range matched {
+		if !m {
+			return "", false
+		}
+	}
+	return snippet.String(), len(terms) > 0
+}
+
+// SearchTasks finds the tasks matching a search query, for storage that
+// doesn't have a search engine of its own. Results are in the order of tasks.
+func SearchTasks(tasks []Task, query string) []SearchResult {
+	terms := SearchTerms(query)
+	results := []SearchResult{}
+	for _, task := range tasks {
+		if snippet, ok := MatchSearch(task.Name, terms); ok {
+			results = append(results, SearchResult{Task: task, Snippet: snippet})
+		}
+	}
+	return results
+}
+
+// Search returns the tasks whose names match a query, best matches first
+// where the storage is able to rank them.
+func (t *TaskList) Search(query string) ([]SearchResult, error) {
+	if len(SearchTerms(query)) == 0 {
+		return nil, fieldError("q", "must contain a word to search for")
+	}
+	return t.storage.Search(query)
+}
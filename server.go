@@ -2,9 +2,15 @@ package todo
 
 import (
 	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
 	"log"
 	"net/http"
+	"net/url"
 	"strconv"
+	"strings"
+	"time"
 
 	"github.com/go-chi/chi/v5"
 	"github.com/go-chi/chi/v5/middleware"
@@ -12,32 +18,108 @@ import (
 
 type TaskServer struct {
 	taskList *TaskList
+	accounts *Accounts
+	presence *presenceHub
 	http.Handler
 }
 
-type StatusResponse struct {
-	Status  string `json:"status"`
-	Message string `json:"message"`
-}
-
-func NewTaskServer(taskList *TaskList) *TaskServer {
+// NewTaskServer creates the web API. Everything but registering and logging
+// in requires a session token from logging in or an API token, and only
+// works with the tasks and lists of the user they belong to.
+func NewTaskServer(taskList *TaskList, accounts *Accounts) *TaskServer {
 	p := new(TaskServer)
 	p.taskList = taskList
+	p.accounts = accounts
+	p.presence = newPresenceHub()
 
 	r := chi.NewRouter()
 
 	r.Use(middleware.Logger)
+	r.NotFound(notFoundHandler)
+	r.MethodNotAllowed(methodNotAllowedHandler)
 
-	r.Route("/tasks", func(r chi.Router) {
+	r.Route("/users", func(r chi.Router) {
+		r.Use(setHeaders)
+		r.Post("/", p.registerHandler)
+		r.With(p.authenticate).Get("/me", p.currentUserHandler)
+	})
+	r.Route("/login", func(r chi.Router) {
 		r.Use(setHeaders)
+		r.Post("/", p.loginHandler)
+	})
+	r.Route("/logout", func(r chi.Router) {
+		r.Use(setHeaders, p.authenticate, requireSession)
+		r.Post("/", p.logoutHandler)
+	})
+
+	r.Route("/tokens", func(r chi.Router) {
+		r.Use(setHeaders, p.authenticate, requireSession)
+		r.Get("/", p.apiTokensHandler)
+		r.Post("/", p.newAPITokenHandler)
+		r.Delete("/{tokenID:^[1-9][0-9]*}", p.revokeAPITokenHandler)
+	})
+
+	r.Route("/tasks", func(r chi.Router) {
+		r.Use(setHeaders, p.authenticate)
 		r.Get("/", p.tasksHandler)
 		r.Post("/", p.newTaskHandler)
 		r.Get("/incomplete", p.incompleteHandler)
+		r.Get("/overdue", p.overdueHandler)
+		r.Get("/due", p.dueHandler)
+		r.Get("/search", p.searchHandler)
 		r.Get("/{taskID:^[1-9][0-9]*}", p.taskHandler)
 		r.Post("/{taskID:^[1-9][0-9]*}", p.taskStatusToggleHandler)
+		r.Put("/{taskID:^[1-9][0-9]*}", p.taskReplaceHandler)
+		r.Patch("/{taskID:^[1-9][0-9]*}", p.taskPatchHandler)
+		r.Get("/{taskID:^[1-9][0-9]*}/subtasks", p.subtasksHandler)
+		r.Get("/{taskID:^[1-9][0-9]*}/history", p.taskHistoryHandler)
+		r.Post("/{taskID:^[1-9][0-9]*}/move", p.taskMoveHandler)
+		r.Post("/{taskID:^[1-9][0-9]*}/restore", p.taskRestoreHandler)
+		r.Put("/{taskID:^[1-9][0-9]*}/complete", p.taskCompleteHandler)
+		r.Delete("/{taskID:^[1-9][0-9]*}/complete", p.taskReopenHandler)
 		r.Delete("/{taskID:^[1-9][0-9]*}", p.taskDeleteHandler)
 	})
 
+	r.Route("/lists", func(r chi.Router) {
+		r.Use(setHeaders)
+		r.Group(func(r chi.Router) {
+			r.Use(p.authenticate)
+			r.Get("/", p.listsHandler)
+			r.Post("/", p.newListHandler)
+			r.Get("/{listID:^[1-9][0-9]*}", p.listHandler)
+			r.Delete("/{listID:^[1-9][0-9]*}", p.listDeleteHandler)
+			r.Get("/{listID:^[1-9][0-9]*}/tasks", p.listTasksHandler)
+			r.Post("/{listID:^[1-9][0-9]*}/tasks", p.newListTaskHandler)
+			r.Get("/{listID:^[1-9][0-9]*}/members", p.membersHandler)
+			r.Post("/{listID:^[1-9][0-9]*}/members", p.newMemberHandler)
+			r.Delete("/{listID:^[1-9][0-9]*}/members/{userID:^[1-9][0-9]*}", p.memberDeleteHandler)
+		})
+		// Only the socket takes the token as a subprotocol, which browsers
+		// can't send an Authorization header with.
+		r.With(socketToken, p.authenticate).Get("/{listID:^[1-9][0-9]*}/socket", p.listSocketHandler)
+	})
+
+	r.Route("/tags", func(r chi.Router) {
+		r.Use(setHeaders, p.authenticate)
+		r.Get("/", p.tagsHandler)
+	})
+
+	r.Route("/activity", func(r chi.Router) {
+		r.Use(setHeaders, p.authenticate)
+		r.Get("/", p.activityHandler)
+	})
+
+	r.Route("/events", func(r chi.Router) {
+		r.Use(setHeaders, p.authenticate)
+		r.Get("/", p.eventsHandler)
+	})
+
+	r.Route("/trash", func(r chi.Router) {
+		r.Use(setHeaders, p.authenticate)
+		r.Get("/", p.trashHandler)
+		r.Delete("/", p.emptyTrashHandler)
+	})
+
 	p.Handler = r
 	return p
 }
@@ -45,157 +127,694 @@ func NewTaskServer(taskList *TaskList) *TaskServer {
 func setHeaders(next http.Handler) http.Handler {
 	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
 		w.Header().Set("content-type", "application/json")
+		// Any origin is allowed since requests are authorized by a token,
+		// which other sites can't get, rather than by cookies.
 		w.Header().Set("Access-Control-Allow-Origin", "*")
+		w.Header().Set("Access-Control-Expose-Headers", "Link")
+		if r.Method == http.MethodOptions {
+			// CORS preflight for the methods that aren't simple requests
+			w.Header().Set("Access-Control-Allow-Methods", "GET, POST, PUT, PATCH, DELETE")
+			w.Header().Set("Access-Control-Allow-Headers", "Authorization, Content-Type, Last-Event-ID")
+			w.WriteHeader(http.StatusNoContent)
+			return
+		}
 		next.ServeHTTP(w, r)
 	})
 }
 
+// tasksHandler returns a page of tasks, filtered and sorted according to the
+// query parameters. Links to the next and previous pages are given in the
+// Link header.
 func (p *TaskServer) tasksHandler(w http.ResponseWriter, r *http.Request) {
-	tasks, err := p.taskList.GetAll()
+	p.queryTasks(w, r, 0)
+}
+
+func (p *TaskServer) queryTasks(w http.ResponseWriter, r *http.Request, listId ListId) {
+	query, cursor, err := parseTaskQuery(r)
 	if err != nil {
-		log.Printf("Could not get tasks %v", err)
-		w.WriteHeader(http.StatusInternalServerError)
+		writeError(w, r, err)
 		return
 	}
+	query.ListId = listId
 
-	writeTasksJSON(w, tasks)
+	page, err := p.tasks(r).Query(query, cursor)
+	if err != nil {
+		writeError(w, r, err)
+		return
+	}
+
+	setPageLinks(w, r, page.NextCursor, page.PrevCursor)
+	writeTasksJSON(w, page.Tasks)
+}
+
+// setPageLinks sets the Link header to the next and previous pages, if
+// there are any.
+func setPageLinks(w http.ResponseWriter, r *http.Request, next, prev string) {
+	links := []string{}
+	if next != "" {
+		links = append(links, pageLink(r, next, "next"))
+	}
+	if prev != "" {
+		links = append(links, pageLink(r, prev, "prev"))
+	}
+	if len(links) > 0 {
+		w.Header().Set("Link", strings.Join(links, ", "))
+	}
+}
+
+const (
+	defaultPageSize = 100
+	maxPageSize     = 500
+)
+
+// parseTaskQuery reads the limit, cursor, sort, complete and name query
+// parameters.
+func parseTaskQuery(r *http.Request) (TaskQuery, string, error) {
+	params := r.URL.Query()
+	query := TaskQuery{NameContains: params.Get("name")}
+
+	if param := params.Get("tag"); param != "" {
+		query.Tag = NormalizeTags([]string{param})[0]
+	}
+
+	limit, err := parseLimit(r)
+	if err != nil {
+		return query, "", err
+	}
+	query.Limit = limit
+
+	sort, descending, err := ParseSort(params.Get("sort"))
+	if err != nil {
+		return query, "", err
+	}
+	query.Sort = sort
+	query.Descending = descending
+
+	if param := params.Get("complete"); param != "" {
+		complete, err := strconv.ParseBool(param)
+		if err != nil {
+			return query, "", fieldError("complete", "must be true or false")
+		}
+		query.Complete = &complete
+	}
+
+	return query, params.Get("cursor"), nil
+}
+
+// parseLimit reads the page size from the limit query parameter.
+func parseLimit(r *http.Request) (int, error) {
+	param := r.URL.Query().Get("limit")
+	if param == "" {
+		return defaultPageSize, nil
+	}
+	limit, err := strconv.Atoi(param)
+	if err != nil || limit < 1 || limit > maxPageSize {
+		return 0, fieldError("limit", fmt.Sprintf("must be between 1 and %d", maxPageSize))
+	}
+	return limit, nil
+}
+
+// pageLink builds a Link header entry for the same request at another cursor.
+func pageLink(r *http.Request, cursor, rel string) string {
+	params := r.URL.Query()
+	params.Set("cursor", cursor)
+
+	u := url.URL{Path: r.URL.Path, RawQuery: params.Encode()}
+	return fmt.Sprintf("<%s>; rel=\"%s\"", u.String(), rel)
 }
 
 func (p *TaskServer) incompleteHandler(w http.ResponseWriter, r *http.Request) {
-	tasks, err := p.taskList.GetOutstanding()
+	tasks, err := p.tasks(r).GetOutstanding()
 	if err != nil {
-		log.Printf("Could not get tasks %v", err)
-		w.WriteHeader(http.StatusInternalServerError)
+		writeError(w, r, err)
 		return
 	}
 
 	writeTasksJSON(w, tasks)
 }
 
-func (p *TaskServer) taskHandler(w http.ResponseWriter, r *http.Request) {
-	idParam := chi.URLParam(r, "taskID")
-	id, err := strconv.Atoi(idParam)
+func (p *TaskServer) overdueHandler(w http.ResponseWriter, r *http.Request) {
+	tasks, err := p.tasks(r).GetOverdue()
 	if err != nil {
-		log.Printf("Invalid taskID given %v", err)
-		w.WriteHeader(http.StatusNotFound)
+		writeError(w, r, err)
 		return
 	}
-	taskId := TaskId(id)
 
-	task, err := p.taskList.GetOne(taskId)
+	writeTasksJSON(w, tasks)
+}
+
+// dueHandler returns tasks due within the optional RFC 3339 "after" and
+// "before" query parameters.
+func (p *TaskServer) dueHandler(w http.ResponseWriter, r *http.Request) {
+	after, err := parseTimeParam(r, "after")
+	if err != nil {
+		writeError(w, r, err)
+		return
+	}
+	before, err := parseTimeParam(r, "before")
 	if err != nil {
-		log.Printf("Could not get task with id %d, %v", taskId, err)
-		w.WriteHeader(http.StatusNotFound)
+		writeError(w, r, err)
 		return
 	}
 
-	err = json.NewEncoder(w).Encode(task)
+	tasks, err := p.tasks(r).GetDue(after, before)
+	if err != nil {
+		writeError(w, r, err)
+		return
+	}
 
+	writeTasksJSON(w, tasks)
+}
+
+// searchHandler returns the tasks matching the "q" parameter, best matches
+// first, each with a snippet of its name highlighting the matching words.
+func (p *TaskServer) searchHandler(w http.ResponseWriter, r *http.Request) {
+	results, err := p.tasks(r).Search(r.URL.Query().Get("q"))
 	if err != nil {
-		log.Printf("Could not encode json %v", err)
-		w.WriteHeader(http.StatusInternalServerError)
+		writeError(w, r, err)
 		return
 	}
+
+	writeJSON(w, results)
 }
 
-func (p *TaskServer) newTaskHandler(w http.ResponseWriter, r *http.Request) {
-	var task Task
-	err := json.NewDecoder(r.Body).Decode(&task)
+// parseTimeParam parses an optional RFC 3339 query parameter, returning the
+// zero time if it is not given.
+func parseTimeParam(r *http.Request, name string) (time.Time, error) {
+	param := r.URL.Query().Get(name)
+	if param == "" {
+		return time.Time{}, nil
+	}
 
+	t, err := time.Parse(time.RFC3339, param)
 	if err != nil {
-		log.Printf("Could not decode json, %v", err)
-		w.WriteHeader(http.StatusBadRequest)
-		writeJSONStatusResponse(w, "failure", "Task could not be added")
+		return time.Time{}, fieldError(name, "must be an RFC 3339 time")
+	}
+	return t, nil
+}
+
+func (p *TaskServer) taskHandler(w http.ResponseWriter, r *http.Request) {
+	task, ok := p.getTaskFromRequest(w, r)
+	if !ok {
 		return
 	}
 
-	err = task.Validate()
-	if err != nil {
-		log.Printf("Validation failed, %v", err)
-		w.WriteHeader(http.StatusBadRequest)
-		writeJSONStatusResponse(w, "failure", "Task could not be added")
+	writeJSON(w, task)
+}
+
+func (p *TaskServer) newTaskHandler(w http.ResponseWriter, r *http.Request) {
+	var task Task
+	if !decodeJSON(w, r, &task) {
 		return
 	}
 
-	id, err := p.taskList.Add(task.Name)
+	p.addTask(w, r, task)
+}
+
+func (p *TaskServer) addTask(w http.ResponseWriter, r *http.Request, task Task) {
+	task.Complete = false
+	_, err := p.tasks(r).AddTask(&task)
 	if err != nil {
-		log.Printf("Could not add task %v, %v", task, err)
-		w.WriteHeader(http.StatusInternalServerError)
-		writeJSONStatusResponse(w, "failure", "Task could not be added")
+		writeError(w, r, err)
 		return
 	}
-	task.Id = id
 	w.WriteHeader(http.StatusCreated)
 	writeTasksJSON(w, []Task{task})
 }
 
 func (p *TaskServer) taskStatusToggleHandler(w http.ResponseWriter, r *http.Request) {
+	task, ok := p.getTaskFromRequest(w, r)
+	if !ok {
+		return
+	}
+
+	err := p.tasks(r).ToggleStatus(&task)
+	if err != nil {
+		writeError(w, r, err)
+		return
+	}
+	w.WriteHeader(http.StatusAccepted)
+}
+
+// taskCompleteHandler marks a task as complete. Unlike the legacy toggle it
+// is idempotent, so clients can safely retry it.
+func (p *TaskServer) taskCompleteHandler(w http.ResponseWriter, r *http.Request) {
+	task, ok := p.getTaskFromRequest(w, r)
+	if !ok {
+		return
+	}
+
+	err := p.tasks(r).Complete(&task)
+	if err != nil {
+		writeError(w, r, err)
+		return
+	}
+	p.writeCurrentTask(w, r, task.Id)
+}
+
+func (p *TaskServer) taskReopenHandler(w http.ResponseWriter, r *http.Request) {
+	task, ok := p.getTaskFromRequest(w, r)
+	if !ok {
+		return
+	}
+
+	err := p.tasks(r).Reopen(&task)
+	if err != nil {
+		writeError(w, r, err)
+		return
+	}
+	p.writeCurrentTask(w, r, task.Id)
+}
+
+// taskMoveHandler moves a task between the tasks given by "after" and
+// "before" in the request body, either of which may be left out to place it
+// next to the other.
+func (p *TaskServer) taskMoveHandler(w http.ResponseWriter, r *http.Request) {
+	task, ok := p.getTaskFromRequest(w, r)
+	if !ok {
+		return
+	}
+
+	var move struct {
+		Before TaskId `json:"before"`
+		After  TaskId `json:"after"`
+	}
+	if !decodeJSON(w, r, &move) {
+		return
+	}
+
+	err := p.tasks(r).Move(task.Id, move.Before, move.After)
+	if err != nil {
+		writeError(w, r, err)
+		return
+	}
+	p.writeCurrentTask(w, r, task.Id)
+}
+
+// writeCurrentTask responds with the stored state of a task after it has
+// been changed.
+func (p *TaskServer) writeCurrentTask(w http.ResponseWriter, r *http.Request, id TaskId) {
+	task, err := p.tasks(r).GetOne(id)
+	if err != nil {
+		writeError(w, r, err)
+		return
+	}
+	writeJSON(w, task)
+}
+
+func (p *TaskServer) taskReplaceHandler(w http.ResponseWriter, r *http.Request) {
+	existing, ok := p.getTaskFromRequest(w, r)
+	if !ok {
+		return
+	}
+
+	var task Task
+	if !decodeJSON(w, r, &task) {
+		return
+	}
+	task.Id = existing.Id
+
+	p.updateTask(w, r, task)
+}
+
+// taskPatchHandler applies a JSON merge patch to a task, so only the fields
+// present in the request are changed and null removes optional fields.
+func (p *TaskServer) taskPatchHandler(w http.ResponseWriter, r *http.Request) {
+	existing, ok := p.getTaskFromRequest(w, r)
+	if !ok {
+		return
+	}
+
+	patch, err := io.ReadAll(r.Body)
+	if err != nil {
+		writeError(w, r, err)
+		return
+	}
+
+	doc, err := json.Marshal(existing)
+	if err != nil {
+		writeError(w, r, err)
+		return
+	}
+
+	patched, err := mergePatch(doc, patch)
+	if err != nil {
+		writeProblem(w, newProblem(r, http.StatusBadRequest, "The request body is not valid JSON: "+err.Error()))
+		return
+	}
+
+	var task Task
+	err = json.Unmarshal(patched, &task)
+	if err != nil {
+		writeProblem(w, newProblem(r, http.StatusBadRequest, "The patched task is not valid: "+err.Error()))
+		return
+	}
+	task.Id = existing.Id
+
+	p.updateTask(w, r, task)
+}
+
+func (p *TaskServer) updateTask(w http.ResponseWriter, r *http.Request, task Task) {
+	err := p.tasks(r).Update(&task)
+	if err != nil {
+		writeError(w, r, err)
+		return
+	}
+	writeJSON(w, task)
+}
+
+// getTaskFromRequest looks up the task named by the taskID URL parameter,
+// writing a problem response if it can't be found.
+func (p *TaskServer) getTaskFromRequest(w http.ResponseWriter, r *http.Request) (Task, bool) {
+	id, ok := taskIdFromRequest(w, r)
+	if !ok {
+		return Task{}, false
+	}
+
+	task, err := p.tasks(r).GetOne(id)
+	if err != nil {
+		writeError(w, r, err)
+		return Task{}, false
+	}
+	return task, true
+}
+
+func taskIdFromRequest(w http.ResponseWriter, r *http.Request) (TaskId, bool) {
 	idParam := chi.URLParam(r, "taskID")
 	id, err := strconv.Atoi(idParam)
 	if err != nil {
-		log.Printf("Invalid taskID given %v", err)
-		w.WriteHeader(http.StatusNotFound)
+		writeProblem(w, newProblem(r, http.StatusNotFound, "task "+idParam+": "+ErrNotFound.Error()))
+		return 0, false
+	}
+	return TaskId(id), true
+}
+
+// subtasksHandler returns the subtasks of a task, each with their own
+// subtasks nested under them.
+func (p *TaskServer) subtasksHandler(w http.ResponseWriter, r *http.Request) {
+	task, ok := p.getTaskFromRequest(w, r)
+	if !ok {
 		return
 	}
-	taskId := TaskId(id)
 
-	task, err := p.taskList.GetOne(taskId)
+	subtasks, err := p.tasks(r).GetSubtasks(task.Id)
 	if err != nil {
-		log.Printf("Could not get task with id %d, %v", taskId, err)
-		w.WriteHeader(http.StatusNotFound)
+		writeError(w, r, err)
 		return
 	}
 
-	err = p.taskList.ToggleStatus(&task)
+	writeJSON(w, subtasks)
+}
+
+// taskDeleteHandler moves a task to the trash. Tasks with subtasks are only
+// deleted, along with their subtasks, when the "cascade" parameter is true.
+func (p *TaskServer) taskDeleteHandler(w http.ResponseWriter, r *http.Request) {
+	task, ok := p.getTaskFromRequest(w, r)
+	if !ok {
+		return
+	}
+
+	cascade := false
+	if param := r.URL.Query().Get("cascade"); param != "" {
+		var err error
+		cascade, err = strconv.ParseBool(param)
+		if err != nil {
+			writeError(w, r, fieldError("cascade", "must be true or false"))
+			return
+		}
+	}
+
+	var err error
+	if cascade {
+		err = p.tasks(r).DeleteWithSubtasks(&task)
+	} else {
+		err = p.tasks(r).Delete(&task)
+	}
 	if err != nil {
-		log.Printf("Could not toggle status of task %v, %v", task, err)
-		w.WriteHeader(http.StatusInternalServerError)
+		writeError(w, r, err)
 		return
 	}
 	w.WriteHeader(http.StatusAccepted)
 }
 
-func (p *TaskServer) taskDeleteHandler(w http.ResponseWriter, r *http.Request) {
-	idParam := chi.URLParam(r, "taskID")
-	id, err := strconv.Atoi(idParam)
+// taskHistoryHandler returns a page of the changes made to a task, most
+// recent first, which is still available after the task is deleted.
+func (p *TaskServer) taskHistoryHandler(w http.ResponseWriter, r *http.Request) {
+	id, ok := taskIdFromRequest(w, r)
+	if !ok {
+		return
+	}
+	limit, err := parseLimit(r)
 	if err != nil {
-		log.Printf("Invalid taskID given %v", err)
-		w.WriteHeader(http.StatusNotFound)
+		writeError(w, r, err)
 		return
 	}
-	taskId := TaskId(id)
 
-	task, err := p.taskList.GetOne(taskId)
+	page, err := p.tasks(r).GetHistory(id, limit, r.URL.Query().Get("cursor"))
 	if err != nil {
-		log.Printf("Could not get task with id %d, %v", taskId, err)
-		w.WriteHeader(http.StatusNotFound)
+		writeError(w, r, err)
 		return
 	}
 
-	err = p.taskList.Delete(&task)
+	setPageLinks(w, r, page.NextCursor, page.PrevCursor)
+	writeJSON(w, page.Events)
+}
+
+// activityHandler returns a page of the changes made to every task, most
+// recent first.
+func (p *TaskServer) activityHandler(w http.ResponseWriter, r *http.Request) {
+	limit, err := parseLimit(r)
 	if err != nil {
-		log.Printf("Could not delete task %v, %v", task, err)
-		w.WriteHeader(http.StatusInternalServerError)
+		writeError(w, r, err)
+		return
+	}
+
+	page, err := p.tasks(r).GetActivity(limit, r.URL.Query().Get("cursor"))
+	if err != nil {
+		writeError(w, r, err)
+		return
+	}
+
+	setPageLinks(w, r, page.NextCursor, page.PrevCursor)
+	writeJSON(w, page.Events)
+}
+
+// taskRestoreHandler takes a task out of the trash and returns it.
+func (p *TaskServer) taskRestoreHandler(w http.ResponseWriter, r *http.Request) {
+	id, ok := taskIdFromRequest(w, r)
+	if !ok {
+		return
+	}
+
+	task, err := p.tasks(r).Restore(id)
+	if err != nil {
+		writeError(w, r, err)
+		return
+	}
+	writeJSON(w, task)
+}
+
+// trashHandler returns the deleted tasks, most recently deleted first.
+func (p *TaskServer) trashHandler(w http.ResponseWriter, r *http.Request) {
+	tasks, err := p.tasks(r).GetTrash()
+	if err != nil {
+		writeError(w, r, err)
+		return
+	}
+
+	writeTasksJSON(w, tasks)
+}
+
+// emptyTrashHandler permanently deletes every task in the trash and returns
+// how many there were.
+func (p *TaskServer) emptyTrashHandler(w http.ResponseWriter, r *http.Request) {
+	purged, err := p.tasks(r).Purge(0)
+	if err != nil {
+		writeError(w, r, err)
+		return
+	}
+
+	writeJSON(w, map[string]int{"purged": purged})
+}
+
+// tagsHandler returns every tag in use with the number of tasks using it.
+func (p *TaskServer) tagsHandler(w http.ResponseWriter, r *http.Request) {
+	tags, err := p.tasks(r).GetTags()
+	if err != nil {
+		writeError(w, r, err)
+		return
+	}
+
+	writeJSON(w, tags)
+}
+
+func (p *TaskServer) listsHandler(w http.ResponseWriter, r *http.Request) {
+	lists, err := p.tasks(r).GetLists()
+	if err != nil {
+		writeError(w, r, err)
+		return
+	}
+
+	writeJSON(w, lists)
+}
+
+func (p *TaskServer) newListHandler(w http.ResponseWriter, r *http.Request) {
+	var list List
+	if !decodeJSON(w, r, &list) {
+		return
+	}
+
+	id, err := p.tasks(r).AddList(list.Name)
+	if err != nil {
+		writeError(w, r, err)
+		return
+	}
+	list.Id = id
+	w.WriteHeader(http.StatusCreated)
+	writeJSON(w, list)
+}
+
+func (p *TaskServer) listHandler(w http.ResponseWriter, r *http.Request) {
+	list, ok := p.getListFromRequest(w, r)
+	if !ok {
+		return
+	}
+
+	writeJSON(w, list)
+}
+
+func (p *TaskServer) listDeleteHandler(w http.ResponseWriter, r *http.Request) {
+	list, ok := p.getListFromRequest(w, r)
+	if !ok {
+		return
+	}
+
+	err := p.tasks(r).DeleteList(&list)
+	if err != nil {
+		writeError(w, r, err)
 		return
 	}
 	w.WriteHeader(http.StatusAccepted)
 }
 
-func writeTasksJSON(w http.ResponseWriter, tasks []Task) {
-	encoder := json.NewEncoder(w)
-	err := encoder.Encode(tasks)
+func (p *TaskServer) listTasksHandler(w http.ResponseWriter, r *http.Request) {
+	list, ok := p.getListFromRequest(w, r)
+	if !ok {
+		return
+	}
+
+	p.queryTasks(w, r, list.Id)
+}
+
+func (p *TaskServer) newListTaskHandler(w http.ResponseWriter, r *http.Request) {
+	list, ok := p.getListFromRequest(w, r)
+	if !ok {
+		return
+	}
 
+	var task Task
+	if !decodeJSON(w, r, &task) {
+		return
+	}
+
+	task.ListId = list.Id
+	p.addTask(w, r, task)
+}
+
+func (p *TaskServer) membersHandler(w http.ResponseWriter, r *http.Request) {
+	list, ok := p.getListFromRequest(w, r)
+	if !ok {
+		return
+	}
+
+	members, err := p.tasks(r).GetMembers(list.Id)
 	if err != nil {
-		log.Printf("Could not encode json %v", err)
-		w.WriteHeader(http.StatusInternalServerError)
+		writeError(w, r, err)
+		return
+	}
+	writeJSON(w, members)
+}
+
+// newMemberHandler shares a list with a user given by username, or changes
+// their role if it is already shared with them.
+func (p *TaskServer) newMemberHandler(w http.ResponseWriter, r *http.Request) {
+	list, ok := p.getListFromRequest(w, r)
+	if !ok {
+		return
+	}
+
+	var body struct {
+		Username string `json:"username"`
+		Role     Role   `json:"role"`
+	}
+	if !decodeJSON(w, r, &body) {
+		return
+	}
+
+	user, err := p.accounts.GetUserByName(body.Username)
+	if errors.Is(err, ErrNotFound) {
+		writeError(w, r, fieldError("username", "does not exist"))
 		return
 	}
+	if err != nil {
+		writeError(w, r, err)
+		return
+	}
+	member, err := p.tasks(r).ShareList(list.Id, user, body.Role)
+	if err != nil {
+		writeError(w, r, err)
+		return
+	}
+	w.WriteHeader(http.StatusCreated)
+	writeJSON(w, member)
+}
+
+// memberDeleteHandler stops sharing a list with a user, which is how members
+// leave a list too.
+func (p *TaskServer) memberDeleteHandler(w http.ResponseWriter, r *http.Request) {
+	list, ok := p.getListFromRequest(w, r)
+	if !ok {
+		return
+	}
+	idParam := chi.URLParam(r, "userID")
+	id, err := strconv.ParseInt(idParam, 10, 64)
+	if err != nil {
+		writeProblem(w, newProblem(r, http.StatusNotFound, "member "+idParam+": "+ErrNotFound.Error()))
+		return
+	}
+
+	err = p.tasks(r).Unshare(list.Id, UserId(id))
+	if err != nil {
+		writeError(w, r, err)
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// getListFromRequest looks up the list named by the listID URL parameter,
+// writing a problem response if it can't be found.
+func (p *TaskServer) getListFromRequest(w http.ResponseWriter, r *http.Request) (List, bool) {
+	idParam := chi.URLParam(r, "listID")
+	id, err := strconv.Atoi(idParam)
+	if err != nil {
+		writeProblem(w, newProblem(r, http.StatusNotFound, "list "+idParam+": "+ErrNotFound.Error()))
+		return List{}, false
+	}
+
+	list, err := p.tasks(r).GetList(ListId(id))
+	if err != nil {
+		writeError(w, r, err)
+		return List{}, false
+	}
+	return list, true
+}
+
+func writeTasksJSON(w http.ResponseWriter, tasks []Task) {
+	writeJSON(w, tasks)
 }
 
-func writeJSONStatusResponse(w http.ResponseWriter, status, message string) {
-	encoder := json.NewEncoder(w)
-	s := StatusResponse{status, message}
-	err := encoder.Encode(s)
+func writeJSON(w http.ResponseWriter, v any) {
+	err := json.NewEncoder(w).Encode(v)
 
 	if err != nil {
 		log.Printf("Could not encode json %v", err)
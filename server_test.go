@@ -1,14 +1,22 @@
 package todo_test
 
 import (
+	"bufio"
 	"bytes"
+	"context"
 	"encoding/json"
+	"errors"
 	"net/http"
 	"net/http/httptest"
 	"reflect"
+	"strconv"
+	"strings"
 	"testing"
+	"time"
 
+	"github.com/gorilla/websocket"
 	"github.com/rosswf/go-todo"
+	storage "github.com/rosswf/go-todo/storage"
 )
 
 var dummyData = []todo.Task{
@@ -16,18 +24,20 @@ var dummyData = []todo.Task{
 		Id:       1,
 		Name:     "Task 1",
 		Complete: false,
+		ListId:   1,
 	},
 	{
 		Id:       2,
 		Name:     "Task 2",
 		Complete: true,
+		ListId:   1,
 	},
 }
 
 func TestGETTasks(t *testing.T) {
-	storage := CreateMockStorage(dummyData)
+	storage := storage.CreateMemoryTaskStorage(dummyData)
 	taskList := todo.CreateTaskList(storage)
-	server := todo.NewTaskServer(taskList)
+	server := newTestServer(t, storage, taskList)
 
 	t.Run("test /tasks returns a list of tasks", func(t *testing.T) {
 		request, _ := http.NewRequest(http.MethodGet, "/tasks", nil)
@@ -60,6 +70,7 @@ func TestGETTasks(t *testing.T) {
 				Id:       1,
 				Name:     "Task 1",
 				Complete: false,
+				ListId:   1,
 			},
 		}
 
@@ -82,6 +93,7 @@ func TestGETTasks(t *testing.T) {
 			Id:       2,
 			Name:     "Task 2",
 			Complete: true,
+			ListId:   1,
 		}
 
 		if !reflect.DeepEqual(got, want) {
@@ -96,15 +108,222 @@ func TestGETTasks(t *testing.T) {
 		server.ServeHTTP(response, request)
 
 		assertStatus(t, response.Code, http.StatusNotFound)
+		assertProblemContentType(t, response)
+	})
+
+	t.Run("test /tasks/9 returns a not found problem", func(t *testing.T) {
+		request, _ := http.NewRequest(http.MethodGet, "/tasks/9", nil)
+		response := httptest.NewRecorder()
+
+		server.ServeHTTP(response, request)
+
+		assertStatus(t, response.Code, http.StatusNotFound)
+		assertProblemContentType(t, response)
+
+		got := decodeProblem(t, response.Body)
+		want := todo.Problem{
+			Type:     "about:blank",
+			Title:    "Not Found",
+			Status:   http.StatusNotFound,
+			Detail:   "task 9: not found",
+			Instance: "/tasks/9",
+		}
+
+		if !reflect.DeepEqual(got, want) {
+			t.Errorf("got response %+v, want %+v", got, want)
+		}
+	})
+}
+
+func TestStorageErrors(t *testing.T) {
+	storage := &FailingTaskStorage{storage.CreateMemoryTaskStorage([]todo.Task{})}
+	taskList := todo.CreateTaskList(storage)
+	server := newTestServer(t, storage, taskList)
+
+	t.Run("test a storage failure returns 500 rather than 404", func(t *testing.T) {
+		request, _ := http.NewRequest(http.MethodGet, "/tasks/1", nil)
+		response := httptest.NewRecorder()
+
+		server.ServeHTTP(response, request)
+
+		assertStatus(t, response.Code, http.StatusInternalServerError)
+
+		got := decodeProblem(t, response.Body)
+		if got.Detail != "An unexpected error occurred" {
+			t.Errorf("got detail %q, storage errors should not be exposed", got.Detail)
+		}
+	})
+
+	t.Run("test an unsupported method returns a problem", func(t *testing.T) {
+		request, _ := http.NewRequest(http.MethodPut, "/tasks", nil)
+		response := httptest.NewRecorder()
+
+		server.ServeHTTP(response, request)
+
+		assertStatus(t, response.Code, http.StatusMethodNotAllowed)
+		assertProblemContentType(t, response)
+	})
+}
+
+// FailingTaskStorage fails to read any task, as a broken database would.
+type FailingTaskStorage struct {
+	*storage.MemoryTaskStorage
+}
+
+func (f *FailingTaskStorage) GetTask(id todo.TaskId) (*todo.Task, error) {
+	return nil, errors.New("disk I/O error")
+}
+
+func (f *FailingTaskStorage) ForUser(id todo.UserId) todo.TaskStorage {
+	return &FailingTaskStorage{f.MemoryTaskStorage.ForUser(id).(*storage.MemoryTaskStorage)}
+}
+
+func TestGETTaskPages(t *testing.T) {
+	storage := storage.CreateMemoryTaskStorage([]todo.Task{
+		{Id: 1, Name: "Task 1", ListId: 1},
+		{Id: 2, Name: "Task 2", ListId: 1, Complete: true},
+		{Id: 3, Name: "Task 3", ListId: 1},
+		{Id: 4, Name: "Task 4", ListId: 1},
+	})
+	taskList := todo.CreateTaskList(storage)
+	server := newTestServer(t, storage, taskList)
+
+	t.Run("test /tasks pages with Link headers", func(t *testing.T) {
+		request, _ := http.NewRequest(http.MethodGet, "/tasks?limit=1&complete=false&sort=-id", nil)
+		response := httptest.NewRecorder()
+
+		server.ServeHTTP(response, request)
+		assertStatus(t, response.Code, http.StatusOK)
+
+		got := decodeTaskList(t, response.Body)
+		want := []todo.Task{{Id: 4, Name: "Task 4", ListId: 1}}
+
+		if !reflect.DeepEqual(got, want) {
+			t.Errorf("got response %+v, want %+v", got, want)
+		}
+
+		link := response.Header().Get("Link")
+		wantLink := `</tasks?complete=false&cursor=MQ&limit=1&sort=-id>; rel="next"`
+		if link != wantLink {
+			t.Fatalf("got Link %q, want %q", link, wantLink)
+		}
+
+		request, _ = http.NewRequest(http.MethodGet, "/tasks?complete=false&cursor=MQ&limit=1&sort=-id", nil)
+		response = httptest.NewRecorder()
+
+		server.ServeHTTP(response, request)
+		assertStatus(t, response.Code, http.StatusOK)
+
+		got = decodeTaskList(t, response.Body)
+		want = []todo.Task{{Id: 3, Name: "Task 3", ListId: 1}}
+
+		if !reflect.DeepEqual(got, want) {
+			t.Errorf("got response %+v, want %+v", got, want)
+		}
+
+		link = response.Header().Get("Link")
+		wantLink = `</tasks?complete=false&cursor=Mg&limit=1&sort=-id>; rel="next", </tasks?complete=false&cursor=MA&limit=1&sort=-id>; rel="prev"`
+		if link != wantLink {
+			t.Errorf("got Link %q, want %q", link, wantLink)
+		}
+	})
+
+	t.Run("test /tasks filters by name", func(t *testing.T) {
+		request, _ := http.NewRequest(http.MethodGet, "/tasks?name=task%202", nil)
+		response := httptest.NewRecorder()
+
+		server.ServeHTTP(response, request)
+		assertStatus(t, response.Code, http.StatusOK)
+
+		got := decodeTaskList(t, response.Body)
+		want := []todo.Task{{Id: 2, Name: "Task 2", ListId: 1, Complete: true}}
+
+		if !reflect.DeepEqual(got, want) {
+			t.Errorf("got response %+v, want %+v", got, want)
+		}
+	})
+
+	for _, params := range []string{"sort=colour", "limit=0", "limit=lots", "complete=maybe", "cursor=%3F"} {
+		t.Run("test /tasks?"+params+" returns 400", func(t *testing.T) {
+			request, _ := http.NewRequest(http.MethodGet, "/tasks?"+params, nil)
+			response := httptest.NewRecorder()
+
+			server.ServeHTTP(response, request)
+			assertStatus(t, response.Code, http.StatusBadRequest)
+			assertProblemContentType(t, response)
+		})
+	}
+}
+
+func TestGETTags(t *testing.T) {
+	storage := storage.CreateMemoryTaskStorage([]todo.Task{
+		{Id: 1, Name: "Task 1", ListId: 1, Tags: []string{"home"}},
+		{Id: 2, Name: "Task 2", ListId: 1, Tags: []string{"errands", "home"}},
+		{Id: 3, Name: "Task 3", ListId: 1},
+	})
+	taskList := todo.CreateTaskList(storage)
+	server := newTestServer(t, storage, taskList)
+
+	t.Run("test /tags returns the tags in use", func(t *testing.T) {
+		request, _ := http.NewRequest(http.MethodGet, "/tags", nil)
+		response := httptest.NewRecorder()
+
+		server.ServeHTTP(response, request)
+		assertStatus(t, response.Code, http.StatusOK)
+		assertJSONContentType(t, response)
+
+		var got []todo.Tag
+		err := json.NewDecoder(response.Body).Decode(&got)
+		if err != nil {
+			t.Fatalf("Unable to parse response from server %q, '%v'", response.Body, err)
+		}
+		want := []todo.Tag{{Name: "errands", Count: 1}, {Name: "home", Count: 2}}
+
+		if !reflect.DeepEqual(got, want) {
+			t.Errorf("got response %+v, want %+v", got, want)
+		}
+	})
+
+	t.Run("test /tasks filters by tag", func(t *testing.T) {
+		request, _ := http.NewRequest(http.MethodGet, "/tasks?tag=%23Errands", nil)
+		response := httptest.NewRecorder()
+
+		server.ServeHTTP(response, request)
+		assertStatus(t, response.Code, http.StatusOK)
+
+		got := decodeTaskList(t, response.Body)
+		want := []todo.Task{{Id: 2, Name: "Task 2", ListId: 1, Tags: []string{"errands", "home"}}}
+
+		if !reflect.DeepEqual(got, want) {
+			t.Errorf("got response %+v, want %+v", got, want)
+		}
+	})
+
+	t.Run("test POST /tasks with an invalid tag returns 400", func(t *testing.T) {
+		body := bytes.NewBufferString(`{"name": "Task 4", "tags": ["not valid"]}`)
+		request, _ := http.NewRequest(http.MethodPost, "/tasks", body)
+		response := httptest.NewRecorder()
+
+		server.ServeHTTP(response, request)
+		assertStatus(t, response.Code, http.StatusBadRequest)
+
+		got := decodeProblem(t, response.Body)
+		want := []todo.FieldError{{Field: "tags[0]", Reason: "must only contain lowercase letters, numbers, - and _"}}
+
+		if !reflect.DeepEqual(got.Errors, want) {
+			t.Errorf("got errors %+v, want %+v", got.Errors, want)
+		}
 	})
 }
 
 func TestPOSTTasks(t *testing.T) {
+	now := time.Date(2022, 10, 1, 12, 0, 0, 0, time.UTC)
 	data := []todo.Task{}
 
-	storage := CreateMockStorage(data)
+	storage := storage.CreateMemoryTaskStorage(data)
 	taskList := todo.CreateTaskList(storage)
-	server := todo.NewTaskServer(taskList)
+	taskList.SetClock(func() time.Time { return now })
+	server := newTestServer(t, storage, taskList)
 
 	t.Run("test POST to /tasks (Create new task) returns task", func(t *testing.T) {
 		jsonData := []byte(`{"Name": "New Task"}`)
@@ -115,7 +334,7 @@ func TestPOSTTasks(t *testing.T) {
 		assertStatus(t, response.Code, http.StatusCreated)
 
 		got := response.Body.String()
-		want := `[{"id":1,"name":"New Task","complete":false}]
+		want := `[{"id":1,"name":"New Task","complete":false,"list_id":1,"created_at":"2022-10-01T12:00:00Z","position":"V"}]
 `
 		if got != want {
 			t.Errorf("got response '%v', want '%v'", got, want)
@@ -138,7 +357,7 @@ func TestPOSTTasks(t *testing.T) {
 		assertStatus(t, response.Code, http.StatusOK)
 
 		got := decodeTaskList(t, response.Body)
-		want := []todo.Task{{Id: 1, Name: "New Task", Complete: true}}
+		want := []todo.Task{{Id: 1, Name: "New Task", Complete: true, ListId: 1, CompletedAt: &now, CreatedAt: &now, Position: "V"}}
 
 		if !reflect.DeepEqual(got, want) {
 			t.Errorf("got response %+v, want %+v", got, want)
@@ -152,22 +371,240 @@ func TestPOSTTasks(t *testing.T) {
 
 		server.ServeHTTP(response, request)
 		assertStatus(t, response.Code, http.StatusBadRequest)
+		assertProblemContentType(t, response)
+
+		got := decodeProblem(t, response.Body)
+		want := todo.Problem{
+			Type:     "about:blank",
+			Title:    "Bad Request",
+			Status:   http.StatusBadRequest,
+			Detail:   "The request body is not valid JSON: json: cannot unmarshal string into Go struct field Task.Id of type todo.TaskId",
+			Instance: "/tasks",
+		}
+
+		if !reflect.DeepEqual(got, want) {
+			t.Errorf("got response %+v, want %+v", got, want)
+		}
+	})
+
+	t.Run("test POST of an invalid task to /tasks returns field errors", func(t *testing.T) {
+		jsonData := []byte(`{"name": "", "list_id": 9}`)
+		request, _ := http.NewRequest(http.MethodPost, "/tasks", bytes.NewBuffer(jsonData))
+		response := httptest.NewRecorder()
+
+		server.ServeHTTP(response, request)
+		assertStatus(t, response.Code, http.StatusBadRequest)
+		assertProblemContentType(t, response)
+
+		got := decodeProblem(t, response.Body)
+		want := []todo.FieldError{{Field: "name", Reason: "is required"}}
+
+		if !reflect.DeepEqual(got.Errors, want) {
+			t.Errorf("got errors %+v, want %+v", got.Errors, want)
+		}
+	})
+
+	t.Run("test POST of a recurring task to /tasks returns the canonical rule", func(t *testing.T) {
+		jsonData := []byte(`{"name": "Bins", "recurrence": "freq=weekly;byday=th"}`)
+		request, _ := http.NewRequest(http.MethodPost, "/tasks", bytes.NewBuffer(jsonData))
+		response := httptest.NewRecorder()
+
+		server.ServeHTTP(response, request)
+		assertStatus(t, response.Code, http.StatusCreated)
+
+		got := response.Body.String()
+		want := `[{"id":2,"name":"Bins","complete":false,"list_id":1,"created_at":"2022-10-01T12:00:00Z","position":"W","recurrence":"FREQ=WEEKLY;BYDAY=TH"}]
+`
+		if got != want {
+			t.Errorf("got response '%v', want '%v'", got, want)
+		}
+	})
+
+	t.Run("test POST to /tasks ignores the timestamps in the request", func(t *testing.T) {
+		jsonData := []byte(`{"name": "x", "completed_at": "2001-01-01T00:00:00Z",
+"created_at": "1999-01-01T00:00:00Z", "trashed_at": "2001-01-01T00:00:00Z"}`)
+		request, _ := http.NewRequest(http.MethodPost, "/tasks", bytes.NewBuffer(jsonData))
+		response := httptest.NewRecorder()
+
+		server.ServeHTTP(response, request)
+		assertStatus(t, response.Code, http.StatusCreated)
+
+		request, _ = http.NewRequest(http.MethodGet, "/tasks/3", nil)
+		response = httptest.NewRecorder()
+
+		server.ServeHTTP(response, request)
+		assertStatus(t, response.Code, http.StatusOK)
+
+		got := decodeTask(t, response.Body)
+		want := todo.Task{Id: 3, Name: "x", ListId: 1, CreatedAt: &now, Position: "X"}
+
+		if !reflect.DeepEqual(got, want) {
+			t.Errorf("got response %+v, want %+v", got, want)
+		}
+	})
+}
+
+func TestUpdateTasks(t *testing.T) {
+	now := time.Date(2022, 10, 2, 9, 0, 0, 0, time.UTC)
+	storage := storage.CreateMemoryTaskStorage([]todo.Task{
+		{Id: 1, Name: "Task 1", ListId: 1},
+	})
+	taskList := todo.CreateTaskList(storage)
+	taskList.SetClock(func() time.Time { return now })
+	server := newTestServer(t, storage, taskList)
+
+	t.Run("test PUT to /tasks/1 replaces the task", func(t *testing.T) {
+		jsonData := []byte(`{"name": "Replaced", "complete": true, "due": "2022-10-01T12:00:00Z"}`)
+		request, _ := http.NewRequest(http.MethodPut, "/tasks/1", bytes.NewBuffer(jsonData))
+		response := httptest.NewRecorder()
+
+		server.ServeHTTP(response, request)
+		assertStatus(t, response.Code, http.StatusOK)
 
 		got := response.Body.String()
-		want := `{"status":"failure","message":"Task could not be added"}
+		want := `{"id":1,"name":"Replaced","complete":true,"list_id":1,"due":"2022-10-01T12:00:00Z","completed_at":"2022-10-02T09:00:00Z"}
 `
 		if got != want {
 			t.Errorf("got response '%v', want '%v'", got, want)
 		}
+	})
+
+	t.Run("test PATCH to /tasks/1 only changes the given fields", func(t *testing.T) {
+		jsonData := []byte(`{"name": "Patched", "due": null}`)
+		request, _ := http.NewRequest(http.MethodPatch, "/tasks/1", bytes.NewBuffer(jsonData))
+		request.Header.Set("content-type", "application/merge-patch+json")
+		response := httptest.NewRecorder()
+
+		server.ServeHTTP(response, request)
+		assertStatus(t, response.Code, http.StatusOK)
+
+		got := decodeTask(t, response.Body)
+		want := todo.Task{Id: 1, Name: "Patched", Complete: true, ListId: 1, CompletedAt: &now}
+
+		if !reflect.DeepEqual(got, want) {
+			t.Errorf("got response %+v, want %+v", got, want)
+		}
+	})
+
+	t.Run("test PATCH that fails validation returns 400", func(t *testing.T) {
+		jsonData := []byte(`{"name": null}`)
+		request, _ := http.NewRequest(http.MethodPatch, "/tasks/1", bytes.NewBuffer(jsonData))
+		response := httptest.NewRecorder()
+
+		server.ServeHTTP(response, request)
+		assertStatus(t, response.Code, http.StatusBadRequest)
+	})
+
+	t.Run("test PUT to a missing task returns 404", func(t *testing.T) {
+		jsonData := []byte(`{"name": "Replaced"}`)
+		request, _ := http.NewRequest(http.MethodPut, "/tasks/9", bytes.NewBuffer(jsonData))
+		response := httptest.NewRecorder()
+
+		server.ServeHTTP(response, request)
+		assertStatus(t, response.Code, http.StatusNotFound)
+	})
+}
+
+func TestCompleteTasks(t *testing.T) {
+	now := time.Date(2022, 10, 1, 12, 0, 0, 0, time.UTC)
+	storage := storage.CreateMemoryTaskStorage([]todo.Task{
+		{Id: 1, Name: "Task 1", ListId: 1},
+	})
+	taskList := todo.CreateTaskList(storage)
+	taskList.SetClock(func() time.Time { return now })
+	server := newTestServer(t, storage, taskList)
+
+	for _, attempt := range []string{"first", "retried"} {
+		t.Run("test "+attempt+" PUT to /tasks/1/complete completes the task", func(t *testing.T) {
+			request, _ := http.NewRequest(http.MethodPut, "/tasks/1/complete", nil)
+			response := httptest.NewRecorder()
+
+			server.ServeHTTP(response, request)
+			assertStatus(t, response.Code, http.StatusOK)
+
+			got := response.Body.String()
+			want := `{"id":1,"name":"Task 1","complete":true,"list_id":1,"completed_at":"2022-10-01T12:00:00Z"}
+`
+			if got != want {
+				t.Errorf("got response '%v', want '%v'", got, want)
+			}
+		})
+	}
+
+	for _, attempt := range []string{"first", "retried"} {
+		t.Run("test "+attempt+" DELETE to /tasks/1/complete reopens the task", func(t *testing.T) {
+			request, _ := http.NewRequest(http.MethodDelete, "/tasks/1/complete", nil)
+			response := httptest.NewRecorder()
+
+			server.ServeHTTP(response, request)
+			assertStatus(t, response.Code, http.StatusOK)
+
+			got := response.Body.String()
+			want := `{"id":1,"name":"Task 1","complete":false,"list_id":1}
+`
+			if got != want {
+				t.Errorf("got response '%v', want '%v'", got, want)
+			}
+		})
+	}
+
+	t.Run("test PUT to /tasks/9/complete returns 404", func(t *testing.T) {
+		request, _ := http.NewRequest(http.MethodPut, "/tasks/9/complete", nil)
+		response := httptest.NewRecorder()
+
+		server.ServeHTTP(response, request)
+		assertStatus(t, response.Code, http.StatusNotFound)
+	})
+}
+
+func TestMoveTasks(t *testing.T) {
+	storage := storage.CreateMemoryTaskStorage([]todo.Task{})
+	taskList := todo.CreateTaskList(storage)
+	taskList.Add("Task 1")
+	taskList.Add("Task 2")
+	taskList.Add("Task 3")
+	server := newTestServer(t, storage, taskList)
+
+	t.Run("test POST to /tasks/3/move moves the task", func(t *testing.T) {
+		body := bytes.NewBufferString(`{"before": 2, "after": 1}`)
+		request, _ := http.NewRequest(http.MethodPost, "/tasks/3/move", body)
+		response := httptest.NewRecorder()
+
+		server.ServeHTTP(response, request)
+		assertStatus(t, response.Code, http.StatusOK)
+		assertJSONContentType(t, response)
+
+		request, _ = http.NewRequest(http.MethodGet, "/tasks?sort=position", nil)
+		response = httptest.NewRecorder()
+
+		server.ServeHTTP(response, request)
+
+		got := []string{}
+		for _, task := range decodeTaskList(t, response.Body) {
+			got = append(got, task.Name)
+		}
+		want := []string{"Task 1", "Task 3", "Task 2"}
 
+		if !reflect.DeepEqual(got, want) {
+			t.Errorf("got response %+v, want %+v", got, want)
+		}
 	})
 
+	t.Run("test POST to /tasks/3/move without a neighbour returns 400", func(t *testing.T) {
+		body := bytes.NewBufferString(`{}`)
+		request, _ := http.NewRequest(http.MethodPost, "/tasks/3/move", body)
+		response := httptest.NewRecorder()
+
+		server.ServeHTTP(response, request)
+		assertStatus(t, response.Code, http.StatusBadRequest)
+		assertProblemContentType(t, response)
+	})
 }
 
 func TestDELETETasks(t *testing.T) {
-	storage := CreateMockStorage(dummyData)
+	storage := storage.CreateMemoryTaskStorage(dummyData)
 	taskList := todo.CreateTaskList(storage)
-	server := todo.NewTaskServer(taskList)
+	server := newTestServer(t, storage, taskList)
 
 	t.Run("test DELETE to /tasks/1 deletes task 1", func(t *testing.T) {
 		request, _ := http.NewRequest(http.MethodDelete, "/tasks/1", nil)
@@ -185,7 +622,7 @@ func TestDELETETasks(t *testing.T) {
 		assertStatus(t, response.Code, http.StatusOK)
 
 		got := decodeTaskList(t, response.Body)
-		want := []todo.Task{{Id: 2, Name: "Task 2", Complete: true}}
+		want := []todo.Task{{Id: 2, Name: "Task 2", Complete: true, ListId: 1}}
 
 		if !reflect.DeepEqual(got, want) {
 			t.Errorf("got response %+v, want %+v", got, want)
@@ -194,16 +631,1092 @@ func TestDELETETasks(t *testing.T) {
 
 }
 
-func assertJSONContentType(t testing.TB, response *httptest.ResponseRecorder) {
-	t.Helper()
+func TestTrashTasks(t *testing.T) {
+	now := time.Date(2022, 10, 1, 12, 0, 0, 0, time.UTC)
+	storage := storage.CreateMemoryTaskStorage([]todo.Task{
+		{Id: 1, Name: "Task 1", ListId: 1},
+	})
+	taskList := todo.CreateTaskList(storage)
+	taskList.SetClock(func() time.Time { return now })
+	server := newTestServer(t, storage, taskList)
 
-	if response.Result().Header.Get("content-type") != "application/json" {
-		t.Errorf("response did not have content-type of application/json, got %v", response.Result().Header)
-	}
-}
+	t.Run("test GET /trash returns deleted tasks", func(t *testing.T) {
+		request, _ := http.NewRequest(http.MethodDelete, "/tasks/1", nil)
+		server.ServeHTTP(httptest.NewRecorder(), request)
 
-func assertStatus(t testing.TB, got, want int) {
-	t.Helper()
+		request, _ = http.NewRequest(http.MethodGet, "/trash", nil)
+		response := httptest.NewRecorder()
+
+		server.ServeHTTP(response, request)
+		assertStatus(t, response.Code, http.StatusOK)
+
+		got := response.Body.String()
+		want := `[{"id":1,"name":"Task 1","complete":false,"list_id":1,"trashed_at":"2022-10-01T12:00:00Z"}]
+`
+		if got != want {
+			t.Errorf("got response '%v', want '%v'", got, want)
+		}
+	})
+
+	t.Run("test POST to /tasks/1/restore returns the restored task", func(t *testing.T) {
+		request, _ := http.NewRequest(http.MethodPost, "/tasks/1/restore", nil)
+		response := httptest.NewRecorder()
+
+		server.ServeHTTP(response, request)
+		assertStatus(t, response.Code, http.StatusOK)
+
+		got := response.Body.String()
+		want := `{"id":1,"name":"Task 1","complete":false,"list_id":1}
+`
+		if got != want {
+			t.Errorf("got response '%v', want '%v'", got, want)
+		}
+	})
+
+	t.Run("test POST to /tasks/1/restore when it isn't in the trash returns 404", func(t *testing.T) {
+		request, _ := http.NewRequest(http.MethodPost, "/tasks/1/restore", nil)
+		response := httptest.NewRecorder()
+
+		server.ServeHTTP(response, request)
+		assertStatus(t, response.Code, http.StatusNotFound)
+		assertProblemContentType(t, response)
+	})
+
+	t.Run("test DELETE to /trash purges every deleted task", func(t *testing.T) {
+		request, _ := http.NewRequest(http.MethodDelete, "/tasks/1", nil)
+		server.ServeHTTP(httptest.NewRecorder(), request)
+
+		request, _ = http.NewRequest(http.MethodDelete, "/trash", nil)
+		response := httptest.NewRecorder()
+
+		server.ServeHTTP(response, request)
+		assertStatus(t, response.Code, http.StatusOK)
+
+		got := response.Body.String()
+		want := `{"purged":1}
+`
+		if got != want {
+			t.Errorf("got response '%v', want '%v'", got, want)
+		}
+	})
+}
+
+func TestGETHistory(t *testing.T) {
+	now := time.Date(2022, 10, 1, 12, 0, 0, 0, time.UTC)
+	storage := storage.CreateMemoryTaskStorage([]todo.Task{})
+	taskList := todo.CreateTaskList(storage)
+	taskList.SetClock(func() time.Time { return now })
+	taskList.Add("Task 1")
+	taskList.Add("Task 2")
+	task, _ := taskList.GetOne(1)
+	taskList.Complete(&task)
+	server := newTestServer(t, storage, taskList)
+
+	t.Run("test GET /tasks/1/history returns the task's events", func(t *testing.T) {
+		request, _ := http.NewRequest(http.MethodGet, "/tasks/1/history", nil)
+		response := httptest.NewRecorder()
+
+		server.ServeHTTP(response, request)
+		assertStatus(t, response.Code, http.StatusOK)
+
+		got := response.Body.String()
+		want := `[{"id":3,"task_id":1,"type":"completed","name":"Task 1","at":"2022-10-01T12:00:00Z"},{"id":1,"task_id":1,"type":"created","name":"Task 1","at":"2022-10-01T12:00:00Z"}]
+`
+		if got != want {
+			t.Errorf("got response '%v', want '%v'", got, want)
+		}
+	})
+
+	t.Run("test GET /tasks/9/history returns 404", func(t *testing.T) {
+		request, _ := http.NewRequest(http.MethodGet, "/tasks/9/history", nil)
+		response := httptest.NewRecorder()
+
+		server.ServeHTTP(response, request)
+		assertStatus(t, response.Code, http.StatusNotFound)
+	})
+
+	t.Run("test /activity pages with Link headers", func(t *testing.T) {
+		request, _ := http.NewRequest(http.MethodGet, "/activity?limit=2", nil)
+		response := httptest.NewRecorder()
+
+		server.ServeHTTP(response, request)
+		assertStatus(t, response.Code, http.StatusOK)
+
+		var got []todo.Event
+		AssertNoError(t, json.NewDecoder(response.Body).Decode(&got))
+		if len(got) != 2 || got[0].Id != 3 || got[1].Id != 2 {
+			t.Errorf("got %+v, want events 3 and 2", got)
+		}
+
+		link := response.Header().Get("Link")
+		wantLink := `</activity?cursor=Mg&limit=2>; rel="next"`
+		if link != wantLink {
+			t.Errorf("got Link %q, want %q", link, wantLink)
+		}
+	})
+
+	t.Run("test /activity says who made each change", func(t *testing.T) {
+		request, _ := http.NewRequest(http.MethodPost, "/tasks", bytes.NewBufferString(`{"name": "Task 3"}`))
+		response := httptest.NewRecorder()
+		server.ServeHTTP(response, request)
+		assertStatus(t, response.Code, http.StatusCreated)
+
+		request, _ = http.NewRequest(http.MethodGet, "/activity?limit=1", nil)
+		response = httptest.NewRecorder()
+
+		server.ServeHTTP(response, request)
+		assertStatus(t, response.Code, http.StatusOK)
+
+		got := response.Body.String()
+		want := `[{"id":4,"task_id":3,"type":"created","name":"Task 3","at":"2022-10-01T12:00:00Z","actor_id":1,"actor":"tester"}]
+`
+		if got != want {
+			t.Errorf("got response '%v', want '%v'", got, want)
+		}
+	})
+
+	t.Run("test /activity with an invalid limit returns 400", func(t *testing.T) {
+		request, _ := http.NewRequest(http.MethodGet, "/activity?limit=0", nil)
+		response := httptest.NewRecorder()
+
+		server.ServeHTTP(response, request)
+		assertStatus(t, response.Code, http.StatusBadRequest)
+		assertProblemContentType(t, response)
+	})
+}
+
+func TestGETSearch(t *testing.T) {
+	storage := storage.CreateMemoryTaskStorage([]todo.Task{
+		{Id: 1, Name: "Release version 1.2", ListId: 1},
+		{Id: 2, Name: "Write release notes", ListId: 1},
+		{Id: 3, Name: "Buy milk", ListId: 1},
+	})
+	taskList := todo.CreateTaskList(storage)
+	server := newTestServer(t, storage, taskList)
+
+	t.Run("test /tasks/search returns matches with snippets", func(t *testing.T) {
+		request, _ := http.NewRequest(http.MethodGet, "/tasks/search?q=release", nil)
+		response := httptest.NewRecorder()
+
+		server.ServeHTTP(response, request)
+		assertStatus(t, response.Code, http.StatusOK)
+		assertJSONContentType(t, response)
+
+		var got []todo.SearchResult
+		err := json.NewDecoder(response.Body).Decode(&got)
+		if err != nil {
+			t.Fatalf("Unable to parse response from server %q, '%v'", response.Body, err)
+		}
+		want := []todo.SearchResult{
+			{Task: todo.Task{Id: 1, Name: "Release version 1.2", ListId: 1}, Snippet: "<mark>Release</mark> version 1.2"},
+			{Task: todo.Task{Id: 2, Name: "Write release notes", ListId: 1}, Snippet: "Write <mark>release</mark> notes"},
+		}
+
+		if !reflect.DeepEqual(got, want) {
+			t.Errorf("got response %+v, want %+v", got, want)
+		}
+	})
+
+	t.Run("test /tasks/search without a query returns 400", func(t *testing.T) {
+		request, _ := http.NewRequest(http.MethodGet, "/tasks/search", nil)
+		response := httptest.NewRecorder()
+
+		server.ServeHTTP(response, request)
+		assertStatus(t, response.Code, http.StatusBadRequest)
+		assertProblemContentType(t, response)
+	})
+}
+
+func TestGETSubtasks(t *testing.T) {
+	storage := storage.CreateMemoryTaskStorage([]todo.Task{
+		{Id: 1, Name: "Release", ListId: 1},
+		{Id: 2, Name: "Write changelog", ListId: 1, ParentId: 1},
+		{Id: 3, Name: "Tag version", ListId: 1, ParentId: 1},
+		{Id: 4, Name: "Push tag", ListId: 1, ParentId: 3},
+	})
+	taskList := todo.CreateTaskList(storage)
+	server := newTestServer(t, storage, taskList)
+
+	t.Run("test /tasks/1/subtasks returns a tree of subtasks", func(t *testing.T) {
+		request, _ := http.NewRequest(http.MethodGet, "/tasks/1/subtasks", nil)
+		response := httptest.NewRecorder()
+
+		server.ServeHTTP(response, request)
+		assertStatus(t, response.Code, http.StatusOK)
+		assertJSONContentType(t, response)
+
+		var got []todo.TaskNode
+		err := json.NewDecoder(response.Body).Decode(&got)
+		if err != nil {
+			t.Fatalf("Unable to parse response from server %q, '%v'", response.Body, err)
+		}
+		want := []todo.TaskNode{
+			{Task: todo.Task{Id: 2, Name: "Write changelog", ListId: 1, ParentId: 1}, Subtasks: []todo.TaskNode{}},
+			{Task: todo.Task{Id: 3, Name: "Tag version", ListId: 1, ParentId: 1}, Subtasks: []todo.TaskNode{
+				{Task: todo.Task{Id: 4, Name: "Push tag", ListId: 1, ParentId: 3}, Subtasks: []todo.TaskNode{}},
+			}},
+		}
+
+		if !reflect.DeepEqual(got, want) {
+			t.Errorf("got response %+v, want %+v", got, want)
+		}
+	})
+
+	t.Run("test PATCH making a task its own subtask returns 400", func(t *testing.T) {
+		body := bytes.NewBufferString(`{"parent_id": 4}`)
+		request, _ := http.NewRequest(http.MethodPatch, "/tasks/1", body)
+		response := httptest.NewRecorder()
+
+		server.ServeHTTP(response, request)
+		assertStatus(t, response.Code, http.StatusBadRequest)
+
+		got := decodeProblem(t, response.Body)
+		want := []todo.FieldError{{Field: "parent_id", Reason: "would make the task a subtask of itself"}}
+
+		if !reflect.DeepEqual(got.Errors, want) {
+			t.Errorf("got errors %+v, want %+v", got.Errors, want)
+		}
+	})
+
+	t.Run("test DELETE of a task with subtasks returns 409", func(t *testing.T) {
+		request, _ := http.NewRequest(http.MethodDelete, "/tasks/3", nil)
+		response := httptest.NewRecorder()
+
+		server.ServeHTTP(response, request)
+		assertStatus(t, response.Code, http.StatusConflict)
+		assertProblemContentType(t, response)
+	})
+
+	t.Run("test DELETE with cascade deletes the subtasks", func(t *testing.T) {
+		request, _ := http.NewRequest(http.MethodDelete, "/tasks/3?cascade=true", nil)
+		response := httptest.NewRecorder()
+
+		server.ServeHTTP(response, request)
+		assertStatus(t, response.Code, http.StatusAccepted)
+
+		got, _ := taskList.GetAll()
+		want := []todo.Task{
+			{Id: 1, Name: "Release", ListId: 1, OwnerId: 1},
+			{Id: 2, Name: "Write changelog", ListId: 1, ParentId: 1, OwnerId: 1},
+		}
+
+		AssertTaskListsEqual(t, got, want)
+	})
+}
+
+func TestLists(t *testing.T) {
+	now := time.Date(2022, 10, 1, 12, 0, 0, 0, time.UTC)
+	storage := storage.CreateMemoryTaskStorage([]todo.Task{})
+	taskList := todo.CreateTaskList(storage)
+	taskList.SetClock(func() time.Time { return now })
+	server := newTestServer(t, storage, taskList)
+
+	t.Run("test POST to /lists creates a list", func(t *testing.T) {
+		jsonData := []byte(`{"name": "Work"}`)
+		request, _ := http.NewRequest(http.MethodPost, "/lists", bytes.NewBuffer(jsonData))
+		response := httptest.NewRecorder()
+
+		server.ServeHTTP(response, request)
+		assertStatus(t, response.Code, http.StatusCreated)
+
+		got := response.Body.String()
+		want := `{"id":2,"name":"Work"}
+`
+		if got != want {
+			t.Errorf("got response '%v', want '%v'", got, want)
+		}
+	})
+
+	t.Run("test POST to /lists/2/tasks adds a task to the list", func(t *testing.T) {
+		jsonData := []byte(`{"name": "Work task"}`)
+		request, _ := http.NewRequest(http.MethodPost, "/lists/2/tasks", bytes.NewBuffer(jsonData))
+		response := httptest.NewRecorder()
+
+		server.ServeHTTP(response, request)
+		assertStatus(t, response.Code, http.StatusCreated)
+
+		request, _ = http.NewRequest(http.MethodGet, "/lists/2/tasks", nil)
+		response = httptest.NewRecorder()
+
+		server.ServeHTTP(response, request)
+		assertStatus(t, response.Code, http.StatusOK)
+		assertJSONContentType(t, response)
+
+		got := decodeTaskList(t, response.Body)
+		want := []todo.Task{{Id: 1, Name: "Work task", Complete: false, ListId: 2, CreatedAt: &now, Position: "V"}}
+
+		if !reflect.DeepEqual(got, want) {
+			t.Errorf("got response %+v, want %+v", got, want)
+		}
+	})
+
+	t.Run("test POST to /tasks with a missing list returns 400", func(t *testing.T) {
+		jsonData := []byte(`{"name": "Task", "list_id": 9}`)
+		request, _ := http.NewRequest(http.MethodPost, "/tasks", bytes.NewBuffer(jsonData))
+		response := httptest.NewRecorder()
+
+		server.ServeHTTP(response, request)
+		assertStatus(t, response.Code, http.StatusBadRequest)
+	})
+
+	t.Run("test GET /lists/9 returns 404", func(t *testing.T) {
+		request, _ := http.NewRequest(http.MethodGet, "/lists/9", nil)
+		response := httptest.NewRecorder()
+
+		server.ServeHTTP(response, request)
+		assertStatus(t, response.Code, http.StatusNotFound)
+	})
+
+	t.Run("test DELETE /lists/1 is refused", func(t *testing.T) {
+		request, _ := http.NewRequest(http.MethodDelete, "/lists/1", nil)
+		response := httptest.NewRecorder()
+
+		server.ServeHTTP(response, request)
+		assertStatus(t, response.Code, http.StatusConflict)
+	})
+
+	t.Run("test DELETE /lists/2 deletes the list", func(t *testing.T) {
+		request, _ := http.NewRequest(http.MethodDelete, "/lists/2", nil)
+		response := httptest.NewRecorder()
+
+		server.ServeHTTP(response, request)
+		assertStatus(t, response.Code, http.StatusAccepted)
+
+		request, _ = http.NewRequest(http.MethodGet, "/lists", nil)
+		response = httptest.NewRecorder()
+
+		server.ServeHTTP(response, request)
+		assertStatus(t, response.Code, http.StatusOK)
+
+		got := response.Body.String()
+		want := `[{"id":1,"name":"Tasks"}]
+`
+		if got != want {
+			t.Errorf("got response '%v', want '%v'", got, want)
+		}
+	})
+}
+
+func TestGETDueTasks(t *testing.T) {
+	now := time.Date(2022, 10, 1, 12, 0, 0, 0, time.UTC)
+	yesterday := now.AddDate(0, 0, -1)
+	nextWeek := now.AddDate(0, 0, 7)
+
+	storage := storage.CreateMemoryTaskStorage([]todo.Task{
+		{Id: 1, Name: "Task 1", ListId: 1, Due: &yesterday},
+		{Id: 2, Name: "Task 2", ListId: 1, Due: &nextWeek},
+	})
+	taskList := todo.CreateTaskList(storage)
+	taskList.SetClock(func() time.Time { return now })
+	server := newTestServer(t, storage, taskList)
+
+	t.Run("test /tasks/overdue returns overdue tasks", func(t *testing.T) {
+		request, _ := http.NewRequest(http.MethodGet, "/tasks/overdue", nil)
+		response := httptest.NewRecorder()
+
+		server.ServeHTTP(response, request)
+
+		assertStatus(t, response.Code, http.StatusOK)
+		assertJSONContentType(t, response)
+
+		got := response.Body.String()
+		want := `[{"id":1,"name":"Task 1","complete":false,"list_id":1,"due":"2022-09-30T12:00:00Z"}]
+`
+		if got != want {
+			t.Errorf("got response '%v', want '%v'", got, want)
+		}
+	})
+
+	t.Run("test /tasks/due filters by range", func(t *testing.T) {
+		request, _ := http.NewRequest(http.MethodGet, "/tasks/due?after=2022-10-01T00:00:00Z", nil)
+		response := httptest.NewRecorder()
+
+		server.ServeHTTP(response, request)
+
+		assertStatus(t, response.Code, http.StatusOK)
+
+		got := decodeTaskList(t, response.Body)
+		want := []todo.Task{{Id: 2, Name: "Task 2", ListId: 1, Due: &nextWeek}}
+
+		if !reflect.DeepEqual(got, want) {
+			t.Errorf("got response %+v, want %+v", got, want)
+		}
+	})
+
+	t.Run("test /tasks/due with an invalid time returns 400", func(t *testing.T) {
+		request, _ := http.NewRequest(http.MethodGet, "/tasks/due?before=tomorrow", nil)
+		response := httptest.NewRecorder()
+
+		server.ServeHTTP(response, request)
+
+		assertStatus(t, response.Code, http.StatusBadRequest)
+	})
+}
+
+func TestAuthentication(t *testing.T) {
+	storage := storage.CreateMemoryTaskStorage(dummyData)
+	taskList := todo.CreateTaskList(storage)
+	server := todo.NewTaskServer(taskList, todo.CreateAccounts(storage))
+	var token string
+
+	t.Run("test /tasks without a token returns 401", func(t *testing.T) {
+		request, _ := http.NewRequest(http.MethodGet, "/tasks", nil)
+		response := httptest.NewRecorder()
+
+		server.ServeHTTP(response, request)
+		assertStatus(t, response.Code, http.StatusUnauthorized)
+		assertProblemContentType(t, response)
+
+		if got := response.Header().Get("WWW-Authenticate"); got != "Bearer" {
+			t.Errorf("got WWW-Authenticate %q, want Bearer", got)
+		}
+	})
+
+	t.Run("test POST to /users registers a user", func(t *testing.T) {
+		body := bytes.NewBufferString(`{"username": "alice", "password": "correct horse"}`)
+		request, _ := http.NewRequest(http.MethodPost, "/users", body)
+		response := httptest.NewRecorder()
+
+		server.ServeHTTP(response, request)
+		assertStatus(t, response.Code, http.StatusCreated)
+		assertJSONContentType(t, response)
+
+		var got map[string]any
+		json.NewDecoder(response.Body).Decode(&got)
+		if got["username"] != "alice" || got["password_hash"] != nil {
+			t.Errorf("got %v, want alice without a password hash", got)
+		}
+	})
+
+	t.Run("test POST to /users with a taken username returns 409", func(t *testing.T) {
+		body := bytes.NewBufferString(`{"username": "Alice", "password": "correct horse"}`)
+		request, _ := http.NewRequest(http.MethodPost, "/users", body)
+		response := httptest.NewRecorder()
+
+		server.ServeHTTP(response, request)
+		assertStatus(t, response.Code, http.StatusConflict)
+	})
+
+	t.Run("test POST to /login with a wrong password returns 401", func(t *testing.T) {
+		body := bytes.NewBufferString(`{"username": "alice", "password": "wrong password"}`)
+		request, _ := http.NewRequest(http.MethodPost, "/login", body)
+		response := httptest.NewRecorder()
+
+		server.ServeHTTP(response, request)
+		assertStatus(t, response.Code, http.StatusUnauthorized)
+	})
+
+	t.Run("test POST to /login returns a token", func(t *testing.T) {
+		body := bytes.NewBufferString(`{"username": "alice", "password": "correct horse"}`)
+		request, _ := http.NewRequest(http.MethodPost, "/login", body)
+		response := httptest.NewRecorder()
+
+		server.ServeHTTP(response, request)
+		assertStatus(t, response.Code, http.StatusOK)
+
+		var got struct {
+			Token string `json:"token"`
+		}
+		json.NewDecoder(response.Body).Decode(&got)
+		if got.Token == "" {
+			t.Fatal("got no token")
+		}
+		token = got.Token
+	})
+
+	t.Run("test /tasks with the token returns the user's tasks", func(t *testing.T) {
+		request, _ := http.NewRequest(http.MethodGet, "/tasks", nil)
+		request.Header.Set("Authorization", "Bearer "+token)
+		response := httptest.NewRecorder()
+
+		server.ServeHTTP(response, request)
+		assertStatus(t, response.Code, http.StatusOK)
+
+		got := decodeTaskList(t, response.Body)
+		if !reflect.DeepEqual(got, dummyData) {
+			t.Errorf("got response %+v, want %+v", got, dummyData)
+		}
+	})
+
+	t.Run("test GET /users/me returns the logged in user", func(t *testing.T) {
+		request, _ := http.NewRequest(http.MethodGet, "/users/me", nil)
+		request.Header.Set("Authorization", "Bearer "+token)
+		response := httptest.NewRecorder()
+
+		server.ServeHTTP(response, request)
+		assertStatus(t, response.Code, http.StatusOK)
+
+		var got todo.User
+		json.NewDecoder(response.Body).Decode(&got)
+		if got.Username != "alice" {
+			t.Errorf("got user %+v, want alice", got)
+		}
+	})
+
+	t.Run("test CORS preflight for /login and /logout is allowed", func(t *testing.T) {
+		for _, path := range []string{"/login", "/logout"} {
+			request, _ := http.NewRequest(http.MethodOptions, path, nil)
+			request.Header.Set("Origin", "http://localhost:8080")
+			request.Header.Set("Access-Control-Request-Method", http.MethodPost)
+			request.Header.Set("Access-Control-Request-Headers", "Authorization")
+			response := httptest.NewRecorder()
+
+			server.ServeHTTP(response, request)
+			assertStatus(t, response.Code, http.StatusNoContent)
+
+			for header, want := range map[string]string{
+				"Access-Control-Allow-Origin":  "*",
+				"Access-Control-Allow-Methods": "GET, POST, PUT, PATCH, DELETE",
+				"Access-Control-Allow-Headers": "Authorization, Content-Type, Last-Event-ID",
+			} {
+				if got := response.Header().Get(header); got != want {
+					t.Errorf("got %s %q for %s, want %q", header, got, path, want)
+				}
+			}
+		}
+	})
+
+	t.Run("test POST to /logout ends the session", func(t *testing.T) {
+		request, _ := http.NewRequest(http.MethodPost, "/logout", nil)
+		request.Header.Set("Authorization", "Bearer "+token)
+		response := httptest.NewRecorder()
+
+		server.ServeHTTP(response, request)
+		assertStatus(t, response.Code, http.StatusNoContent)
+
+		request, _ = http.NewRequest(http.MethodGet, "/tasks", nil)
+		request.Header.Set("Authorization", "Bearer "+token)
+		response = httptest.NewRecorder()
+
+		server.ServeHTTP(response, request)
+		assertStatus(t, response.Code, http.StatusUnauthorized)
+	})
+}
+
+func TestAPITokenRequests(t *testing.T) {
+	storage := storage.CreateMemoryTaskStorage(dummyData)
+	taskList := todo.CreateTaskList(storage)
+	server := newTestServer(t, storage, taskList)
+	var readToken string
+
+	t.Run("test POST to /tokens returns the new token", func(t *testing.T) {
+		body := bytes.NewBufferString(`{"name": "CI", "scopes": ["read"]}`)
+		request, _ := http.NewRequest(http.MethodPost, "/tokens", body)
+		response := httptest.NewRecorder()
+
+		server.ServeHTTP(response, request)
+		assertStatus(t, response.Code, http.StatusCreated)
+
+		var got struct {
+			Id     int          `json:"id"`
+			Name   string       `json:"name"`
+			Scopes []todo.Scope `json:"scopes"`
+			Token  string       `json:"token"`
+		}
+		json.NewDecoder(response.Body).Decode(&got)
+		if got.Id != 1 || got.Name != "CI" || !reflect.DeepEqual(got.Scopes, []todo.Scope{todo.ScopeRead}) || got.Token == "" {
+			t.Errorf("got %+v, want token 1 named CI with the read scope", got)
+		}
+		readToken = got.Token
+	})
+
+	t.Run("test GET /tokens doesn't show the tokens themselves", func(t *testing.T) {
+		request, _ := http.NewRequest(http.MethodGet, "/tokens", nil)
+		response := httptest.NewRecorder()
+
+		server.ServeHTTP(response, request)
+		assertStatus(t, response.Code, http.StatusOK)
+
+		got := response.Body.String()
+		want := `[{"id":1,"name":"CI","scopes":["read"],"created_at":`
+		if !strings.HasPrefix(got, want) || strings.Contains(got, readToken) {
+			t.Errorf("got response '%v', want it to start with '%v'", got, want)
+		}
+	})
+
+	t.Run("test a read token can GET /tasks", func(t *testing.T) {
+		request, _ := http.NewRequest(http.MethodGet, "/tasks", nil)
+		request.Header.Set("Authorization", "Bearer "+readToken)
+		response := httptest.NewRecorder()
+
+		server.ServeHTTP(response, request)
+		assertStatus(t, response.Code, http.StatusOK)
+
+		got := decodeTaskList(t, response.Body)
+		if !reflect.DeepEqual(got, dummyData) {
+			t.Errorf("got response %+v, want %+v", got, dummyData)
+		}
+	})
+
+	t.Run("test a read token can't POST to /tasks", func(t *testing.T) {
+		body := bytes.NewBufferString(`{"name": "Task 3"}`)
+		request, _ := http.NewRequest(http.MethodPost, "/tasks", body)
+		request.Header.Set("Authorization", "Bearer "+readToken)
+		response := httptest.NewRecorder()
+
+		server.ServeHTTP(response, request)
+		assertStatus(t, response.Code, http.StatusForbidden)
+		assertProblemContentType(t, response)
+	})
+
+	t.Run("test a token can't manage tokens", func(t *testing.T) {
+		request, _ := http.NewRequest(http.MethodGet, "/tokens", nil)
+		request.Header.Set("Authorization", "Bearer "+readToken)
+		response := httptest.NewRecorder()
+
+		server.ServeHTTP(response, request)
+		assertStatus(t, response.Code, http.StatusForbidden)
+	})
+
+	t.Run("test DELETE /tokens/1 revokes the token", func(t *testing.T) {
+		request, _ := http.NewRequest(http.MethodDelete, "/tokens/1", nil)
+		response := httptest.NewRecorder()
+
+		server.ServeHTTP(response, request)
+		assertStatus(t, response.Code, http.StatusNoContent)
+
+		request, _ = http.NewRequest(http.MethodGet, "/tasks", nil)
+		request.Header.Set("Authorization", "Bearer "+readToken)
+		response = httptest.NewRecorder()
+
+		server.ServeHTTP(response, request)
+		assertStatus(t, response.Code, http.StatusUnauthorized)
+	})
+
+	t.Run("test DELETE /tokens/9 returns 404", func(t *testing.T) {
+		request, _ := http.NewRequest(http.MethodDelete, "/tokens/9", nil)
+		response := httptest.NewRecorder()
+
+		server.ServeHTTP(response, request)
+		assertStatus(t, response.Code, http.StatusNotFound)
+	})
+}
+
+func TestSharedListRequests(t *testing.T) {
+	storage := storage.CreateMemoryTaskStorage([]todo.Task{})
+	taskList := todo.CreateTaskList(storage)
+	server := newTestServer(t, storage, taskList)
+	accounts := todo.CreateAccounts(storage)
+	_, err := accounts.Register("viewer", "correct horse")
+	AssertNoError(t, err)
+	viewerToken, _, err := accounts.Login("viewer", "correct horse")
+	AssertNoError(t, err)
+	taskList.ForUser(1).AddList("Team")
+
+	t.Run("test POST to /lists/2/members shares the list", func(t *testing.T) {
+		body := bytes.NewBufferString(`{"username": "viewer", "role": "viewer"}`)
+		request, _ := http.NewRequest(http.MethodPost, "/lists/2/members", body)
+		response := httptest.NewRecorder()
+
+		server.ServeHTTP(response, request)
+		assertStatus(t, response.Code, http.StatusCreated)
+
+		got := response.Body.String()
+		want := `{"user_id":2,"username":"viewer","role":"viewer"}
+`
+		if got != want {
+			t.Errorf("got response '%v', want '%v'", got, want)
+		}
+	})
+
+	t.Run("test POST to /lists/2/members with an unknown user returns 400", func(t *testing.T) {
+		body := bytes.NewBufferString(`{"username": "nobody", "role": "viewer"}`)
+		request, _ := http.NewRequest(http.MethodPost, "/lists/2/members", body)
+		response := httptest.NewRecorder()
+
+		server.ServeHTTP(response, request)
+		assertStatus(t, response.Code, http.StatusBadRequest)
+	})
+
+	t.Run("test GET /lists/2/members returns the members", func(t *testing.T) {
+		request, _ := http.NewRequest(http.MethodGet, "/lists/2/members", nil)
+		request.Header.Set("Authorization", "Bearer "+viewerToken)
+		response := httptest.NewRecorder()
+
+		server.ServeHTTP(response, request)
+		assertStatus(t, response.Code, http.StatusOK)
+
+		got := response.Body.String()
+		want := `[{"user_id":1,"username":"tester","role":"owner"},{"user_id":2,"username":"viewer","role":"viewer"}]
+`
+		if got != want {
+			t.Errorf("got response '%v', want '%v'", got, want)
+		}
+	})
+
+	t.Run("test a viewer adding a task returns 403", func(t *testing.T) {
+		body := bytes.NewBufferString(`{"name": "Task 1"}`)
+		request, _ := http.NewRequest(http.MethodPost, "/lists/2/tasks", body)
+		request.Header.Set("Authorization", "Bearer "+viewerToken)
+		response := httptest.NewRecorder()
+
+		server.ServeHTTP(response, request)
+		assertStatus(t, response.Code, http.StatusForbidden)
+		assertProblemContentType(t, response)
+
+		got := decodeProblem(t, response.Body)
+		if got.Detail != "forbidden: this requires the editor role on the list" {
+			t.Errorf("got detail %q, want the role that is required", got.Detail)
+		}
+	})
+
+	t.Run("test a viewer deleting the list returns 403", func(t *testing.T) {
+		request, _ := http.NewRequest(http.MethodDelete, "/lists/2", nil)
+		request.Header.Set("Authorization", "Bearer "+viewerToken)
+		response := httptest.NewRecorder()
+
+		server.ServeHTTP(response, request)
+		assertStatus(t, response.Code, http.StatusForbidden)
+	})
+
+	t.Run("test DELETE /lists/2/members/2 removes the member", func(t *testing.T) {
+		request, _ := http.NewRequest(http.MethodDelete, "/lists/2/members/2", nil)
+		response := httptest.NewRecorder()
+
+		server.ServeHTTP(response, request)
+		assertStatus(t, response.Code, http.StatusNoContent)
+
+		request, _ = http.NewRequest(http.MethodGet, "/lists/2", nil)
+		request.Header.Set("Authorization", "Bearer "+viewerToken)
+		response = httptest.NewRecorder()
+
+		server.ServeHTTP(response, request)
+		assertStatus(t, response.Code, http.StatusNotFound)
+	})
+}
+
+func assertJSONContentType(t testing.TB, response *httptest.ResponseRecorder) {
+	t.Helper()
+
+	if response.Result().Header.Get("content-type") != "application/json" {
+		t.Errorf("response did not have content-type of application/json, got %v", response.Result().Header)
+	}
+}
+
+func assertProblemContentType(t testing.TB, response *httptest.ResponseRecorder) {
+	t.Helper()
+
+	if response.Result().Header.Get("content-type") != "application/problem+json" {
+		t.Errorf("response did not have content-type of application/problem+json, got %v", response.Result().Header)
+	}
+}
+
+func TestEventStream(t *testing.T) {
+	storage := storage.CreateMemoryTaskStorage(dummyData)
+	taskList := todo.CreateTaskList(storage)
+	server := httptest.NewServer(newTestServer(t, storage, taskList))
+	defer server.Close()
+	accounts := todo.CreateAccounts(storage)
+	other, err := accounts.Register("other", "correct horse")
+	AssertNoError(t, err)
+	tasks := taskList.ForUser(1)
+
+	t.Run("test GET /events streams new changes", func(t *testing.T) {
+		stream := openEventStream(t, server.URL, "")
+		_, err := taskList.ForUser(other.Id).AddTask(&todo.Task{Name: "Not mine"})
+		AssertNoError(t, err)
+		task := todo.Task{Name: "Streamed"}
+		_, err = tasks.AddTask(&task)
+		AssertNoError(t, err)
+		err = tasks.Complete(&task)
+		AssertNoError(t, err)
+		err = tasks.Delete(&task)
+		AssertNoError(t, err)
+
+		for _, want := range []string{"task.created", "task.updated", "task.deleted"} {
+			got := readServerEvent(t, stream)
+			if got.name != want {
+				t.Errorf("got event %q, want %q", got.name, want)
+			}
+			if got.change.TaskId != task.Id {
+				t.Errorf("got a change to task %d, want task %d", got.change.TaskId, task.Id)
+			}
+		}
+	})
+
+	t.Run("test GET /events with Last-Event-ID replays missed changes", func(t *testing.T) {
+		page, err := tasks.GetActivity(2, "")
+		AssertNoError(t, err)
+		stream := openEventStream(t, server.URL, strconv.FormatInt(int64(page.Events[1].Id), 10))
+
+		got := readServerEvent(t, stream)
+		if got.id != page.Events[0].Id || got.name != "task.deleted" || got.change.Task != nil {
+			t.Errorf("got event %d %q with task %v, want event %d \"task.deleted\" without a task",
+				got.id, got.name, got.change.Task, page.Events[0].Id)
+		}
+
+		task := todo.Task{Name: "Live"}
+		_, err = tasks.AddTask(&task)
+		AssertNoError(t, err)
+		got = readServerEvent(t, stream)
+		if got.name != "task.created" || got.change.Task == nil || got.change.Task.Name != "Live" {
+			t.Errorf("got event %q with task %v, want \"task.created\" with the task", got.name, got.change.Task)
+		}
+	})
+
+	t.Run("test GET /events with an invalid Last-Event-ID returns 400", func(t *testing.T) {
+		request, _ := http.NewRequest(http.MethodGet, server.URL+"/events", nil)
+		request.Header.Set("Last-Event-ID", "latest")
+
+		response, err := http.DefaultClient.Do(request)
+		AssertNoError(t, err)
+		response.Body.Close()
+		assertStatus(t, response.StatusCode, http.StatusBadRequest)
+	})
+}
+
+func TestListSocket(t *testing.T) {
+	storage := storage.CreateMemoryTaskStorage([]todo.Task{})
+	taskList := todo.CreateTaskList(storage)
+	accounts := todo.CreateAccounts(storage)
+	tokens := map[string]string{}
+	users := map[string]todo.User{}
+	for _, username := range []string{"tester", "alex", "viewer", "stranger"} {
+		user, err := accounts.Register(username, "correct horse")
+		AssertNoError(t, err)
+		users[username] = user
+		tokens[username], _, err = accounts.Login(username, "correct horse")
+		AssertNoError(t, err)
+	}
+	server := httptest.NewServer(todo.NewTaskServer(taskList, accounts))
+	defer server.Close()
+
+	tasks := taskList.ForUser(users["tester"].Id)
+	listId, err := tasks.AddList("Team")
+	AssertNoError(t, err)
+	_, err = tasks.ShareList(listId, users["alex"], todo.RoleEditor)
+	AssertNoError(t, err)
+	_, err = tasks.ShareList(listId, users["viewer"], todo.RoleViewer)
+	AssertNoError(t, err)
+	shared := todo.Task{Name: "Shared", ListId: listId}
+	_, err = tasks.AddTask(&shared)
+	AssertNoError(t, err)
+	url := "ws" + strings.TrimPrefix(server.URL, "http") + "/lists/" + strconv.Itoa(int(listId)) + "/socket"
+
+	tester := dialListSocket(t, url, tokens["tester"], false)
+	got := expectMessage(t, tester, todo.MessageTasks)
+	if len(got.Tasks) != 1 || got.Tasks[0].Id != shared.Id {
+		t.Errorf("got tasks %v, want the shared task", got.Tasks)
+	}
+	assertPresence(t, expectMessage(t, tester, todo.MessagePresence), "tester")
+
+	// alex connects like a browser, with the token in a subprotocol.
+	alex := dialListSocket(t, url, tokens["alex"], true)
+	if alex.Subprotocol() != "todo" {
+		t.Errorf("got subprotocol %q, want \"todo\"", alex.Subprotocol())
+	}
+	expectMessage(t, alex, todo.MessageTasks)
+	assertPresence(t, expectMessage(t, alex, todo.MessagePresence), "alex", "tester")
+	assertPresence(t, expectMessage(t, tester, todo.MessagePresence), "alex", "tester")
+
+	t.Run("test editing a task is shared with everyone on the list", func(t *testing.T) {
+		AssertNoError(t, alex.WriteJSON(todo.Message{Type: todo.MessageEditing, TaskId: shared.Id}))
+		for _, conn := range []*websocket.Conn{tester, alex} {
+			got := expectMessage(t, conn, todo.MessagePresence)
+			assertPresence(t, got, "alex", "tester")
+			if got.Presence[0].Editing != shared.Id {
+				t.Errorf("got alex editing task %d, want %d", got.Presence[0].Editing, shared.Id)
+			}
+		}
+	})
+
+	t.Run("test changes to the list's tasks are sent", func(t *testing.T) {
+		added := todo.Task{Name: "Added", ListId: listId}
+		_, err := tasks.AddTask(&added)
+		AssertNoError(t, err)
+		for _, conn := range []*websocket.Conn{tester, alex} {
+			got := expectMessage(t, conn, todo.MessageChange)
+			if got.Name != "task.created" || got.Change.Task == nil || got.Change.Task.Name != "Added" {
+				t.Errorf("got change %q with task %v, want \"task.created\" with the added task", got.Name, got.Change.Task)
+			}
+		}
+
+		_, err = taskList.ForUser(users["stranger"].Id).Add("Elsewhere")
+		AssertNoError(t, err)
+		moved := shared
+		moved.ListId = todo.DefaultListId
+		AssertNoError(t, tasks.Update(&moved))
+		for _, conn := range []*websocket.Conn{tester, alex} {
+			got := expectMessage(t, conn, todo.MessageChange)
+			if got.Name != "task.deleted" || got.Change.TaskId != shared.Id || got.Change.Task != nil {
+				t.Errorf("got change %q to task %d, want \"task.deleted\" to task %d", got.Name, got.Change.TaskId, shared.Id)
+			}
+		}
+	})
+
+	t.Run("test viewers can't edit and invalid messages return errors", func(t *testing.T) {
+		viewer := dialListSocket(t, url, tokens["viewer"], false)
+		expectMessage(t, viewer, todo.MessageTasks)
+		for _, conn := range []*websocket.Conn{viewer, tester, alex} {
+			assertPresence(t, expectMessage(t, conn, todo.MessagePresence), "alex", "tester", "viewer")
+		}
+
+		AssertNoError(t, viewer.WriteJSON(todo.Message{Type: todo.MessageEditing, TaskId: shared.Id}))
+		got := expectMessage(t, viewer, todo.MessageError)
+		if !strings.Contains(got.Error, "editor role") {
+			t.Errorf("got error %q, want it to require the editor role", got.Error)
+		}
+		AssertNoError(t, viewer.WriteMessage(websocket.TextMessage, []byte("hello")))
+		expectMessage(t, viewer, todo.MessageError)
+		AssertNoError(t, viewer.WriteJSON(todo.Message{Type: "dance"}))
+		expectMessage(t, viewer, todo.MessageError)
+		AssertNoError(t, viewer.WriteJSON(todo.Message{Type: todo.MessagePing}))
+		expectMessage(t, viewer, todo.MessagePong)
+
+		viewer.Close()
+		for _, conn := range []*websocket.Conn{tester, alex} {
+			assertPresence(t, expectMessage(t, conn, todo.MessagePresence), "alex", "tester")
+		}
+	})
+
+	t.Run("test connecting to a list that isn't shared returns 404", func(t *testing.T) {
+		header := http.Header{"Authorization": {"Bearer " + tokens["stranger"]}}
+		_, response, err := websocket.DefaultDialer.Dial(url, header)
+		if err == nil {
+			t.Fatal("connected to a list that isn't shared")
+		}
+		assertStatus(t, response.StatusCode, http.StatusNotFound)
+	})
+
+	t.Run("Other list requests can't authenticate with a subprotocol", func(t *testing.T) {
+		request, _ := http.NewRequest(http.MethodGet, server.URL+"/lists/"+strconv.Itoa(int(listId)), nil)
+		request.Header.Set("Sec-WebSocket-Protocol", "todo, bearer."+tokens["tester"])
+		response, err := http.DefaultClient.Do(request)
+		AssertNoError(t, err)
+		response.Body.Close()
+		assertStatus(t, response.StatusCode, http.StatusUnauthorized)
+	})
+}
+
+// dialListSocket connects to a list's WebSocket with token, sent as a
+// subprotocol like a browser would if asked, closing it when the test
+// finishes.
+func dialListSocket(t testing.TB, url, token string, subprotocol bool) *websocket.Conn {
+	t.Helper()
+	dialer := *websocket.DefaultDialer
+	header := http.Header{}
+	if subprotocol {
+		dialer.Subprotocols = []string{"todo", "bearer." + token}
+	} else {
+		header.Set("Authorization", "Bearer "+token)
+	}
+
+	conn, response, err := dialer.Dial(url, header)
+	AssertNoError(t, err)
+	assertStatus(t, response.StatusCode, http.StatusSwitchingProtocols)
+	t.Cleanup(func() { conn.Close() })
+	return conn
+}
+
+// expectMessage reads the next message from a WebSocket, which must be of
+// the given type.
+func expectMessage(t testing.TB, conn *websocket.Conn, messageType todo.MessageType) todo.Message {
+	t.Helper()
+	conn.SetReadDeadline(time.Now().Add(5 * time.Second))
+	var got todo.Message
+	AssertNoError(t, conn.ReadJSON(&got))
+	if got.Type != messageType {
+		t.Fatalf("got a %q message %+v, want %q", got.Type, got, messageType)
+	}
+	return got
+}
+
+func assertPresence(t testing.TB, got todo.Message, usernames ...string) {
+	t.Helper()
+	present := []string{}
+	for _, presence := range got.Presence {
+		present = append(present, presence.Username)
+	}
+	if !reflect.DeepEqual(present, usernames) {
+		t.Errorf("got %v present, want %v", present, usernames)
+	}
+}
+
+type serverEvent struct {
+	id     todo.EventId
+	name   string
+	change todo.Change
+}
+
+// openEventStream connects to the event stream as the test user, closing it
+// when the test finishes.
+func openEventStream(t testing.TB, url, lastEventId string) *bufio.Reader {
+	t.Helper()
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	t.Cleanup(cancel)
+	request, _ := http.NewRequestWithContext(ctx, http.MethodGet, url+"/events", nil)
+	if lastEventId != "" {
+		request.Header.Set("Last-Event-ID", lastEventId)
+	}
+
+	response, err := http.DefaultClient.Do(request)
+	AssertNoError(t, err)
+	t.Cleanup(func() { response.Body.Close() })
+	assertStatus(t, response.StatusCode, http.StatusOK)
+	if got := response.Header.Get("Content-Type"); got != "text/event-stream" {
+		t.Fatalf("got content-type %q, want text/event-stream", got)
+	}
+	return bufio.NewReader(response.Body)
+}
+
+// readServerEvent reads the next event from a stream, skipping comments.
+func readServerEvent(t testing.TB, stream *bufio.Reader) serverEvent {
+	t.Helper()
+	var event serverEvent
+	for {
+		line, err := stream.ReadString('\n')
+		if err != nil {
+			t.Fatalf("could not read the next event, %v", err)
+		}
+		field, value, _ := strings.Cut(strings.TrimSuffix(line, "\n"), ": ")
+		switch field {
+		case "id":
+			id, _ := strconv.ParseInt(value, 10, 64)
+			event.id = todo.EventId(id)
+		case "event":
+			event.name = value
+		case "data":
+			AssertNoError(t, json.Unmarshal([]byte(value), &event.change))
+		case "":
+			if event.name != "" {
+				return event
+			}
+		}
+	}
+}
+
+// authorizedServer makes every request that doesn't have its own
+// authorization as a logged in user.
+type authorizedServer struct {
+	server http.Handler
+	token  string
+}
+
+func (a authorizedServer) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if r.Header.Get("Authorization") == "" {
+		r.Header.Set("Authorization", "Bearer "+a.token)
+	}
+	a.server.ServeHTTP(w, r)
+}
+
+// newTestServer returns a server for taskList whose requests are made by the
+// first user to register, who owns everything that is already stored.
+func newTestServer(t testing.TB, storage todo.UserStorage, taskList *todo.TaskList) http.Handler {
+	t.Helper()
+	accounts := todo.CreateAccounts(storage)
+	_, err := accounts.Register("tester", "correct horse")
+	if err != nil {
+		t.Fatal(err)
+	}
+	token, _, err := accounts.Login("tester", "correct horse")
+	if err != nil {
+		t.Fatal(err)
+	}
+	return authorizedServer{todo.NewTaskServer(taskList, accounts), token}
+}
+
+func assertStatus(t testing.TB, got, want int) {
+	t.Helper()
 
 	if got != want {
 		t.Fatalf("got status %d, want %d", got, want)
@@ -221,6 +1734,17 @@ func decodeTaskList(t testing.TB, taskList *bytes.Buffer) []todo.Task {
 	return got
 }
 
+func decodeProblem(t testing.TB, problem *bytes.Buffer) todo.Problem {
+	t.Helper()
+	var got todo.Problem
+	err := json.NewDecoder(problem).Decode(&got)
+
+	if err != nil {
+		t.Fatalf("Could not decode json, %v", err)
+	}
+	return got
+}
+
 func decodeTask(t testing.TB, task *bytes.Buffer) todo.Task {
 	t.Helper()
 	var got todo.Task
@@ -0,0 +1,110 @@
+package todo
+
+import (
+	"fmt"
+)
+
+// Role is what a user may do with a list. Each role allows everything the
+// roles before it do.
+type Role string
+
+const (
+	// RoleViewer can see the list and its tasks.
+	RoleViewer Role = "viewer"
+	// RoleEditor can also add, change and delete the list's tasks.
+	RoleEditor Role = "editor"
+	// RoleOwner can also share and delete the list.
+	RoleOwner Role = "owner"
+)
+
+var roleRanks = map[Role]int{RoleViewer: 1, RoleEditor: 2, RoleOwner: 3}
+
+// Includes reports whether someone with role r may do what required allows.
+func (r Role) Includes(required Role) bool {
+	return roleRanks[r] >= roleRanks[required]
+}
+
+// Member is a user a list is shared with. The user who created a list is
+// always a member with the owner role.
+type Member struct {
+	UserId   UserId `json:"user_id"`
+	Username string `json:"username"`
+	Role     Role   `json:"role" validate:"required,oneof=viewer editor owner"`
+}
+
+var ErrShareDefaultList = fmt.Errorf("%w: the default list can't be shared", ErrConflict)
+
+func errRoleRequired(role Role) error {
+	return fmt.Errorf("%w: this requires the %s role on the list", ErrForbidden, role)
+}
+
+// requireRole returns ErrForbidden unless the user has at least role on a
+// list.
+func (t *TaskList) requireRole(id ListId, role Role) error {
+	have, err := t.storage.GetRole(id)
+	if err != nil {
+		return err
+	}
+	if !have.Includes(role) {
+		return errRoleRequired(role)
+	}
+	return nil
+}
+
+// requireTaskRole returns ErrForbidden unless the user has at least role on
+// the list of a stored task.
+func (t *TaskList) requireTaskRole(id TaskId, role Role) error {
+	task, err := t.storage.GetTask(id)
+	if err != nil {
+		return err
+	}
+	return t.requireRole(task.ListId, role)
+}
+
+// GetMembers returns the users a list is shared with, starting with the
+// user who created it.
+func (t *TaskList) GetMembers(id ListId) ([]Member, error) {
+	_, err := t.storage.GetList(id)
+	if err != nil {
+		return nil, err
+	}
+	return t.storage.GetMembers(id)
+}
+
+// ShareList gives a user a role on a list, or changes the role they have.
+// Only owners can share a list.
+func (t *TaskList) ShareList(id ListId, user User, role Role) (Member, error) {
+	if id == DefaultListId {
+		return Member{}, ErrShareDefaultList
+	}
+	list, err := t.storage.GetList(id)
+	if err != nil {
+		return Member{}, err
+	}
+	err = t.requireRole(id, RoleOwner)
+	if err != nil {
+		return Member{}, err
+	}
+
+	member := Member{UserId: user.Id, Username: user.Username, Role: role}
+	err = validateStruct(&member)
+	if err != nil {
+		return Member{}, err
+	}
+	if user.Id == list.OwnerId {
+		return Member{}, fieldError("username", "already owns the list")
+	}
+	return member, t.storage.SetMember(id, user.Id, role)
+}
+
+// Unshare removes a user from a list. Owners can remove anyone, and other
+// members can only remove themselves.
+func (t *TaskList) Unshare(id ListId, userId UserId) error {
+	if userId != t.user {
+		err := t.requireRole(id, RoleOwner)
+		if err != nil {
+			return err
+		}
+	}
+	return t.storage.RemoveMember(id, userId)
+}
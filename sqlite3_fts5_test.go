@@ -0,0 +1,28 @@
+//go:build cgo && sqlite_fts5
+
+package todo_test
+
+import (
+	"testing"
+
+	todo "github.com/rosswf/go-todo"
+)
+
+// TestSqlite3SearchRanking checks that builds with FTS5 rank search results,
+// where without it they are in id order.
+func TestSqlite3SearchRanking(t *testing.T) {
+	storage := createSqlite3Storage(t)
+	storage.Add(&todo.Task{Name: "Buy apples, pears, plums, grapes and cherries"})
+	storage.Add(&todo.Task{Name: "Apple <pie>"})
+
+	got, err := storage.Search("apple")
+	AssertNoError(t, err)
+
+	if len(got) != 2 || got[0].Id != 2 || got[1].Id != 1 {
+		t.Fatalf("got results %+v, want the shorter name first", got)
+	}
+	want := todo.HighlightStart + "Apple" + todo.HighlightEnd + " &lt;pie&gt;"
+	if got[0].Snippet != want {
+		t.Errorf("got snippet %q, want %q", got[0].Snippet, want)
+	}
+}
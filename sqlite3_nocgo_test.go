@@ -0,0 +1,10 @@
+//go:build !cgo
+
+package todo_test
+
+import "testing"
+
+// createSqlite3Storage returns nil, as SQLite needs cgo.
+func createSqlite3Storage(t testing.TB) accountStorage {
+	return nil
+}
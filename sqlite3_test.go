@@ -0,0 +1,457 @@
+//go:build cgo
+
+package todo_test
+
+import (
+	"database/sql"
+	"errors"
+	"path/filepath"
+	"reflect"
+	"testing"
+	"time"
+
+	_ "github.com/mattn/go-sqlite3"
+	todo "github.com/rosswf/go-todo"
+	storage "github.com/rosswf/go-todo/storage"
+	"github.com/rosswf/go-todo/storage/storagetest"
+)
+
+// createSqlite3Storage returns empty in-memory SQLite storage.
+func createSqlite3Storage(t testing.TB) accountStorage {
+	t.Helper()
+	sqliteStorage, err := storage.CreateSqlite3TaskStorage(":memory:")
+	AssertNoError(t, err)
+	return sqliteStorage
+}
+
+func TestSqlite3TaskStorage(t *testing.T) {
+	storage, err := storage.CreateSqlite3TaskStorage(":memory:")
+	AssertNoError(t, err)
+
+	t.Run("Add a new task to sqlite storage", func(t *testing.T) {
+		id := AddTaskToDB(t, storage, "Task 1", false)
+
+		if id != 1 {
+			t.Errorf("got %d want %d", id, 1)
+		}
+
+		got, err := storage.GetAll()
+		AssertNoError(t, err)
+
+		want := []todo.Task{{Id: 1, Name: "Task 1", Complete: false, ListId: 1, Position: "V"}}
+
+		AssertTaskListsEqual(t, got, want)
+	})
+
+	t.Run("Get a single task by ID", func(t *testing.T) {
+		AddTaskToDB(t, storage, "Task 2", true)
+
+		got, err := storage.GetTask(2)
+		AssertNoError(t, err)
+
+		want := &todo.Task{Id: 2, Name: "Task 2", Complete: true, ListId: 1, Position: "W"}
+
+		if !reflect.DeepEqual(got, want) {
+			t.Errorf("got %+v, want %+v", got, want)
+		}
+	})
+
+	t.Run("Complete a task", func(t *testing.T) {
+		completedAt := time.Date(2022, 10, 1, 12, 0, 0, 0, time.UTC)
+		err := storage.Complete(1, completedAt)
+		AssertNoError(t, err)
+
+		got, _ := storage.GetTask(1)
+
+		want := &todo.Task{Id: 1, Name: "Task 1", Complete: true, ListId: 1, CompletedAt: &completedAt, Position: "V"}
+
+		if !reflect.DeepEqual(got, want) {
+			t.Errorf("got %+v, want %+v", got, want)
+		}
+	})
+
+	t.Run("Get oustanding tasks", func(t *testing.T) {
+		AddTaskToDB(t, storage, "Task 3", false)
+		AddTaskToDB(t, storage, "Task 4", false)
+
+		got, err := storage.GetOutstanding()
+		AssertNoError(t, err)
+
+		want := []todo.Task{
+			{Id: 3, Name: "Task 3", Complete: false, ListId: 1, Position: "X"},
+			{Id: 4, Name: "Task 4", Complete: false, ListId: 1, Position: "Y"},
+		}
+
+		AssertTaskListsEqual(t, got, want)
+	})
+
+	t.Run("Trash a task", func(t *testing.T) {
+		err := storage.Trash(2, time.Now())
+		AssertNoError(t, err)
+
+		got, _ := storage.GetAll()
+		completed, _ := storage.GetTask(1)
+
+		want := []todo.Task{
+			{Id: 1, Name: "Task 1", Complete: true, ListId: 1, CompletedAt: completed.CompletedAt, Position: "V"},
+			{Id: 3, Name: "Task 3", Complete: false, ListId: 1, Position: "X"},
+			{Id: 4, Name: "Task 4", Complete: false, ListId: 1, Position: "Y"},
+		}
+
+		AssertTaskListsEqual(t, got, want)
+	})
+}
+
+func TestSqlite3Update(t *testing.T) {
+	storage, err := storage.CreateSqlite3TaskStorage(":memory:")
+	AssertNoError(t, err)
+
+	due := time.Date(2022, 10, 1, 12, 0, 0, 0, time.UTC)
+	AddTaskToDB(t, storage, "Task 1", false)
+
+	t.Run("Update every field of a task", func(t *testing.T) {
+		listId, _ := storage.AddList(&todo.List{Name: "Work"})
+		want := &todo.Task{Id: 1, Name: "Renamed", Complete: true, ListId: listId, Due: &due, Position: "V",
+			Recurrence: "FREQ=DAILY"}
+
+		err := storage.Update(want)
+		AssertNoError(t, err)
+
+		got, _ := storage.GetTask(1)
+
+		if !reflect.DeepEqual(got, want) {
+			t.Errorf("got %+v, want %+v", got, want)
+		}
+	})
+
+	t.Run("Updating a missing task is a not found error", func(t *testing.T) {
+		err := storage.Update(&todo.Task{Id: 9, Name: "Missing", ListId: 1})
+		if !errors.Is(err, todo.ErrNotFound) {
+			t.Errorf("got error %v, want %v", err, todo.ErrNotFound)
+		}
+	})
+
+	t.Run("Getting a missing task is a not found error", func(t *testing.T) {
+		_, err := storage.GetTask(9)
+		if !errors.Is(err, todo.ErrNotFound) {
+			t.Errorf("got error %v, want %v", err, todo.ErrNotFound)
+		}
+	})
+
+	t.Run("Trashing a missing task is a not found error", func(t *testing.T) {
+		err := storage.Trash(9, due)
+		if !errors.Is(err, todo.ErrNotFound) {
+			t.Errorf("got error %v, want %v", err, todo.ErrNotFound)
+		}
+	})
+}
+
+func TestSqlite3Tags(t *testing.T) {
+	storage, err := storage.CreateSqlite3TaskStorage(":memory:")
+	AssertNoError(t, err)
+
+	storage.Add(&todo.Task{Name: "Shopping", Tags: []string{"errands", "home"}})
+	storage.Add(&todo.Task{Name: "Gardening", Tags: []string{"home"}})
+
+	t.Run("Tags are stored with a task", func(t *testing.T) {
+		got, _ := storage.GetTask(1)
+		want := []string{"errands", "home"}
+
+		if !reflect.DeepEqual(got.Tags, want) {
+			t.Errorf("got %v, want %v", got.Tags, want)
+		}
+	})
+
+	t.Run("Updating a task replaces its tags", func(t *testing.T) {
+		err := storage.Update(&todo.Task{Id: 2, Name: "Gardening", ListId: 1, Tags: []string{"outdoors"}})
+		AssertNoError(t, err)
+
+		got, _ := storage.GetTask(2)
+		want := []string{"outdoors"}
+
+		if !reflect.DeepEqual(got.Tags, want) {
+			t.Errorf("got %v, want %v", got.Tags, want)
+		}
+	})
+
+	t.Run("Tags are counted by the tasks using them", func(t *testing.T) {
+		storage.Trash(1, time.Now())
+
+		got, err := storage.GetTags()
+		AssertNoError(t, err)
+
+		want := []todo.Tag{{Name: "outdoors", Count: 1}}
+
+		if !reflect.DeepEqual(got, want) {
+			t.Errorf("got %v, want %v", got, want)
+		}
+	})
+}
+
+func TestSqlite3Subtasks(t *testing.T) {
+	storage, err := storage.CreateSqlite3TaskStorage(":memory:")
+	AssertNoError(t, err)
+
+	listId, _ := storage.AddList(&todo.List{Name: "Work"})
+	storage.Add(&todo.Task{Name: "Release", ListId: listId})
+	storage.Add(&todo.Task{Name: "Push tag", ListId: 1})
+	storage.Add(&todo.Task{Name: "Tag version", ListId: listId, ParentId: 1})
+	storage.Update(&todo.Task{Id: 2, Name: "Push tag", ListId: 1, ParentId: 3})
+
+	t.Run("Get subtasks at any depth", func(t *testing.T) {
+		got, err := storage.GetSubtasks(1)
+		AssertNoError(t, err)
+
+		want := []todo.Task{
+			{Id: 2, Name: "Push tag", ListId: 1, ParentId: 3, Position: "V"},
+			{Id: 3, Name: "Tag version", ListId: listId, ParentId: 1, Position: "W"},
+		}
+
+		AssertTaskListsEqual(t, got, want)
+	})
+
+	t.Run("Deleting a list keeps subtasks in other lists", func(t *testing.T) {
+		err := storage.DeleteList(listId, time.Now())
+		AssertNoError(t, err)
+
+		got, _ := storage.GetAll()
+		want := []todo.Task{{Id: 2, Name: "Push tag", ListId: 1, Position: "V"}}
+
+		AssertTaskListsEqual(t, got, want)
+	})
+}
+
+func TestSqlite3Completion(t *testing.T) {
+	storage, err := storage.CreateSqlite3TaskStorage(":memory:")
+	AssertNoError(t, err)
+
+	now := time.Date(2022, 10, 1, 12, 0, 0, 0, time.UTC)
+	AddTaskToDB(t, storage, "Task 1", false)
+
+	t.Run("Completing a task twice keeps the first completion time", func(t *testing.T) {
+		AssertNoError(t, storage.Complete(1, now))
+		AssertNoError(t, storage.Complete(1, now.Add(time.Hour)))
+
+		got, _ := storage.GetTask(1)
+		want := &todo.Task{Id: 1, Name: "Task 1", Complete: true, ListId: 1, CompletedAt: &now, Position: "V"}
+
+		if !reflect.DeepEqual(got, want) {
+			t.Errorf("got %+v, want %+v", got, want)
+		}
+	})
+
+	t.Run("Reopening a task clears the completion time", func(t *testing.T) {
+		AssertNoError(t, storage.Reopen(1))
+		AssertNoError(t, storage.Reopen(1))
+
+		got, _ := storage.GetTask(1)
+		want := &todo.Task{Id: 1, Name: "Task 1", Complete: false, ListId: 1, Position: "V"}
+
+		if !reflect.DeepEqual(got, want) {
+			t.Errorf("got %+v, want %+v", got, want)
+		}
+	})
+
+	t.Run("Completing a missing task is a not found error", func(t *testing.T) {
+		err := storage.Complete(9, now)
+		if !errors.Is(err, todo.ErrNotFound) {
+			t.Errorf("got error %v, want %v", err, todo.ErrNotFound)
+		}
+	})
+}
+
+func TestSqlite3ListStorage(t *testing.T) {
+	storage, err := storage.CreateSqlite3TaskStorage(":memory:")
+	AssertNoError(t, err)
+
+	t.Run("The default list exists", func(t *testing.T) {
+		got, err := storage.GetLists()
+		AssertNoError(t, err)
+
+		want := []todo.List{{Id: todo.DefaultListId, Name: "Tasks"}}
+
+		if !reflect.DeepEqual(got, want) {
+			t.Errorf("got %+v, want %+v", got, want)
+		}
+	})
+
+	t.Run("Add a list and a task to it", func(t *testing.T) {
+		id, err := storage.AddList(&todo.List{Name: "Work"})
+		AssertNoError(t, err)
+
+		if id != 2 {
+			t.Errorf("got %d want %d", id, 2)
+		}
+
+		AddTaskToDB(t, storage, "Default task", false)
+		_, err = storage.Add(&todo.Task{Name: "Work task", ListId: id})
+		AssertNoError(t, err)
+
+		got, err := storage.GetListTasks(id)
+		AssertNoError(t, err)
+
+		want := []todo.Task{{Id: 2, Name: "Work task", Complete: false, ListId: 2, Position: "V"}}
+
+		AssertTaskListsEqual(t, got, want)
+	})
+
+	t.Run("Delete a list, trashing its tasks", func(t *testing.T) {
+		err := storage.DeleteList(2, time.Now())
+		AssertNoError(t, err)
+
+		_, err = storage.GetList(2)
+		if !errors.Is(err, todo.ErrNotFound) {
+			t.Errorf("got error %v, want %v", err, todo.ErrNotFound)
+		}
+
+		got, _ := storage.GetAll()
+		want := []todo.Task{{Id: 1, Name: "Default task", Complete: false, ListId: 1, Position: "V"}}
+
+		AssertTaskListsEqual(t, got, want)
+	})
+}
+
+func TestSqlite3DueDates(t *testing.T) {
+	storage, err := storage.CreateSqlite3TaskStorage(":memory:")
+	AssertNoError(t, err)
+
+	now := time.Date(2022, 10, 1, 12, 0, 0, 0, time.UTC)
+	yesterday := now.AddDate(0, 0, -1)
+	tomorrow := now.Add(90 * time.Minute).In(time.FixedZone("UTC+2", 2*60*60))
+	tomorrowUTC := tomorrow.UTC()
+
+	storage.Add(&todo.Task{Name: "Overdue", Due: &yesterday})
+	storage.Add(&todo.Task{Name: "Due soon", Due: &tomorrow, RemindAt: &now})
+	storage.Add(&todo.Task{Name: "Done", Due: &yesterday, Complete: true})
+	storage.Add(&todo.Task{Name: "Whenever"})
+
+	t.Run("Due dates are stored", func(t *testing.T) {
+		got, err := storage.GetTask(2)
+		AssertNoError(t, err)
+
+		want := &todo.Task{Id: 2, Name: "Due soon", ListId: 1, Due: &tomorrowUTC, RemindAt: &now, Position: "W"}
+
+		if !reflect.DeepEqual(got, want) {
+			t.Errorf("got %+v, want %+v", got, want)
+		}
+	})
+
+	t.Run("Get overdue tasks", func(t *testing.T) {
+		got, err := storage.GetOverdue(now)
+		AssertNoError(t, err)
+
+		want := []todo.Task{{Id: 1, Name: "Overdue", ListId: 1, Due: &yesterday, Position: "V"}}
+
+		AssertTaskListsEqual(t, got, want)
+	})
+
+	t.Run("Get tasks due in a range", func(t *testing.T) {
+		got, err := storage.GetDue(yesterday, now.AddDate(0, 0, 1))
+		AssertNoError(t, err)
+
+		want := []todo.Task{{Id: 2, Name: "Due soon", ListId: 1, Due: &tomorrowUTC, RemindAt: &now, Position: "W"}}
+
+		AssertTaskListsEqual(t, got, want)
+	})
+}
+
+func TestSqlite3Migrations(t *testing.T) {
+	t.Run("A database from before schema versions is upgraded in place", func(t *testing.T) {
+		location := filepath.Join(t.TempDir(), "tasks.db")
+
+		db, err := sql.Open("sqlite3", location)
+		AssertNoError(t, err)
+		_, err = db.Exec(`CREATE TABLE tasks
+(id INTEGER not null primary key, name TEXT, complete BOOL);
+INSERT INTO tasks(name, complete) VALUES('Old task', true);`)
+		AssertNoError(t, err)
+		db.Close()
+
+		taskStorage, err := storage.CreateSqlite3TaskStorage(location)
+		AssertNoError(t, err)
+
+		got, err := taskStorage.GetAll()
+		AssertNoError(t, err)
+
+		// Existing tasks are given positions in id order.
+		want := []todo.Task{{Id: 1, Name: "Old task", Complete: true, ListId: 1, Position: "00000000011"}}
+
+		AssertTaskListsEqual(t, got, want)
+		taskStorage.Close()
+
+		assertSchemaVersion(t, location, latestSchemaVersion(t))
+	})
+
+	t.Run("A migrated database can be reopened", func(t *testing.T) {
+		location := filepath.Join(t.TempDir(), "tasks.db")
+
+		taskStorage, err := storage.CreateSqlite3TaskStorage(location)
+		AssertNoError(t, err)
+		AddTaskToDB(t, taskStorage, "Task 1", false)
+		taskStorage.Close()
+
+		taskStorage, err = storage.CreateSqlite3TaskStorage(location)
+		AssertNoError(t, err)
+		defer taskStorage.Close()
+
+		got, _ := taskStorage.GetAll()
+		want := []todo.Task{{Id: 1, Name: "Task 1", Complete: false, ListId: 1, Position: "V"}}
+
+		AssertTaskListsEqual(t, got, want)
+	})
+
+	t.Run("A database from a newer version is refused", func(t *testing.T) {
+		location := filepath.Join(t.TempDir(), "tasks.db")
+
+		db, err := sql.Open("sqlite3", location)
+		AssertNoError(t, err)
+		_, err = db.Exec("PRAGMA user_version = 999")
+		AssertNoError(t, err)
+		db.Close()
+
+		_, err = storage.CreateSqlite3TaskStorage(location)
+		if !errors.Is(err, storage.ErrSchemaTooNew) {
+			t.Errorf("got error %v, want %v", err, storage.ErrSchemaTooNew)
+		}
+	})
+}
+
+// latestSchemaVersion is the number of SQLite migrations, which is the version
+// databases are migrated to when opened.
+func latestSchemaVersion(t testing.TB) int {
+	t.Helper()
+	migrations, err := filepath.Glob(filepath.Join("storage", "migrations", "*.sql"))
+	AssertNoError(t, err)
+	return len(migrations)
+}
+
+func assertSchemaVersion(t testing.TB, location string, want int) {
+	t.Helper()
+	db, err := sql.Open("sqlite3", location)
+	AssertNoError(t, err)
+	defer db.Close()
+
+	var got int
+	err = db.QueryRow("PRAGMA user_version").Scan(&got)
+	AssertNoError(t, err)
+
+	if got != want {
+		t.Errorf("got schema version %d, want %d", got, want)
+	}
+}
+
+func TestSqlite3StorageConformance(t *testing.T) {
+	storagetest.Run(t, func(t *testing.T) todo.TaskStorage {
+		sqliteStorage, err := storage.CreateSqlite3TaskStorage(":memory:")
+		AssertNoError(t, err)
+		return sqliteStorage
+	})
+}
+
+func AddTaskToDB(t testing.TB, storage *storage.Sqlite3TaskStorage, name string, status bool) todo.TaskId {
+	t.Helper()
+	task := todo.Task{Name: name, Complete: status}
+	id, err := storage.Add(&task)
+	AssertNoError(t, err)
+	return id
+}
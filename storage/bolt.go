@@ -0,0 +1,294 @@
+package todo_storage
+
+import (
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"sort"
+	"time"
+
+	bolt "go.etcd.io/bbolt"
+)
+
+// boltVersion is increased whenever a database written by an older version
+// couldn't be read as it is.
+const boltVersion = 1
+
+// The buckets in a bbolt database. Each record is stored as JSON under its
+// id, apart from sessions which are under their token hash and members which
+// are under their list and user ids.
+var (
+	boltMeta      = []byte("meta")
+	boltTasks     = []byte("tasks")
+	boltLists     = []byte("lists")
+	boltEvents    = []byte("events")
+	boltUsers     = []byte("users")
+	boltSessions  = []byte("sessions")
+	boltAPITokens = []byte("api_tokens")
+	boltMembers   = []byte("members")
+
+	boltVersionKey = []byte("version")
+	boltLastKey    = []byte("last")
+)
+
+// boltStore saves what has changed to a bbolt database, in one transaction.
+type boltStore struct {
+	db *bolt.DB
+}
+
+// OpenBoltTaskStorage returns storage that is loaded from the bbolt database
+// at path, which is created if it doesn't exist, and saves each change to it.
+// Everything is kept in memory as it is by MemoryTaskStorage, but only what
+// changed is written. It is pure Go, so works in builds without cgo. Only one
+// process can have the database open, which the others fail to open while it
+// is locked.
+func OpenBoltTaskStorage(path string) (*MemoryTaskStorage, error) {
+	db, err := bolt.Open(path, 0600, &bolt.Options{Timeout: time.Second})
+	if errors.Is(err, bolt.ErrTimeout) {
+		return nil, fmt.Errorf("opening %s: it is in use by another process, and only one can use a bbolt database at a time: %w",
+			path, err)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	s := CreateMemoryTaskStorage(nil)
+	s.store = boltStore{db}
+	err = db.Update(func(tx *bolt.Tx) error {
+		meta := tx.Bucket(boltMeta)
+		if meta == nil {
+			return createBoltBuckets(tx, s.memoryData)
+		}
+		return loadBolt(tx, s.memoryData)
+	})
+	if err != nil {
+		db.Close()
+		return nil, fmt.Errorf("opening %s: %w", path, err)
+	}
+	s.changes = newMemoryChanges()
+	return s, nil
+}
+
+// createBoltBuckets sets up a new database, saving the default list.
+func createBoltBuckets(tx *bolt.Tx, data *memoryData) error {
+	for _, bucket := range [][]byte{boltMeta, boltTasks, boltLists, boltEvents, boltUsers,
+		boltSessions, boltAPITokens, boltMembers} {
+		_, err := tx.CreateBucket(bucket)
+		if err != nil {
+			return err
+		}
+	}
+	err := putBolt(tx.Bucket(boltMeta), boltVersionKey, boltVersion)
+	if err != nil {
+		return err
+	}
+	for _, list := range data.lists {
+		data.changes.lists[list.Id] = true
+	}
+	return boltStore{}.write(tx, data)
+}
+
+func loadBolt(tx *bolt.Tx, data *memoryData) error {
+	var version int
+	err := getBolt(tx.Bucket(boltMeta), boltVersionKey, &version)
+	if err != nil {
+		return err
+	}
+	if version != boltVersion {
+		return fmt.Errorf("version %d databases aren't supported", version)
+	}
+
+	var snapshot memorySnapshot
+	err = getBolt(tx.Bucket(boltMeta), boltLastKey, &snapshot.Last)
+	if err != nil {
+		return err
+	}
+	// Keys are in order, so records numbered by id are loaded in order of id.
+	loads := []struct {
+		bucket []byte
+		add    func(value []byte) error
+	}{
+		{boltTasks, appendJSON(&snapshot.Tasks)},
+		{boltLists, appendJSON(&snapshot.Lists)},
+		{boltEvents, appendJSON(&snapshot.Events)},
+		{boltUsers, appendJSON(&snapshot.Users)},
+		{boltSessions, appendJSON(&snapshot.Sessions)},
+		{boltAPITokens, appendJSON(&snapshot.APITokens)},
+		{boltMembers, appendJSON(&snapshot.Members)},
+	}
+	for _, load := range loads {
+		err = tx.Bucket(load.bucket).ForEach(func(_, value []byte) error {
+			return load.add(value)
+		})
+		if err != nil {
+			return err
+		}
+	}
+	snapshot.restore(data)
+	return nil
+}
+
+// appendJSON returns a function that decodes a record and appends it to
+// records.
+func appendJSON[T any](records *[]T) func([]byte) error {
+	return func(value []byte) error {
+		var record T
+		err := json.Unmarshal(value, &record)
+		*records = append(*records, record)
+		return err
+	}
+}
+
+func (store boltStore) save(data *memoryData) error {
+	return store.db.Update(func(tx *bolt.Tx) error {
+		return store.write(tx, data)
+	})
+}
+
+// write puts each changed record, or deletes it if it no longer exists, and
+// the last ids given out.
+func (boltStore) write(tx *bolt.Tx, data *memoryData) error {
+	tasks := tx.Bucket(boltTasks)
+	for id := range data.changes.tasks {
+		err := putOrDelete(tasks, boltId(int64(id)), func() (any, bool) {
+			i := sort.Search(len(data.tasks), func(i int) bool { return data.tasks[i].Id >= id })
+			if i < len(data.tasks) && data.tasks[i].Id == id {
+				return snapshotTask{data.tasks[i], data.tasks[i].OwnerId}, true
+			}
+			return nil, false
+		})
+		if err != nil {
+			return err
+		}
+	}
+
+	lists := tx.Bucket(boltLists)
+	for id := range data.changes.lists {
+		err := putOrDelete(lists, boltId(int64(id)), func() (any, bool) {
+			for _, list := range data.lists {
+				if list.Id == id {
+					return snapshotList{list, list.OwnerId}, true
+				}
+			}
+			return nil, false
+		})
+		if err != nil {
+			return err
+		}
+	}
+
+	events := tx.Bucket(boltEvents)
+	for id := range data.changes.events {
+		err := putOrDelete(events, boltId(int64(id)), func() (any, bool) {
+			i := sort.Search(len(data.events), func(i int) bool { return data.events[i].Id >= id })
+			if i < len(data.events) && data.events[i].Id == id {
+				return data.events[i], true
+			}
+			return nil, false
+		})
+		if err != nil {
+			return err
+		}
+	}
+
+	users := tx.Bucket(boltUsers)
+	for id := range data.changes.users {
+		err := putOrDelete(users, boltId(int64(id)), func() (any, bool) {
+			for _, user := range data.users {
+				if user.Id == id {
+					return snapshotUser{user, user.PasswordHash}, true
+				}
+			}
+			return nil, false
+		})
+		if err != nil {
+			return err
+		}
+	}
+
+	sessions := tx.Bucket(boltSessions)
+	for tokenHash := range data.changes.sessions {
+		err := putOrDelete(sessions, []byte(tokenHash), func() (any, bool) {
+			for _, session := range data.sessions {
+				if session.TokenHash == tokenHash {
+					return session, true
+				}
+			}
+			return nil, false
+		})
+		if err != nil {
+			return err
+		}
+	}
+
+	apiTokens := tx.Bucket(boltAPITokens)
+	for id := range data.changes.apiTokens {
+		err := putOrDelete(apiTokens, boltId(int64(id)), func() (any, bool) {
+			for _, token := range data.apiTokens {
+				if token.Id == id {
+					return snapshotToken{token, token.UserId, token.TokenHash}, true
+				}
+			}
+			return nil, false
+		})
+		if err != nil {
+			return err
+		}
+	}
+
+	members := tx.Bucket(boltMembers)
+	for key := range data.changes.members {
+		err := putOrDelete(members, append(boltId(int64(key.ListId)), boltId(int64(key.UserId))...),
+			func() (any, bool) {
+				for _, member := range data.members {
+					if member.key() == key {
+						return member, true
+					}
+				}
+				return nil, false
+			})
+		if err != nil {
+			return err
+		}
+	}
+
+	return putBolt(tx.Bucket(boltMeta), boltLastKey, data.last)
+}
+
+func (store boltStore) close() error {
+	return store.db.Close()
+}
+
+// boltId encodes an id as a key, big endian so that keys are in order of id.
+func boltId(id int64) []byte {
+	key := make([]byte, 8)
+	binary.BigEndian.PutUint64(key, uint64(id))
+	return key
+}
+
+// putOrDelete puts the record that find finds under key, or deletes the key
+// if it finds nothing.
+func putOrDelete(bucket *bolt.Bucket, key []byte, find func() (any, bool)) error {
+	record, found := find()
+	if !found {
+		return bucket.Delete(key)
+	}
+	return putBolt(bucket, key, record)
+}
+
+func putBolt(bucket *bolt.Bucket, key []byte, record any) error {
+	value, err := json.Marshal(record)
+	if err != nil {
+		return err
+	}
+	return bucket.Put(key, value)
+}
+
+func getBolt(bucket *bolt.Bucket, key []byte, record any) error {
+	value := bucket.Get(key)
+	if value == nil {
+		return fmt.Errorf("the database has no %s", key)
+	}
+	return json.Unmarshal(value, record)
+}
@@ -0,0 +1,75 @@
+package todo_storage
+
+import (
+	todo "github.com/rosswf/go-todo"
+)
+
+// AddEvent records an event, which belongs to the owner of its task.
+func (s *Sqlite3TaskStorage) AddEvent(event *todo.Event) (todo.EventId, error) {
+	sqlStmt := `INSERT INTO events(task_id, type, name, previous, at, owner_id, actor_id)
+values(?, ?, ?, ?, ?, (SELECT owner_id FROM tasks WHERE id = ?), ?)`
+	result, err := s.conn.Exec(sqlStmt, event.TaskId, event.Type, event.Name,
+		event.Previous, event.At.UTC(), event.TaskId, nullUserId(event.ActorId))
+	if err != nil {
+		return -1, err
+	}
+
+	id, err := result.LastInsertId()
+	if err != nil {
+		return -1, err
+	}
+	event.Id = todo.EventId(id)
+	return event.Id, nil
+}
+
+// GetEvents returns the events matching a query, most recent first unless it
+// is Ascending.
+func (s *Sqlite3TaskStorage) GetEvents(q todo.EventQuery) ([]todo.Event, error) {
+	query := `SELECT id, task_id, type, name, previous, at, COALESCE(actor_id, 0),
+COALESCE((SELECT username FROM users WHERE users.id = events.actor_id), '') FROM events WHERE true`
+	args := []any{}
+	// Users see the events of their own tasks, even once the tasks have been
+	// purged, and of every task they can see.
+	if s.owner != 0 {
+		query += " AND (owner_id = ? OR task_id IN (SELECT id FROM tasks WHERE " + s.visibleTasks() + "))"
+		args = append(args, s.owner)
+	}
+	if q.TaskId != 0 {
+		query += " AND task_id = ?"
+		args = append(args, q.TaskId)
+	}
+	if q.After != 0 {
+		query += " AND id > ?"
+		args = append(args, q.After)
+	}
+	order := "DESC"
+	if q.Ascending {
+		order = "ASC"
+	}
+
+	// SQLite requires a LIMIT to use OFFSET, -1 means no limit.
+	limit := q.Limit
+	if limit <= 0 {
+		limit = -1
+	}
+	query += " ORDER BY id " + order + " LIMIT ? OFFSET ?"
+	args = append(args, limit, q.Offset)
+
+	events := []todo.Event{}
+	rows, err := s.conn.Query(query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var event todo.Event
+		err = rows.Scan(&event.Id, &event.TaskId, &event.Type, &event.Name,
+			&event.Previous, &event.At, &event.ActorId, &event.Actor)
+		if err != nil {
+			return nil, err
+		}
+		events = append(events, event)
+	}
+	return events, rows.Err()
+}
@@ -0,0 +1,1040 @@
+package todo_storage
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	todo "github.com/rosswf/go-todo"
+)
+
+// MemoryTaskStorage keeps everything in memory, behaving as the SQLite
+// storage does. It can be saved to a snapshot file after every change, see
+// OpenMemoryTaskStorage, which suits the CLI and demos but not a busy server
+// since the whole file is rewritten each time, or to a bbolt database, see
+// OpenBoltTaskStorage, which only writes what changed.
+type MemoryTaskStorage struct {
+	*memoryData
+	// owner is the user the storage is scoped to, or zero for everyone.
+	owner todo.UserId
+}
+
+// memoryData is shared between the views of each user returned by ForUser.
+type memoryData struct {
+	// mu guards everything below. Methods taking it don't call each other.
+	mu sync.RWMutex
+	// store saves the data after every change, if it is saved anywhere.
+	store   memoryStore
+	changes memoryChanges
+	// tasks are in order of id, with those in the trash having TrashedAt set.
+	tasks     []todo.Task
+	lists     []todo.List
+	events    []memoryEvent
+	users     []todo.User
+	sessions  []todo.Session
+	apiTokens []todo.APIToken
+	members   []memoryMember
+	// last holds the last id given out of each kind, so that none are
+	// given out twice even once what had them is deleted.
+	last memoryIds
+}
+
+// memoryStore saves a MemoryTaskStorage's data.
+type memoryStore interface {
+	// save saves the data, or at least what has changed since it was last
+	// saved. It is called with mu held.
+	save(data *memoryData) error
+	close() error
+}
+
+// memoryChanges is what has changed since the data was last saved, keyed by
+// id, including what has been deleted.
+type memoryChanges struct {
+	tasks     map[todo.TaskId]bool
+	lists     map[todo.ListId]bool
+	events    map[todo.EventId]bool
+	users     map[todo.UserId]bool
+	sessions  map[string]bool
+	apiTokens map[todo.APITokenId]bool
+	members   map[memoryMember]bool
+}
+
+func newMemoryChanges() memoryChanges {
+	return memoryChanges{
+		tasks:     map[todo.TaskId]bool{},
+		lists:     map[todo.ListId]bool{},
+		events:    map[todo.EventId]bool{},
+		users:     map[todo.UserId]bool{},
+		sessions:  map[string]bool{},
+		apiTokens: map[todo.APITokenId]bool{},
+		members:   map[memoryMember]bool{},
+	}
+}
+
+type memoryEvent struct {
+	todo.Event
+	OwnerId todo.UserId `json:"owner_id,omitempty"`
+}
+
+type memoryMember struct {
+	ListId todo.ListId `json:"list_id"`
+	UserId todo.UserId `json:"user_id"`
+	Role   todo.Role   `json:"role"`
+}
+
+// key returns the member without its role, to key changes by.
+func (m memoryMember) key() memoryMember {
+	return memoryMember{ListId: m.ListId, UserId: m.UserId}
+}
+
+type memoryIds struct {
+	Task     todo.TaskId     `json:"task"`
+	List     todo.ListId     `json:"list"`
+	Event    todo.EventId    `json:"event"`
+	User     todo.UserId     `json:"user"`
+	APIToken todo.APITokenId `json:"api_token"`
+}
+
+// CreateMemoryTaskStorage returns storage holding tasks, which are kept as
+// they are given, that isn't saved anywhere.
+func CreateMemoryTaskStorage(tasks []todo.Task) *MemoryTaskStorage {
+	data := &memoryData{
+		lists:   []todo.List{{Id: todo.DefaultListId, Name: "Tasks"}},
+		changes: newMemoryChanges(),
+		last:    memoryIds{List: todo.DefaultListId},
+	}
+	for _, task := range tasks {
+		task = copyTask(task)
+		task.Tags = sortedTags(task.Tags)
+		data.tasks = append(data.tasks, task)
+		if task.Id > data.last.Task {
+			data.last.Task = task.Id
+		}
+	}
+	sort.SliceStable(data.tasks, func(i, j int) bool { return data.tasks[i].Id < data.tasks[j].Id })
+	return &MemoryTaskStorage{memoryData: data}
+}
+
+// OpenMemoryTaskStorage returns storage that is loaded from a snapshot file,
+// if there is one, and saved to it after every change.
+func OpenMemoryTaskStorage(path string) (*MemoryTaskStorage, error) {
+	s := CreateMemoryTaskStorage(nil)
+	s.store = snapshotStore{path}
+	data, err := os.ReadFile(path)
+	if errors.Is(err, fs.ErrNotExist) {
+		return s, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	var snapshot memorySnapshot
+	err = json.Unmarshal(data, &snapshot)
+	if err != nil {
+		return nil, fmt.Errorf("reading %s: %w", path, err)
+	}
+	if snapshot.Version != memorySnapshotVersion {
+		return nil, fmt.Errorf("reading %s: version %d snapshots aren't supported", path, snapshot.Version)
+	}
+	snapshot.restore(s.memoryData)
+	return s, nil
+}
+
+// Close closes the file the storage is saved to, if there is one. Every
+// change has already been saved.
+func (s *MemoryTaskStorage) Close() {
+	if s.store != nil {
+		s.store.close()
+	}
+}
+
+func (s *MemoryTaskStorage) ForUser(id todo.UserId) todo.TaskStorage {
+	return &MemoryTaskStorage{memoryData: s.memoryData, owner: id}
+}
+
+// copyTask returns a copy of a task that shares nothing with it.
+func copyTask(task todo.Task) todo.Task {
+	if task.Tags != nil {
+		task.Tags = append([]string{}, task.Tags...)
+	}
+	for _, field := range []**time.Time{&task.Due, &task.RemindAt, &task.CompletedAt,
+		&task.CreatedAt, &task.TrashedAt} {
+		if *field != nil {
+			copied := **field
+			*field = &copied
+		}
+	}
+	return task
+}
+
+// sortedTags returns a sorted copy of tags, or nil if there are none, as the
+// SQLite storage returns them.
+func sortedTags(tags []string) []string {
+	if len(tags) == 0 {
+		return nil
+	}
+	sorted := append([]string{}, tags...)
+	sort.Strings(sorted)
+	return sorted
+}
+
+// visibleList reports whether the user can see a list, by the same rule as
+// listsVisibleTo.
+func (s *MemoryTaskStorage) visibleList(list todo.List) bool {
+	if s.owner == 0 || list.OwnerId == 0 || list.OwnerId == s.owner {
+		return true
+	}
+	_, found := s.member(list.Id, s.owner)
+	return found
+}
+
+// visible reports whether the user can see a task, by the same rule as
+// tasksVisibleTo.
+func (s *MemoryTaskStorage) visible(task todo.Task) bool {
+	if s.owner == 0 {
+		return true
+	}
+	list, found := s.list(task.ListId)
+	return found && s.visibleList(*list) && (list.OwnerId != 0 || task.OwnerId == s.owner)
+}
+
+// editable reports whether the user can change a task, by the same rule as
+// tasksEditableBy.
+func (s *MemoryTaskStorage) editable(task todo.Task) bool {
+	if !s.visible(task) {
+		return false
+	}
+	role, err := s.role(task.ListId)
+	return err == nil && role.Includes(todo.RoleEditor)
+}
+
+// find returns the index of a task the user can see, that is in the trash
+// or not as trashed says.
+func (s *MemoryTaskStorage) find(id todo.TaskId, trashed bool) (int, error) {
+	i := sort.Search(len(s.tasks), func(i int) bool { return s.tasks[i].Id >= id })
+	if i < len(s.tasks) && s.tasks[i].Id == id && (s.tasks[i].TrashedAt != nil) == trashed &&
+		s.visible(s.tasks[i]) {
+		return i, nil
+	}
+	if trashed {
+		return -1, fmt.Errorf("task %d in trash: %w", id, todo.ErrNotFound)
+	}
+	return -1, fmt.Errorf("task %d: %w", id, todo.ErrNotFound)
+}
+
+// matching returns copies of the tasks outside the trash that the user can
+// see and that match, in order of position.
+func (s *MemoryTaskStorage) matching(match func(todo.Task) bool) []todo.Task {
+	tasks := []todo.Task{}
+	for _, task := range s.tasks {
+		if task.TrashedAt == nil && s.visible(task) && match(task) {
+			tasks = append(tasks, copyTask(task))
+		}
+	}
+	sort.SliceStable(tasks, func(i, j int) bool { return tasks[i].Position < tasks[j].Position })
+	return tasks
+}
+
+func anyTask(todo.Task) bool {
+	return true
+}
+
+func (s *MemoryTaskStorage) Add(task *todo.Task) (todo.TaskId, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	listId := task.ListId
+	if listId == 0 {
+		listId = todo.DefaultListId
+	}
+	// New tasks go at the end of their list.
+	last := ""
+	for _, t := range s.tasks {
+		if t.ListId == listId && t.Position > last {
+			last = t.Position
+		}
+	}
+	task.Position = todo.PositionBetween(last, "")
+	if s.owner != 0 || task.OwnerId == 0 {
+		task.OwnerId = s.addedOwner()
+	}
+
+	added := copyTask(*task)
+	s.last.Task++
+	added.Id = s.last.Task
+	added.ListId = listId
+	added.Tags = sortedTags(task.Tags)
+	added.TrashedAt = nil
+	s.tasks = append(s.tasks, added)
+	s.changes.tasks[added.Id] = true
+	return added.Id, s.save()
+}
+
+func (s *MemoryTaskStorage) GetAll() ([]todo.Task, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	return s.matching(anyTask), nil
+}
+
+func (s *MemoryTaskStorage) GetTask(id todo.TaskId) (*todo.Task, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	i, err := s.find(id, false)
+	if err != nil {
+		return nil, err
+	}
+	task := copyTask(s.tasks[i])
+	return &task, nil
+}
+
+// change calls f with a task outside the trash that the user can see, then
+// saves the change.
+func (s *MemoryTaskStorage) change(id todo.TaskId, f func(*todo.Task)) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	i, err := s.find(id, false)
+	if err != nil {
+		return err
+	}
+	f(&s.tasks[i])
+	s.changes.tasks[id] = true
+	return s.save()
+}
+
+func (s *MemoryTaskStorage) Complete(id todo.TaskId, at time.Time) error {
+	return s.change(id, func(task *todo.Task) {
+		if !task.Complete {
+			task.Complete = true
+			task.CompletedAt = &at
+		}
+	})
+}
+
+func (s *MemoryTaskStorage) Reopen(id todo.TaskId) error {
+	return s.change(id, func(task *todo.Task) {
+		task.Complete = false
+		task.CompletedAt = nil
+	})
+}
+
+// Update changes everything about a task but when it was created, where it
+// is and who owns it.
+func (s *MemoryTaskStorage) Update(task *todo.Task) error {
+	return s.change(task.Id, func(stored *todo.Task) {
+		updated := copyTask(*task)
+		updated.Tags = sortedTags(task.Tags)
+		updated.CreatedAt = stored.CreatedAt
+		updated.Position = stored.Position
+		updated.TrashedAt = nil
+		updated.OwnerId = stored.OwnerId
+		*stored = updated
+	})
+}
+
+func (s *MemoryTaskStorage) GetOutstanding() ([]todo.Task, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	return s.matching(func(task todo.Task) bool { return !task.Complete }), nil
+}
+
+func (s *MemoryTaskStorage) Trash(id todo.TaskId, at time.Time) error {
+	return s.change(id, func(task *todo.Task) {
+		task.TrashedAt = &at
+	})
+}
+
+func (s *MemoryTaskStorage) Restore(id todo.TaskId) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	i, err := s.find(id, true)
+	if err != nil {
+		return err
+	}
+
+	trashedAt := *s.tasks[i].TrashedAt
+	restored := map[todo.TaskId]bool{id: true}
+	// Subtasks can be added after their parents' subtasks, so keep looking
+	// until a pass finds nothing new.
+	for found := true; found; {
+		found = false
+		for _, task := range s.tasks {
+			if restored[task.ParentId] && !restored[task.Id] && task.TrashedAt != nil &&
+				task.TrashedAt.Equal(trashedAt) {
+				restored[task.Id] = true
+				found = true
+			}
+		}
+	}
+	for i := range s.tasks {
+		if restored[s.tasks[i].Id] {
+			s.tasks[i].TrashedAt = nil
+			s.changes.tasks[s.tasks[i].Id] = true
+		}
+	}
+	return s.save()
+}
+
+func (s *MemoryTaskStorage) GetTrash() ([]todo.Task, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	trash := []todo.Task{}
+	for _, task := range s.tasks {
+		if task.TrashedAt != nil && s.visible(task) {
+			trash = append(trash, copyTask(task))
+		}
+	}
+	sort.SliceStable(trash, func(i, j int) bool { return trash[i].TrashedAt.After(*trash[j].TrashedAt) })
+	return trash, nil
+}
+
+func (s *MemoryTaskStorage) Purge(before time.Time) (int, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	kept := []todo.Task{}
+	for _, task := range s.tasks {
+		if task.TrashedAt == nil || task.TrashedAt.After(before) || !s.editable(task) {
+			kept = append(kept, task)
+		} else {
+			s.changes.tasks[task.Id] = true
+		}
+	}
+	purged := len(s.tasks) - len(kept)
+	s.tasks = kept
+	return purged, s.save()
+}
+
+// AddEvent records an event, which belongs to the owner of its task.
+func (s *MemoryTaskStorage) AddEvent(event *todo.Event) (todo.EventId, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.last.Event++
+	event.Id = s.last.Event
+
+	added := memoryEvent{Event: *event}
+	added.Actor = ""
+	for _, task := range s.tasks {
+		if task.Id == event.TaskId {
+			added.OwnerId = task.OwnerId
+		}
+	}
+	s.events = append(s.events, added)
+	s.changes.events[event.Id] = true
+	return event.Id, s.save()
+}
+
+// GetEvents returns the events matching a query. Users see the events of
+// their own tasks, even once the tasks have been purged, and of every task
+// they can see.
+func (s *MemoryTaskStorage) GetEvents(q todo.EventQuery) ([]todo.Event, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	visible := map[todo.TaskId]bool{}
+	for _, task := range s.tasks {
+		visible[task.Id] = s.visible(task)
+	}
+
+	events := []todo.Event{}
+	for _, event := range s.events {
+		if s.owner == 0 || event.OwnerId == s.owner || visible[event.TaskId] {
+			if actor, found := s.user(event.ActorId); found {
+				event.Actor = actor.Username
+			}
+			events = append(events, event.Event)
+		}
+	}
+	return todo.QueryEvents(events, q), nil
+}
+
+func (s *MemoryTaskStorage) AddList(list *todo.List) (todo.ListId, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.owner != 0 || list.OwnerId == 0 {
+		list.OwnerId = s.addedOwner()
+	}
+	s.last.List++
+	list.Id = s.last.List
+	s.lists = append(s.lists, *list)
+	s.changes.lists[list.Id] = true
+	return list.Id, s.save()
+}
+
+func (s *MemoryTaskStorage) GetLists() ([]todo.List, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	lists := []todo.List{}
+	for _, list := range s.lists {
+		if s.visibleList(list) {
+			lists = append(lists, list)
+		}
+	}
+	return lists, nil
+}
+
+// list returns a list whether or not the user can see it.
+func (s *MemoryTaskStorage) list(id todo.ListId) (*todo.List, bool) {
+	for i := range s.lists {
+		if s.lists[i].Id == id {
+			return &s.lists[i], true
+		}
+	}
+	return nil, false
+}
+
+func (s *MemoryTaskStorage) GetList(id todo.ListId) (*todo.List, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	list, found := s.list(id)
+	if !found || !s.visibleList(*list) {
+		return nil, fmt.Errorf("list %d: %w", id, todo.ErrNotFound)
+	}
+	copied := *list
+	return &copied, nil
+}
+
+func (s *MemoryTaskStorage) GetListTasks(id todo.ListId) ([]todo.Task, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	return s.matching(func(task todo.Task) bool { return task.ListId == id }), nil
+}
+
+func (s *MemoryTaskStorage) DeleteList(id todo.ListId, at time.Time) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	// Only owners can delete a list, which rules out lists without an owner
+	// like the default.
+	role, err := s.role(id)
+	if err != nil {
+		return err
+	}
+	if role != todo.RoleOwner {
+		return fmt.Errorf("list %d: %w", id, todo.ErrNotFound)
+	}
+
+	list, _ := s.list(id)
+	trashed := map[todo.TaskId]bool{}
+	for i := range s.tasks {
+		if s.tasks[i].ListId == id {
+			trashed[s.tasks[i].Id] = true
+		}
+	}
+	for i := range s.tasks {
+		task := &s.tasks[i]
+		switch {
+		case trashed[task.Id]:
+			// The tasks go to the owner's trash in the default list, keeping
+			// when those already there were trashed.
+			task.ListId = todo.DefaultListId
+			task.OwnerId = list.OwnerId
+			if task.TrashedAt == nil {
+				task.TrashedAt = &at
+			}
+		case trashed[task.ParentId]:
+			// Subtasks in other lists outlive their parent as top level tasks.
+			task.ParentId = 0
+		default:
+			continue
+		}
+		s.changes.tasks[task.Id] = true
+	}
+
+	members := []memoryMember{}
+	for _, member := range s.members {
+		if member.ListId != id {
+			members = append(members, member)
+		} else {
+			s.changes.members[member.key()] = true
+		}
+	}
+	s.members = members
+
+	lists := []todo.List{}
+	for _, list := range s.lists {
+		if list.Id != id {
+			lists = append(lists, list)
+		}
+	}
+	s.lists = lists
+	s.changes.lists[id] = true
+	return s.save()
+}
+
+// role returns the role the user has on a list, by the same rules as the
+// SQLite storage.
+func (s *MemoryTaskStorage) role(id todo.ListId) (todo.Role, error) {
+	list, found := s.list(id)
+	switch {
+	case !found:
+	case s.owner == 0 || list.OwnerId == s.owner:
+		return todo.RoleOwner, nil
+	case list.OwnerId == 0:
+		return todo.RoleEditor, nil
+	default:
+		if member, found := s.member(id, s.owner); found {
+			return member.Role, nil
+		}
+	}
+	return "", fmt.Errorf("list %d: %w", id, todo.ErrNotFound)
+}
+
+func (s *MemoryTaskStorage) member(list todo.ListId, user todo.UserId) (*memoryMember, bool) {
+	for i := range s.members {
+		if s.members[i].ListId == list && s.members[i].UserId == user {
+			return &s.members[i], true
+		}
+	}
+	return nil, false
+}
+
+func (s *MemoryTaskStorage) GetRole(id todo.ListId) (todo.Role, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	return s.role(id)
+}
+
+// GetMembers returns a list's creator followed by its members in order of
+// username.
+func (s *MemoryTaskStorage) GetMembers(id todo.ListId) ([]todo.Member, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	members := []todo.Member{}
+	if list, found := s.list(id); found {
+		if owner, found := s.user(list.OwnerId); found {
+			members = append(members, todo.Member{UserId: owner.Id, Username: owner.Username,
+				Role: todo.RoleOwner})
+		}
+	}
+
+	shared := []todo.Member{}
+	for _, member := range s.members {
+		if user, found := s.user(member.UserId); found && member.ListId == id {
+			shared = append(shared, todo.Member{UserId: user.Id, Username: user.Username,
+				Role: member.Role})
+		}
+	}
+	sort.SliceStable(shared, func(i, j int) bool {
+		return strings.ToLower(shared[i].Username) < strings.ToLower(shared[j].Username)
+	})
+	return append(members, shared...), nil
+}
+
+func (s *MemoryTaskStorage) SetMember(id todo.ListId, userId todo.UserId, role todo.Role) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if member, found := s.member(id, userId); found {
+		member.Role = role
+	} else {
+		s.members = append(s.members, memoryMember{ListId: id, UserId: userId, Role: role})
+	}
+	s.changes.members[memoryMember{ListId: id, UserId: userId}] = true
+	return s.save()
+}
+
+func (s *MemoryTaskStorage) RemoveMember(id todo.ListId, userId todo.UserId) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for i, member := range s.members {
+		if member.ListId == id && member.UserId == userId {
+			s.members = append(s.members[:i], s.members[i+1:]...)
+			s.changes.members[member.key()] = true
+			return s.save()
+		}
+	}
+	return fmt.Errorf("member %d of list %d: %w", userId, id, todo.ErrNotFound)
+}
+
+func (s *MemoryTaskStorage) GetOverdue(now time.Time) ([]todo.Task, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	tasks := s.matching(func(task todo.Task) bool { return task.IsOverdue(now) })
+	return todo.QueryTasks(tasks, todo.TaskQuery{Sort: todo.SortByDue}), nil
+}
+
+func (s *MemoryTaskStorage) GetDue(after, before time.Time) ([]todo.Task, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	tasks := s.matching(func(task todo.Task) bool { return task.IsDueBetween(after, before) })
+	return todo.QueryTasks(tasks, todo.TaskQuery{Sort: todo.SortByDue}), nil
+}
+
+func (s *MemoryTaskStorage) Query(q todo.TaskQuery) ([]todo.Task, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	return todo.QueryTasks(s.matching(anyTask), q), nil
+}
+
+func (s *MemoryTaskStorage) GetTags() ([]todo.Tag, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	return todo.CountTags(s.matching(anyTask)), nil
+}
+
+func (s *MemoryTaskStorage) Search(query string) ([]todo.SearchResult, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	return todo.SearchTasks(s.matching(anyTask), query), nil
+}
+
+// GetSubtasks returns every task nested under a task, at any depth.
+func (s *MemoryTaskStorage) GetSubtasks(id todo.TaskId) ([]todo.Task, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	tasks := s.matching(anyTask)
+	nested := map[todo.TaskId]bool{id: true}
+	// Tasks can be nested under tasks added after them, so keep looking
+	// until a pass finds nothing new.
+	for found := true; found; {
+		found = false
+		for _, task := range tasks {
+			if nested[task.ParentId] && !nested[task.Id] {
+				nested[task.Id] = true
+				found = true
+			}
+		}
+	}
+
+	subtasks := []todo.Task{}
+	for _, task := range tasks {
+		if task.Id != id && nested[task.Id] {
+			subtasks = append(subtasks, task)
+		}
+	}
+	return subtasks, nil
+}
+
+// Move places a task after the task after and before the task before,
+// either of which may be zero to place it next to the other.
+func (s *MemoryTaskStorage) Move(id, before, after todo.TaskId) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for _, neighbour := range []todo.TaskId{before, after} {
+		if neighbour != 0 {
+			if _, err := s.find(neighbour, false); err != nil {
+				return err
+			}
+		}
+	}
+	i, err := s.find(id, false)
+	if err != nil {
+		return err
+	}
+	position, err := todo.MovePosition(s.matching(anyTask), id, before, after)
+	if err != nil {
+		return err
+	}
+	s.tasks[i].Position = position
+	s.changes.tasks[id] = true
+	return s.save()
+}
+
+func (s *MemoryTaskStorage) AddUser(user *todo.User) (todo.UserId, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if _, found := s.userByName(user.Username); found {
+		return -1, todo.ErrUsernameTaken
+	}
+	s.last.User++
+	user.Id = s.last.User
+
+	// The first user takes over everything created before there were users,
+	// apart from the default list.
+	if len(s.users) == 0 {
+		for i := range s.tasks {
+			if s.tasks[i].OwnerId == 0 {
+				s.tasks[i].OwnerId = user.Id
+				s.changes.tasks[s.tasks[i].Id] = true
+			}
+		}
+		for i := range s.events {
+			if s.events[i].OwnerId == 0 {
+				s.events[i].OwnerId = user.Id
+				s.changes.events[s.events[i].Id] = true
+			}
+		}
+		for i := range s.lists {
+			if s.lists[i].OwnerId == 0 && s.lists[i].Id != todo.DefaultListId {
+				s.lists[i].OwnerId = user.Id
+				s.changes.lists[s.lists[i].Id] = true
+			}
+		}
+	}
+	added := *user
+	added.PasswordHash = append([]byte{}, user.PasswordHash...)
+	s.users = append(s.users, added)
+	s.changes.users[user.Id] = true
+	return user.Id, s.save()
+}
+
+// addedOwner returns who owns the tasks and lists the storage adds, by the
+// same rules as the SQLite storage.
+func (s *MemoryTaskStorage) addedOwner() todo.UserId {
+	if s.owner != 0 || len(s.users) == 0 {
+		return s.owner
+	}
+	return s.users[0].Id
+}
+
+func (s *MemoryTaskStorage) user(id todo.UserId) (todo.User, bool) {
+	for _, user := range s.users {
+		if user.Id == id {
+			return user, true
+		}
+	}
+	return todo.User{}, false
+}
+
+func (s *MemoryTaskStorage) userByName(username string) (todo.User, bool) {
+	for _, user := range s.users {
+		if strings.EqualFold(user.Username, username) {
+			return user, true
+		}
+	}
+	return todo.User{}, false
+}
+
+// copyUser returns a copy of a user that shares nothing with it.
+func copyUser(user todo.User) *todo.User {
+	user.PasswordHash = append([]byte{}, user.PasswordHash...)
+	return &user
+}
+
+func (s *MemoryTaskStorage) GetUser(id todo.UserId) (*todo.User, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	user, found := s.user(id)
+	if !found {
+		return nil, fmt.Errorf("user %d: %w", id, todo.ErrNotFound)
+	}
+	return copyUser(user), nil
+}
+
+func (s *MemoryTaskStorage) GetUserByName(username string) (*todo.User, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	user, found := s.userByName(username)
+	if !found {
+		return nil, fmt.Errorf("user %s: %w", username, todo.ErrNotFound)
+	}
+	return copyUser(user), nil
+}
+
+func (s *MemoryTaskStorage) AddSession(session *todo.Session) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.sessions = append(s.sessions, *session)
+	s.changes.sessions[session.TokenHash] = true
+	return s.save()
+}
+
+func (s *MemoryTaskStorage) GetSession(tokenHash string) (*todo.Session, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	for _, session := range s.sessions {
+		if session.TokenHash == tokenHash {
+			return &session, nil
+		}
+	}
+	return nil, fmt.Errorf("session: %w", todo.ErrNotFound)
+}
+
+func (s *MemoryTaskStorage) DeleteSession(tokenHash string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for i, session := range s.sessions {
+		if session.TokenHash == tokenHash {
+			s.sessions = append(s.sessions[:i], s.sessions[i+1:]...)
+			s.changes.sessions[tokenHash] = true
+			return s.save()
+		}
+	}
+	return fmt.Errorf("session: %w", todo.ErrNotFound)
+}
+
+// copyAPIToken returns a copy of a token that shares nothing with it.
+func copyAPIToken(token todo.APIToken) todo.APIToken {
+	token.Scopes = append([]todo.Scope{}, token.Scopes...)
+	return token
+}
+
+func (s *MemoryTaskStorage) AddAPIToken(token *todo.APIToken) (todo.APITokenId, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.last.APIToken++
+	token.Id = s.last.APIToken
+	s.apiTokens = append(s.apiTokens, copyAPIToken(*token))
+	s.changes.apiTokens[token.Id] = true
+	return token.Id, s.save()
+}
+
+func (s *MemoryTaskStorage) GetAPITokens(userId todo.UserId) ([]todo.APIToken, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	tokens := []todo.APIToken{}
+	for _, token := range s.apiTokens {
+		if token.UserId == userId {
+			tokens = append(tokens, copyAPIToken(token))
+		}
+	}
+	return tokens, nil
+}
+
+func (s *MemoryTaskStorage) GetAPIToken(tokenHash string) (*todo.APIToken, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	for _, token := range s.apiTokens {
+		if token.TokenHash == tokenHash {
+			token = copyAPIToken(token)
+			return &token, nil
+		}
+	}
+	return nil, fmt.Errorf("API token: %w", todo.ErrNotFound)
+}
+
+func (s *MemoryTaskStorage) DeleteAPIToken(userId todo.UserId, id todo.APITokenId) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for i, token := range s.apiTokens {
+		if token.Id == id && token.UserId == userId {
+			s.apiTokens = append(s.apiTokens[:i], s.apiTokens[i+1:]...)
+			s.changes.apiTokens[id] = true
+			return s.save()
+		}
+	}
+	return fmt.Errorf("API token %d: %w", id, todo.ErrNotFound)
+}
+
+// memorySnapshotVersion is increased whenever a snapshot written by an older
+// version couldn't be read as it is.
+const memorySnapshotVersion = 1
+
+// memorySnapshot is everything in a MemoryTaskStorage, as saved to its
+// snapshot file. The owners, password hashes and token hashes left out of
+// the API's JSON are included alongside.
+type memorySnapshot struct {
+	Version   int             `json:"version"`
+	Tasks     []snapshotTask  `json:"tasks"`
+	Lists     []snapshotList  `json:"lists"`
+	Events    []memoryEvent   `json:"events"`
+	Users     []snapshotUser  `json:"users"`
+	Sessions  []todo.Session  `json:"sessions"`
+	APITokens []snapshotToken `json:"api_tokens"`
+	Members   []memoryMember  `json:"members"`
+	Last      memoryIds       `json:"last"`
+}
+
+type snapshotTask struct {
+	todo.Task
+	OwnerId todo.UserId `json:"owner_id,omitempty"`
+}
+
+type snapshotList struct {
+	todo.List
+	OwnerId todo.UserId `json:"owner_id,omitempty"`
+}
+
+type snapshotUser struct {
+	todo.User
+	PasswordHash []byte `json:"password_hash"`
+}
+
+type snapshotToken struct {
+	todo.APIToken
+	UserId    todo.UserId `json:"user_id"`
+	TokenHash string      `json:"token_hash"`
+}
+
+func newMemorySnapshot(data *memoryData) memorySnapshot {
+	snapshot := memorySnapshot{Version: memorySnapshotVersion, Events: data.events,
+		Sessions: data.sessions, Members: data.members, Last: data.last}
+	for _, task := range data.tasks {
+		snapshot.Tasks = append(snapshot.Tasks, snapshotTask{task, task.OwnerId})
+	}
+	for _, list := range data.lists {
+		snapshot.Lists = append(snapshot.Lists, snapshotList{list, list.OwnerId})
+	}
+	for _, user := range data.users {
+		snapshot.Users = append(snapshot.Users, snapshotUser{user, user.PasswordHash})
+	}
+	for _, token := range data.apiTokens {
+		snapshot.APITokens = append(snapshot.APITokens, snapshotToken{token, token.UserId, token.TokenHash})
+	}
+	return snapshot
+}
+
+func (snapshot memorySnapshot) restore(data *memoryData) {
+	data.tasks, data.lists, data.users, data.apiTokens = nil, nil, nil, nil
+	for _, task := range snapshot.Tasks {
+		task.Task.OwnerId = task.OwnerId
+		data.tasks = append(data.tasks, task.Task)
+	}
+	for _, list := range snapshot.Lists {
+		list.List.OwnerId = list.OwnerId
+		data.lists = append(data.lists, list.List)
+	}
+	for _, user := range snapshot.Users {
+		user.User.PasswordHash = user.PasswordHash
+		data.users = append(data.users, user.User)
+	}
+	for _, token := range snapshot.APITokens {
+		token.APIToken.UserId, token.APIToken.TokenHash = token.UserId, token.TokenHash
+		data.apiTokens = append(data.apiTokens, token.APIToken)
+	}
+	data.events, data.sessions, data.members = snapshot.Events, snapshot.Sessions, snapshot.Members
+	data.last = snapshot.Last
+}
+
+// save saves the data to the store, if there is one, after which nothing
+// has changed since it was saved. It must be called with mu held.
+func (s *MemoryTaskStorage) save() error {
+	if s.store != nil {
+		err := s.store.save(s.memoryData)
+		if err != nil {
+			// The changes are kept, to be saved along with the next ones.
+			return err
+		}
+	}
+	s.changes = newMemoryChanges()
+	return nil
+}
+
+// snapshotStore saves all of the data to a JSON file every time.
+type snapshotStore struct {
+	path string
+}
+
+// save writes the snapshot file. It is written to a temporary file first and
+// renamed over the old one, so that a crash while saving leaves the previous
+// snapshot rather than half of this one.
+func (store snapshotStore) save(data *memoryData) error {
+	encoded, err := json.Marshal(newMemorySnapshot(data))
+	if err != nil {
+		return err
+	}
+
+	file, err := os.CreateTemp(filepath.Dir(store.path), filepath.Base(store.path)+".*.tmp")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(file.Name())
+	_, err = file.Write(encoded)
+	if err == nil {
+		err = file.Sync()
+	}
+	if closeErr := file.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		return err
+	}
+	return os.Rename(file.Name(), store.path)
+}
+
+func (store snapshotStore) close() error {
+	return nil
+}
@@ -0,0 +1,159 @@
+package todo_storage
+
+import (
+	"database/sql"
+	"embed"
+	"errors"
+	"fmt"
+	"io/fs"
+	"path"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+//go:embed migrations/*.sql migrations/postgres/*.sql
+var migrationFiles embed.FS
+
+// ErrSchemaTooNew is returned when a database has been migrated by a newer
+// version of the application than this one.
+var ErrSchemaTooNew = errors.New("database schema is newer than this version supports")
+
+type migration struct {
+	version int
+	name    string
+	sql     string
+}
+
+// loadMigrations reads the migrations in a directory, which are named
+// NNNN_description.sql and must be numbered consecutively from 1. SQLite's
+// are in migrations and Postgres's in migrations/postgres.
+func loadMigrations(files fs.FS, dir string) ([]migration, error) {
+	names, err := fs.Glob(files, dir+"/*.sql")
+	if err != nil {
+		return nil, err
+	}
+
+	migrations := []migration{}
+	for _, name := range names {
+		base := path.Base(name)
+		prefix, _, found := strings.Cut(base, "_")
+		if !found {
+			return nil, fmt.Errorf("migration %s is not named NNNN_description.sql", base)
+		}
+		version, err := strconv.Atoi(prefix)
+		if err != nil {
+			return nil, fmt.Errorf("migration %s is not named NNNN_description.sql", base)
+		}
+
+		contents, err := fs.ReadFile(files, name)
+		if err != nil {
+			return nil, err
+		}
+		migrations = append(migrations, migration{version, base, string(contents)})
+	}
+
+	sort.Slice(migrations, func(i, j int) bool {
+		return migrations[i].version < migrations[j].version
+	})
+	for i, m := range migrations {
+		if m.version != i+1 {
+			return nil, fmt.Errorf("migration %s is out of sequence, expected version %d", m.name, i+1)
+		}
+	}
+	return migrations, nil
+}
+
+// migrate brings the database schema up to date, applying each outstanding
+// migration in its own transaction. The schema version is kept in SQLite's
+// user_version so that it is updated atomically with the migration itself.
+func migrate(db *sql.DB) error {
+	migrations, err := loadMigrations(migrationFiles, "migrations")
+	if err != nil {
+		return err
+	}
+
+	version, err := schemaVersion(db)
+	if err != nil {
+		return err
+	}
+	if version > len(migrations) {
+		return fmt.Errorf("%w: database is at version %d, latest known is %d",
+			ErrSchemaTooNew, version, len(migrations))
+	}
+
+	for _, m := range migrations[version:] {
+		err = applyMigration(db, m)
+		if err != nil {
+			return fmt.Errorf("applying migration %s: %w", m.name, err)
+		}
+	}
+	return nil
+}
+
+func applyMigration(db *sql.DB, m migration) error {
+	tx, err := db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	_, err = tx.Exec(m.sql)
+	if err != nil {
+		return err
+	}
+	_, err = tx.Exec(fmt.Sprintf("PRAGMA user_version = %d", m.version))
+	if err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+func schemaVersion(db *sql.DB) (int, error) {
+	var version int
+	err := db.QueryRow("PRAGMA user_version").Scan(&version)
+	if err != nil {
+		return 0, err
+	}
+	if version > 0 {
+		return version, nil
+	}
+	return legacySchemaVersion(db)
+}
+
+// legacySchemaVersion works out which migrations have already been applied
+// to a database created before schema versions were recorded, from the
+// columns its tasks table has.
+func legacySchemaVersion(db *sql.DB) (int, error) {
+	rows, err := db.Query("PRAGMA table_info(tasks)")
+	if err != nil {
+		return 0, err
+	}
+	defer rows.Close()
+
+	columns := map[string]bool{}
+	for rows.Next() {
+		var cid, notNull, pk int
+		var name, columnType string
+		var defaultValue sql.NullString
+		err = rows.Scan(&cid, &name, &columnType, &notNull, &defaultValue, &pk)
+		if err != nil {
+			return 0, err
+		}
+		columns[name] = true
+	}
+	if err = rows.Err(); err != nil {
+		return 0, err
+	}
+
+	switch {
+	case len(columns) == 0:
+		return 0, nil
+	case columns["remind_at"]:
+		return 3, nil
+	case columns["list_id"]:
+		return 2, nil
+	default:
+		return 1, nil
+	}
+}
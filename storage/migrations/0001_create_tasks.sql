@@ -0,0 +1,2 @@
+CREATE TABLE IF NOT EXISTS tasks
+(id INTEGER not null primary key, name TEXT, complete BOOL);
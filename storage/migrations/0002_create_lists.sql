@@ -0,0 +1,5 @@
+CREATE TABLE lists
+(id INTEGER not null primary key, name TEXT not null);
+INSERT INTO lists(id, name) VALUES(1, 'Tasks');
+
+ALTER TABLE tasks ADD COLUMN list_id INTEGER not null DEFAULT 1;
@@ -0,0 +1,2 @@
+ALTER TABLE tasks ADD COLUMN due DATETIME;
+ALTER TABLE tasks ADD COLUMN remind_at DATETIME;
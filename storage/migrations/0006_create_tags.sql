@@ -0,0 +1,7 @@
+CREATE TABLE tags
+(id INTEGER not null primary key, name TEXT not null UNIQUE);
+
+CREATE TABLE task_tags
+(task_id INTEGER not null, tag_id INTEGER not null,
+PRIMARY KEY (task_id, tag_id));
+CREATE INDEX task_tags_tag_id ON task_tags(tag_id);
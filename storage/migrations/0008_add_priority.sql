@@ -0,0 +1,2 @@
+ALTER TABLE tasks ADD COLUMN priority TEXT;
+CREATE INDEX tasks_priority ON tasks(priority, due);
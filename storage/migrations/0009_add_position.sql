@@ -0,0 +1,5 @@
+ALTER TABLE tasks ADD COLUMN position TEXT;
+-- Keep existing tasks in id order. Position keys must not end in "0", see
+-- todo.PositionBetween.
+UPDATE tasks SET position = printf('%010d', id) || '1';
+CREATE INDEX tasks_position ON tasks(position);
@@ -0,0 +1,2 @@
+ALTER TABLE tasks ADD COLUMN trashed_at DATETIME;
+CREATE INDEX tasks_trashed_at ON tasks(trashed_at);
@@ -0,0 +1,5 @@
+CREATE TABLE events
+(id INTEGER not null primary key AUTOINCREMENT, task_id INTEGER not null,
+type TEXT not null, name TEXT not null, previous TEXT not null DEFAULT '',
+at DATETIME not null);
+CREATE INDEX events_task_id ON events(task_id, id);
@@ -0,0 +1,14 @@
+CREATE TABLE users
+(id INTEGER not null primary key AUTOINCREMENT,
+username TEXT not null UNIQUE COLLATE NOCASE, password_hash BLOB not null,
+created_at DATETIME not null);
+CREATE TABLE sessions
+(token_hash TEXT not null primary key, user_id INTEGER not null,
+expires_at DATETIME not null);
+CREATE INDEX sessions_user_id ON sessions(user_id);
+
+ALTER TABLE tasks ADD COLUMN owner_id INTEGER;
+CREATE INDEX tasks_owner_id ON tasks(owner_id);
+ALTER TABLE lists ADD COLUMN owner_id INTEGER;
+ALTER TABLE events ADD COLUMN owner_id INTEGER;
+CREATE INDEX events_owner_id ON events(owner_id, id);
@@ -0,0 +1,5 @@
+CREATE TABLE api_tokens
+(id INTEGER not null primary key AUTOINCREMENT, user_id INTEGER not null,
+name TEXT not null, scopes TEXT not null, token_hash TEXT not null UNIQUE,
+created_at DATETIME not null);
+CREATE INDEX api_tokens_user_id ON api_tokens(user_id);
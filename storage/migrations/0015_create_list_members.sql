@@ -0,0 +1,4 @@
+CREATE TABLE list_members
+(list_id INTEGER not null, user_id INTEGER not null, role TEXT not null,
+primary key (list_id, user_id));
+CREATE INDEX list_members_user_id ON list_members(user_id);
@@ -0,0 +1,58 @@
+-- The Postgres schema matches the SQLite one after its migrations. Positions
+-- and tag names use the "C" collation so that they sort byte by byte as they
+-- do in SQLite, and there are no foreign keys since SQLite doesn't enforce
+-- them either.
+CREATE TABLE lists
+(id BIGSERIAL not null primary key, name TEXT not null, owner_id BIGINT);
+INSERT INTO lists(id, name) VALUES(1, 'Tasks');
+SELECT setval(pg_get_serial_sequence('lists', 'id'), 1);
+
+CREATE TABLE tasks
+(id BIGSERIAL not null primary key, name TEXT, complete BOOLEAN,
+list_id BIGINT not null DEFAULT 1, due TIMESTAMPTZ, remind_at TIMESTAMPTZ,
+completed_at TIMESTAMPTZ, created_at TIMESTAMPTZ, parent_id BIGINT,
+priority TEXT, position TEXT COLLATE "C", recurrence TEXT not null DEFAULT '',
+trashed_at TIMESTAMPTZ, owner_id BIGINT);
+CREATE INDEX tasks_list_id ON tasks(list_id);
+CREATE INDEX tasks_parent_id ON tasks(parent_id);
+CREATE INDEX tasks_priority ON tasks(priority, due);
+CREATE INDEX tasks_position ON tasks(position);
+CREATE INDEX tasks_trashed_at ON tasks(trashed_at);
+CREATE INDEX tasks_owner_id ON tasks(owner_id);
+CREATE INDEX tasks_search ON tasks USING gin (to_tsvector('simple', coalesce(name, '')));
+
+CREATE TABLE tags
+(id BIGSERIAL not null primary key, name TEXT COLLATE "C" not null UNIQUE);
+
+CREATE TABLE task_tags
+(task_id BIGINT not null, tag_id BIGINT not null,
+PRIMARY KEY (task_id, tag_id));
+CREATE INDEX task_tags_tag_id ON task_tags(tag_id);
+
+CREATE TABLE events
+(id BIGSERIAL not null primary key, task_id BIGINT not null,
+type TEXT not null, name TEXT not null, previous TEXT not null DEFAULT '',
+at TIMESTAMPTZ not null, owner_id BIGINT);
+CREATE INDEX events_task_id ON events(task_id, id);
+CREATE INDEX events_owner_id ON events(owner_id, id);
+
+CREATE TABLE users
+(id BIGSERIAL not null primary key, username TEXT not null,
+password_hash BYTEA not null, created_at TIMESTAMPTZ not null);
+CREATE UNIQUE INDEX users_username ON users(lower(username));
+
+CREATE TABLE sessions
+(token_hash TEXT not null primary key, user_id BIGINT not null,
+expires_at TIMESTAMPTZ not null);
+CREATE INDEX sessions_user_id ON sessions(user_id);
+
+CREATE TABLE api_tokens
+(id BIGSERIAL not null primary key, user_id BIGINT not null,
+name TEXT not null, scopes TEXT not null, token_hash TEXT not null UNIQUE,
+created_at TIMESTAMPTZ not null);
+CREATE INDEX api_tokens_user_id ON api_tokens(user_id);
+
+CREATE TABLE list_members
+(list_id BIGINT not null, user_id BIGINT not null, role TEXT not null,
+primary key (list_id, user_id));
+CREATE INDEX list_members_user_id ON list_members(user_id);
@@ -0,0 +1 @@
+ALTER TABLE events ADD COLUMN actor_id BIGINT;
@@ -0,0 +1,52 @@
+package todo_storage
+
+import (
+	"strings"
+
+	todo "github.com/rosswf/go-todo"
+)
+
+// Storage is a backend that stores both tasks and accounts.
+type Storage interface {
+	todo.TaskStorage
+	todo.UserStorage
+	Close()
+}
+
+// DatabaseEnv is the environment variable that the web server and CLI read
+// the database location from.
+const DatabaseEnv = "TODO_DATABASE"
+
+// Open opens the storage at location, which is a postgres:// or
+// postgresql:// URL for Postgres, the path of a .json snapshot file for
+// in-memory storage, the path of a .bolt file for a bbolt database and
+// otherwise the path of a SQLite database.
+func Open(location string) (Storage, error) {
+	if strings.HasPrefix(location, "postgres://") || strings.HasPrefix(location, "postgresql://") {
+		storage, err := CreatePostgresTaskStorage(location)
+		if err != nil {
+			return nil, err
+		}
+		return storage, nil
+	}
+	if strings.HasSuffix(location, ".bolt") {
+		storage, err := OpenBoltTaskStorage(location)
+		if err != nil {
+			return nil, err
+		}
+		return storage, nil
+	}
+	if strings.HasSuffix(location, ".json") {
+		storage, err := OpenMemoryTaskStorage(location)
+		if err != nil {
+			return nil, err
+		}
+		return storage, nil
+	}
+
+	storage, err := CreateSqlite3TaskStorage(location)
+	if err != nil {
+		return nil, err
+	}
+	return storage, nil
+}
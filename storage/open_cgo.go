@@ -0,0 +1,8 @@
+//go:build cgo
+
+package todo_storage
+
+// DefaultLocation is where the web server and CLI store tasks when the
+// location isn't given in DatabaseEnv, which is a SQLite database when they
+// are built with cgo.
+const DefaultLocation = "tasks.db"
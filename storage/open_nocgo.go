@@ -0,0 +1,8 @@
+//go:build !cgo
+
+package todo_storage
+
+// DefaultLocation is where the web server and CLI store tasks when the
+// location isn't given in DatabaseEnv, which is a bbolt database when they
+// are built without cgo since SQLite needs it.
+const DefaultLocation = "tasks.bolt"
@@ -0,0 +1,648 @@
+package todo_storage
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	_ "github.com/lib/pq"
+	todo "github.com/rosswf/go-todo"
+)
+
+const postgresTaskColumns = `id, name, complete, list_id, due, remind_at, completed_at,
+created_at, parent_id, priority, position, recurrence, trashed_at, owner_id,
+(SELECT string_agg(tags.name, ',' ORDER BY tags.name) FROM task_tags
+JOIN tags ON tags.id = task_tags.tag_id WHERE task_tags.task_id = tasks.id) AS tags`
+
+// Connection pool limits, which leave room for other clients on a default
+// Postgres server that allows 100 connections.
+const (
+	postgresMaxOpenConns    = 20
+	postgresMaxIdleConns    = 5
+	postgresConnMaxIdleTime = 5 * time.Minute
+)
+
+// positionLock is the advisory lock held while choosing a position, so that
+// concurrent connections can't give two tasks the same one.
+const positionLock = 0x746f646f
+
+// PostgresTaskStorage stores tasks in Postgres, with the same behaviour as
+// Sqlite3TaskStorage. Unlike SQLite it can be shared by several web servers.
+type PostgresTaskStorage struct {
+	conn *sql.DB
+	// owner is the user the storage is scoped to, or zero for everyone.
+	owner todo.UserId
+}
+
+// CreatePostgresTaskStorage connects to the database at a postgres:// URL or
+// in a "key=value" connection string, see github.com/lib/pq, and brings its
+// schema up to date.
+func CreatePostgresTaskStorage(dataSource string) (*PostgresTaskStorage, error) {
+	db, err := sql.Open("postgres", dataSource)
+	if err != nil {
+		return nil, err
+	}
+	db.SetMaxOpenConns(postgresMaxOpenConns)
+	db.SetMaxIdleConns(postgresMaxIdleConns)
+	db.SetConnMaxIdleTime(postgresConnMaxIdleTime)
+
+	err = migratePostgres(db)
+	if err != nil {
+		db.Close()
+		return nil, err
+	}
+	return &PostgresTaskStorage{conn: db}, nil
+}
+
+func (s *PostgresTaskStorage) Close() {
+	s.conn.Close()
+}
+
+// ForUser returns storage sharing the same connection pool that only
+// includes a user's tasks, lists and events.
+func (s *PostgresTaskStorage) ForUser(id todo.UserId) todo.TaskStorage {
+	return &PostgresTaskStorage{conn: s.conn, owner: id}
+}
+
+// migratePostgres brings the database schema up to date. The version is kept
+// in the schema_version table, which is locked while each migration is
+// applied so that servers starting together don't both apply it.
+func migratePostgres(db *sql.DB) error {
+	migrations, err := loadMigrations(migrationFiles, "migrations/postgres")
+	if err != nil {
+		return err
+	}
+	_, err = db.Exec("CREATE TABLE IF NOT EXISTS schema_version (version INTEGER not null)")
+	if err != nil {
+		return err
+	}
+
+	for _, m := range migrations {
+		err = applyPostgresMigration(db, m, len(migrations))
+		if err != nil {
+			return fmt.Errorf("applying migration %s: %w", m.name, err)
+		}
+	}
+	return nil
+}
+
+func applyPostgresMigration(db *sql.DB, m migration, latest int) error {
+	tx, err := db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	_, err = tx.Exec("LOCK TABLE schema_version IN EXCLUSIVE MODE")
+	if err != nil {
+		return err
+	}
+	var version int
+	err = tx.QueryRow("SELECT COALESCE(MAX(version), 0) FROM schema_version").Scan(&version)
+	if err != nil {
+		return err
+	}
+	if version > latest {
+		return fmt.Errorf("%w: database is at version %d, latest known is %d",
+			ErrSchemaTooNew, version, latest)
+	}
+	if version >= m.version {
+		return nil
+	}
+
+	_, err = tx.Exec(m.sql)
+	if err != nil {
+		return err
+	}
+	_, err = tx.Exec("INSERT INTO schema_version(version) VALUES($1)", m.version)
+	if err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+func (s *PostgresTaskStorage) visibleTasks() string {
+	return tasksVisibleTo(s.owner)
+}
+
+func (s *PostgresTaskStorage) editableTasks() string {
+	return tasksEditableBy(s.owner)
+}
+
+func (s *PostgresTaskStorage) visibleLists() string {
+	return listsVisibleTo(s.owner)
+}
+
+func (s *PostgresTaskStorage) queryTasks(query string, args ...any) ([]todo.Task, error) {
+	tasks := []todo.Task{}
+	rows, err := s.conn.Query(query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		task, err := scanTask(rows)
+		if err != nil {
+			return nil, err
+		}
+		tasks = append(tasks, task)
+	}
+	return tasks, rows.Err()
+}
+
+func (s *PostgresTaskStorage) Add(task *todo.Task) (todo.TaskId, error) {
+	listId := task.ListId
+	if listId == 0 {
+		listId = todo.DefaultListId
+	}
+
+	tx, err := s.conn.Begin()
+	if err != nil {
+		return -1, err
+	}
+	defer tx.Rollback()
+
+	_, err = tx.Exec("SELECT pg_advisory_xact_lock($1)", positionLock)
+	if err != nil {
+		return -1, err
+	}
+	// New tasks go at the end of their list.
+	var last sql.NullString
+	err = tx.QueryRow("SELECT MAX(position) FROM tasks WHERE list_id = $1", listId).Scan(&last)
+	if err != nil {
+		return -1, err
+	}
+	task.Position = todo.PositionBetween(last.String, "")
+	if s.owner != 0 || task.OwnerId == 0 {
+		task.OwnerId, err = s.addedOwner(tx)
+		if err != nil {
+			return -1, err
+		}
+	}
+
+	sqlStmt := `INSERT INTO tasks(name, complete, list_id, due, remind_at,
+completed_at, created_at, parent_id, priority, position, recurrence, owner_id)
+VALUES($1, $2, $3, $4, $5, $6, $7, $8, $9, $10, $11, $12) RETURNING id`
+	var id todo.TaskId
+	err = tx.QueryRow(sqlStmt, task.Name, task.Complete, listId,
+		nullTime(task.Due), nullTime(task.RemindAt), nullTime(task.CompletedAt),
+		nullTime(task.CreatedAt), nullTaskId(task.ParentId), nullPriority(task.Priority),
+		task.Position, task.Recurrence, nullUserId(task.OwnerId)).Scan(&id)
+	if err != nil {
+		return -1, err
+	}
+
+	err = setPostgresTags(tx, id, task.Tags)
+	if err != nil {
+		return -1, err
+	}
+	return id, tx.Commit()
+}
+
+// setPostgresTags replaces the tags of a task.
+func setPostgresTags(tx *sql.Tx, id todo.TaskId, tags []string) error {
+	_, err := tx.Exec("DELETE FROM task_tags WHERE task_id = $1", id)
+	if err != nil {
+		return err
+	}
+
+	for _, tag := range tags {
+		_, err = tx.Exec("INSERT INTO tags(name) VALUES($1) ON CONFLICT (name) DO NOTHING", tag)
+		if err != nil {
+			return err
+		}
+		_, err = tx.Exec(`INSERT INTO task_tags(task_id, tag_id)
+SELECT $1::BIGINT, id FROM tags WHERE name = $2 ON CONFLICT DO NOTHING`, id, tag)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (s *PostgresTaskStorage) GetAll() ([]todo.Task, error) {
+	return s.queryTasks("SELECT " + postgresTaskColumns + ` FROM tasks WHERE trashed_at IS NULL
+AND ` + s.visibleTasks() + " ORDER BY position, id")
+}
+
+func (s *PostgresTaskStorage) GetTask(id todo.TaskId) (*todo.Task, error) {
+	row := s.conn.QueryRow("SELECT "+postgresTaskColumns+` FROM tasks WHERE id = $1
+AND trashed_at IS NULL AND `+s.visibleTasks(), id)
+
+	task, err := scanTask(row)
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, fmt.Errorf("task %d: %w", id, todo.ErrNotFound)
+	}
+	if err != nil {
+		return nil, err
+	}
+	return &task, nil
+}
+
+func (s *PostgresTaskStorage) Complete(id todo.TaskId, at time.Time) error {
+	sqlStmt := `UPDATE tasks SET complete = true, completed_at = CASE
+WHEN complete THEN completed_at ELSE $1::TIMESTAMPTZ END WHERE id = $2
+AND trashed_at IS NULL AND ` + s.visibleTasks()
+
+	return execOne(s.conn, sqlStmt, at.UTC(), id)
+}
+
+func (s *PostgresTaskStorage) Reopen(id todo.TaskId) error {
+	sqlStmt := `UPDATE tasks SET complete = false, completed_at = NULL WHERE id = $1
+AND trashed_at IS NULL AND ` + s.visibleTasks()
+
+	return execOne(s.conn, sqlStmt, id)
+}
+
+func (s *PostgresTaskStorage) Update(task *todo.Task) error {
+	tx, err := s.conn.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	sqlStmt := `UPDATE tasks SET name = $1, complete = $2, list_id = $3, due = $4,
+remind_at = $5, completed_at = $6, parent_id = $7, priority = $8, recurrence = $9
+WHERE id = $10 AND trashed_at IS NULL AND ` + s.visibleTasks()
+
+	err = execOne(tx, sqlStmt, task.Name, task.Complete, task.ListId,
+		nullTime(task.Due), nullTime(task.RemindAt), nullTime(task.CompletedAt),
+		nullTaskId(task.ParentId), nullPriority(task.Priority), task.Recurrence,
+		task.Id)
+	if err != nil {
+		return err
+	}
+
+	err = setPostgresTags(tx, task.Id, task.Tags)
+	if err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+func (s *PostgresTaskStorage) GetOutstanding() ([]todo.Task, error) {
+	return s.queryTasks("SELECT " + postgresTaskColumns + ` FROM tasks WHERE complete = false
+AND trashed_at IS NULL AND ` + s.visibleTasks() + " ORDER BY position, id")
+}
+
+func (s *PostgresTaskStorage) GetOverdue(now time.Time) ([]todo.Task, error) {
+	return s.queryTasks("SELECT "+postgresTaskColumns+` FROM tasks
+WHERE complete = false AND due < $1 AND trashed_at IS NULL AND `+s.visibleTasks()+
+		" ORDER BY due", now.UTC())
+}
+
+func (s *PostgresTaskStorage) GetDue(after, before time.Time) ([]todo.Task, error) {
+	query := "SELECT " + postgresTaskColumns + " FROM tasks WHERE due IS NOT NULL AND trashed_at IS NULL AND " +
+		s.visibleTasks()
+	args := []any{}
+	if !after.IsZero() {
+		args = append(args, after.UTC())
+		query += fmt.Sprintf(" AND due > $%d", len(args))
+	}
+	if !before.IsZero() {
+		args = append(args, before.UTC())
+		query += fmt.Sprintf(" AND due < $%d", len(args))
+	}
+	return s.queryTasks(query+" ORDER BY due", args...)
+}
+
+// postgresOrderings matches the sort order of todo.QueryTasks, %[1]s is
+// replaced with the direction and %[2]s with where NULLs go, which is first
+// when ascending and last when descending as in SQLite.
+var postgresOrderings = map[todo.SortField]string{
+	todo.SortById:      "id %[1]s",
+	todo.SortByName:    `lower(name) COLLATE "C" %[1]s %[2]s, id %[1]s`,
+	todo.SortByCreated: "created_at %[1]s %[2]s, id %[1]s",
+	todo.SortByDue:     "due IS NULL, due %[1]s, id %[1]s",
+	todo.SortByPriority: `priority IS NULL, priority %[1]s, due IS NULL, due %[1]s,
+position %[1]s %[2]s, id %[1]s`,
+	todo.SortByPosition: "position %[1]s %[2]s, id %[1]s",
+}
+
+func (s *PostgresTaskStorage) Query(q todo.TaskQuery) ([]todo.Task, error) {
+	query := "SELECT " + postgresTaskColumns + " FROM tasks WHERE trashed_at IS NULL AND " + s.visibleTasks()
+	args := []any{}
+	arg := func(value any) string {
+		args = append(args, value)
+		return fmt.Sprintf("$%d", len(args))
+	}
+
+	if q.ListId != 0 {
+		query += " AND list_id = " + arg(q.ListId)
+	}
+	if q.Complete != nil {
+		query += " AND complete = " + arg(*q.Complete)
+	}
+	if q.NameContains != "" {
+		// LIKE is case sensitive in Postgres, unlike SQLite where it ignores
+		// the case of ASCII letters.
+		query += ` AND lower(name) LIKE lower(` + arg("%"+escapeLike(q.NameContains)+"%") + `) ESCAPE '\'`
+	}
+	if q.Tag != "" {
+		query += ` AND EXISTS (SELECT 1 FROM task_tags JOIN tags
+ON tags.id = task_tags.tag_id WHERE task_tags.task_id = tasks.id AND tags.name = ` + arg(q.Tag) + ")"
+	}
+
+	ordering, ok := postgresOrderings[q.Sort]
+	if !ok {
+		ordering = postgresOrderings[todo.SortById]
+	}
+	direction, nulls := "ASC", "NULLS FIRST"
+	if q.Descending {
+		direction, nulls = "DESC", "NULLS LAST"
+	}
+	query += " ORDER BY " + fmt.Sprintf(ordering, direction, nulls)
+
+	// A NULL limit means no limit.
+	limit := sql.NullInt64{Int64: int64(q.Limit), Valid: q.Limit > 0}
+	query += " LIMIT " + arg(limit) + " OFFSET " + arg(q.Offset)
+
+	return s.queryTasks(query, args...)
+}
+
+// Trash moves a task to the trash, keeping its tags so that it can be
+// restored as it was.
+func (s *PostgresTaskStorage) Trash(id todo.TaskId, at time.Time) error {
+	return execOne(s.conn, `UPDATE tasks SET trashed_at = $1 WHERE id = $2 AND trashed_at IS NULL
+AND `+s.visibleTasks(), at.UTC(), id)
+}
+
+// Restore takes a task out of the trash along with any of its subtasks that
+// were trashed at the same time.
+func (s *PostgresTaskStorage) Restore(id todo.TaskId) error {
+	sqlStmt := `WITH RECURSIVE restored(id, trashed_at) AS (
+SELECT id, trashed_at FROM tasks WHERE id = $1 AND trashed_at IS NOT NULL AND ` +
+		s.visibleTasks() + `
+UNION SELECT tasks.id, tasks.trashed_at FROM tasks JOIN restored
+ON tasks.parent_id = restored.id AND tasks.trashed_at = restored.trashed_at)
+UPDATE tasks SET trashed_at = NULL WHERE id IN (SELECT id FROM restored)`
+
+	err := execOne(s.conn, sqlStmt, id)
+	if errors.Is(err, todo.ErrNotFound) {
+		return fmt.Errorf("task %d in trash: %w", id, todo.ErrNotFound)
+	}
+	return err
+}
+
+func (s *PostgresTaskStorage) GetTrash() ([]todo.Task, error) {
+	return s.queryTasks("SELECT " + postgresTaskColumns + ` FROM tasks WHERE trashed_at IS NOT NULL
+AND ` + s.visibleTasks() + " ORDER BY trashed_at DESC, id")
+}
+
+// Purge permanently deletes the tasks trashed at or before a time, along
+// with their tags.
+func (s *PostgresTaskStorage) Purge(before time.Time) (int, error) {
+	tx, err := s.conn.Begin()
+	if err != nil {
+		return 0, err
+	}
+	defer tx.Rollback()
+
+	purged := "SELECT id FROM tasks WHERE trashed_at <= $1 AND " + s.editableTasks()
+	_, err = tx.Exec("DELETE FROM task_tags WHERE task_id IN ("+purged+")", before.UTC())
+	if err != nil {
+		return 0, err
+	}
+	result, err := tx.Exec("DELETE FROM tasks WHERE id IN ("+purged+")", before.UTC())
+	if err != nil {
+		return 0, err
+	}
+	rows, err := result.RowsAffected()
+	if err != nil {
+		return 0, err
+	}
+	return int(rows), tx.Commit()
+}
+
+// Move places a task after the task after and before the task before,
+// either of which may be zero to place it next to the other.
+func (s *PostgresTaskStorage) Move(id, before, after todo.TaskId) error {
+	tx, err := s.conn.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	_, err = tx.Exec("SELECT pg_advisory_xact_lock($1)", positionLock)
+	if err != nil {
+		return err
+	}
+	lower, err := s.position(tx, after)
+	if err != nil {
+		return err
+	}
+	upper, err := s.position(tx, before)
+	if err != nil {
+		return err
+	}
+
+	// Find the neighbour that wasn't given.
+	var neighbour sql.NullString
+	switch {
+	case before == 0:
+		err = tx.QueryRow(`SELECT MIN(position) FROM tasks WHERE position > $1
+AND id != $2 AND trashed_at IS NULL AND `+s.visibleTasks(), lower, id).Scan(&neighbour)
+		upper = neighbour.String
+	case after == 0:
+		err = tx.QueryRow(`SELECT MAX(position) FROM tasks WHERE position < $1
+AND id != $2 AND trashed_at IS NULL AND `+s.visibleTasks(), upper, id).Scan(&neighbour)
+		lower = neighbour.String
+	}
+	if err != nil {
+		return err
+	}
+	if upper != "" && lower >= upper {
+		return todo.ErrMoveOutOfOrder
+	}
+
+	err = execOne(tx, "UPDATE tasks SET position = $1 WHERE id = $2 AND trashed_at IS NULL AND "+
+		s.visibleTasks(), todo.PositionBetween(lower, upper), id)
+	if err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+// position returns the position of a task, or an empty string for id zero.
+func (s *PostgresTaskStorage) position(tx *sql.Tx, id todo.TaskId) (string, error) {
+	if id == 0 {
+		return "", nil
+	}
+	var position string
+	err := tx.QueryRow("SELECT position FROM tasks WHERE id = $1 AND trashed_at IS NULL AND "+
+		s.visibleTasks(), id).Scan(&position)
+	if errors.Is(err, sql.ErrNoRows) {
+		return "", fmt.Errorf("task %d: %w", id, todo.ErrNotFound)
+	}
+	return position, err
+}
+
+// GetSubtasks returns every task nested under a task, at any depth.
+func (s *PostgresTaskStorage) GetSubtasks(id todo.TaskId) ([]todo.Task, error) {
+	sqlStmt := `WITH RECURSIVE subtasks(id) AS (
+SELECT id FROM tasks WHERE parent_id = $1 AND trashed_at IS NULL AND ` + s.visibleTasks() + `
+UNION SELECT tasks.id FROM tasks JOIN subtasks ON tasks.parent_id = subtasks.id
+WHERE tasks.trashed_at IS NULL)
+SELECT ` + postgresTaskColumns + ` FROM tasks WHERE id IN (SELECT id FROM subtasks)
+ORDER BY position, id`
+	return s.queryTasks(sqlStmt, id)
+}
+
+// GetTags returns every tag that is in use, with the number of tasks using it.
+func (s *PostgresTaskStorage) GetTags() ([]todo.Tag, error) {
+	tags := []todo.Tag{}
+	rows, err := s.conn.Query(`SELECT tags.name, COUNT(*) FROM tags
+JOIN task_tags ON tags.id = task_tags.tag_id JOIN tasks ON tasks.id = task_tags.task_id
+WHERE tasks.trashed_at IS NULL AND ` + s.visibleTasks() + " GROUP BY tags.name ORDER BY tags.name")
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var tag todo.Tag
+		err = rows.Scan(&tag.Name, &tag.Count)
+		if err != nil {
+			return nil, err
+		}
+		tags = append(tags, tag)
+	}
+	return tags, rows.Err()
+}
+
+// Search returns the tasks whose names contain a word starting with each
+// term of the query, ranked by relevance. Postgres finds and ranks the
+// matches, and todo.MatchSearch highlights them so that the snippets are the
+// same as SQLite's.
+func (s *PostgresTaskStorage) Search(query string) ([]todo.SearchResult, error) {
+	results := []todo.SearchResult{}
+	terms := todo.SearchTerms(query)
+	if len(terms) == 0 {
+		return results, nil
+	}
+
+	// Terms only contain letters and numbers, so are safe to quote.
+	prefixes := []string{}
+	for _, term := range terms {
+		prefixes = append(prefixes, "'"+term+"':*")
+	}
+	sqlStmt := `SELECT ` + postgresTaskColumns + ` FROM tasks
+WHERE to_tsvector('simple', coalesce(name, '')) @@ to_tsquery('simple', $1)
+AND trashed_at IS NULL AND ` + s.visibleTasks() + `
+ORDER BY ts_rank(to_tsvector('simple', coalesce(name, '')), to_tsquery('simple', $1)) DESC, id`
+	tasks, err := s.queryTasks(sqlStmt, strings.Join(prefixes, " & "))
+	if err != nil {
+		return nil, err
+	}
+
+	for _, task := range tasks {
+		snippet, ok := todo.MatchSearch(task.Name, terms)
+		if ok {
+			results = append(results, todo.SearchResult{Task: task, Snippet: snippet})
+		}
+	}
+	return results, nil
+}
+
+func (s *PostgresTaskStorage) AddList(list *todo.List) (todo.ListId, error) {
+	if s.owner != 0 || list.OwnerId == 0 {
+		var err error
+		list.OwnerId, err = s.addedOwner(s.conn)
+		if err != nil {
+			return -1, err
+		}
+	}
+	var id todo.ListId
+	err := s.conn.QueryRow("INSERT INTO lists(name, owner_id) VALUES($1, $2) RETURNING id",
+		list.Name, nullUserId(list.OwnerId)).Scan(&id)
+	if err != nil {
+		return -1, err
+	}
+	return id, nil
+}
+
+func (s *PostgresTaskStorage) GetLists() ([]todo.List, error) {
+	lists := []todo.List{}
+	rows, err := s.conn.Query("SELECT id, name, owner_id FROM lists WHERE " + s.visibleLists() +
+		" ORDER BY id")
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		list, err := scanList(rows)
+		if err != nil {
+			return nil, err
+		}
+		lists = append(lists, list)
+	}
+	return lists, rows.Err()
+}
+
+func (s *PostgresTaskStorage) GetList(id todo.ListId) (*todo.List, error) {
+	row := s.conn.QueryRow("SELECT id, name, owner_id FROM lists WHERE id = $1 AND "+
+		s.visibleLists(), id)
+
+	list, err := scanList(row)
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, fmt.Errorf("list %d: %w", id, todo.ErrNotFound)
+	}
+	if err != nil {
+		return nil, err
+	}
+	return &list, nil
+}
+
+func (s *PostgresTaskStorage) GetListTasks(id todo.ListId) ([]todo.Task, error) {
+	return s.queryTasks("SELECT "+postgresTaskColumns+` FROM tasks WHERE list_id = $1
+AND trashed_at IS NULL AND `+s.visibleTasks()+" ORDER BY position, id", id)
+}
+
+func (s *PostgresTaskStorage) DeleteList(id todo.ListId, at time.Time) error {
+	tx, err := s.conn.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	// Only owners can delete a list, which rules out lists without an owner
+	// like the default.
+	role, err := s.role(tx, id)
+	if err != nil {
+		return err
+	}
+	if role != todo.RoleOwner {
+		return fmt.Errorf("list %d: %w", id, todo.ErrNotFound)
+	}
+
+	// Subtasks in other lists outlive their parent as top level tasks.
+	_, err = tx.Exec(`UPDATE tasks SET parent_id = NULL WHERE list_id != $1 AND
+parent_id IN (SELECT id FROM tasks WHERE list_id = $1)`, id)
+	if err != nil {
+		return err
+	}
+	// The tasks go to the owner's trash in the default list, keeping when
+	// those already there were trashed.
+	_, err = tx.Exec(`UPDATE tasks SET list_id = $2, trashed_at = coalesce(trashed_at, $3),
+owner_id = (SELECT owner_id FROM lists WHERE id = $1) WHERE list_id = $1`,
+		id, todo.DefaultListId, at.UTC())
+	if err != nil {
+		return err
+	}
+	_, err = tx.Exec("DELETE FROM list_members WHERE list_id = $1", id)
+	if err != nil {
+		return err
+	}
+	err = execOne(tx, "DELETE FROM lists WHERE id = $1", id)
+	if errors.Is(err, todo.ErrNotFound) {
+		return fmt.Errorf("list %d: %w", id, todo.ErrNotFound)
+	}
+	if err != nil {
+		return err
+	}
+	return tx.Commit()
+}
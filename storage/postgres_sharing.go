@@ -0,0 +1,137 @@
+package todo_storage
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+
+	todo "github.com/rosswf/go-todo"
+)
+
+// AddEvent records an event, which belongs to the owner of its task.
+func (s *PostgresTaskStorage) AddEvent(event *todo.Event) (todo.EventId, error) {
+	sqlStmt := `INSERT INTO events(task_id, type, name, previous, at, owner_id, actor_id)
+VALUES($1, $2, $3, $4, $5, (SELECT owner_id FROM tasks WHERE id = $1), $6) RETURNING id`
+	err := s.conn.QueryRow(sqlStmt, event.TaskId, event.Type, event.Name,
+		event.Previous, event.At.UTC(), nullUserId(event.ActorId)).Scan(&event.Id)
+	if err != nil {
+		return -1, err
+	}
+	return event.Id, nil
+}
+
+// GetEvents returns the events matching a query, most recent first unless it
+// is Ascending.
+func (s *PostgresTaskStorage) GetEvents(q todo.EventQuery) ([]todo.Event, error) {
+	query := `SELECT id, task_id, type, name, previous, at, COALESCE(actor_id, 0),
+COALESCE((SELECT username FROM users WHERE users.id = events.actor_id), '') FROM events WHERE true`
+	args := []any{}
+	arg := func(value any) string {
+		args = append(args, value)
+		return fmt.Sprintf("$%d", len(args))
+	}
+	// Users see the events of their own tasks, even once the tasks have been
+	// purged, and of every task they can see.
+	if s.owner != 0 {
+		query += " AND (owner_id = " + arg(s.owner) + " OR task_id IN (SELECT id FROM tasks WHERE " +
+			s.visibleTasks() + "))"
+	}
+	if q.TaskId != 0 {
+		query += " AND task_id = " + arg(q.TaskId)
+	}
+	if q.After != 0 {
+		query += " AND id > " + arg(q.After)
+	}
+	order := "DESC"
+	if q.Ascending {
+		order = "ASC"
+	}
+
+	// A NULL limit means no limit.
+	limit := sql.NullInt64{Int64: int64(q.Limit), Valid: q.Limit > 0}
+	query += " ORDER BY id " + order + " LIMIT " + arg(limit) + " OFFSET " + arg(q.Offset)
+
+	events := []todo.Event{}
+	rows, err := s.conn.Query(query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var event todo.Event
+		err = rows.Scan(&event.Id, &event.TaskId, &event.Type, &event.Name,
+			&event.Previous, &event.At, &event.ActorId, &event.Actor)
+		if err != nil {
+			return nil, err
+		}
+		events = append(events, event)
+	}
+	return events, rows.Err()
+}
+
+func (s *PostgresTaskStorage) GetRole(id todo.ListId) (todo.Role, error) {
+	return s.role(s.conn, id)
+}
+
+// role finds the storage user's role on a list. Lists the user isn't a
+// member of have no role and are treated as not existing.
+func (s *PostgresTaskStorage) role(db queryer, id todo.ListId) (todo.Role, error) {
+	sqlStmt := `SELECT CASE WHEN $1::BIGINT = 0 OR lists.owner_id = $1 THEN $2::TEXT
+WHEN lists.owner_id IS NULL THEN $3::TEXT
+ELSE (SELECT role FROM list_members WHERE list_id = lists.id AND user_id = $1) END
+FROM lists WHERE lists.id = $4`
+
+	var role sql.NullString
+	err := db.QueryRow(sqlStmt, s.owner, todo.RoleOwner, todo.RoleEditor, id).Scan(&role)
+	if errors.Is(err, sql.ErrNoRows) || err == nil && !role.Valid {
+		return "", fmt.Errorf("list %d: %w", id, todo.ErrNotFound)
+	}
+	if err != nil {
+		return "", err
+	}
+	return todo.Role(role.String), nil
+}
+
+// GetMembers returns a list's creator followed by its members in order of
+// username.
+func (s *PostgresTaskStorage) GetMembers(id todo.ListId) ([]todo.Member, error) {
+	sqlStmt := `SELECT id, username, role FROM (
+SELECT users.id, users.username, $1::TEXT AS role, 0 AS creator FROM lists
+JOIN users ON users.id = lists.owner_id WHERE lists.id = $2
+UNION ALL
+SELECT users.id, users.username, list_members.role, 1 AS creator FROM list_members
+JOIN users ON users.id = list_members.user_id WHERE list_members.list_id = $2) AS members
+ORDER BY creator, lower(username) COLLATE "C"`
+
+	members := []todo.Member{}
+	rows, err := s.conn.Query(sqlStmt, todo.RoleOwner, id)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var member todo.Member
+		err = rows.Scan(&member.UserId, &member.Username, &member.Role)
+		if err != nil {
+			return nil, err
+		}
+		members = append(members, member)
+	}
+	return members, rows.Err()
+}
+
+func (s *PostgresTaskStorage) SetMember(id todo.ListId, userId todo.UserId, role todo.Role) error {
+	_, err := s.conn.Exec(`INSERT INTO list_members(list_id, user_id, role) VALUES($1, $2, $3)
+ON CONFLICT(list_id, user_id) DO UPDATE SET role = excluded.role`, id, userId, role)
+	return err
+}
+
+func (s *PostgresTaskStorage) RemoveMember(id todo.ListId, userId todo.UserId) error {
+	err := execOne(s.conn, "DELETE FROM list_members WHERE list_id = $1 AND user_id = $2", id, userId)
+	if errors.Is(err, todo.ErrNotFound) {
+		return fmt.Errorf("member %d of list %d: %w", userId, id, todo.ErrNotFound)
+	}
+	return err
+}
@@ -0,0 +1,186 @@
+package todo_storage
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+	"strings"
+
+	"github.com/lib/pq"
+	todo "github.com/rosswf/go-todo"
+)
+
+// uniqueViolation is the Postgres error code for breaking a unique
+// constraint.
+const uniqueViolation = "23505"
+
+// AddUser adds a user. The first user takes ownership of everything that was
+// created before there were users, apart from the default list which stays
+// shared. The users table is locked meanwhile so that only one user can be
+// the first.
+func (s *PostgresTaskStorage) AddUser(user *todo.User) (todo.UserId, error) {
+	tx, err := s.conn.Begin()
+	if err != nil {
+		return -1, err
+	}
+	defer tx.Rollback()
+
+	_, err = tx.Exec("LOCK TABLE users IN EXCLUSIVE MODE")
+	if err != nil {
+		return -1, err
+	}
+	var existing int
+	err = tx.QueryRow("SELECT COUNT(*) FROM users").Scan(&existing)
+	if err != nil {
+		return -1, err
+	}
+
+	var id todo.UserId
+	err = tx.QueryRow(`INSERT INTO users(username, password_hash, created_at)
+VALUES($1, $2, $3) RETURNING id`, user.Username, user.PasswordHash, user.CreatedAt.UTC()).Scan(&id)
+	var pqErr *pq.Error
+	if errors.As(err, &pqErr) && pqErr.Code == uniqueViolation {
+		return -1, todo.ErrUsernameTaken
+	}
+	if err != nil {
+		return -1, err
+	}
+
+	if existing == 0 {
+		for _, adopt := range []string{
+			"UPDATE tasks SET owner_id = $1 WHERE owner_id IS NULL",
+			"UPDATE events SET owner_id = $1 WHERE owner_id IS NULL",
+			"UPDATE lists SET owner_id = $1 WHERE owner_id IS NULL AND id != 1",
+		} {
+			_, err = tx.Exec(adopt, id)
+			if err != nil {
+				return -1, err
+			}
+		}
+	}
+	user.Id = id
+	return user.Id, tx.Commit()
+}
+
+// addedOwner returns who owns the tasks and lists the storage adds. Storage
+// that isn't for a user adds them for the first user once there are users,
+// as they own everything from before then too.
+func (s *PostgresTaskStorage) addedOwner(db queryer) (todo.UserId, error) {
+	if s.owner != 0 {
+		return s.owner, nil
+	}
+	var first sql.NullInt64
+	err := db.QueryRow("SELECT min(id) FROM users").Scan(&first)
+	return todo.UserId(first.Int64), err
+}
+
+func (s *PostgresTaskStorage) GetUser(id todo.UserId) (*todo.User, error) {
+	return s.getUser("id = $1", id)
+}
+
+// GetUserByName finds a user by their username, ignoring case.
+func (s *PostgresTaskStorage) GetUserByName(username string) (*todo.User, error) {
+	return s.getUser("lower(username) = lower($1)", username)
+}
+
+func (s *PostgresTaskStorage) getUser(condition string, arg any) (*todo.User, error) {
+	row := s.conn.QueryRow(`SELECT id, username, password_hash, created_at FROM users
+WHERE `+condition, arg)
+
+	var user todo.User
+	err := row.Scan(&user.Id, &user.Username, &user.PasswordHash, &user.CreatedAt)
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, fmt.Errorf("user %v: %w", arg, todo.ErrNotFound)
+	}
+	if err != nil {
+		return nil, err
+	}
+	return &user, nil
+}
+
+func (s *PostgresTaskStorage) AddSession(session *todo.Session) error {
+	_, err := s.conn.Exec("INSERT INTO sessions(token_hash, user_id, expires_at) VALUES($1, $2, $3)",
+		session.TokenHash, session.UserId, session.ExpiresAt.UTC())
+	return err
+}
+
+func (s *PostgresTaskStorage) GetSession(tokenHash string) (*todo.Session, error) {
+	row := s.conn.QueryRow("SELECT token_hash, user_id, expires_at FROM sessions WHERE token_hash = $1",
+		tokenHash)
+
+	var session todo.Session
+	err := row.Scan(&session.TokenHash, &session.UserId, &session.ExpiresAt)
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, fmt.Errorf("session: %w", todo.ErrNotFound)
+	}
+	if err != nil {
+		return nil, err
+	}
+	return &session, nil
+}
+
+func (s *PostgresTaskStorage) DeleteSession(tokenHash string) error {
+	err := execOne(s.conn, "DELETE FROM sessions WHERE token_hash = $1", tokenHash)
+	if errors.Is(err, todo.ErrNotFound) {
+		return fmt.Errorf("session: %w", todo.ErrNotFound)
+	}
+	return err
+}
+
+// AddAPIToken stores an API token, with its scopes joined by commas.
+func (s *PostgresTaskStorage) AddAPIToken(token *todo.APIToken) (todo.APITokenId, error) {
+	scopes := []string{}
+	for _, scope := range token.Scopes {
+		scopes = append(scopes, string(scope))
+	}
+
+	sqlStmt := `INSERT INTO api_tokens(user_id, name, scopes, token_hash, created_at)
+VALUES($1, $2, $3, $4, $5) RETURNING id`
+	err := s.conn.QueryRow(sqlStmt, token.UserId, token.Name, strings.Join(scopes, ","),
+		token.TokenHash, token.CreatedAt.UTC()).Scan(&token.Id)
+	if err != nil {
+		return -1, err
+	}
+	return token.Id, nil
+}
+
+func (s *PostgresTaskStorage) GetAPITokens(userId todo.UserId) ([]todo.APIToken, error) {
+	tokens := []todo.APIToken{}
+	rows, err := s.conn.Query("SELECT "+apiTokenColumns+" FROM api_tokens WHERE user_id = $1 ORDER BY id",
+		userId)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		token, err := scanAPIToken(rows)
+		if err != nil {
+			return nil, err
+		}
+		tokens = append(tokens, token)
+	}
+	return tokens, rows.Err()
+}
+
+func (s *PostgresTaskStorage) GetAPIToken(tokenHash string) (*todo.APIToken, error) {
+	row := s.conn.QueryRow("SELECT "+apiTokenColumns+" FROM api_tokens WHERE token_hash = $1",
+		tokenHash)
+
+	token, err := scanAPIToken(row)
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, fmt.Errorf("API token: %w", todo.ErrNotFound)
+	}
+	if err != nil {
+		return nil, err
+	}
+	return &token, nil
+}
+
+func (s *PostgresTaskStorage) DeleteAPIToken(userId todo.UserId, id todo.APITokenId) error {
+	err := execOne(s.conn, "DELETE FROM api_tokens WHERE id = $1 AND user_id = $2", id, userId)
+	if errors.Is(err, todo.ErrNotFound) {
+		return fmt.Errorf("API token %d: %w", id, todo.ErrNotFound)
+	}
+	return err
+}
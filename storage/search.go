@@ -0,0 +1,127 @@
+package todo_storage
+
+import (
+	"database/sql"
+	"html"
+	"strings"
+
+	todo "github.com/rosswf/go-todo"
+)
+
+// setupSearch creates the full-text search index if SQLite was built with
+// FTS5, which needs the sqlite_fts5 build tag, and reports whether it is
+// available. The index is kept in sync by the storage methods rather than
+// triggers so that the database can still be written by builds without FTS5,
+// and it is rebuilt on opening to pick up any changes they made.
+func setupSearch(db *sql.DB) (bool, error) {
+	var available bool
+	err := db.QueryRow("SELECT sqlite_compileoption_used('ENABLE_FTS5')").Scan(&available)
+	if err != nil || !available {
+		return false, err
+	}
+
+	_, err = db.Exec("CREATE VIRTUAL TABLE IF NOT EXISTS tasks_fts USING fts5(name)")
+	if err != nil {
+		return false, err
+	}
+
+	tx, err := db.Begin()
+	if err != nil {
+		return false, err
+	}
+	defer tx.Rollback()
+
+	_, err = tx.Exec("DELETE FROM tasks_fts")
+	if err != nil {
+		return false, err
+	}
+	_, err = tx.Exec("INSERT INTO tasks_fts(rowid, name) SELECT id, name FROM tasks")
+	if err != nil {
+		return false, err
+	}
+	return true, tx.Commit()
+}
+
+// FTS5 marks matches with control characters rather than the highlight
+// markers, so that the snippet can be escaped before they are swapped in.
+const (
+	ftsHighlightStart = "\x02"
+	ftsHighlightEnd   = "\x03"
+)
+
+var ftsHighlighter = strings.NewReplacer(ftsHighlightStart, todo.HighlightStart,
+	ftsHighlightEnd, todo.HighlightEnd)
+
+// execIndex runs a statement against the search index, if there is one.
+func (s *Sqlite3TaskStorage) execIndex(db execer, query string, args ...any) error {
+	if !s.fts {
+		return nil
+	}
+	_, err := db.Exec(query, args...)
+	return err
+}
+
+func (s *Sqlite3TaskStorage) indexTask(db execer, id todo.TaskId, name string) error {
+	err := s.execIndex(db, "DELETE FROM tasks_fts WHERE rowid=?", id)
+	if err != nil {
+		return err
+	}
+	return s.execIndex(db, "INSERT INTO tasks_fts(rowid, name) values(?, ?)", id, name)
+}
+
+// Search returns the tasks whose names contain a word starting with each
+// term of the query, ranked by relevance. Without FTS5 the results are in id
+// order instead.
+func (s *Sqlite3TaskStorage) Search(query string) ([]todo.SearchResult, error) {
+	if !s.fts {
+		tasks, err := s.GetAll()
+		if err != nil {
+			return nil, err
+		}
+		return todo.SearchTasks(tasks, query), nil
+	}
+
+	// Quote each term so that nothing in it is taken as FTS5 query syntax,
+	// and make it a prefix query to match the fallback.
+	terms := []string{}
+	for _, term := range todo.SearchTerms(query) {
+		terms = append(terms, `"`+strings.ReplaceAll(term, `"`, `""`)+`"*`)
+	}
+	// FTS5 can't match an empty query, which matches nothing in the fallback.
+	if len(terms) == 0 {
+		return []todo.SearchResult{}, nil
+	}
+
+	sqlStmt := `SELECT ` + taskColumns + `, matches.snippet FROM tasks JOIN
+(SELECT rowid, snippet(tasks_fts, 0, ?, ?, '…', 64) AS snippet, rank
+FROM tasks_fts WHERE tasks_fts MATCH ?) AS matches ON matches.rowid = tasks.id
+WHERE tasks.trashed_at IS NULL AND ` + s.visibleTasks() + " ORDER BY matches.rank, tasks.id"
+	rows, err := s.conn.Query(sqlStmt, ftsHighlightStart, ftsHighlightEnd,
+		strings.Join(terms, " "))
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	results := []todo.SearchResult{}
+	for rows.Next() {
+		var result todo.SearchResult
+		result.Task, err = scanTask(withSnippet{rows, &result.Snippet})
+		if err != nil {
+			return nil, err
+		}
+		result.Snippet = ftsHighlighter.Replace(html.EscapeString(result.Snippet))
+		results = append(results, result)
+	}
+	return results, rows.Err()
+}
+
+// withSnippet scans a task followed by its search snippet.
+type withSnippet struct {
+	row     scanner
+	snippet *string
+}
+
+func (w withSnippet) Scan(dest ...any) error {
+	return w.row.Scan(append(dest, w.snippet)...)
+}
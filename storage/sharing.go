@@ -0,0 +1,80 @@
+package todo_storage
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+
+	todo "github.com/rosswf/go-todo"
+)
+
+type queryer interface {
+	QueryRow(query string, args ...any) *sql.Row
+}
+
+func (s *Sqlite3TaskStorage) GetRole(id todo.ListId) (todo.Role, error) {
+	return s.role(s.conn, id)
+}
+
+// role finds the storage user's role on a list. Lists the user isn't a
+// member of have no role and are treated as not existing.
+func (s *Sqlite3TaskStorage) role(db queryer, id todo.ListId) (todo.Role, error) {
+	sqlStmt := `SELECT CASE WHEN ? = 0 OR lists.owner_id = ? THEN ?
+WHEN lists.owner_id IS NULL THEN ?
+ELSE (SELECT role FROM list_members WHERE list_id = lists.id AND user_id = ?) END
+FROM lists WHERE lists.id = ?`
+
+	var role sql.NullString
+	err := db.QueryRow(sqlStmt, s.owner, s.owner, todo.RoleOwner, todo.RoleEditor,
+		s.owner, id).Scan(&role)
+	if errors.Is(err, sql.ErrNoRows) || err == nil && !role.Valid {
+		return "", fmt.Errorf("list %d: %w", id, todo.ErrNotFound)
+	}
+	if err != nil {
+		return "", err
+	}
+	return todo.Role(role.String), nil
+}
+
+// GetMembers returns a list's creator followed by its members in order of
+// username.
+func (s *Sqlite3TaskStorage) GetMembers(id todo.ListId) ([]todo.Member, error) {
+	sqlStmt := `SELECT id, username, role FROM (
+SELECT users.id, users.username, ? AS role, 0 AS creator FROM lists
+JOIN users ON users.id = lists.owner_id WHERE lists.id = ?
+UNION ALL
+SELECT users.id, users.username, list_members.role, 1 AS creator FROM list_members
+JOIN users ON users.id = list_members.user_id WHERE list_members.list_id = ?)
+ORDER BY creator, username COLLATE NOCASE`
+
+	members := []todo.Member{}
+	rows, err := s.conn.Query(sqlStmt, todo.RoleOwner, id, id)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var member todo.Member
+		err = rows.Scan(&member.UserId, &member.Username, &member.Role)
+		if err != nil {
+			return nil, err
+		}
+		members = append(members, member)
+	}
+	return members, rows.Err()
+}
+
+func (s *Sqlite3TaskStorage) SetMember(id todo.ListId, userId todo.UserId, role todo.Role) error {
+	_, err := s.conn.Exec(`INSERT INTO list_members(list_id, user_id, role) values(?, ?, ?)
+ON CONFLICT(list_id, user_id) DO UPDATE SET role = excluded.role`, id, userId, role)
+	return err
+}
+
+func (s *Sqlite3TaskStorage) RemoveMember(id todo.ListId, userId todo.UserId) error {
+	err := execOne(s.conn, "DELETE FROM list_members WHERE list_id = ? AND user_id = ?", id, userId)
+	if errors.Is(err, todo.ErrNotFound) {
+		return fmt.Errorf("member %d of list %d: %w", userId, id, todo.ErrNotFound)
+	}
+	return err
+}
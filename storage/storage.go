@@ -2,13 +2,27 @@ package todo_storage
 
 import (
 	"database/sql"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
 
 	_ "github.com/mattn/go-sqlite3"
 	todo "github.com/rosswf/go-todo"
 )
 
+const taskColumns = `id, name, complete, list_id, due, remind_at, completed_at,
+created_at, parent_id, priority, position, recurrence, trashed_at, owner_id,
+(SELECT group_concat(name) FROM (SELECT tags.name FROM task_tags
+JOIN tags ON tags.id = task_tags.tag_id WHERE task_tags.task_id = tasks.id
+ORDER BY tags.name)) AS tags`
+
 type Sqlite3TaskStorage struct {
 	conn *sql.DB
+	// fts is set when the full-text search index is available.
+	fts bool
+	// owner is the user the storage is scoped to, or zero for everyone.
+	owner todo.UserId
 }
 
 func CreateSqlite3TaskStorage(location string) (*Sqlite3TaskStorage, error) {
@@ -16,23 +30,196 @@ func CreateSqlite3TaskStorage(location string) (*Sqlite3TaskStorage, error) {
 	if err != nil {
 		return nil, err
 	}
-	sqlStmt := `CREATE TABLE IF NOT EXISTS tasks
-(id INTEGER not null primary key, name TEXT, complete BOOL);`
+	// SQLite only allows a single writer, and every connection to ":memory:"
+	// opens a fresh database, so share one connection.
+	db.SetMaxOpenConns(1)
 
-	_, err = db.Exec(sqlStmt)
+	err = migrate(db)
+	if err != nil {
+		db.Close()
+		return nil, err
+	}
+	fts, err := setupSearch(db)
 	if err != nil {
+		db.Close()
 		return nil, err
 	}
-	return &Sqlite3TaskStorage{db}, nil
+	return &Sqlite3TaskStorage{conn: db, fts: fts}, nil
 }
 
 func (s *Sqlite3TaskStorage) Close() {
 	s.conn.Close()
 }
 
+// ForUser returns storage sharing the same database that only includes a
+// user's tasks, lists and events.
+func (s *Sqlite3TaskStorage) ForUser(id todo.UserId) todo.TaskStorage {
+	return &Sqlite3TaskStorage{conn: s.conn, fts: s.fts, owner: id}
+}
+
+// visibleTasks is a condition matching the tasks the storage includes, see
+// tasksVisibleTo.
+func (s *Sqlite3TaskStorage) visibleTasks() string {
+	return tasksVisibleTo(s.owner)
+}
+
+// editableTasks is visibleTasks without the lists the user can only view.
+func (s *Sqlite3TaskStorage) editableTasks() string {
+	return tasksEditableBy(s.owner)
+}
+
+// visibleLists is a condition matching the lists the storage includes, see
+// listsVisibleTo.
+func (s *Sqlite3TaskStorage) visibleLists() string {
+	return listsVisibleTo(s.owner)
+}
+
+// tasksVisibleTo is a condition matching the tasks a user can see, which are
+// their own tasks in lists without an owner, such as the default list, and
+// every task in the lists they own or are a member of. Every task matches
+// for the zero user. It is plain SQL that works with any of the databases.
+func tasksVisibleTo(owner todo.UserId) string {
+	return tasksInLists(owner, "")
+}
+
+// tasksEditableBy is tasksVisibleTo without the lists the user can only view.
+func tasksEditableBy(owner todo.UserId) string {
+	return tasksInLists(owner, fmt.Sprintf(" AND role != '%s'", todo.RoleViewer))
+}
+
+func tasksInLists(owner todo.UserId, memberCondition string) string {
+	if owner == 0 {
+		return "true"
+	}
+	return fmt.Sprintf(`(tasks.owner_id = %[1]d AND tasks.list_id IN
+(SELECT id FROM lists WHERE owner_id IS NULL)
+OR tasks.list_id IN (SELECT id FROM lists WHERE owner_id = %[1]d)
+OR tasks.list_id IN (SELECT list_id FROM list_members WHERE user_id = %[1]d%[2]s))`,
+		owner, memberCondition)
+}
+
+// listsVisibleTo is a condition matching the lists a user can see, which are
+// those without an owner such as the default list and those the user owns or
+// is a member of.
+func listsVisibleTo(owner todo.UserId) string {
+	if owner == 0 {
+		return "true"
+	}
+	return fmt.Sprintf(`(lists.owner_id IS NULL OR lists.owner_id = %[1]d
+OR lists.id IN (SELECT list_id FROM list_members WHERE user_id = %[1]d))`, owner)
+}
+
+// nullUserId stores the zero id, meaning no user, as NULL.
+func nullUserId(id todo.UserId) sql.NullInt64 {
+	return sql.NullInt64{Int64: int64(id), Valid: id != 0}
+}
+
+type scanner interface {
+	Scan(dest ...any) error
+}
+
+func scanTask(row scanner) (todo.Task, error) {
+	var task todo.Task
+	var due, remindAt, completedAt, createdAt, trashedAt sql.NullTime
+	var parentId, ownerId sql.NullInt64
+	var priority, position, tags sql.NullString
+	err := row.Scan(&task.Id, &task.Name, &task.Complete, &task.ListId, &due,
+		&remindAt, &completedAt, &createdAt, &parentId, &priority, &position,
+		&task.Recurrence, &trashedAt, &ownerId, &tags)
+	task.OwnerId = todo.UserId(ownerId.Int64)
+	task.Position = position.String
+	task.ParentId = todo.TaskId(parentId.Int64)
+	task.Priority = todo.Priority(priority.String)
+	if tags.Valid {
+		// Tags can't contain commas so are safe to join with them.
+		task.Tags = strings.Split(tags.String, ",")
+	}
+	task.Due = timePtr(due)
+	task.RemindAt = timePtr(remindAt)
+	task.CompletedAt = timePtr(completedAt)
+	task.CreatedAt = timePtr(createdAt)
+	task.TrashedAt = timePtr(trashedAt)
+	return task, err
+}
+
+// nullTime converts an optional time for storage. Times are stored in UTC so
+// that they compare correctly as text.
+func nullTime(t *time.Time) sql.NullTime {
+	if t == nil {
+		return sql.NullTime{}
+	}
+	return sql.NullTime{Time: t.UTC(), Valid: true}
+}
+
+// nullTaskId stores the zero id, meaning no task, as NULL.
+func nullTaskId(id todo.TaskId) sql.NullInt64 {
+	return sql.NullInt64{Int64: int64(id), Valid: id != 0}
+}
+
+// nullPriority stores no priority as NULL so that it sorts apart from the
+// others.
+func nullPriority(p todo.Priority) sql.NullString {
+	return sql.NullString{String: string(p), Valid: p != ""}
+}
+
+func timePtr(t sql.NullTime) *time.Time {
+	if !t.Valid {
+		return nil
+	}
+	return &t.Time
+}
+
+func (s *Sqlite3TaskStorage) queryTasks(query string, args ...any) ([]todo.Task, error) {
+	tasks := []todo.Task{}
+	rows, err := s.conn.Query(query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		task, err := scanTask(rows)
+		if err != nil {
+			return nil, err
+		}
+		tasks = append(tasks, task)
+	}
+	return tasks, rows.Err()
+}
+
 func (s *Sqlite3TaskStorage) Add(task *todo.Task) (todo.TaskId, error) {
-	sqlStmt := "INSERT INTO tasks(name, complete) values(?, ?)"
-	result, err := s.conn.Exec(sqlStmt, task.Name, task.Complete)
+	listId := task.ListId
+	if listId == 0 {
+		listId = todo.DefaultListId
+	}
+
+	tx, err := s.conn.Begin()
+	if err != nil {
+		return -1, err
+	}
+	defer tx.Rollback()
+
+	// New tasks go at the end of their list.
+	var last sql.NullString
+	err = tx.QueryRow("SELECT MAX(position) FROM tasks WHERE list_id = ?", listId).Scan(&last)
+	if err != nil {
+		return -1, err
+	}
+	task.Position = todo.PositionBetween(last.String, "")
+	if s.owner != 0 || task.OwnerId == 0 {
+		task.OwnerId, err = s.addedOwner(tx)
+		if err != nil {
+			return -1, err
+		}
+	}
+
+	sqlStmt := `INSERT INTO tasks(name, complete, list_id, due, remind_at,
+completed_at, created_at, parent_id, priority, position, recurrence, owner_id)
+values(?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?)`
+	result, err := tx.Exec(sqlStmt, task.Name, task.Complete, listId,
+		nullTime(task.Due), nullTime(task.RemindAt), nullTime(task.CompletedAt),
+		nullTime(task.CreatedAt), nullTaskId(task.ParentId), nullPriority(task.Priority),
+		task.Position, task.Recurrence, nullUserId(task.OwnerId))
 	if err != nil {
 		return -1, err
 	}
@@ -41,74 +228,470 @@ func (s *Sqlite3TaskStorage) Add(task *todo.Task) (todo.TaskId, error) {
 	if err != nil {
 		return -1, err
 	}
-	return todo.TaskId(id), err
+
+	err = setTags(tx, todo.TaskId(id), task.Tags)
+	if err != nil {
+		return -1, err
+	}
+	err = s.indexTask(tx, todo.TaskId(id), task.Name)
+	if err != nil {
+		return -1, err
+	}
+	return todo.TaskId(id), tx.Commit()
 }
 
-func (s *Sqlite3TaskStorage) GetAll() ([]todo.Task, error) {
-	tasks := []todo.Task{}
-	rows, err := s.conn.Query("SELECT * FROM tasks")
+// setTags replaces the tags of a task.
+func setTags(tx *sql.Tx, id todo.TaskId, tags []string) error {
+	_, err := tx.Exec("DELETE FROM task_tags WHERE task_id=?", id)
 	if err != nil {
-		return nil, err
+		return err
 	}
-	for rows.Next() {
-		var id todo.TaskId
-		var name string
-		var complete bool
-		_ = rows.Scan(&id, &name, &complete)
-		tasks = append(tasks, todo.Task{Id: id, Name: name, Complete: complete})
+
+	for _, tag := range tags {
+		_, err = tx.Exec("INSERT OR IGNORE INTO tags(name) values(?)", tag)
+		if err != nil {
+			return err
+		}
+		_, err = tx.Exec(`INSERT OR IGNORE INTO task_tags(task_id, tag_id)
+SELECT ?, id FROM tags WHERE name = ?`, id, tag)
+		if err != nil {
+			return err
+		}
 	}
-	return tasks, nil
+	return nil
+}
+
+func (s *Sqlite3TaskStorage) GetAll() ([]todo.Task, error) {
+	return s.queryTasks("SELECT " + taskColumns + ` FROM tasks WHERE trashed_at IS NULL
+AND ` + s.visibleTasks() + " ORDER BY position, id")
 }
 
 func (s *Sqlite3TaskStorage) GetTask(id todo.TaskId) (*todo.Task, error) {
-	row := s.conn.QueryRow("SELECT * FROM tasks WHERE id = ?", id)
+	row := s.conn.QueryRow("SELECT "+taskColumns+` FROM tasks WHERE id = ?
+AND trashed_at IS NULL AND `+s.visibleTasks(), id)
 
-	var taskId todo.TaskId
-	var name string
-	var complete bool
-	err := row.Scan(&taskId, &name, &complete)
+	task, err := scanTask(row)
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, fmt.Errorf("task %d: %w", id, todo.ErrNotFound)
+	}
 	if err != nil {
 		return nil, err
 	}
-	return &todo.Task{Id: taskId, Name: name, Complete: complete}, nil
+	return &task, nil
 }
 
-func (s *Sqlite3TaskStorage) ToggleStatus(id todo.TaskId) error {
-	sqlStmt := `UPDATE tasks SET complete = CASE WHEN complete = true 
-THEN false ELSE true END WHERE id=?`
+func (s *Sqlite3TaskStorage) Complete(id todo.TaskId, at time.Time) error {
+	sqlStmt := `UPDATE tasks SET complete = true, completed_at = CASE
+WHEN complete = true THEN completed_at ELSE ? END WHERE id=? AND trashed_at IS NULL
+AND ` + s.visibleTasks()
+
+	return execOne(s.conn, sqlStmt, at.UTC(), id)
+}
+
+func (s *Sqlite3TaskStorage) Reopen(id todo.TaskId) error {
+	sqlStmt := `UPDATE tasks SET complete = false, completed_at = NULL WHERE id=?
+AND trashed_at IS NULL AND ` + s.visibleTasks()
+
+	return execOne(s.conn, sqlStmt, id)
+}
+
+type execer interface {
+	Exec(query string, args ...any) (sql.Result, error)
+}
 
-	_, err := s.conn.Exec(sqlStmt, id)
+// execOne runs a statement that should affect exactly one row, returning
+// todo.ErrNotFound if it matched nothing.
+func execOne(db execer, query string, args ...any) error {
+	result, err := db.Exec(query, args...)
 	if err != nil {
 		return err
 	}
+	rows, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if rows == 0 {
+		return todo.ErrNotFound
+	}
 	return nil
 }
 
+func (s *Sqlite3TaskStorage) Update(task *todo.Task) error {
+	tx, err := s.conn.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	sqlStmt := `UPDATE tasks SET name = ?, complete = ?, list_id = ?, due = ?,
+remind_at = ?, completed_at = ?, parent_id = ?, priority = ?, recurrence = ?
+WHERE id=? AND trashed_at IS NULL AND ` + s.visibleTasks()
+
+	err = execOne(tx, sqlStmt, task.Name, task.Complete, task.ListId,
+		nullTime(task.Due), nullTime(task.RemindAt), nullTime(task.CompletedAt),
+		nullTaskId(task.ParentId), nullPriority(task.Priority), task.Recurrence,
+		task.Id)
+	if err != nil {
+		return err
+	}
+
+	err = setTags(tx, task.Id, task.Tags)
+	if err != nil {
+		return err
+	}
+	err = s.indexTask(tx, task.Id, task.Name)
+	if err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
 func (s *Sqlite3TaskStorage) GetOutstanding() ([]todo.Task, error) {
-	tasks := []todo.Task{}
-	rows, err := s.conn.Query("SELECT * FROM tasks WHERE complete = false")
+	return s.queryTasks("SELECT " + taskColumns + ` FROM tasks WHERE complete = false
+AND trashed_at IS NULL AND ` + s.visibleTasks() + " ORDER BY position, id")
+}
+
+func (s *Sqlite3TaskStorage) GetOverdue(now time.Time) ([]todo.Task, error) {
+	return s.queryTasks("SELECT "+taskColumns+` FROM tasks
+WHERE complete = false AND due < ? AND trashed_at IS NULL AND `+s.visibleTasks()+
+		" ORDER BY due", now.UTC())
+}
+
+func (s *Sqlite3TaskStorage) GetDue(after, before time.Time) ([]todo.Task, error) {
+	query := "SELECT " + taskColumns + " FROM tasks WHERE due IS NOT NULL AND trashed_at IS NULL AND " +
+		s.visibleTasks()
+	args := []any{}
+	if !after.IsZero() {
+		query += " AND due > ?"
+		args = append(args, after.UTC())
+	}
+	if !before.IsZero() {
+		query += " AND due < ?"
+		args = append(args, before.UTC())
+	}
+	return s.queryTasks(query+" ORDER BY due", args...)
+}
+
+// orderings matches the sort order of todo.QueryTasks, %[1]s is replaced
+// with the direction.
+var orderings = map[todo.SortField]string{
+	todo.SortById:      "id %[1]s",
+	todo.SortByName:    "name COLLATE NOCASE %[1]s, id %[1]s",
+	todo.SortByCreated: "created_at %[1]s, id %[1]s",
+	todo.SortByDue:     "due IS NULL, due %[1]s, id %[1]s",
+	todo.SortByPriority: `priority IS NULL, priority %[1]s, due IS NULL, due %[1]s,
+position %[1]s, id %[1]s`,
+	todo.SortByPosition: "position %[1]s, id %[1]s",
+}
+
+func (s *Sqlite3TaskStorage) Query(q todo.TaskQuery) ([]todo.Task, error) {
+	query := "SELECT " + taskColumns + " FROM tasks WHERE trashed_at IS NULL AND " + s.visibleTasks()
+	args := []any{}
+
+	if q.ListId != 0 {
+		query += " AND list_id = ?"
+		args = append(args, q.ListId)
+	}
+	if q.Complete != nil {
+		query += " AND complete = ?"
+		args = append(args, *q.Complete)
+	}
+	if q.NameContains != "" {
+		query += ` AND name LIKE ? ESCAPE '\'`
+		args = append(args, "%"+escapeLike(q.NameContains)+"%")
+	}
+	if q.Tag != "" {
+		query += ` AND EXISTS (SELECT 1 FROM task_tags JOIN tags
+ON tags.id = task_tags.tag_id WHERE task_tags.task_id = tasks.id AND tags.name = ?)`
+		args = append(args, q.Tag)
+	}
+
+	ordering, ok := orderings[q.Sort]
+	if !ok {
+		ordering = orderings[todo.SortById]
+	}
+	direction := "ASC"
+	if q.Descending {
+		direction = "DESC"
+	}
+	query += " ORDER BY " + fmt.Sprintf(ordering, direction)
+
+	// SQLite requires a LIMIT to use OFFSET, -1 means no limit.
+	limit := q.Limit
+	if limit <= 0 {
+		limit = -1
+	}
+	query += " LIMIT ? OFFSET ?"
+	args = append(args, limit, q.Offset)
+
+	return s.queryTasks(query, args...)
+}
+
+func escapeLike(s string) string {
+	return strings.NewReplacer(`\`, `\\`, "%", `\%`, "_", `\_`).Replace(s)
+}
+
+// Trash moves a task to the trash. Its tags and search index entry are kept
+// so that it can be restored as it was.
+func (s *Sqlite3TaskStorage) Trash(id todo.TaskId, at time.Time) error {
+	return execOne(s.conn, `UPDATE tasks SET trashed_at = ? WHERE id=? AND trashed_at IS NULL
+AND `+s.visibleTasks(), at.UTC(), id)
+}
+
+// Restore takes a task out of the trash along with any of its subtasks that
+// were trashed at the same time.
+func (s *Sqlite3TaskStorage) Restore(id todo.TaskId) error {
+	sqlStmt := `WITH RECURSIVE restored(id, trashed_at) AS (
+SELECT id, trashed_at FROM tasks WHERE id = ? AND trashed_at IS NOT NULL AND ` +
+		s.visibleTasks() + `
+UNION SELECT tasks.id, tasks.trashed_at FROM tasks JOIN restored
+ON tasks.parent_id = restored.id AND tasks.trashed_at = restored.trashed_at)
+UPDATE tasks SET trashed_at = NULL WHERE id IN (SELECT id FROM restored)`
+
+	err := execOne(s.conn, sqlStmt, id)
+	if errors.Is(err, todo.ErrNotFound) {
+		return fmt.Errorf("task %d in trash: %w", id, todo.ErrNotFound)
+	}
+	return err
+}
+
+func (s *Sqlite3TaskStorage) GetTrash() ([]todo.Task, error) {
+	return s.queryTasks("SELECT " + taskColumns + ` FROM tasks WHERE trashed_at IS NOT NULL
+AND ` + s.visibleTasks() + " ORDER BY trashed_at DESC, id")
+}
+
+// Purge permanently deletes the tasks trashed at or before a time, along
+// with their tags and search index entries.
+func (s *Sqlite3TaskStorage) Purge(before time.Time) (int, error) {
+	tx, err := s.conn.Begin()
+	if err != nil {
+		return 0, err
+	}
+	defer tx.Rollback()
+
+	purged := "SELECT id FROM tasks WHERE trashed_at <= ? AND " + s.editableTasks()
+	_, err = tx.Exec("DELETE FROM task_tags WHERE task_id IN ("+purged+")", before.UTC())
+	if err != nil {
+		return 0, err
+	}
+	err = s.execIndex(tx, "DELETE FROM tasks_fts WHERE rowid IN ("+purged+")", before.UTC())
+	if err != nil {
+		return 0, err
+	}
+	result, err := tx.Exec("DELETE FROM tasks WHERE id IN ("+purged+")", before.UTC())
+	if err != nil {
+		return 0, err
+	}
+	rows, err := result.RowsAffected()
+	if err != nil {
+		return 0, err
+	}
+	return int(rows), tx.Commit()
+}
+
+// Move places a task after the task after and before the task before,
+// either of which may be zero to place it next to the other.
+func (s *Sqlite3TaskStorage) Move(id, before, after todo.TaskId) error {
+	tx, err := s.conn.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	lower, err := s.position(tx, after)
+	if err != nil {
+		return err
+	}
+	upper, err := s.position(tx, before)
+	if err != nil {
+		return err
+	}
+
+	// Find the neighbour that wasn't given.
+	var neighbour sql.NullString
+	switch {
+	case before == 0:
+		err = tx.QueryRow(`SELECT MIN(position) FROM tasks WHERE position > ?
+AND id != ? AND trashed_at IS NULL AND `+s.visibleTasks(), lower, id).Scan(&neighbour)
+		upper = neighbour.String
+	case after == 0:
+		err = tx.QueryRow(`SELECT MAX(position) FROM tasks WHERE position < ?
+AND id != ? AND trashed_at IS NULL AND `+s.visibleTasks(), upper, id).Scan(&neighbour)
+		lower = neighbour.String
+	}
+	if err != nil {
+		return err
+	}
+	if upper != "" && lower >= upper {
+		return todo.ErrMoveOutOfOrder
+	}
+
+	err = execOne(tx, "UPDATE tasks SET position = ? WHERE id=? AND trashed_at IS NULL AND "+
+		s.visibleTasks(), todo.PositionBetween(lower, upper), id)
+	if err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+// position returns the position of a task, or an empty string for id zero.
+func (s *Sqlite3TaskStorage) position(tx *sql.Tx, id todo.TaskId) (string, error) {
+	if id == 0 {
+		return "", nil
+	}
+	var position string
+	err := tx.QueryRow("SELECT position FROM tasks WHERE id=? AND trashed_at IS NULL AND "+
+		s.visibleTasks(), id).Scan(&position)
+	if errors.Is(err, sql.ErrNoRows) {
+		return "", fmt.Errorf("task %d: %w", id, todo.ErrNotFound)
+	}
+	return position, err
+}
+
+// GetSubtasks returns every task nested under a task, at any depth.
+func (s *Sqlite3TaskStorage) GetSubtasks(id todo.TaskId) ([]todo.Task, error) {
+	sqlStmt := `WITH RECURSIVE subtasks(id) AS (
+SELECT id FROM tasks WHERE parent_id = ? AND trashed_at IS NULL AND ` + s.visibleTasks() + `
+UNION SELECT tasks.id FROM tasks JOIN subtasks ON tasks.parent_id = subtasks.id
+WHERE tasks.trashed_at IS NULL)
+SELECT ` + taskColumns + ` FROM tasks WHERE id IN subtasks ORDER BY position, id`
+	return s.queryTasks(sqlStmt, id)
+}
+
+// GetTags returns every tag that is in use, with the number of tasks using it.
+func (s *Sqlite3TaskStorage) GetTags() ([]todo.Tag, error) {
+	tags := []todo.Tag{}
+	rows, err := s.conn.Query(`SELECT tags.name, COUNT(*) FROM tags
+JOIN task_tags ON tags.id = task_tags.tag_id JOIN tasks ON tasks.id = task_tags.task_id
+WHERE tasks.trashed_at IS NULL AND ` + s.visibleTasks() + " GROUP BY tags.name ORDER BY tags.name")
 	if err != nil {
 		return nil, err
 	}
+	defer rows.Close()
+
 	for rows.Next() {
-		var id todo.TaskId
-		var name string
-		var complete bool
-		err = rows.Scan(&id, &name, &complete)
+		var tag todo.Tag
+		err = rows.Scan(&tag.Name, &tag.Count)
 		if err != nil {
 			return nil, err
 		}
-		tasks = append(tasks, todo.Task{Id: id, Name: name, Complete: complete})
+		tags = append(tags, tag)
 	}
-	return tasks, nil
+	return tags, rows.Err()
 }
 
-func (s *Sqlite3TaskStorage) Delete(id todo.TaskId) error {
-	sqlStmt := "DELETE FROM tasks WHERE id=?"
-	_, err := s.conn.Exec(sqlStmt, id)
+func (s *Sqlite3TaskStorage) AddList(list *todo.List) (todo.ListId, error) {
+	if s.owner != 0 || list.OwnerId == 0 {
+		var err error
+		list.OwnerId, err = s.addedOwner(s.conn)
+		if err != nil {
+			return -1, err
+		}
+	}
+	sqlStmt := "INSERT INTO lists(name, owner_id) values(?, ?)"
+	result, err := s.conn.Exec(sqlStmt, list.Name, nullUserId(list.OwnerId))
+	if err != nil {
+		return -1, err
+	}
+
+	id, err := result.LastInsertId()
+	if err != nil {
+		return -1, err
+	}
+	return todo.ListId(id), err
+}
+
+func (s *Sqlite3TaskStorage) GetLists() ([]todo.List, error) {
+	lists := []todo.List{}
+	rows, err := s.conn.Query("SELECT id, name, owner_id FROM lists WHERE " + s.visibleLists() +
+		" ORDER BY id")
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		list, err := scanList(rows)
+		if err != nil {
+			return nil, err
+		}
+		lists = append(lists, list)
+	}
+	return lists, rows.Err()
+}
+
+func (s *Sqlite3TaskStorage) GetList(id todo.ListId) (*todo.List, error) {
+	row := s.conn.QueryRow("SELECT id, name, owner_id FROM lists WHERE id = ? AND "+
+		s.visibleLists(), id)
+
+	list, err := scanList(row)
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, fmt.Errorf("list %d: %w", id, todo.ErrNotFound)
+	}
+	if err != nil {
+		return nil, err
+	}
+	return &list, nil
+}
+
+func (s *Sqlite3TaskStorage) GetListTasks(id todo.ListId) ([]todo.Task, error) {
+	return s.queryTasks("SELECT "+taskColumns+` FROM tasks WHERE list_id = ?
+AND trashed_at IS NULL AND `+s.visibleTasks()+" ORDER BY position, id", id)
+}
+
+func scanList(row scanner) (todo.List, error) {
+	var list todo.List
+	var ownerId sql.NullInt64
+	err := row.Scan(&list.Id, &list.Name, &ownerId)
+	list.OwnerId = todo.UserId(ownerId.Int64)
+	return list, err
+}
+
+func (s *Sqlite3TaskStorage) DeleteList(id todo.ListId, at time.Time) error {
+	tx, err := s.conn.Begin()
 	if err != nil {
 		return err
 	}
+	defer tx.Rollback()
 
-	return nil
+	// Only owners can delete a list, which rules out lists without an owner
+	// like the default.
+	role, err := s.role(tx, id)
+	if err != nil {
+		return err
+	}
+	if role != todo.RoleOwner {
+		return fmt.Errorf("list %d: %w", id, todo.ErrNotFound)
+	}
+
+	// Subtasks in other lists outlive their parent as top level tasks.
+	_, err = tx.Exec(`UPDATE tasks SET parent_id = NULL WHERE list_id != ? AND
+parent_id IN (SELECT id FROM tasks WHERE list_id=?)`, id, id)
+	if err != nil {
+		return err
+	}
+	// The tasks go to the owner's trash in the default list, keeping when
+	// those already there were trashed.
+	_, err = tx.Exec(`UPDATE tasks SET list_id = ?, trashed_at = coalesce(trashed_at, ?),
+owner_id = (SELECT owner_id FROM lists WHERE id=?) WHERE list_id=?`,
+		todo.DefaultListId, at.UTC(), id, id)
+	if err != nil {
+		return err
+	}
+	_, err = tx.Exec("DELETE FROM list_members WHERE list_id=?", id)
+	if err != nil {
+		return err
+	}
+	result, err := tx.Exec("DELETE FROM lists WHERE id=?", id)
+	if err != nil {
+		return err
+	}
+	rows, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if rows == 0 {
+		return fmt.Errorf("list %d: %w", id, todo.ErrNotFound)
+	}
+	return tx.Commit()
 }
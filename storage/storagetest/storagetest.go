@@ -0,0 +1,865 @@
+// Package storagetest checks that a todo.TaskStorage behaves like the others,
+// so that the web server and CLI work the same whichever one they use.
+package storagetest
+
+import (
+	"errors"
+	"fmt"
+	"reflect"
+	"sync"
+	"testing"
+	"time"
+
+	todo "github.com/rosswf/go-todo"
+)
+
+// Open returns empty storage for a test, closing it when the test finishes.
+// Storage that also implements todo.UserStorage is checked as that too.
+type Open func(t *testing.T) todo.TaskStorage
+
+// Run checks storage from open against the behaviour the rest of the
+// application relies on, in a subtest for each area.
+func Run(t *testing.T, open Open) {
+	tests := []struct {
+		name string
+		test func(t *testing.T, open Open)
+	}{
+		{"Tasks", testTasks},
+		{"Completion", testCompletion},
+		{"Due", testDue},
+		{"Query", testQuery},
+		{"Trash", testTrash},
+		{"Move", testMove},
+		{"Subtasks", testSubtasks},
+		{"Tags", testTags},
+		{"Lists", testLists},
+		{"Events", testEvents},
+		{"Search", testSearch},
+		{"Users", testUsers},
+		{"Sharing", testSharing},
+		{"Concurrency", testConcurrency},
+	}
+	for _, test := range tests {
+		test := test
+		t.Run(test.name, func(t *testing.T) {
+			test.test(t, open)
+		})
+	}
+}
+
+// base is a fixed time for tests, in whole seconds since not every database
+// stores nanoseconds.
+var base = time.Date(2030, time.March, 4, 9, 30, 0, 0, time.UTC)
+
+func at(hours int) *time.Time {
+	t := base.Add(time.Duration(hours) * time.Hour)
+	return &t
+}
+
+func add(t *testing.T, storage todo.TaskStorage, task todo.Task) todo.TaskId {
+	t.Helper()
+	id, err := storage.Add(&task)
+	assertNoError(t, err)
+	return id
+}
+
+func get(t *testing.T, storage todo.TaskStorage, id todo.TaskId) todo.Task {
+	t.Helper()
+	task, err := storage.GetTask(id)
+	assertNoError(t, err)
+	return *task
+}
+
+// normalize makes tasks from different storage comparable, by putting times
+// in UTC and leaving out the position, which is only compared by order.
+func normalize(task todo.Task) todo.Task {
+	for _, field := range []**time.Time{&task.Due, &task.RemindAt, &task.CompletedAt,
+		&task.CreatedAt, &task.TrashedAt} {
+		if *field != nil {
+			utc := (*field).UTC()
+			*field = &utc
+		}
+	}
+	task.Position = ""
+	return task
+}
+
+func assertTask(t *testing.T, got, want todo.Task) {
+	t.Helper()
+	if !reflect.DeepEqual(normalize(got), normalize(want)) {
+		t.Errorf("got task %+v, want %+v", normalize(got), normalize(want))
+	}
+}
+
+// assertIds checks the ids of tasks, in order.
+func assertIds(t *testing.T, tasks []todo.Task, want ...todo.TaskId) {
+	t.Helper()
+	got := []todo.TaskId{}
+	for _, task := range tasks {
+		got = append(got, task.Id)
+	}
+	if want == nil {
+		want = []todo.TaskId{}
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("got tasks %v, want %v", got, want)
+	}
+}
+
+func assertNoError(t *testing.T, err error) {
+	t.Helper()
+	if err != nil {
+		t.Fatalf("got an error but didn't want one, %v", err)
+	}
+}
+
+func assertNotFound(t *testing.T, err error) {
+	t.Helper()
+	if !errors.Is(err, todo.ErrNotFound) {
+		t.Errorf("got error %v, want todo.ErrNotFound", err)
+	}
+}
+
+func testTasks(t *testing.T, open Open) {
+	storage := open(t)
+	want := todo.Task{
+		Name:       "Write report",
+		ListId:     todo.DefaultListId,
+		Due:        at(24),
+		RemindAt:   at(20),
+		CreatedAt:  at(0),
+		Tags:       []string{"work", "admin"},
+		Priority:   todo.P1,
+		Recurrence: "FREQ=WEEKLY",
+	}
+	id := add(t, storage, want)
+	if id <= 0 {
+		t.Fatalf("got id %d, want a positive id", id)
+	}
+
+	got := get(t, storage, id)
+	want.Id = id
+	// Tags come back sorted.
+	want.Tags = []string{"admin", "work"}
+	assertTask(t, got, want)
+	if got.Position == "" {
+		t.Error("the task wasn't given a position")
+	}
+
+	// The default list is used when none is given.
+	second := add(t, storage, todo.Task{Name: "Second", ParentId: id})
+	if second <= id {
+		t.Errorf("got id %d after %d, want ids to increase", second, id)
+	}
+	assertTask(t, get(t, storage, second), todo.Task{Id: second, Name: "Second",
+		ListId: todo.DefaultListId, ParentId: id})
+	all, err := storage.GetAll()
+	assertNoError(t, err)
+	assertIds(t, all, id, second)
+
+	updated := got
+	updated.Name = "Write the report"
+	updated.Due = nil
+	updated.Tags = []string{"work"}
+	updated.Priority = ""
+	updated.Recurrence = ""
+	assertNoError(t, storage.Update(&updated))
+	assertTask(t, get(t, storage, id), updated)
+
+	// Tasks that are returned are copies.
+	got = get(t, storage, id)
+	got.Name = "Changed"
+	got.Tags[0] = "changed"
+	all, err = storage.GetAll()
+	assertNoError(t, err)
+	all[0].Tags[0] = "changed"
+	assertTask(t, get(t, storage, id), updated)
+
+	_, err = storage.GetTask(999)
+	assertNotFound(t, err)
+	assertNotFound(t, storage.Update(&todo.Task{Id: 999, Name: "Missing"}))
+}
+
+func testCompletion(t *testing.T, open Open) {
+	storage := open(t)
+	id := add(t, storage, todo.Task{Name: "Task"})
+
+	assertNoError(t, storage.Complete(id, *at(1)))
+	assertTask(t, get(t, storage, id), todo.Task{Id: id, Name: "Task",
+		ListId: todo.DefaultListId, Complete: true, CompletedAt: at(1)})
+	// Completing a complete task keeps when it was completed.
+	assertNoError(t, storage.Complete(id, *at(2)))
+	if got := get(t, storage, id); !got.CompletedAt.Equal(*at(1)) {
+		t.Errorf("got completed at %v, want %v", got.CompletedAt, at(1))
+	}
+
+	assertNoError(t, storage.Reopen(id))
+	assertTask(t, get(t, storage, id), todo.Task{Id: id, Name: "Task", ListId: todo.DefaultListId})
+	outstanding, err := storage.GetOutstanding()
+	assertNoError(t, err)
+	assertIds(t, outstanding, id)
+
+	assertNotFound(t, storage.Complete(999, *at(1)))
+	assertNotFound(t, storage.Reopen(999))
+}
+
+func testDue(t *testing.T, open Open) {
+	storage := open(t)
+	later := add(t, storage, todo.Task{Name: "Later", Due: at(48)})
+	overdue := add(t, storage, todo.Task{Name: "Overdue", Due: at(-1)})
+	done := add(t, storage, todo.Task{Name: "Done", Due: at(-2)})
+	assertNoError(t, storage.Complete(done, *at(-3)))
+	add(t, storage, todo.Task{Name: "Whenever"})
+
+	tasks, err := storage.GetOverdue(base)
+	assertNoError(t, err)
+	assertIds(t, tasks, overdue)
+
+	tasks, err = storage.GetDue(time.Time{}, time.Time{})
+	assertNoError(t, err)
+	assertIds(t, tasks, done, overdue, later)
+	tasks, err = storage.GetDue(*at(-2), time.Time{})
+	assertNoError(t, err)
+	assertIds(t, tasks, overdue, later)
+	tasks, err = storage.GetDue(time.Time{}, *at(48))
+	assertNoError(t, err)
+	assertIds(t, tasks, done, overdue)
+}
+
+func testQuery(t *testing.T, open Open) {
+	storage := open(t)
+	listId, err := storage.AddList(&todo.List{Name: "Other"})
+	assertNoError(t, err)
+	banana := add(t, storage, todo.Task{Name: "banana", Due: at(2), Priority: todo.P1,
+		CreatedAt: at(0), Tags: []string{"fruit"}})
+	apple := add(t, storage, todo.Task{Name: "Apple 100%", Due: at(1), Priority: todo.P1,
+		CreatedAt: at(1), Tags: []string{"fruit", "red"}})
+	cherry := add(t, storage, todo.Task{Name: "cherry", ListId: listId, Priority: todo.P0})
+	date := add(t, storage, todo.Task{Name: "Date", Due: at(3), CreatedAt: at(2)})
+	assertNoError(t, storage.Complete(date, *at(0)))
+
+	complete, incomplete := true, false
+	queries := []struct {
+		name  string
+		query todo.TaskQuery
+		want  []todo.TaskId
+	}{
+		{"everything by id", todo.TaskQuery{}, []todo.TaskId{banana, apple, cherry, date}},
+		{"list", todo.TaskQuery{ListId: listId}, []todo.TaskId{cherry}},
+		{"complete", todo.TaskQuery{Complete: &complete}, []todo.TaskId{date}},
+		{"incomplete", todo.TaskQuery{Complete: &incomplete}, []todo.TaskId{banana, apple, cherry}},
+		{"name ignoring case", todo.TaskQuery{NameContains: "APP"}, []todo.TaskId{apple}},
+		{"name with wildcards", todo.TaskQuery{NameContains: "0%"}, []todo.TaskId{apple}},
+		{"name with no wildcards", todo.TaskQuery{NameContains: "_"}, nil},
+		{"tag", todo.TaskQuery{Tag: "red"}, []todo.TaskId{apple}},
+		{"sorted by name", todo.TaskQuery{Sort: todo.SortByName}, []todo.TaskId{apple, banana, cherry, date}},
+		{"sorted by name descending", todo.TaskQuery{Sort: todo.SortByName, Descending: true},
+			[]todo.TaskId{date, cherry, banana, apple}},
+		{"sorted by due", todo.TaskQuery{Sort: todo.SortByDue}, []todo.TaskId{apple, banana, date, cherry}},
+		{"sorted by due descending", todo.TaskQuery{Sort: todo.SortByDue, Descending: true},
+			[]todo.TaskId{date, banana, apple, cherry}},
+		{"sorted by created", todo.TaskQuery{Sort: todo.SortByCreated}, []todo.TaskId{cherry, banana, apple, date}},
+		{"sorted by created descending", todo.TaskQuery{Sort: todo.SortByCreated, Descending: true},
+			[]todo.TaskId{date, apple, banana, cherry}},
+		{"sorted by priority", todo.TaskQuery{Sort: todo.SortByPriority}, []todo.TaskId{cherry, apple, banana, date}},
+		// Each list's positions start from the same key, ties are in id order.
+		{"sorted by position", todo.TaskQuery{Sort: todo.SortByPosition}, []todo.TaskId{banana, cherry, apple, date}},
+		{"limited", todo.TaskQuery{Limit: 2}, []todo.TaskId{banana, apple}},
+		{"offset", todo.TaskQuery{Offset: 1}, []todo.TaskId{apple, cherry, date}},
+		{"limited and offset", todo.TaskQuery{Limit: 2, Offset: 1}, []todo.TaskId{apple, cherry}},
+	}
+	for _, q := range queries {
+		t.Run(q.name, func(t *testing.T) {
+			tasks, err := storage.Query(q.query)
+			assertNoError(t, err)
+			assertIds(t, tasks, q.want...)
+		})
+	}
+}
+
+func testTrash(t *testing.T, open Open) {
+	storage := open(t)
+	parent := add(t, storage, todo.Task{Name: "Parent"})
+	child := add(t, storage, todo.Task{Name: "Child", ParentId: parent})
+	other := add(t, storage, todo.Task{Name: "Other"})
+
+	assertNoError(t, storage.Trash(child, *at(0)))
+	assertNoError(t, storage.Trash(parent, *at(1)))
+	assertNoError(t, storage.Trash(other, *at(2)))
+	_, err := storage.GetTask(parent)
+	assertNotFound(t, err)
+	assertNotFound(t, storage.Trash(parent, *at(3)))
+	all, err := storage.GetAll()
+	assertNoError(t, err)
+	assertIds(t, all)
+
+	trash, err := storage.GetTrash()
+	assertNoError(t, err)
+	assertIds(t, trash, other, parent, child)
+	if trash[0].TrashedAt == nil || !trash[0].TrashedAt.Equal(*at(2)) {
+		t.Errorf("got trashed at %v, want %v", trash[0].TrashedAt, at(2))
+	}
+
+	// The child was trashed before its parent, so stays in the trash.
+	assertNoError(t, storage.Restore(parent))
+	all, err = storage.GetAll()
+	assertNoError(t, err)
+	assertIds(t, all, parent)
+	assertNotFound(t, storage.Restore(parent))
+
+	purged, err := storage.Purge(*at(0))
+	assertNoError(t, err)
+	if purged != 1 {
+		t.Errorf("got %d tasks purged, want 1", purged)
+	}
+	trash, err = storage.GetTrash()
+	assertNoError(t, err)
+	assertIds(t, trash, other)
+	assertNotFound(t, storage.Restore(child))
+
+	// Purging tasks doesn't lead to new ones sharing the ids of those left.
+	if id := add(t, storage, todo.Task{Name: "New"}); id <= other {
+		t.Errorf("got id %d after purging, want one after %d", id, other)
+	}
+}
+
+func testMove(t *testing.T, open Open) {
+	storage := open(t)
+	first := add(t, storage, todo.Task{Name: "First"})
+	second := add(t, storage, todo.Task{Name: "Second"})
+	third := add(t, storage, todo.Task{Name: "Third"})
+
+	moves := []struct {
+		id, before, after todo.TaskId
+		want              []todo.TaskId
+	}{
+		{third, first, 0, []todo.TaskId{third, first, second}},
+		{third, 0, second, []todo.TaskId{first, second, third}},
+		{first, third, second, []todo.TaskId{second, first, third}},
+		{third, 0, first, []todo.TaskId{second, first, third}},
+	}
+	for _, move := range moves {
+		assertNoError(t, storage.Move(move.id, move.before, move.after))
+		all, err := storage.GetAll()
+		assertNoError(t, err)
+		assertIds(t, all, move.want...)
+	}
+
+	err := storage.Move(first, second, third)
+	if !errors.Is(err, todo.ErrMoveOutOfOrder) {
+		t.Errorf("got error %v moving between tasks out of order, want todo.ErrMoveOutOfOrder", err)
+	}
+	assertNotFound(t, storage.Move(999, first, 0))
+	assertNotFound(t, storage.Move(first, 999, 0))
+}
+
+func testSubtasks(t *testing.T, open Open) {
+	storage := open(t)
+	parent := add(t, storage, todo.Task{Name: "Parent"})
+	child := add(t, storage, todo.Task{Name: "Child", ParentId: parent})
+	grandchild := add(t, storage, todo.Task{Name: "Grandchild", ParentId: child})
+	trashed := add(t, storage, todo.Task{Name: "Trashed", ParentId: parent})
+	add(t, storage, todo.Task{Name: "Unrelated"})
+	assertNoError(t, storage.Trash(trashed, *at(0)))
+
+	subtasks, err := storage.GetSubtasks(parent)
+	assertNoError(t, err)
+	assertIds(t, subtasks, child, grandchild)
+	subtasks, err = storage.GetSubtasks(grandchild)
+	assertNoError(t, err)
+	assertIds(t, subtasks)
+}
+
+func testTags(t *testing.T, open Open) {
+	storage := open(t)
+	add(t, storage, todo.Task{Name: "One", Tags: []string{"work", "home"}})
+	add(t, storage, todo.Task{Name: "Two", Tags: []string{"work"}})
+	trashed := add(t, storage, todo.Task{Name: "Three", Tags: []string{"garden", "work"}})
+	assertNoError(t, storage.Trash(trashed, *at(0)))
+
+	tags, err := storage.GetTags()
+	assertNoError(t, err)
+	want := []todo.Tag{{Name: "home", Count: 1}, {Name: "work", Count: 2}}
+	if !reflect.DeepEqual(tags, want) {
+		t.Errorf("got tags %v, want %v", tags, want)
+	}
+}
+
+func testLists(t *testing.T, open Open) {
+	storage := open(t)
+	lists, err := storage.GetLists()
+	assertNoError(t, err)
+	if len(lists) != 1 || lists[0].Id != todo.DefaultListId {
+		t.Fatalf("got lists %v, want only the default list", lists)
+	}
+
+	id, err := storage.AddList(&todo.List{Name: "Shopping"})
+	assertNoError(t, err)
+	list, err := storage.GetList(id)
+	assertNoError(t, err)
+	if list.Id != id || list.Name != "Shopping" {
+		t.Errorf("got list %+v, want Shopping with id %d", list, id)
+	}
+	_, err = storage.GetList(999)
+	assertNotFound(t, err)
+
+	kept, err := storage.AddList(&todo.List{Name: "Kept"})
+	assertNoError(t, err)
+
+	inList := add(t, storage, todo.Task{Name: "Milk", ListId: id})
+	parent := add(t, storage, todo.Task{Name: "Parent", ListId: id})
+	child := add(t, storage, todo.Task{Name: "Child", ParentId: parent})
+	alreadyTrashed := add(t, storage, todo.Task{Name: "Already trashed", ListId: id})
+	assertNoError(t, storage.Trash(alreadyTrashed, *at(1)))
+	tasks, err := storage.GetListTasks(id)
+	assertNoError(t, err)
+	assertIds(t, tasks, inList, parent)
+
+	assertNoError(t, storage.DeleteList(id, *at(2)))
+	_, err = storage.GetList(id)
+	assertNotFound(t, err)
+	all, err := storage.GetAll()
+	assertNoError(t, err)
+	assertIds(t, all, child)
+	// Subtasks in other lists become top level tasks.
+	if got := get(t, storage, child); got.ParentId != 0 {
+		t.Errorf("got parent %d for a subtask of a deleted list's task, want none", got.ParentId)
+	}
+	// The list's tasks are trashed in the default list, keeping when those
+	// already in the trash were trashed, and can be restored there.
+	trash, err := storage.GetTrash()
+	assertNoError(t, err)
+	assertIds(t, trash, inList, parent, alreadyTrashed)
+	for _, task := range trash {
+		want := at(2)
+		if task.Id == alreadyTrashed {
+			want = at(1)
+		}
+		if task.ListId != todo.DefaultListId || task.TrashedAt == nil || !task.TrashedAt.Equal(*want) {
+			t.Errorf("got %+v in the trash, want it in the default list trashed at %v", task, want)
+		}
+	}
+	assertNoError(t, storage.Restore(inList))
+	assertTask(t, get(t, storage, inList), todo.Task{Id: inList, Name: "Milk", ListId: todo.DefaultListId})
+	assertNotFound(t, storage.DeleteList(id, *at(3)))
+
+	// Deleting a list doesn't lead to new ones sharing the ids of those left.
+	newId, err := storage.AddList(&todo.List{Name: "New"})
+	assertNoError(t, err)
+	if newId <= kept {
+		t.Errorf("got list id %d after deleting a list, want one after %d", newId, kept)
+	}
+}
+
+func testEvents(t *testing.T, open Open) {
+	storage := open(t)
+	task := add(t, storage, todo.Task{Name: "Task"})
+	other := add(t, storage, todo.Task{Name: "Other"})
+
+	events := []todo.Event{
+		{TaskId: task, Type: todo.EventCreated, Name: "Task", At: *at(0)},
+		{TaskId: other, Type: todo.EventCreated, Name: "Other", At: *at(1)},
+		{TaskId: task, Type: todo.EventRenamed, Name: "Renamed", Previous: "Task", At: *at(2)},
+	}
+	ids := []todo.EventId{}
+	for i := range events {
+		id, err := storage.AddEvent(&events[i])
+		assertNoError(t, err)
+		if len(ids) > 0 && id <= ids[len(ids)-1] {
+			t.Errorf("got event id %d after %d, want ids to increase", id, ids[len(ids)-1])
+		}
+		events[i].Id = id
+		ids = append(ids, id)
+	}
+
+	queries := []struct {
+		name  string
+		query todo.EventQuery
+		want  []todo.Event
+	}{
+		{"everything", todo.EventQuery{}, []todo.Event{events[2], events[1], events[0]}},
+		{"ascending", todo.EventQuery{Ascending: true}, events},
+		{"task", todo.EventQuery{TaskId: task}, []todo.Event{events[2], events[0]}},
+		{"after", todo.EventQuery{After: ids[0], Ascending: true}, events[1:]},
+		{"limited", todo.EventQuery{Limit: 1}, []todo.Event{events[2]}},
+		{"offset", todo.EventQuery{Limit: 1, Offset: 1}, []todo.Event{events[1]}},
+	}
+	for _, q := range queries {
+		t.Run(q.name, func(t *testing.T) {
+			got, err := storage.GetEvents(q.query)
+			assertNoError(t, err)
+			for i := range got {
+				got[i].At = got[i].At.UTC()
+			}
+			if !reflect.DeepEqual(got, q.want) {
+				t.Errorf("got events %+v, want %+v", got, q.want)
+			}
+		})
+	}
+}
+
+func testSearch(t *testing.T, open Open) {
+	storage := open(t)
+	report := add(t, storage, todo.Task{Name: "Write the quarterly report"})
+	add(t, storage, todo.Task{Name: "Buy a new printer"})
+	trashed := add(t, storage, todo.Task{Name: "Report the broken printer"})
+	assertNoError(t, storage.Trash(trashed, *at(0)))
+
+	results, err := storage.Search("REP quart")
+	assertNoError(t, err)
+	if len(results) != 1 || results[0].Id != report {
+		t.Fatalf("got results %+v, want the report", results)
+	}
+	want := "Write the " + todo.HighlightStart + "quarterly" + todo.HighlightEnd + " " +
+		todo.HighlightStart + "report" + todo.HighlightEnd
+	if results[0].Snippet != want {
+		t.Errorf("got snippet %q, want %q", results[0].Snippet, want)
+	}
+
+	// Snippets are HTML, so the rest of the name is escaped.
+	tag := add(t, storage, todo.Task{Name: "Fix <b> tags & <mark>markers</mark>"})
+	results, err = storage.Search("fix")
+	assertNoError(t, err)
+	if len(results) != 1 || results[0].Id != tag {
+		t.Fatalf("got results %+v, want the task with markup", results)
+	}
+	want = todo.HighlightStart + "Fix" + todo.HighlightEnd +
+		" &lt;b&gt; tags &amp; &lt;mark&gt;markers&lt;/mark&gt;"
+	if results[0].Snippet != want {
+		t.Errorf("got snippet %q, want %q", results[0].Snippet, want)
+	}
+
+	for _, query := range []string{"port", "report printer", ""} {
+		results, err = storage.Search(query)
+		assertNoError(t, err)
+		if len(results) != 0 {
+			t.Errorf("got results %+v searching for %q, want none", results, query)
+		}
+	}
+}
+
+// userStorage is storage for both tasks and accounts.
+type userStorage interface {
+	todo.TaskStorage
+	todo.UserStorage
+}
+
+func openUsers(t *testing.T, open Open) userStorage {
+	t.Helper()
+	storage, ok := open(t).(userStorage)
+	if !ok {
+		t.Skip("the storage doesn't store users")
+	}
+	return storage
+}
+
+func addUser(t *testing.T, storage todo.UserStorage, username string) todo.User {
+	t.Helper()
+	user := todo.User{Username: username, PasswordHash: []byte("hash"), CreatedAt: base}
+	_, err := storage.AddUser(&user)
+	assertNoError(t, err)
+	return user
+}
+
+func testUsers(t *testing.T, open Open) {
+	storage := openUsers(t, open)
+	unowned := add(t, storage, todo.Task{Name: "Before accounts"})
+	listId, err := storage.AddList(&todo.List{Name: "Before accounts"})
+	assertNoError(t, err)
+
+	alice := addUser(t, storage, "Alice")
+	bob := addUser(t, storage, "bob")
+	if alice.Id <= 0 || bob.Id <= alice.Id {
+		t.Errorf("got user ids %d and %d, want them to increase", alice.Id, bob.Id)
+	}
+	_, err = storage.AddUser(&todo.User{Username: "ALICE", PasswordHash: []byte("hash"), CreatedAt: base})
+	if !errors.Is(err, todo.ErrUsernameTaken) {
+		t.Errorf("got error %v adding a username in another case, want todo.ErrUsernameTaken", err)
+	}
+
+	got, err := storage.GetUserByName("alice")
+	assertNoError(t, err)
+	if got.Id != alice.Id || got.Username != "Alice" || string(got.PasswordHash) != "hash" {
+		t.Errorf("got user %+v, want %+v", got, alice)
+	}
+	_, err = storage.GetUser(alice.Id)
+	assertNoError(t, err)
+	_, err = storage.GetUser(999)
+	assertNotFound(t, err)
+	_, err = storage.GetUserByName("carol")
+	assertNotFound(t, err)
+
+	// The first user adopts everything created before there were users,
+	// apart from the default list.
+	alices, bobs := storage.ForUser(alice.Id), storage.ForUser(bob.Id)
+	assertTask(t, get(t, alices, unowned), todo.Task{Id: unowned, Name: "Before accounts",
+		ListId: todo.DefaultListId, OwnerId: alice.Id})
+	list, err := alices.GetList(listId)
+	assertNoError(t, err)
+	if list.OwnerId != alice.Id {
+		t.Errorf("got list owner %d, want %d", list.OwnerId, alice.Id)
+	}
+
+	bobsTask := add(t, bobs, todo.Task{Name: "Bob's"})
+	all, err := bobs.GetAll()
+	assertNoError(t, err)
+	assertIds(t, all, bobsTask)
+	_, err = bobs.GetTask(unowned)
+	assertNotFound(t, err)
+	_, err = bobs.GetList(listId)
+	assertNotFound(t, err)
+	assertNotFound(t, bobs.Complete(unowned, base))
+	all, err = storage.GetAll()
+	assertNoError(t, err)
+	assertIds(t, all, unowned, bobsTask)
+
+	session := todo.Session{TokenHash: "session", UserId: bob.Id, ExpiresAt: *at(1)}
+	assertNoError(t, storage.AddSession(&session))
+	gotSession, err := storage.GetSession("session")
+	assertNoError(t, err)
+	if gotSession.UserId != bob.Id || !gotSession.ExpiresAt.Equal(*at(1)) {
+		t.Errorf("got session %+v, want %+v", gotSession, session)
+	}
+	assertNoError(t, storage.DeleteSession("session"))
+	_, err = storage.GetSession("session")
+	assertNotFound(t, err)
+	assertNotFound(t, storage.DeleteSession("session"))
+
+	token := todo.APIToken{UserId: bob.Id, Name: "ci", Scopes: []todo.Scope{todo.ScopeRead},
+		TokenHash: "token", CreatedAt: base}
+	tokenId, err := storage.AddAPIToken(&token)
+	assertNoError(t, err)
+	gotToken, err := storage.GetAPIToken("token")
+	assertNoError(t, err)
+	if gotToken.Id != tokenId || gotToken.Name != "ci" ||
+		!reflect.DeepEqual(gotToken.Scopes, token.Scopes) {
+		t.Errorf("got API token %+v, want %+v", gotToken, token)
+	}
+	tokens, err := storage.GetAPITokens(bob.Id)
+	assertNoError(t, err)
+	if len(tokens) != 1 {
+		t.Errorf("got %d API tokens, want 1", len(tokens))
+	}
+	assertNotFound(t, storage.DeleteAPIToken(alice.Id, tokenId))
+	assertNoError(t, storage.DeleteAPIToken(bob.Id, tokenId))
+	_, err = storage.GetAPIToken("token")
+	assertNotFound(t, err)
+}
+
+func testSharing(t *testing.T, open Open) {
+	storage := openUsers(t, open)
+	owner := addUser(t, storage, "owner")
+	editor := addUser(t, storage, "editor")
+	viewer := addUser(t, storage, "Viewer")
+	owners, editors, viewers := storage.ForUser(owner.Id), storage.ForUser(editor.Id),
+		storage.ForUser(viewer.Id)
+
+	listId, err := owners.AddList(&todo.List{Name: "Team"})
+	assertNoError(t, err)
+	shared := add(t, owners, todo.Task{Name: "Shared", ListId: listId})
+	trashed := add(t, owners, todo.Task{Name: "Trashed", ListId: listId})
+	assertNoError(t, owners.Trash(trashed, base))
+	_, err = editors.GetList(listId)
+	assertNotFound(t, err)
+	_, err = editors.GetRole(listId)
+	assertNotFound(t, err)
+
+	assertNoError(t, owners.SetMember(listId, editor.Id, todo.RoleViewer))
+	assertNoError(t, owners.SetMember(listId, editor.Id, todo.RoleEditor))
+	assertNoError(t, owners.SetMember(listId, viewer.Id, todo.RoleViewer))
+	roles := []struct {
+		storage todo.TaskStorage
+		list    todo.ListId
+		want    todo.Role
+	}{
+		{storage, listId, todo.RoleOwner},
+		{owners, listId, todo.RoleOwner},
+		{editors, listId, todo.RoleEditor},
+		{viewers, listId, todo.RoleViewer},
+		{viewers, todo.DefaultListId, todo.RoleEditor},
+	}
+	for _, role := range roles {
+		got, err := role.storage.GetRole(role.list)
+		assertNoError(t, err)
+		if got != role.want {
+			t.Errorf("got role %q on list %d, want %q", got, role.list, role.want)
+		}
+	}
+
+	members, err := viewers.GetMembers(listId)
+	assertNoError(t, err)
+	want := []todo.Member{
+		{UserId: owner.Id, Username: "owner", Role: todo.RoleOwner},
+		{UserId: editor.Id, Username: "editor", Role: todo.RoleEditor},
+		{UserId: viewer.Id, Username: "Viewer", Role: todo.RoleViewer},
+	}
+	if !reflect.DeepEqual(members, want) {
+		t.Errorf("got members %+v, want %+v", members, want)
+	}
+
+	// Members see every task in the list, but only editors can purge them.
+	tasks, err := viewers.GetListTasks(listId)
+	assertNoError(t, err)
+	assertIds(t, tasks, shared)
+	added := add(t, editors, todo.Task{Name: "Added by the editor", ListId: listId})
+	tasks, err = owners.GetListTasks(listId)
+	assertNoError(t, err)
+	assertIds(t, tasks, shared, added)
+	purged, err := viewers.Purge(*at(1))
+	assertNoError(t, err)
+	if purged != 0 {
+		t.Errorf("got %d tasks purged by a viewer, want 0", purged)
+	}
+	purged, err = editors.Purge(*at(1))
+	assertNoError(t, err)
+	if purged != 1 {
+		t.Errorf("got %d tasks purged by an editor, want 1", purged)
+	}
+
+	// Events are seen by everyone who can see their task, along with who
+	// made the change.
+	_, err = owners.AddEvent(&todo.Event{TaskId: shared, Type: todo.EventCreated, Name: "Shared", At: base,
+		ActorId: editor.Id})
+	assertNoError(t, err)
+	events, err := viewers.GetEvents(todo.EventQuery{})
+	assertNoError(t, err)
+	if len(events) != 1 || events[0].TaskId != shared {
+		t.Errorf("got events %+v, want the shared task's", events)
+	} else if events[0].ActorId != editor.Id || events[0].Actor != "editor" {
+		t.Errorf("got actor %d %q, want %d %q", events[0].ActorId, events[0].Actor, editor.Id, "editor")
+	}
+
+	assertNoError(t, owners.RemoveMember(listId, viewer.Id))
+	assertNotFound(t, owners.RemoveMember(listId, viewer.Id))
+	_, err = viewers.GetTask(shared)
+	assertNotFound(t, err)
+	events, err = viewers.GetEvents(todo.EventQuery{})
+	assertNoError(t, err)
+	if len(events) != 0 {
+		t.Errorf("got events %+v after leaving the list, want none", events)
+	}
+
+	assertNotFound(t, editors.DeleteList(listId, *at(2)))
+	assertNoError(t, owners.DeleteList(listId, *at(2)))
+	_, err = editors.GetRole(listId)
+	assertNotFound(t, err)
+
+	// The tasks of a deleted list, including those added by members, go to
+	// the owner's trash.
+	trash, err := owners.GetTrash()
+	assertNoError(t, err)
+	assertIds(t, trash, shared, added)
+	trash, err = editors.GetTrash()
+	assertNoError(t, err)
+	assertIds(t, trash)
+	assertNotFound(t, editors.Restore(added))
+	assertNoError(t, owners.Restore(added))
+	tasks, err = owners.GetListTasks(todo.DefaultListId)
+	assertNoError(t, err)
+	assertIds(t, tasks, added)
+
+	// Storage that isn't for a user, like the CLI's, adds tasks and lists for
+	// the first user, who owns everything from before there were users.
+	unscoped := add(t, storage, todo.Task{Name: "From the CLI"})
+	unscopedList, err := storage.AddList(&todo.List{Name: "CLI"})
+	assertNoError(t, err)
+	tasks, err = owners.GetListTasks(todo.DefaultListId)
+	assertNoError(t, err)
+	assertIds(t, tasks, added, unscoped)
+	role, err := owners.GetRole(unscopedList)
+	assertNoError(t, err)
+	if role != todo.RoleOwner {
+		t.Errorf("got role %q on a list added without a user, want %q", role, todo.RoleOwner)
+	}
+	_, err = editors.GetTask(unscoped)
+	assertNotFound(t, err)
+	_, err = editors.GetRole(unscopedList)
+	assertNotFound(t, err)
+}
+
+// testConcurrency checks that storage can be used from many goroutines at
+// once, as the web server does.
+func testConcurrency(t *testing.T, open Open) {
+	storage := open(t)
+	last := add(t, storage, todo.Task{Name: "Last"})
+
+	const workers, tasksEach = 8, 10
+	errs := make(chan error, workers)
+	var wg sync.WaitGroup
+	for w := 0; w < workers; w++ {
+		wg.Add(1)
+		go func(w int) {
+			defer wg.Done()
+			errs <- work(storage, w, tasksEach, last)
+		}(w)
+	}
+	wg.Wait()
+	close(errs)
+	for err := range errs {
+		assertNoError(t, err)
+	}
+
+	all, err := storage.GetAll()
+	assertNoError(t, err)
+	if len(all) != workers*tasksEach+1 {
+		t.Fatalf("got %d tasks, want %d", len(all), workers*tasksEach+1)
+	}
+	ids, positions := map[todo.TaskId]bool{}, map[string]bool{}
+	for _, task := range all {
+		if ids[task.Id] || positions[task.Position] {
+			t.Errorf("got task %d at %q more than once, want every id and position to be unique",
+				task.Id, task.Position)
+		}
+		ids[task.Id], positions[task.Position] = true, true
+		if task.Id != last && !task.Complete {
+			t.Errorf("got task %d incomplete, want it complete", task.Id)
+		}
+	}
+	if all[len(all)-1].Id != last {
+		t.Errorf("got task %d last, want %d", all[len(all)-1].Id, last)
+	}
+
+	events, err := storage.GetEvents(todo.EventQuery{})
+	assertNoError(t, err)
+	eventIds := map[todo.EventId]bool{}
+	for _, event := range events {
+		eventIds[event.Id] = true
+	}
+	if len(events) != workers*tasksEach || len(eventIds) != len(events) {
+		t.Errorf("got %d events with %d ids, want %d with unique ids", len(events), len(eventIds),
+			workers*tasksEach)
+	}
+}
+
+// work adds tasks, moving each before last and completing it, reading the
+// tasks in between.
+func work(storage todo.TaskStorage, worker, tasks int, last todo.TaskId) error {
+	for i := 0; i < tasks; i++ {
+		name := fmt.Sprintf("Task %d from %d", i, worker)
+		id, err := storage.Add(&todo.Task{Name: name, Tags: []string{"concurrent"}})
+		if err != nil {
+			return err
+		}
+		_, err = storage.AddEvent(&todo.Event{TaskId: id, Type: todo.EventCreated, Name: name, At: base})
+		if err != nil {
+			return err
+		}
+		err = storage.Move(id, last, 0)
+		if err != nil {
+			return err
+		}
+		err = storage.Complete(id, base)
+		if err != nil {
+			return err
+		}
+		_, err = storage.Query(todo.TaskQuery{Tag: "concurrent", Sort: todo.SortByPosition})
+		if err != nil {
+			return err
+		}
+		_, err = storage.GetTask(id)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
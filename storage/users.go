@@ -0,0 +1,202 @@
+package todo_storage
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+	"strings"
+
+	todo "github.com/rosswf/go-todo"
+)
+
+// AddUser adds a user. The first user takes ownership of everything that was
+// created before there were users, apart from the default list which stays
+// shared.
+func (s *Sqlite3TaskStorage) AddUser(user *todo.User) (todo.UserId, error) {
+	tx, err := s.conn.Begin()
+	if err != nil {
+		return -1, err
+	}
+	defer tx.Rollback()
+
+	var existing int
+	err = tx.QueryRow("SELECT COUNT(*) FROM users").Scan(&existing)
+	if err != nil {
+		return -1, err
+	}
+	var taken bool
+	err = tx.QueryRow("SELECT EXISTS (SELECT 1 FROM users WHERE username = ?)",
+		user.Username).Scan(&taken)
+	if err != nil {
+		return -1, err
+	}
+	if taken {
+		return -1, todo.ErrUsernameTaken
+	}
+
+	result, err := tx.Exec("INSERT INTO users(username, password_hash, created_at) values(?, ?, ?)",
+		user.Username, user.PasswordHash, user.CreatedAt.UTC())
+	if err != nil {
+		return -1, err
+	}
+	id, err := result.LastInsertId()
+	if err != nil {
+		return -1, err
+	}
+
+	if existing == 0 {
+		for _, adopt := range []string{
+			"UPDATE tasks SET owner_id = ? WHERE owner_id IS NULL",
+			"UPDATE events SET owner_id = ? WHERE owner_id IS NULL",
+			"UPDATE lists SET owner_id = ? WHERE owner_id IS NULL AND id != 1",
+		} {
+			_, err = tx.Exec(adopt, id)
+			if err != nil {
+				return -1, err
+			}
+		}
+	}
+	user.Id = todo.UserId(id)
+	return user.Id, tx.Commit()
+}
+
+// addedOwner returns who owns the tasks and lists the storage adds. Storage
+// that isn't for a user adds them for the first user once there are users,
+// as they own everything from before then too.
+func (s *Sqlite3TaskStorage) addedOwner(db queryer) (todo.UserId, error) {
+	if s.owner != 0 {
+		return s.owner, nil
+	}
+	var first sql.NullInt64
+	err := db.QueryRow("SELECT min(id) FROM users").Scan(&first)
+	return todo.UserId(first.Int64), err
+}
+
+func (s *Sqlite3TaskStorage) GetUser(id todo.UserId) (*todo.User, error) {
+	return s.getUser("id = ?", id)
+}
+
+func (s *Sqlite3TaskStorage) GetUserByName(username string) (*todo.User, error) {
+	return s.getUser("username = ?", username)
+}
+
+func (s *Sqlite3TaskStorage) getUser(condition string, arg any) (*todo.User, error) {
+	row := s.conn.QueryRow(`SELECT id, username, password_hash, created_at FROM users
+WHERE `+condition, arg)
+
+	var user todo.User
+	err := row.Scan(&user.Id, &user.Username, &user.PasswordHash, &user.CreatedAt)
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, fmt.Errorf("user %v: %w", arg, todo.ErrNotFound)
+	}
+	if err != nil {
+		return nil, err
+	}
+	return &user, nil
+}
+
+func (s *Sqlite3TaskStorage) AddSession(session *todo.Session) error {
+	_, err := s.conn.Exec("INSERT INTO sessions(token_hash, user_id, expires_at) values(?, ?, ?)",
+		session.TokenHash, session.UserId, session.ExpiresAt.UTC())
+	return err
+}
+
+func (s *Sqlite3TaskStorage) GetSession(tokenHash string) (*todo.Session, error) {
+	row := s.conn.QueryRow("SELECT token_hash, user_id, expires_at FROM sessions WHERE token_hash = ?",
+		tokenHash)
+
+	var session todo.Session
+	err := row.Scan(&session.TokenHash, &session.UserId, &session.ExpiresAt)
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, fmt.Errorf("session: %w", todo.ErrNotFound)
+	}
+	if err != nil {
+		return nil, err
+	}
+	return &session, nil
+}
+
+func (s *Sqlite3TaskStorage) DeleteSession(tokenHash string) error {
+	err := execOne(s.conn, "DELETE FROM sessions WHERE token_hash = ?", tokenHash)
+	if errors.Is(err, todo.ErrNotFound) {
+		return fmt.Errorf("session: %w", todo.ErrNotFound)
+	}
+	return err
+}
+
+// AddAPIToken stores an API token, with its scopes joined by commas.
+func (s *Sqlite3TaskStorage) AddAPIToken(token *todo.APIToken) (todo.APITokenId, error) {
+	scopes := []string{}
+	for _, scope := range token.Scopes {
+		scopes = append(scopes, string(scope))
+	}
+
+	sqlStmt := `INSERT INTO api_tokens(user_id, name, scopes, token_hash, created_at)
+values(?, ?, ?, ?, ?)`
+	result, err := s.conn.Exec(sqlStmt, token.UserId, token.Name, strings.Join(scopes, ","),
+		token.TokenHash, token.CreatedAt.UTC())
+	if err != nil {
+		return -1, err
+	}
+
+	id, err := result.LastInsertId()
+	if err != nil {
+		return -1, err
+	}
+	token.Id = todo.APITokenId(id)
+	return token.Id, nil
+}
+
+const apiTokenColumns = "id, user_id, name, scopes, token_hash, created_at"
+
+func scanAPIToken(row scanner) (todo.APIToken, error) {
+	var token todo.APIToken
+	var scopes string
+	err := row.Scan(&token.Id, &token.UserId, &token.Name, &scopes, &token.TokenHash,
+		&token.CreatedAt)
+	for _, scope := range strings.Split(scopes, ",") {
+		token.Scopes = append(token.Scopes, todo.Scope(scope))
+	}
+	return token, err
+}
+
+func (s *Sqlite3TaskStorage) GetAPITokens(userId todo.UserId) ([]todo.APIToken, error) {
+	tokens := []todo.APIToken{}
+	rows, err := s.conn.Query("SELECT "+apiTokenColumns+" FROM api_tokens WHERE user_id = ? ORDER BY id",
+		userId)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		token, err := scanAPIToken(rows)
+		if err != nil {
+			return nil, err
+		}
+		tokens = append(tokens, token)
+	}
+	return tokens, rows.Err()
+}
+
+func (s *Sqlite3TaskStorage) GetAPIToken(tokenHash string) (*todo.APIToken, error) {
+	row := s.conn.QueryRow("SELECT "+apiTokenColumns+" FROM api_tokens WHERE token_hash = ?",
+		tokenHash)
+
+	token, err := scanAPIToken(row)
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, fmt.Errorf("API token: %w", todo.ErrNotFound)
+	}
+	if err != nil {
+		return nil, err
+	}
+	return &token, nil
+}
+
+func (s *Sqlite3TaskStorage) DeleteAPIToken(userId todo.UserId, id todo.APITokenId) error {
+	err := execOne(s.conn, "DELETE FROM api_tokens WHERE id = ? AND user_id = ?", id, userId)
+	if errors.Is(err, todo.ErrNotFound) {
+		return fmt.Errorf("API token %d: %w", id, todo.ErrNotFound)
+	}
+	return err
+}
@@ -0,0 +1,135 @@
+package todo
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"strconv"
+	"time"
+)
+
+// streamHeartbeat is how often a comment is sent on a stream with no changes,
+// so that proxies don't close it as idle.
+const streamHeartbeat = 30 * time.Second
+
+// Change is sent on the event stream for each event, with the task as it is
+// now unless it has been deleted.
+type Change struct {
+	Event
+	Task *Task `json:"task,omitempty"`
+}
+
+// changeName returns the name of the server-sent event for a change, which
+// clients can listen for.
+func changeName(eventType EventType) string {
+	switch eventType {
+	case EventCreated, EventRestored:
+		return "task.created"
+	case EventDeleted:
+		return "task.deleted"
+	default:
+		return "task.updated"
+	}
+}
+
+// eventsHandler streams the changes to the user's tasks as server-sent
+// events as they happen. Each event's id is the id of the history event it
+// is for, so a client that reconnects with a Last-Event-ID header is sent
+// the changes it missed before any new ones.
+func (p *TaskServer) eventsHandler(w http.ResponseWriter, r *http.Request) {
+	flusher, ok := w.(http.Flusher)
+	if !ok {
+		writeError(w, r, fmt.Errorf("the response can't be streamed"))
+		return
+	}
+	tasks := p.tasks(r)
+	// Subscribe before finding where to start so no changes are missed.
+	events, unsubscribe := tasks.Subscribe()
+	defer unsubscribe()
+
+	last, err := lastEventId(r, tasks)
+	if err != nil {
+		writeError(w, r, err)
+		return
+	}
+
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-cache")
+	w.WriteHeader(http.StatusOK)
+	flusher.Flush()
+
+	heartbeat := time.NewTicker(streamHeartbeat)
+	defer heartbeat.Stop()
+	for {
+		// The events are fetched rather than taken from the broker, which
+		// sends every change, so that only those the user can see are sent.
+		last, err = writeChanges(w, tasks, last)
+		if err != nil {
+			log.Printf("%s %s failed, %v", r.Method, r.URL.Path, err)
+			return
+		}
+		flusher.Flush()
+
+		select {
+		case <-r.Context().Done():
+			return
+		case <-events:
+		case <-heartbeat.C:
+			fmt.Fprint(w, ": heartbeat\n\n")
+		}
+	}
+}
+
+// lastEventId returns the id of the last event the client has seen, from
+// the Last-Event-ID header when reconnecting, otherwise the latest event so
+// that only new changes are sent.
+func lastEventId(r *http.Request, tasks *TaskList) (EventId, error) {
+	if header := r.Header.Get("Last-Event-ID"); header != "" {
+		id, err := strconv.ParseInt(header, 10, 64)
+		if err != nil || id < 0 {
+			return 0, fieldError("Last-Event-ID", "must be an event id")
+		}
+		return EventId(id), nil
+	}
+	return latestEventId(tasks)
+}
+
+// latestEventId returns the id of the most recent event the user can see, or
+// zero if there are none.
+func latestEventId(tasks *TaskList) (EventId, error) {
+	page, err := tasks.GetActivity(1, "")
+	if err != nil || len(page.Events) == 0 {
+		return 0, err
+	}
+	return page.Events[0].Id, nil
+}
+
+// writeChanges writes the events after last as server-sent events, returning
+// the id of the last one written.
+func writeChanges(w http.ResponseWriter, tasks *TaskList, last EventId) (EventId, error) {
+	events, err := tasks.GetEventsAfter(last)
+	if err != nil {
+		return last, err
+	}
+
+	for _, event := range events {
+		change := Change{Event: event}
+		if event.Type != EventDeleted {
+			task, err := tasks.GetOne(event.TaskId)
+			if err == nil {
+				change.Task = &task
+			}
+		}
+		data, err := json.Marshal(change)
+		if err != nil {
+			return last, err
+		}
+		_, err = fmt.Fprintf(w, "id: %d\nevent: %s\ndata: %s\n\n", event.Id, changeName(event.Type), data)
+		if err != nil {
+			return last, err
+		}
+		last = event.Id
+	}
+	return last, nil
+}
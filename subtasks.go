@@ -0,0 +1,133 @@
+package todo
+
+import (
+	"errors"
+	"fmt"
+	"time"
+)
+
+var ErrHasSubtasks = fmt.Errorf("%w: the task has subtasks", ErrConflict)
+
+// TaskNode is a task with its subtasks, nested to any depth.
+type TaskNode struct {
+	Task
+	Subtasks []TaskNode `json:"subtasks"`
+}
+
+// BuildTree nests tasks under their parents. Tasks whose parent isn't one of
+// tasks are returned at the top level. Siblings keep their relative order.
+func BuildTree(tasks []Task) []TaskNode {
+	present := map[TaskId]bool{}
+	for _, task := range tasks {
+		present[task.Id] = true
+	}
+
+	children := map[TaskId][]Task{}
+	roots := []Task{}
+	for _, task := range tasks {
+		if task.ParentId != 0 && present[task.ParentId] {
+			children[task.ParentId] = append(children[task.ParentId], task)
+		} else {
+			roots = append(roots, task)
+		}
+	}
+
+	var build func(tasks []Task) []TaskNode
+	build = func(tasks []Task) []TaskNode {
+		nodes := []TaskNode{}
+		for _, task := range tasks {
+			nodes = append(nodes, TaskNode{Task: task, Subtasks: build(children[task.Id])})
+		}
+		return nodes
+	}
+	return build(roots)
+}
+
+// GetSubtasks returns the subtasks of a task nested under their parents.
+func (t *TaskList) GetSubtasks(id TaskId) ([]TaskNode, error) {
+	_, err := t.storage.GetTask(id)
+	if err != nil {
+		return nil, err
+	}
+	subtasks, err := t.storage.GetSubtasks(id)
+	if err != nil {
+		return nil, err
+	}
+	return BuildTree(subtasks), nil
+}
+
+// validateParent checks the parent of a task exists and that the task isn't
+// one of its ancestors, returning the parent if there is one.
+func (t *TaskList) validateParent(task *Task) (*Task, error) {
+	if task.ParentId == 0 {
+		return nil, nil
+	}
+	parent, err := t.storage.GetTask(task.ParentId)
+	if errors.Is(err, ErrNotFound) {
+		return nil, fieldError("parent_id", "does not exist")
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	for ancestor := parent; task.Id != 0; {
+		if ancestor.Id == task.Id {
+			return nil, fieldError("parent_id", "would make the task a subtask of itself")
+		}
+		if ancestor.ParentId == 0 {
+			break
+		}
+		ancestor, err = t.storage.GetTask(ancestor.ParentId)
+		if err != nil {
+			return nil, err
+		}
+	}
+	return parent, nil
+}
+
+// DeleteWithSubtasks moves a task to the trash along with every task nested
+// under it, so that restoring the task brings them all back.
+func (t *TaskList) DeleteWithSubtasks(task *Task) error {
+	stored, err := t.storage.GetTask(task.Id)
+	if err != nil {
+		return err
+	}
+	subtasks, err := t.GetSubtasks(task.Id)
+	if err != nil {
+		return err
+	}
+	// Check every task can be deleted before deleting any of them.
+	node := TaskNode{Task: *task, Subtasks: subtasks}
+	node.ListId = stored.ListId
+	err = t.requireTreeRole(node, RoleEditor)
+	if err != nil {
+		return err
+	}
+	return t.deleteTree(node, t.now())
+}
+
+func (t *TaskList) requireTreeRole(node TaskNode, role Role) error {
+	for _, subtask := range node.Subtasks {
+		err := t.requireTreeRole(subtask, role)
+		if err != nil {
+			return err
+		}
+	}
+	return t.requireRole(node.ListId, role)
+}
+
+// deleteTree trashes the deepest tasks first so that a failure part way
+// through never leaves a subtask without its parent.
+func (t *TaskList) deleteTree(node TaskNode, at time.Time) error {
+	for _, subtask := range node.Subtasks {
+		err := t.deleteTree(subtask, at)
+		if err != nil {
+			return err
+		}
+	}
+	err := t.storage.Trash(node.Id, at)
+	if err != nil {
+		return err
+	}
+	return t.record(EventDeleted, &node.Task, "")
+}
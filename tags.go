@@ -0,0 +1,79 @@
+package todo
+
+import (
+	"regexp"
+	"sort"
+	"strings"
+)
+
+// Tag is a label in use on one or more tasks.
+type Tag struct {
+	Name  string `json:"name"`
+	Count int    `json:"count"`
+}
+
+var tagPattern = regexp.MustCompile(`^[a-z0-9][a-z0-9_-]*$`)
+
+// NormalizeTags lowercases tags, strips any leading "#" and returns them
+// sorted without duplicates, or nil if there are none.
+func NormalizeTags(tags []string) []string {
+	seen := map[string]bool{}
+	normalized := []string{}
+	for _, tag := range tags {
+		tag = strings.ToLower(strings.TrimPrefix(strings.TrimSpace(tag), "#"))
+		if !seen[tag] {
+			seen[tag] = true
+			normalized = append(normalized, tag)
+		}
+	}
+	if len(normalized) == 0 {
+		return nil
+	}
+	sort.Strings(normalized)
+	return normalized
+}
+
+// ParseTags splits "#tag" words out of text typed for a new task, returning
+// the remaining text as the task name.
+func ParseTags(input string) (string, []string) {
+	words := []string{}
+	tags := []string{}
+	for _, word := range strings.Fields(input) {
+		if len(word) > 1 && strings.HasPrefix(word, "#") {
+			tags = append(tags, word)
+		} else {
+			words = append(words, word)
+		}
+	}
+	return strings.Join(words, " "), NormalizeTags(tags)
+}
+
+// HasTag reports whether the task is labelled with tag.
+func (t *Task) HasTag(tag string) bool {
+	for _, name := range t.Tags {
+		if name == tag {
+			return true
+		}
+	}
+	return false
+}
+
+// CountTags tallies the tags used by tasks, for storage that doesn't have a
+// query engine of its own.
+func CountTags(tasks []Task) []Tag {
+	counts := map[string]int{}
+	for _, task := range tasks {
+		for _, tag := range task.Tags {
+			counts[tag]++
+		}
+	}
+
+	tags := []Tag{}
+	for name, count := range counts {
+		tags = append(tags, Tag{Name: name, Count: count})
+	}
+	sort.Slice(tags, func(i, j int) bool {
+		return tags[i].Name < tags[j].Name
+	})
+	return tags
+}
@@ -1,67 +1,402 @@
 package todo
 
 import (
-	"github.com/go-playground/validator/v10"
+	"errors"
+	"fmt"
+	"time"
 )
 
 type TaskStorage interface {
+	// ForUser returns a view of the storage that only includes a user's
+	// tasks, lists and events, along with those of the lists shared with
+	// them, and adds new ones for them. Storage that isn't for a user
+	// includes everything, and adds new ones for the first user once there
+	// are users.
+	ForUser(UserId) TaskStorage
 	Add(*Task) (TaskId, error)
 	GetAll() ([]Task, error)
 	GetTask(TaskId) (*Task, error)
-	ToggleStatus(TaskId) error
+	Complete(TaskId, time.Time) error
+	Reopen(TaskId) error
+	Update(*Task) error
 	GetOutstanding() ([]Task, error)
-	Delete(TaskId) error
+	// Trash moves a task to the trash, after which it is left out of
+	// everything but GetTrash until it is restored or purged.
+	Trash(TaskId, time.Time) error
+	// Restore takes a task out of the trash along with any of its subtasks
+	// that were trashed at the same time.
+	Restore(TaskId) error
+	GetTrash() ([]Task, error)
+	// Purge permanently deletes the tasks trashed at or before a time,
+	// returning how many there were.
+	Purge(before time.Time) (int, error)
+	AddEvent(*Event) (EventId, error)
+	// GetEvents returns the events matching a query, most recent first.
+	GetEvents(EventQuery) ([]Event, error)
+	AddList(*List) (ListId, error)
+	GetLists() ([]List, error)
+	GetList(ListId) (*List, error)
+	GetListTasks(ListId) ([]Task, error)
+	// DeleteList removes a list, moving its tasks to the trash of the
+	// default list, where they belong to the list's owner.
+	DeleteList(ListId, time.Time) error
+	// GetRole returns the role the storage's user has on a list, which is
+	// RoleOwner for storage that isn't for a user and RoleEditor for lists
+	// without an owner. Lists the user can't see aren't found.
+	GetRole(ListId) (Role, error)
+	// GetMembers returns a list's creator, as an owner, and the users it is
+	// shared with.
+	GetMembers(ListId) ([]Member, error)
+	// SetMember shares a list with a user, replacing any role they had.
+	SetMember(ListId, UserId, Role) error
+	RemoveMember(ListId, UserId) error
+	GetOverdue(time.Time) ([]Task, error)
+	GetDue(after, before time.Time) ([]Task, error)
+	Query(TaskQuery) ([]Task, error)
+	GetTags() ([]Tag, error)
+	GetSubtasks(TaskId) ([]Task, error)
+	Search(query string) ([]SearchResult, error)
+	// Move places a task after the task after and before the task before,
+	// either of which may be zero to place it next to the other.
+	Move(id, before, after TaskId) error
 }
 
 type TaskId int64
 
+type ListId int64
+
+// DefaultListId is the list that tasks are added to when no list is given.
+// Storage implementations must ensure it always exists.
+const DefaultListId ListId = 1
+
+var ErrDeleteDefaultList = fmt.Errorf("%w: the default list cannot be deleted", ErrConflict)
+
 type Task struct {
-	Id       TaskId `json:"id"`
-	Name     string `json:"name" validate:"required"`
-	Complete bool   `json:"complete"`
+	Id       TaskId     `json:"id"`
+	Name     string     `json:"name" validate:"required"`
+	Complete bool       `json:"complete"`
+	ListId   ListId     `json:"list_id"`
+	Due      *time.Time `json:"due,omitempty"`
+	RemindAt *time.Time `json:"remind_at,omitempty"`
+	// CompletedAt records when the task was completed, it is cleared if the
+	// task is reopened.
+	CompletedAt *time.Time `json:"completed_at,omitempty"`
+	// CreatedAt is set when the task is added and never changes. Tasks
+	// created before it was recorded don't have one.
+	CreatedAt *time.Time `json:"created_at,omitempty"`
+	Tags      []string   `json:"tags,omitempty" validate:"max=20,dive,max=32,tag"`
+	// ParentId is the task this is a subtask of, or zero for a top level task.
+	ParentId TaskId   `json:"parent_id,omitempty"`
+	Priority Priority `json:"priority,omitempty" validate:"omitempty,oneof=P0 P1 P2 P3"`
+	// Position orders a list's tasks as the user has arranged them. Storage
+	// puts new tasks last in their list and only Move changes it.
+	Position string `json:"position,omitempty"`
+	// Recurrence is a rule for repeating the task, see ParseRecurrence. The
+	// next occurrence is added when the task is completed.
+	Recurrence string `json:"recurrence,omitempty" validate:"omitempty,rrule"`
+	// TrashedAt is when the task was deleted, it is only set on tasks in the
+	// trash.
+	TrashedAt *time.Time `json:"trashed_at,omitempty"`
+	// OwnerId is the user the task belongs to, it is set by storage.
+	OwnerId UserId `json:"-"`
 }
 
 func (t *Task) Validate() error {
-	validate := validator.New()
-	return validate.Struct(t)
+	return validateStruct(t)
+}
+
+// IsOverdue reports whether an incomplete task was due before now.
+func (t *Task) IsOverdue(now time.Time) bool {
+	return !t.Complete && t.Due != nil && t.Due.Before(now)
+}
+
+// IsDueBetween reports whether the task is due in the range (after, before).
+// A zero time leaves that end of the range open.
+func (t *Task) IsDueBetween(after, before time.Time) bool {
+	if t.Due == nil {
+		return false
+	}
+	if !after.IsZero() && !t.Due.After(after) {
+		return false
+	}
+	if !before.IsZero() && !t.Due.Before(before) {
+		return false
+	}
+	return true
+}
+
+// ReminderDue reports whether an incomplete task has a reminder at or before now.
+func (t *Task) ReminderDue(now time.Time) bool {
+	return !t.Complete && t.RemindAt != nil && !t.RemindAt.After(now)
+}
+
+type List struct {
+	Id   ListId `json:"id"`
+	Name string `json:"name" validate:"required"`
+	// OwnerId is the user the list belongs to, it is set by storage. The
+	// default list doesn't have one and is used by everyone.
+	OwnerId UserId `json:"-"`
+}
+
+func (l *List) Validate() error {
+	return validateStruct(l)
 }
 
 type TaskList struct {
 	storage TaskStorage
+	now     func() time.Time
+	// user is the user the task list is for, or zero for everyone.
+	user   UserId
+	broker *Broker
 }
 
 func CreateTaskList(storage TaskStorage) *TaskList {
-	return &TaskList{storage: storage}
+	return &TaskList{storage: storage, now: time.Now, broker: NewBroker()}
+}
+
+// ForUser returns a task list of only a user's tasks and those shared with
+// them, which enforces the user's role on each list.
+func (t *TaskList) ForUser(id UserId) *TaskList {
+	return &TaskList{storage: t.storage.ForUser(id), now: t.now, user: id, broker: t.broker}
+}
+
+// SetClock replaces the function used to get the current time, for
+// deciding which tasks are overdue and recording when tasks are completed.
+func (t *TaskList) SetClock(now func() time.Time) {
+	t.now = now
 }
 
 func (t *TaskList) Add(name string) (TaskId, error) {
-	task := Task{Name: name, Complete: false}
-	id, err := t.storage.Add(&task)
+	return t.AddToList(DefaultListId, name)
+}
+
+func (t *TaskList) AddToList(listId ListId, name string) (TaskId, error) {
+	task := Task{Name: name, Complete: false, ListId: listId}
+	return t.AddTask(&task)
+}
+
+// AddTask adds a fully populated task, such as one with a due date. When it
+// was created, completed and trashed are up to the task list rather than the
+// caller: it is created now, and completed now if it is complete.
+func (t *TaskList) AddTask(task *Task) (TaskId, error) {
+	err := t.validateTask(task)
 	if err != nil {
 		return -1, err
 	}
-	return id, nil
+	now := t.now()
+	task.CreatedAt = &now
+	task.CompletedAt = nil
+	if task.Complete {
+		task.CompletedAt = &now
+	}
+	task.TrashedAt = nil
+	id, err := t.storage.Add(task)
+	if err != nil {
+		return -1, err
+	}
+	task.Id = id
+	return id, t.record(EventCreated, task, "")
+}
+
+// validateTask checks a task is valid before it is stored, putting it in its
+// parent's list or the default list if it has none and normalizing its tags.
+func (t *TaskList) validateTask(task *Task) error {
+	task.Tags = NormalizeTags(task.Tags)
+	if rule, err := ParseRecurrence(task.Recurrence); err == nil {
+		task.Recurrence = rule.String()
+	}
+	err := task.Validate()
+	if err != nil {
+		return err
+	}
+	parent, err := t.validateParent(task)
+	if err != nil {
+		return err
+	}
+	if task.ListId == 0 && parent != nil {
+		task.ListId = parent.ListId
+	}
+	if task.ListId == 0 {
+		task.ListId = DefaultListId
+	}
+	_, err = t.storage.GetList(task.ListId)
+	if errors.Is(err, ErrNotFound) {
+		return fieldError("list_id", "does not exist")
+	}
+	if err != nil {
+		return err
+	}
+	return t.requireRole(task.ListId, RoleEditor)
 }
 
 func (t *TaskList) GetAll() ([]Task, error) {
 	return t.storage.GetAll()
 }
 
+// ToggleStatus completes an incomplete task or reopens a complete one, based
+// on its stored state rather than the possibly stale state of task.
 func (t *TaskList) ToggleStatus(task *Task) error {
-	err := t.storage.ToggleStatus(task.Id)
+	current, err := t.storage.GetTask(task.Id)
 	if err != nil {
 		return err
 	}
-	return nil
+	if current.Complete {
+		return t.Reopen(task)
+	}
+	return t.Complete(task)
+}
+
+// Complete marks a task as complete, adding the next occurrence of recurring
+// tasks. Completing a task that is already complete leaves its completion
+// time unchanged.
+func (t *TaskList) Complete(task *Task) error {
+	stored, err := t.storage.GetTask(task.Id)
+	if err != nil {
+		return err
+	}
+	current := *stored
+	err = t.requireRole(current.ListId, RoleEditor)
+	if err != nil {
+		return err
+	}
+	now := t.now()
+	err = t.storage.Complete(task.Id, now)
+	if err != nil || current.Complete {
+		return err
+	}
+	err = t.record(EventCompleted, &current, "")
+	if err != nil {
+		return err
+	}
+	return t.spawnNext(&current, now)
+}
+
+// Reopen marks a task as incomplete, it has no effect on incomplete tasks.
+func (t *TaskList) Reopen(task *Task) error {
+	stored, err := t.storage.GetTask(task.Id)
+	if err != nil {
+		return err
+	}
+	current := *stored
+	err = t.requireRole(current.ListId, RoleEditor)
+	if err != nil {
+		return err
+	}
+	err = t.storage.Reopen(task.Id)
+	if err != nil || !current.Complete {
+		return err
+	}
+	return t.record(EventReopened, &current, "")
+}
+
+// Query returns the page of tasks matching q that starts at cursor, which is
+// empty for the first page or taken from a previous page.
+func (t *TaskList) Query(q TaskQuery, cursor string) (TaskPage, error) {
+	offset, err := decodeCursor(cursor)
+	if err != nil {
+		return TaskPage{}, err
+	}
+	q.Offset = offset
+
+	// Fetch one extra task to find out if there is another page.
+	limit := q.Limit
+	if limit > 0 {
+		q.Limit++
+	}
+	tasks, err := t.storage.Query(q)
+	if err != nil {
+		return TaskPage{}, err
+	}
+
+	page := TaskPage{Tasks: tasks}
+	page.NextCursor, page.PrevCursor = pageCursors(offset, limit, len(tasks))
+	if page.NextCursor != "" {
+		page.Tasks = tasks[:limit]
+	}
+	return page, nil
+}
+
+// Update replaces the stored task with the same id, adding the next
+// occurrence if it completes a recurring task. The creation time of a task
+// can't be changed.
+func (t *TaskList) Update(task *Task) error {
+	err := t.validateTask(task)
+	if err != nil {
+		return err
+	}
+	stored, err := t.storage.GetTask(task.Id)
+	if err != nil {
+		return err
+	}
+	current := *stored
+	// Moving a task to another list needs the editor role on both.
+	err = t.requireRole(current.ListId, RoleEditor)
+	if err != nil {
+		return err
+	}
+	task.CreatedAt = current.CreatedAt
+	task.Position = current.Position
+	task.OwnerId = current.OwnerId
+	completing := task.Complete && !current.Complete
+	if !task.Complete {
+		task.CompletedAt = nil
+	} else if task.CompletedAt == nil {
+		now := t.now()
+		task.CompletedAt = &now
+	}
+	err = t.storage.Update(task)
+	if err != nil {
+		return err
+	}
+	err = t.recordUpdate(&current, task)
+	if err != nil || !completing {
+		return err
+	}
+	return t.spawnNext(task, t.now())
+}
+
+func (t *TaskList) GetTags() ([]Tag, error) {
+	return t.storage.GetTags()
 }
 
 func (t *TaskList) GetOutstanding() ([]Task, error) {
 	return t.storage.GetOutstanding()
 }
 
+func (t *TaskList) GetOverdue() ([]Task, error) {
+	return t.storage.GetOverdue(t.now())
+}
+
+// GetDue returns tasks due between after and before, either of which may be
+// zero to leave that end of the range open.
+func (t *TaskList) GetDue(after, before time.Time) ([]Task, error) {
+	return t.storage.GetDue(after, before)
+}
+
+// Now returns the current time according to the task list's clock.
+func (t *TaskList) Now() time.Time {
+	return t.now()
+}
+
+// Delete moves a task to the trash. Tasks with subtasks can't be deleted,
+// use DeleteWithSubtasks to remove them all together.
 func (t *TaskList) Delete(task *Task) error {
-	err := t.storage.Delete(task.Id)
-	return err
+	err := t.requireTaskRole(task.Id, RoleEditor)
+	if err != nil {
+		return err
+	}
+	subtasks, err := t.storage.GetSubtasks(task.Id)
+	if err != nil {
+		return err
+	}
+	if len(subtasks) > 0 {
+		return ErrHasSubtasks
+	}
+	err = t.storage.Trash(task.Id, t.now())
+	if err != nil {
+		return err
+	}
+	return t.record(EventDeleted, task, "")
 }
 
 func (t *TaskList) GetOne(id TaskId) (Task, error) {
@@ -73,3 +408,76 @@ func (t *TaskList) GetOne(id TaskId) (Task, error) {
 
 	return *task, nil
 }
+
+func (t *TaskList) AddList(name string) (ListId, error) {
+	list := List{Name: name}
+	err := list.Validate()
+	if err != nil {
+		return -1, err
+	}
+	id, err := t.storage.AddList(&list)
+	if err != nil {
+		return -1, err
+	}
+	return id, nil
+}
+
+func (t *TaskList) GetLists() ([]List, error) {
+	return t.storage.GetLists()
+}
+
+func (t *TaskList) GetList(id ListId) (List, error) {
+	list, err := t.storage.GetList(id)
+
+	if err != nil {
+		return List{}, err
+	}
+
+	return *list, nil
+}
+
+func (t *TaskList) GetListTasks(id ListId) ([]Task, error) {
+	return t.storage.GetListTasks(id)
+}
+
+func (t *TaskList) GetListOutstanding(id ListId) ([]Task, error) {
+	tasks, err := t.storage.GetListTasks(id)
+	if err != nil {
+		return nil, err
+	}
+
+	outstanding := []Task{}
+	for _, task := range tasks {
+		if !task.Complete {
+			outstanding = append(outstanding, task)
+		}
+	}
+	return outstanding, nil
+}
+
+// DeleteList removes a list and moves its tasks to the trash, from where
+// they can be restored to the default list until they are purged.
+func (t *TaskList) DeleteList(list *List) error {
+	if list.Id == DefaultListId {
+		return ErrDeleteDefaultList
+	}
+	err := t.requireRole(list.Id, RoleOwner)
+	if err != nil {
+		return err
+	}
+	tasks, err := t.storage.GetListTasks(list.Id)
+	if err != nil {
+		return err
+	}
+	err = t.storage.DeleteList(list.Id, t.now())
+	if err != nil {
+		return err
+	}
+	for _, task := range tasks {
+		err = t.record(EventDeleted, &task, "")
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
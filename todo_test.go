@@ -1,77 +1,28 @@
 package todo_test
 
 import (
+	"database/sql"
 	"errors"
+	"fmt"
+	"net/url"
+	"os"
+	"path/filepath"
 	"reflect"
+	"strings"
 	"testing"
+	"time"
 
-	_ "github.com/mattn/go-sqlite3"
 	todo "github.com/rosswf/go-todo"
 	storage "github.com/rosswf/go-todo/storage"
+	"github.com/rosswf/go-todo/storage/storagetest"
 )
 
-type MockTaskStorage struct {
-	taskList []todo.Task
-}
-
-func (m *MockTaskStorage) Add(task *todo.Task) (todo.TaskId, error) {
-	id := len(m.taskList) + 1
-	task.Id = todo.TaskId(id)
-	m.taskList = append(m.taskList, *task)
-	return todo.TaskId(id), nil
-}
-
-func (m *MockTaskStorage) GetAll() ([]todo.Task, error) {
-	return m.taskList, nil
-}
-
-func (m *MockTaskStorage) ToggleStatus(id todo.TaskId) error {
-	task, err := m.GetTask(id)
-	if err != nil {
-		return err
-	}
-	if task.Complete {
-		task.Complete = false
-	} else {
-		task.Complete = true
-	}
-	return nil
-}
-
-func (m *MockTaskStorage) GetTask(id todo.TaskId) (*todo.Task, error) {
-	for i, task := range m.taskList {
-		if task.Id == id {
-			return &m.taskList[i], nil
-		}
-	}
-	return nil, errors.New("Task not found")
-}
-
-func (m *MockTaskStorage) GetOutstanding() ([]todo.Task, error) {
-	outstanding := make([]todo.Task, 0)
-
-	for _, task := range m.taskList {
-		if !task.Complete {
-			outstanding = append(outstanding, task)
-		}
-	}
-	return outstanding, nil
-}
-
-func (m *MockTaskStorage) Delete(id todo.TaskId) error {
-	id-- // slice is 0 indexed
-	m.taskList = append(m.taskList[:id], m.taskList[id+1:]...)
-	return nil
-}
-
-func CreateMockStorage(data []todo.Task) *MockTaskStorage {
-	return &MockTaskStorage{data}
-}
-
 func TestTasks(t *testing.T) {
-	storage := CreateMockStorage([]todo.Task{})
+	now := time.Date(2022, 10, 1, 12, 0, 0, 0, time.UTC)
+	storage := storage.CreateMemoryTaskStorage([]todo.Task{})
 
 	taskList := todo.CreateTaskList(storage)
+	taskList.SetClock(func() time.Time { return now })
 
 	t.Run("A task is added to the task list", func(t *testing.T) {
 		_, err := taskList.Add("Task 1")
@@ -80,7 +31,7 @@ func TestTasks(t *testing.T) {
 		got, err := taskList.GetAll()
 		AssertNoError(t, err)
 
-		want := []todo.Task{{Id: 1, Name: "Task 1", Complete: false}}
+		want := []todo.Task{{Id: 1, Name: "Task 1", Complete: false, ListId: 1, CreatedAt: &now, Position: "V"}}
 
 		AssertTaskListsEqual(t, got, want)
 	})
@@ -95,7 +46,7 @@ func TestTasks(t *testing.T) {
 		got, err := taskList.GetAll()
 		AssertNoError(t, err)
 
-		want := []todo.Task{{Id: 1, Name: "Task 1", Complete: true}}
+		want := []todo.Task{{Id: 1, Name: "Task 1", Complete: true, ListId: 1, CompletedAt: &now, CreatedAt: &now, Position: "V"}}
 
 		AssertTaskListsEqual(t, got, want)
 	})
@@ -108,7 +59,7 @@ func TestTasks(t *testing.T) {
 
 		got, _ := taskList.GetAll()
 
-		want := []todo.Task{{Id: 1, Name: "Task 1", Complete: false}}
+		want := []todo.Task{{Id: 1, Name: "Task 1", Complete: false, ListId: 1, CreatedAt: &now, Position: "V"}}
 
 		AssertTaskListsEqual(t, got, want)
 	})
@@ -127,8 +78,8 @@ func TestTasks(t *testing.T) {
 		AssertNoError(t, err)
 
 		want := []todo.Task{
-			{Id: 2, Name: "Task 2", Complete: false},
-			{Id: 4, Name: "Task 4", Complete: false},
+			{Id: 2, Name: "Task 2", Complete: false, ListId: 1, CreatedAt: &now, Position: "W"},
+			{Id: 4, Name: "Task 4", Complete: false, ListId: 1, CreatedAt: &now, Position: "Y"},
 		}
 
 		AssertTaskListsEqual(t, got, want)
@@ -144,103 +95,1622 @@ func TestTasks(t *testing.T) {
 		AssertNoError(t, err)
 
 		want := []todo.Task{
-			{Id: 1, Name: "Task 1", Complete: true},
-			{Id: 3, Name: "Task 3", Complete: true},
-			{Id: 4, Name: "Task 4", Complete: false},
+			{Id: 1, Name: "Task 1", Complete: true, ListId: 1, CompletedAt: &now, CreatedAt: &now, Position: "V"},
+			{Id: 3, Name: "Task 3", Complete: true, ListId: 1, CompletedAt: &now, CreatedAt: &now, Position: "X"},
+			{Id: 4, Name: "Task 4", Complete: false, ListId: 1, CreatedAt: &now, Position: "Y"},
 		}
 
 		AssertTaskListsEqual(t, got, want)
 	})
 
+	t.Run("Rename a task", func(t *testing.T) {
+		task, _ := taskList.GetOne(4)
+		task.Name = "Task four"
+
+		err := taskList.Update(&task)
+		AssertNoError(t, err)
+
+		got, _ := taskList.GetOne(4)
+		want := todo.Task{Id: 4, Name: "Task four", Complete: false, ListId: 1, CreatedAt: &now, Position: "Y"}
+
+		if !reflect.DeepEqual(got, want) {
+			t.Errorf("got %v, want %v", got, want)
+		}
+	})
+
+	t.Run("An update must be valid", func(t *testing.T) {
+		task, _ := taskList.GetOne(4)
+		task.Name = ""
+
+		err := taskList.Update(&task)
+		if !errors.Is(err, todo.ErrValidation) {
+			t.Errorf("got error %v, want %v", err, todo.ErrValidation)
+		}
+
+		var validationError *todo.ValidationError
+		errors.As(err, &validationError)
+		want := []todo.FieldError{{Field: "name", Reason: "is required"}}
+
+		if validationError == nil || !reflect.DeepEqual(validationError.Fields, want) {
+			t.Errorf("got %+v, want fields %+v", validationError, want)
+		}
+	})
+
+	t.Run("Getting a missing task is a not found error", func(t *testing.T) {
+		_, err := taskList.GetOne(9)
+		if !errors.Is(err, todo.ErrNotFound) {
+			t.Errorf("got error %v, want %v", err, todo.ErrNotFound)
+		}
+	})
+
 	t.Run("Get a task", func(t *testing.T) {
 		task, err := taskList.GetOne(3)
 
 		AssertNoError(t, err)
 
 		want := todo.Task{
-			Id: 3, Name: "Task 3", Complete: true,
+			Id: 3, Name: "Task 3", Complete: true, ListId: 1, CompletedAt: &now, CreatedAt: &now, Position: "X",
 		}
 
-		if task != want {
+		if !reflect.DeepEqual(task, want) {
 			t.Errorf("got %v, want %v", task, want)
 		}
 
 	})
 }
 
-func TestSqlite3TaskStorage(t *testing.T) {
-	storage, err := storage.CreateSqlite3TaskStorage(":memory:")
-	AssertNoError(t, err)
+func TestCompletion(t *testing.T) {
+	now := time.Date(2022, 10, 1, 12, 0, 0, 0, time.UTC)
+	later := now.Add(time.Hour)
+	clock := now
+
+	storage := storage.CreateMemoryTaskStorage([]todo.Task{})
 
-	t.Run("Add a new task to sqlite storage", func(t *testing.T) {
-		id := AddTaskToDB(t, storage, "Task 1", false)
+	taskList := todo.CreateTaskList(storage)
+	taskList.SetClock(func() time.Time { return clock })
+	taskList.Add("Task 1")
 
-		if id != 1 {
-			t.Errorf("got %d want %d", id, 1)
+	t.Run("Completing a task twice keeps the first completion time", func(t *testing.T) {
+		task, _ := taskList.GetOne(1)
+
+		AssertNoError(t, taskList.Complete(&task))
+		clock = later
+		AssertNoError(t, taskList.Complete(&task))
+
+		got, _ := taskList.GetOne(1)
+		want := todo.Task{Id: 1, Name: "Task 1", Complete: true, ListId: 1, CompletedAt: &now, CreatedAt: &now, Position: "V"}
+
+		if !reflect.DeepEqual(got, want) {
+			t.Errorf("got %+v, want %+v", got, want)
 		}
+	})
 
-		got, err := storage.GetAll()
-		AssertNoError(t, err)
+	t.Run("Reopening a task twice leaves it incomplete", func(t *testing.T) {
+		task, _ := taskList.GetOne(1)
 
-		want := []todo.Task{{Id: 1, Name: "Task 1", Complete: false}}
+		AssertNoError(t, taskList.Reopen(&task))
+		AssertNoError(t, taskList.Reopen(&task))
 
-		AssertTaskListsEqual(t, got, want)
+		got, _ := taskList.GetOne(1)
+		want := todo.Task{Id: 1, Name: "Task 1", Complete: false, ListId: 1, CreatedAt: &now, Position: "V"}
+
+		if !reflect.DeepEqual(got, want) {
+			t.Errorf("got %+v, want %+v", got, want)
+		}
 	})
 
-	t.Run("Get a single task by ID", func(t *testing.T) {
-		AddTaskToDB(t, storage, "Task 2", true)
+	t.Run("Toggling a stale task uses its stored state", func(t *testing.T) {
+		stale, _ := taskList.GetOne(1)
+		AssertNoError(t, taskList.Complete(&stale))
+
+		AssertNoError(t, taskList.ToggleStatus(&stale))
+
+		got, _ := taskList.GetOne(1)
+		if got.Complete {
+			t.Errorf("got %+v, want an incomplete task", got)
+		}
+	})
+}
+
+func TestTaskLists(t *testing.T) {
+	now := time.Date(2022, 10, 1, 12, 0, 0, 0, time.UTC)
+	storage := storage.CreateMemoryTaskStorage([]todo.Task{})
+
+	taskList := todo.CreateTaskList(storage)
+	taskList.SetClock(func() time.Time { return now })
+
+	t.Run("A list is added", func(t *testing.T) {
+		id, err := taskList.AddList("Work")
+		AssertNoError(t, err)
 
-		got, err := storage.GetTask(2)
+		got, err := taskList.GetLists()
 		AssertNoError(t, err)
 
-		want := &todo.Task{Id: 2, Name: "Task 2", Complete: true}
+		want := []todo.List{{Id: 1, Name: "Tasks"}, {Id: id, Name: "Work"}}
 
 		if !reflect.DeepEqual(got, want) {
 			t.Errorf("got %+v, want %+v", got, want)
 		}
 	})
 
-	t.Run("Toggle task status", func(t *testing.T) {
-		err := storage.ToggleStatus(1)
+	t.Run("Tasks are added to a list", func(t *testing.T) {
+		taskList.Add("Personal task")
+		taskList.AddToList(2, "Work task")
+
+		got, err := taskList.GetListTasks(2)
 		AssertNoError(t, err)
 
-		got, _ := storage.GetTask(1)
+		want := []todo.Task{{Id: 2, Name: "Work task", Complete: false, ListId: 2, CreatedAt: &now, Position: "V"}}
+
+		AssertTaskListsEqual(t, got, want)
+	})
 
-		want := &todo.Task{Id: 1, Name: "Task 1", Complete: true}
+	t.Run("A task cannot be added to a missing list", func(t *testing.T) {
+		_, err := taskList.AddToList(3, "Task")
+		if !errors.Is(err, todo.ErrValidation) {
+			t.Errorf("got error %v, want %v", err, todo.ErrValidation)
+		}
+	})
+
+	t.Run("The default list cannot be deleted", func(t *testing.T) {
+		list, _ := taskList.GetList(todo.DefaultListId)
+
+		err := taskList.DeleteList(&list)
+		if err != todo.ErrDeleteDefaultList {
+			t.Errorf("got error %v, want %v", err, todo.ErrDeleteDefaultList)
+		}
+		if !errors.Is(err, todo.ErrConflict) {
+			t.Errorf("got error %v, want %v", err, todo.ErrConflict)
+		}
+	})
+
+	t.Run("Deleting a list moves its tasks to the trash", func(t *testing.T) {
+		list, _ := taskList.GetList(2)
+
+		err := taskList.DeleteList(&list)
+		AssertNoError(t, err)
+
+		got, _ := taskList.GetAll()
+		want := []todo.Task{{Id: 1, Name: "Personal task", Complete: false, ListId: 1, CreatedAt: &now, Position: "V"}}
+
+		AssertTaskListsEqual(t, got, want)
+
+		trash, _ := taskList.GetTrash()
+		if len(trash) != 1 || trash[0].Id != 2 || trash[0].ListId != todo.DefaultListId || !trash[0].TrashedAt.Equal(now) {
+			t.Errorf("got trash %+v, want task 2 trashed in the default list", trash)
+		}
+
+		_, err = taskList.Restore(2)
+		AssertNoError(t, err)
+	})
+}
+
+func TestDueDates(t *testing.T) {
+	now := time.Date(2022, 10, 1, 12, 0, 0, 0, time.UTC)
+	yesterday := now.AddDate(0, 0, -1)
+	tomorrow := now.AddDate(0, 0, 1)
+
+	storage := storage.CreateMemoryTaskStorage([]todo.Task{})
+
+	taskList := todo.CreateTaskList(storage)
+	taskList.SetClock(func() time.Time { return now })
+
+	taskList.AddTask(&todo.Task{Name: "Overdue", Due: &yesterday})
+	taskList.AddTask(&todo.Task{Name: "Due soon", Due: &tomorrow, RemindAt: &now})
+	taskList.AddTask(&todo.Task{Name: "Done", Due: &yesterday, Complete: true})
+	taskList.Add("Whenever")
+
+	t.Run("Get overdue tasks", func(t *testing.T) {
+		got, err := taskList.GetOverdue()
+		AssertNoError(t, err)
+
+		want := []todo.Task{{Id: 1, Name: "Overdue", ListId: 1, Due: &yesterday, CreatedAt: &now, Position: "V"}}
+
+		AssertTaskListsEqual(t, got, want)
+	})
+
+	t.Run("Get tasks due after now", func(t *testing.T) {
+		got, err := taskList.GetDue(now, time.Time{})
+		AssertNoError(t, err)
+
+		want := []todo.Task{{Id: 2, Name: "Due soon", ListId: 1, Due: &tomorrow, RemindAt: &now, CreatedAt: &now, Position: "W"}}
+
+		AssertTaskListsEqual(t, got, want)
+	})
+
+	t.Run("Reminders are due once their time has passed", func(t *testing.T) {
+		task, _ := taskList.GetOne(2)
+
+		if !task.ReminderDue(now) {
+			t.Error("expected the reminder to be due")
+		}
+		if task.ReminderDue(yesterday) {
+			t.Error("expected the reminder not to be due yet")
+		}
+	})
+}
+
+func TestTags(t *testing.T) {
+	now := time.Date(2022, 10, 1, 12, 0, 0, 0, time.UTC)
+	storage := storage.CreateMemoryTaskStorage([]todo.Task{})
+
+	taskList := todo.CreateTaskList(storage)
+	taskList.SetClock(func() time.Time { return now })
+
+	t.Run("Tags are normalized when a task is added", func(t *testing.T) {
+		task := todo.Task{Name: "Shopping", Tags: []string{"#Home", "errands", "home"}}
+		_, err := taskList.AddTask(&task)
+		AssertNoError(t, err)
+
+		got, _ := taskList.GetOne(1)
+		want := []string{"errands", "home"}
+
+		if !reflect.DeepEqual(got.Tags, want) {
+			t.Errorf("got %v, want %v", got.Tags, want)
+		}
+	})
+
+	t.Run("Invalid tags are a validation error", func(t *testing.T) {
+		for _, tags := range [][]string{{"two words"}, {""}, {strings.Repeat("a", 33)}} {
+			_, err := taskList.AddTask(&todo.Task{Name: "Bad tag", Tags: tags})
+			if !errors.Is(err, todo.ErrValidation) {
+				t.Errorf("tags %q got error %v, want %v", tags, err, todo.ErrValidation)
+			}
+		}
+	})
+
+	t.Run("Tags are counted across tasks", func(t *testing.T) {
+		taskList.AddTask(&todo.Task{Name: "Gardening", Tags: []string{"home"}})
+
+		got, err := taskList.GetTags()
+		AssertNoError(t, err)
+
+		want := []todo.Tag{{Name: "errands", Count: 1}, {Name: "home", Count: 2}}
+
+		if !reflect.DeepEqual(got, want) {
+			t.Errorf("got %v, want %v", got, want)
+		}
+	})
+
+	t.Run("Tags are parsed out of typed input", func(t *testing.T) {
+		name, tags := todo.ParseTags("Buy milk #Shopping # #urgent")
+
+		if name != "Buy milk #" {
+			t.Errorf("got name %q, want %q", name, "Buy milk #")
+		}
+		if !reflect.DeepEqual(tags, []string{"shopping", "urgent"}) {
+			t.Errorf("got tags %v, want %v", tags, []string{"shopping", "urgent"})
+		}
+	})
+}
+
+func TestSubtasks(t *testing.T) {
+	now := time.Date(2022, 10, 1, 12, 0, 0, 0, time.UTC)
+	storage := storage.CreateMemoryTaskStorage([]todo.Task{})
+	storage.AddList(&todo.List{Name: "Work"})
+
+	taskList := todo.CreateTaskList(storage)
+	taskList.SetClock(func() time.Time { return now })
+
+	taskList.AddToList(2, "Release")
+	taskList.AddTask(&todo.Task{Name: "Tag version", ParentId: 1})
+	taskList.AddTask(&todo.Task{Name: "Push tag", ParentId: 2})
+
+	t.Run("Subtasks are added to their parent's list", func(t *testing.T) {
+		got, _ := taskList.GetOne(3)
+
+		if got.ListId != 2 {
+			t.Errorf("got list %d, want %d", got.ListId, 2)
+		}
+	})
+
+	t.Run("Subtasks must have a parent that exists", func(t *testing.T) {
+		_, err := taskList.AddTask(&todo.Task{Name: "Orphan", ParentId: 9})
+		if !errors.Is(err, todo.ErrValidation) {
+			t.Errorf("got error %v, want %v", err, todo.ErrValidation)
+		}
+	})
+
+	t.Run("A task can't be nested under its own subtasks", func(t *testing.T) {
+		for _, parentId := range []todo.TaskId{1, 3} {
+			task, _ := taskList.GetOne(1)
+			task.ParentId = parentId
+
+			err := taskList.Update(&task)
+			if !errors.Is(err, todo.ErrValidation) {
+				t.Errorf("parent %d got error %v, want %v", parentId, err, todo.ErrValidation)
+			}
+		}
+	})
+
+	t.Run("Get subtasks as a tree", func(t *testing.T) {
+		got, err := taskList.GetSubtasks(1)
+		AssertNoError(t, err)
+
+		want := []todo.TaskNode{
+			{Task: todo.Task{Id: 2, Name: "Tag version", ListId: 2, ParentId: 1, CreatedAt: &now, Position: "W"}, Subtasks: []todo.TaskNode{
+				{Task: todo.Task{Id: 3, Name: "Push tag", ListId: 2, ParentId: 2, CreatedAt: &now, Position: "X"}, Subtasks: []todo.TaskNode{}},
+			}},
+		}
 
 		if !reflect.DeepEqual(got, want) {
 			t.Errorf("got %+v, want %+v", got, want)
 		}
 	})
 
-	t.Run("Get oustanding tasks", func(t *testing.T) {
-		AddTaskToDB(t, storage, "Task 3", false)
-		AddTaskToDB(t, storage, "Task 4", false)
+	t.Run("Tasks with subtasks can't be deleted on their own", func(t *testing.T) {
+		task, _ := taskList.GetOne(2)
 
-		got, err := storage.GetOutstanding()
+		err := taskList.Delete(&task)
+		if !errors.Is(err, todo.ErrConflict) {
+			t.Errorf("got error %v, want %v", err, todo.ErrConflict)
+		}
+	})
+
+	t.Run("Delete a task with its subtasks", func(t *testing.T) {
+		task, _ := taskList.GetOne(1)
+
+		err := taskList.DeleteWithSubtasks(&task)
 		AssertNoError(t, err)
 
-		want := []todo.Task{
-			{Id: 3, Name: "Task 3", Complete: false},
-			{Id: 4, Name: "Task 4", Complete: false},
+		got, _ := taskList.GetAll()
+		AssertTaskListsEqual(t, got, []todo.Task{})
+	})
+}
+
+func TestPriority(t *testing.T) {
+	now := time.Date(2022, 10, 1, 12, 0, 0, 0, time.UTC)
+	tomorrow := now.AddDate(0, 0, 1)
+	storage := storage.CreateMemoryTaskStorage([]todo.Task{})
+
+	taskList := todo.CreateTaskList(storage)
+	taskList.SetClock(func() time.Time { return now })
+
+	taskList.AddTask(&todo.Task{Name: "Whenever"})
+	taskList.AddTask(&todo.Task{Name: "Soon", Priority: todo.P1, Due: &tomorrow})
+	taskList.AddTask(&todo.Task{Name: "Urgent", Priority: todo.P0})
+	taskList.AddTask(&todo.Task{Name: "Sooner", Priority: todo.P1, Due: &now})
+	taskList.AddTask(&todo.Task{Name: "Done", Priority: todo.P0, Complete: true})
+
+	t.Run("Priorities must be P0 to P3", func(t *testing.T) {
+		_, err := taskList.AddTask(&todo.Task{Name: "Bad", Priority: "P4"})
+
+		var validationError *todo.ValidationError
+		if !errors.As(err, &validationError) {
+			t.Fatalf("got error %v, want a validation error", err)
 		}
+		want := []todo.FieldError{{Field: "priority", Reason: "must be one of P0 P1 P2 P3"}}
+		if !reflect.DeepEqual(validationError.Fields, want) {
+			t.Errorf("got %+v, want %+v", validationError.Fields, want)
+		}
+	})
 
-		AssertTaskListsEqual(t, got, want)
+	t.Run("Outstanding tasks ranked by priority then due date", func(t *testing.T) {
+		got, err := taskList.GetOutstandingRanked(todo.DefaultListId)
+		AssertNoError(t, err)
+
+		gotNames := []string{}
+		for _, task := range got {
+			gotNames = append(gotNames, task.Name)
+		}
+		want := []string{"Urgent", "Sooner", "Soon", "Whenever"}
+
+		if !reflect.DeepEqual(gotNames, want) {
+			t.Errorf("got %v, want %v", gotNames, want)
+		}
 	})
 
-	t.Run("Delete a task", func(t *testing.T) {
-		err := storage.Delete(2)
+	t.Run("Priorities cycle back to none", func(t *testing.T) {
+		got := []todo.Priority{}
+		for p := todo.Priority(""); len(got) < 5; p = p.Next() {
+			got = append(got, p)
+		}
+		want := []todo.Priority{"", todo.P0, todo.P1, todo.P2, todo.P3}
+
+		if !reflect.DeepEqual(got, want) || todo.P3.Next() != "" {
+			t.Errorf("got %v, want %v then back to none", got, want)
+		}
+	})
+}
+
+func TestRecurrence(t *testing.T) {
+	t.Run("Rules are parsed into their canonical form", func(t *testing.T) {
+		cases := map[string]string{
+			"FREQ=DAILY":                      "FREQ=DAILY",
+			"rrule:freq=daily;interval=3":     "FREQ=DAILY;INTERVAL=3",
+			"FREQ=WEEKLY;BYDAY=TH,MO":         "FREQ=WEEKLY;BYDAY=MO,TH",
+			"INTERVAL=1;FREQ=MONTHLY":         "FREQ=MONTHLY",
+			"FREQ=WEEKLY;INTERVAL=2;BYDAY=SU": "FREQ=WEEKLY;INTERVAL=2;BYDAY=SU",
+		}
+		for rule, want := range cases {
+			got, err := todo.ParseRecurrence(rule)
+			AssertNoError(t, err)
+			if got.String() != want {
+				t.Errorf("got %q for %q, want %q", got, rule, want)
+			}
+		}
+	})
+
+	t.Run("Invalid rules are rejected", func(t *testing.T) {
+		for _, rule := range []string{"", "FREQ=HOURLY", "FREQ=DAILY;INTERVAL=0",
+			"FREQ=DAILY;BYDAY=MO", "FREQ=WEEKLY;BYDAY=XX", "FREQ=DAILY;COUNT=3", "DAILY"} {
+			_, err := todo.ParseRecurrence(rule)
+			if err == nil {
+				t.Errorf("got no error for %q", rule)
+			}
+		}
+	})
+
+	t.Run("Next occurrence", func(t *testing.T) {
+		// Saturday.
+		from := time.Date(2022, 10, 1, 9, 0, 0, 0, time.UTC)
+		cases := []struct {
+			rule string
+			from time.Time
+			want time.Time
+		}{
+			{"FREQ=DAILY;INTERVAL=3", from, time.Date(2022, 10, 4, 9, 0, 0, 0, time.UTC)},
+			{"FREQ=WEEKLY", from, time.Date(2022, 10, 8, 9, 0, 0, 0, time.UTC)},
+			{"FREQ=WEEKLY;BYDAY=MO,TH", from, time.Date(2022, 10, 3, 9, 0, 0, 0, time.UTC)},
+			{"FREQ=WEEKLY;BYDAY=MO,TH", time.Date(2022, 10, 3, 9, 0, 0, 0, time.UTC),
+				time.Date(2022, 10, 6, 9, 0, 0, 0, time.UTC)},
+			{"FREQ=WEEKLY;INTERVAL=2;BYDAY=MO,SA", from, time.Date(2022, 10, 10, 9, 0, 0, 0, time.UTC)},
+			{"FREQ=WEEKLY;INTERVAL=2;BYDAY=MO,SA", time.Date(2022, 10, 10, 9, 0, 0, 0, time.UTC),
+				time.Date(2022, 10, 15, 9, 0, 0, 0, time.UTC)},
+			{"FREQ=MONTHLY", time.Date(2023, 1, 31, 9, 0, 0, 0, time.UTC), time.Date(2023, 2, 28, 9, 0, 0, 0, time.UTC)},
+			{"FREQ=MONTHLY;INTERVAL=12", time.Date(2024, 2, 29, 9, 0, 0, 0, time.UTC), time.Date(2025, 2, 28, 9, 0, 0, 0, time.UTC)},
+		}
+		for _, c := range cases {
+			rule, err := todo.ParseRecurrence(c.rule)
+			AssertNoError(t, err)
+			got := rule.Next(c.from)
+			if !got.Equal(c.want) {
+				t.Errorf("%s after %v: got %v, want %v", c.rule, c.from, got, c.want)
+			}
+		}
+	})
+
+	t.Run("Rules are described in words", func(t *testing.T) {
+		rule, _ := todo.ParseRecurrence("FREQ=WEEKLY;INTERVAL=2;BYDAY=TH,MO")
+		got := rule.Describe()
+		want := "every 2 weeks on Mon, Thu"
+		if got != want {
+			t.Errorf("got %q, want %q", got, want)
+		}
+	})
+}
+
+func TestRecurringTasks(t *testing.T) {
+	now := time.Date(2022, 10, 1, 12, 0, 0, 0, time.UTC)
+	due := now.Add(-time.Hour)
+	remindAt := due.Add(-30 * time.Minute)
+
+	storage := storage.CreateMemoryTaskStorage([]todo.Task{})
+	taskList := todo.CreateTaskList(storage)
+	taskList.SetClock(func() time.Time { return now })
+
+	taskList.AddTask(&todo.Task{Name: "Water plants", Due: &due, RemindAt: &remindAt,
+		Tags: []string{"home"}, Priority: todo.P2, Recurrence: "freq=daily;interval=2"})
+	taskList.Add("Other")
+
+	t.Run("Completing a recurring task adds the next occurrence after it", func(t *testing.T) {
+		task, _ := taskList.GetOne(1)
+		AssertNoError(t, taskList.Complete(&task))
+		AssertNoError(t, taskList.Complete(&task))
+
+		tasks, _ := taskList.GetAll()
+		if len(tasks) != 3 {
+			t.Fatalf("got %d tasks, want 3", len(tasks))
+		}
+
+		nextDue := due.AddDate(0, 0, 2)
+		nextRemindAt := remindAt.AddDate(0, 0, 2)
+		want := todo.Task{Id: 3, Name: "Water plants", ListId: 1, Due: &nextDue, RemindAt: &nextRemindAt,
+			CreatedAt: &now, Tags: []string{"home"}, Priority: todo.P2, Position: "VV",
+			Recurrence: "FREQ=DAILY;INTERVAL=2"}
+		if !reflect.DeepEqual(tasks[1], want) {
+			t.Errorf("got %+v, want %+v", tasks[1], want)
+		}
+	})
+
+	t.Run("Completing by update adds the next occurrence", func(t *testing.T) {
+		task, _ := taskList.GetOne(3)
+		task.Complete = true
+		AssertNoError(t, taskList.Update(&task))
+
+		got, _ := taskList.GetOne(4)
+		if got.Complete || got.Recurrence != task.Recurrence {
+			t.Errorf("got %+v, want an incomplete occurrence of %+v", got, task)
+		}
+	})
+
+	t.Run("Overdue occurrences are skipped", func(t *testing.T) {
+		longAgo := now.AddDate(0, 0, -10)
+		id, _ := taskList.AddTask(&todo.Task{Name: "Weekly", Due: &longAgo, Recurrence: "FREQ=WEEKLY"})
+		task, _ := taskList.GetOne(id)
+		AssertNoError(t, taskList.Complete(&task))
+
+		got, _ := taskList.GetOne(id + 1)
+		want := longAgo.AddDate(0, 0, 14)
+		if got.Due == nil || !got.Due.Equal(want) {
+			t.Errorf("got due %v, want %v", got.Due, want)
+		}
+	})
+
+	t.Run("Invalid rules are validation errors", func(t *testing.T) {
+		_, err := taskList.AddTask(&todo.Task{Name: "Bad", Recurrence: "FREQ=HOURLY"})
+
+		var validationError *todo.ValidationError
+		if !errors.As(err, &validationError) {
+			t.Fatalf("got error %v, want a validation error", err)
+		}
+		want := []todo.FieldError{{Field: "recurrence",
+			Reason: "is not a valid recurrence rule: FREQ must be DAILY, WEEKLY or MONTHLY"}}
+		if !reflect.DeepEqual(validationError.Fields, want) {
+			t.Errorf("got %+v, want %+v", validationError.Fields, want)
+		}
+	})
+}
+
+func TestTrash(t *testing.T) {
+	now := time.Date(2022, 10, 1, 12, 0, 0, 0, time.UTC)
+	later := now.Add(2 * time.Hour)
+
+	storages := testStorages(t)
+
+	for name, taskStorage := range storages {
+		clock := now
+		taskList := todo.CreateTaskList(taskStorage)
+		taskList.SetClock(func() time.Time { return clock })
+
+		taskList.Add("Parent")
+		taskList.AddTask(&todo.Task{Name: "Child", ParentId: 1})
+		taskList.AddTask(&todo.Task{Name: "Grandchild", ParentId: 2})
+		taskList.AddTask(&todo.Task{Name: "Other", Tags: []string{"home"}})
+
+		t.Run(name+" deleted tasks are only in the trash", func(t *testing.T) {
+			task, _ := taskList.GetOne(4)
+			AssertNoError(t, taskList.Delete(&task))
+
+			_, err := taskList.GetOne(4)
+			if !errors.Is(err, todo.ErrNotFound) {
+				t.Errorf("got error %v, want %v", err, todo.ErrNotFound)
+			}
+			tasks, _ := taskList.GetAll()
+			outstanding, _ := taskList.GetOutstanding()
+			tags, _ := taskList.GetTags()
+			results, _ := taskList.Search("other")
+			if len(tasks) != 3 || len(outstanding) != 3 || len(tags) != 0 || len(results) != 0 {
+				t.Errorf("got %v, %v, %v and %v, want the trashed task left out",
+					tasks, outstanding, tags, results)
+			}
+
+			trash, err := taskList.GetTrash()
+			AssertNoError(t, err)
+			want := []todo.Task{{Id: 4, Name: "Other", ListId: 1, CreatedAt: &now,
+				Tags: []string{"home"}, Position: "Y", TrashedAt: &now}}
+			AssertTaskListsEqual(t, trash, want)
+		})
+
+		t.Run(name+" trashed tasks can't be changed", func(t *testing.T) {
+			err := taskStorage.Complete(4, now)
+			if !errors.Is(err, todo.ErrNotFound) {
+				t.Errorf("got error %v, want %v", err, todo.ErrNotFound)
+			}
+		})
+
+		t.Run(name+" subtasks can't be restored without their parent", func(t *testing.T) {
+			clock = now.Add(time.Hour)
+			task, _ := taskList.GetOne(1)
+			AssertNoError(t, taskList.DeleteWithSubtasks(&task))
+
+			trash, _ := taskList.GetTrash()
+			gotIds := []todo.TaskId{}
+			for _, task := range trash {
+				gotIds = append(gotIds, task.Id)
+			}
+			if want := []todo.TaskId{1, 2, 3, 4}; !reflect.DeepEqual(gotIds, want) {
+				t.Errorf("got trash %v, want %v", gotIds, want)
+			}
+
+			_, err := taskList.Restore(2)
+			if !errors.Is(err, todo.ErrParentTrashed) {
+				t.Errorf("got error %v, want %v", err, todo.ErrParentTrashed)
+			}
+		})
+
+		t.Run(name+" restoring a task restores the subtasks deleted with it", func(t *testing.T) {
+			got, err := taskList.Restore(1)
+			AssertNoError(t, err)
+			if got.Name != "Parent" || got.TrashedAt != nil {
+				t.Errorf("got %+v, want the restored parent", got)
+			}
+
+			subtasks, _ := taskList.GetSubtasks(1)
+			if len(subtasks) != 1 || len(subtasks[0].Subtasks) != 1 {
+				t.Errorf("got subtasks %+v, want the child and grandchild", subtasks)
+			}
+			trash, _ := taskList.GetTrash()
+			if len(trash) != 1 {
+				t.Errorf("got trash %+v, want only the other task", trash)
+			}
+		})
+
+		t.Run(name+" restoring a task that isn't in the trash is a not found error", func(t *testing.T) {
+			_, err := taskList.Restore(1)
+			if !errors.Is(err, todo.ErrNotFound) {
+				t.Errorf("got error %v, want %v", err, todo.ErrNotFound)
+			}
+		})
+
+		t.Run(name+" purging deletes tasks trashed before the retention period", func(t *testing.T) {
+			clock = later.Add(-time.Minute)
+			task, _ := taskList.GetOne(3)
+			AssertNoError(t, taskList.Delete(&task))
+
+			clock = later
+			purged, err := taskList.Purge(time.Hour)
+			AssertNoError(t, err)
+			if purged != 1 {
+				t.Errorf("got %d purged, want 1", purged)
+			}
+
+			trash, _ := taskList.GetTrash()
+			if len(trash) != 1 || trash[0].Id != 3 {
+				t.Errorf("got trash %+v, want only the recently deleted task", trash)
+			}
+		})
+	}
+}
+
+func TestHistory(t *testing.T) {
+	now := time.Date(2022, 10, 1, 12, 0, 0, 0, time.UTC)
+	tomorrow := now.AddDate(0, 0, 1)
+
+	storages := testStorages(t)
+
+	for name, taskStorage := range storages {
+		taskList := todo.CreateTaskList(taskStorage)
+		taskList.SetClock(func() time.Time { return now })
+
+		t.Run(name+" every change to a task is recorded", func(t *testing.T) {
+			taskList.Add("Task 1")
+			taskList.Add("Task 2")
+
+			task, _ := taskList.GetOne(1)
+			task.Name = "Renamed"
+			AssertNoError(t, taskList.Update(&task))
+			task.Due = &tomorrow
+			AssertNoError(t, taskList.Update(&task))
+			AssertNoError(t, taskList.Complete(&task))
+			AssertNoError(t, taskList.Complete(&task))
+			AssertNoError(t, taskList.Reopen(&task))
+			AssertNoError(t, taskList.Reopen(&task))
+			AssertNoError(t, taskList.Move(1, 0, 2))
+			AssertNoError(t, taskList.Delete(&task))
+			_, err := taskList.Restore(1)
+			AssertNoError(t, err)
+
+			page, err := taskList.GetHistory(1, 0, "")
+			AssertNoError(t, err)
+
+			got := []todo.EventType{}
+			for _, event := range page.Events {
+				got = append(got, event.Type)
+			}
+			want := []todo.EventType{todo.EventRestored, todo.EventDeleted, todo.EventMoved,
+				todo.EventReopened, todo.EventCompleted, todo.EventUpdated, todo.EventRenamed,
+				todo.EventCreated}
+			if !reflect.DeepEqual(got, want) {
+				t.Errorf("got %v, want %v", got, want)
+			}
+
+			renamed := page.Events[len(page.Events)-2]
+			wantRenamed := todo.Event{Id: 3, TaskId: 1, Type: todo.EventRenamed, Name: "Renamed",
+				Previous: "Task 1", At: now}
+			if !reflect.DeepEqual(renamed, wantRenamed) {
+				t.Errorf("got %+v, want %+v", renamed, wantRenamed)
+			}
+		})
+
+		t.Run(name+" activity is paged", func(t *testing.T) {
+			first, err := taskList.GetActivity(4, "")
+			AssertNoError(t, err)
+			second, err := taskList.GetActivity(4, first.NextCursor)
+			AssertNoError(t, err)
+			last, err := taskList.GetActivity(4, second.NextCursor)
+			AssertNoError(t, err)
+
+			got := []todo.EventId{}
+			for _, page := range []todo.EventPage{first, second, last} {
+				for _, event := range page.Events {
+					got = append(got, event.Id)
+				}
+			}
+			want := []todo.EventId{9, 8, 7, 6, 5, 4, 3, 2, 1}
+			if !reflect.DeepEqual(got, want) || last.NextCursor != "" || last.PrevCursor == "" {
+				t.Errorf("got %v ending with cursors %q and %q, want %v and only a previous page",
+					got, last.NextCursor, last.PrevCursor, want)
+			}
+		})
+
+		t.Run(name+" history of a missing task is a not found error", func(t *testing.T) {
+			_, err := taskList.GetHistory(9, 0, "")
+			if !errors.Is(err, todo.ErrNotFound) {
+				t.Errorf("got error %v, want %v", err, todo.ErrNotFound)
+			}
+		})
+	}
+}
+
+// accountStorage is storage that also keeps user accounts, as both the
+// SQLite and memory storage do.
+type accountStorage interface {
+	todo.TaskStorage
+	todo.UserStorage
+}
+
+// testStorages returns empty memory storage and, in builds with cgo, empty
+// SQLite storage, for the tests that check both behave alike.
+func testStorages(t testing.TB) map[string]accountStorage {
+	t.Helper()
+	storages := map[string]accountStorage{"memory": storage.CreateMemoryTaskStorage([]todo.Task{})}
+	if sqliteStorage := createSqlite3Storage(t); sqliteStorage != nil {
+		storages["sqlite"] = sqliteStorage
+	}
+	return storages
+}
+
+func TestAccounts(t *testing.T) {
+	now := time.Date(2022, 10, 1, 12, 0, 0, 0, time.UTC)
+
+	storages := testStorages(t)
+
+	for name, taskStorage := range storages {
+		accounts := todo.CreateAccounts(taskStorage)
+		accounts.SetClock(func() time.Time { return now })
+
+		t.Run(name+" a registered user can log in", func(t *testing.T) {
+			user, err := accounts.Register("alice", "correct horse")
+			AssertNoError(t, err)
+
+			token, session, err := accounts.Login("Alice", "correct horse")
+			AssertNoError(t, err)
+			if !session.ExpiresAt.Equal(now.Add(todo.SessionDuration)) {
+				t.Errorf("got session expiring at %v, want %v", session.ExpiresAt, now.Add(todo.SessionDuration))
+			}
+
+			got, err := accounts.Authenticate(token)
+			AssertNoError(t, err)
+			if got.Id != user.Id || got.Username != "alice" {
+				t.Errorf("got user %+v, want %+v", got, user)
+			}
+		})
+
+		t.Run(name+" a username can't be registered twice", func(t *testing.T) {
+			_, err := accounts.Register("ALICE", "another password")
+			if !errors.Is(err, todo.ErrUsernameTaken) {
+				t.Errorf("got error %v, want %v", err, todo.ErrUsernameTaken)
+			}
+		})
+
+		t.Run(name+" short passwords and invalid usernames are rejected", func(t *testing.T) {
+			for _, credentials := range [][2]string{{"bob", "short"}, {"b", "long enough"}, {"bob smith", "long enough"}} {
+				_, err := accounts.Register(credentials[0], credentials[1])
+				var validationErr *todo.ValidationError
+				if !errors.As(err, &validationErr) {
+					t.Errorf("registering %q got error %v, want a validation error", credentials, err)
+				}
+			}
+		})
+
+		t.Run(name+" a wrong password or username can't log in", func(t *testing.T) {
+			for _, credentials := range [][2]string{{"alice", "wrong password"}, {"nobody", "correct horse"}} {
+				_, _, err := accounts.Login(credentials[0], credentials[1])
+				if !errors.Is(err, todo.ErrInvalidLogin) {
+					t.Errorf("logging in as %q got error %v, want %v", credentials, err, todo.ErrInvalidLogin)
+				}
+			}
+		})
+
+		t.Run(name+" sessions end on logging out or expiring", func(t *testing.T) {
+			token, _, err := accounts.Login("alice", "correct horse")
+			AssertNoError(t, err)
+			AssertNoError(t, accounts.Logout(token))
+			_, err = accounts.Authenticate(token)
+			if !errors.Is(err, todo.ErrUnauthorized) {
+				t.Errorf("got error %v after logging out, want %v", err, todo.ErrUnauthorized)
+			}
+
+			token, _, err = accounts.Login("alice", "correct horse")
+			AssertNoError(t, err)
+			accounts.SetClock(func() time.Time { return now.Add(todo.SessionDuration) })
+			defer accounts.SetClock(func() time.Time { return now })
+			_, err = accounts.Authenticate(token)
+			if !errors.Is(err, todo.ErrUnauthorized) {
+				t.Errorf("got error %v after expiring, want %v", err, todo.ErrUnauthorized)
+			}
+		})
+	}
+}
+
+func TestUserIsolation(t *testing.T) {
+	storages := testStorages(t)
+
+	for name, taskStorage := range storages {
+		taskList := todo.CreateTaskList(taskStorage)
+		taskList.Add("Task from before accounts")
+
+		accounts := todo.CreateAccounts(taskStorage)
+		alice, err := accounts.Register("alice", "correct horse")
+		AssertNoError(t, err)
+		bob, err := accounts.Register("bob", "battery staple")
 		AssertNoError(t, err)
+		aliceTasks := taskList.ForUser(alice.Id)
+		bobTasks := taskList.ForUser(bob.Id)
+
+		t.Run(name+" the first user owns the existing tasks", func(t *testing.T) {
+			got, err := aliceTasks.GetAll()
+			AssertNoError(t, err)
+			if len(got) != 1 || got[0].OwnerId != alice.Id {
+				t.Errorf("got %+v, want the existing task owned by alice", got)
+			}
+		})
+
+		t.Run(name+" users only see their own tasks", func(t *testing.T) {
+			_, err := bobTasks.Add("Bob's task")
+			AssertNoError(t, err)
+
+			got, err := bobTasks.GetAll()
+			AssertNoError(t, err)
+			if len(got) != 1 || got[0].Name != "Bob's task" {
+				t.Errorf("got %+v, want only bob's task", got)
+			}
+
+			_, err = bobTasks.GetOne(1)
+			if !errors.Is(err, todo.ErrNotFound) {
+				t.Errorf("got error %v getting alice's task, want %v", err, todo.ErrNotFound)
+			}
+			task := todo.Task{Id: 1, Name: "Taken over"}
+			err = bobTasks.Update(&task)
+			if !errors.Is(err, todo.ErrNotFound) {
+				t.Errorf("got error %v updating alice's task, want %v", err, todo.ErrNotFound)
+			}
+		})
+
+		t.Run(name+" users only see their own lists and the default list", func(t *testing.T) {
+			_, err := aliceTasks.AddList("Work")
+			AssertNoError(t, err)
+
+			got, err := bobTasks.GetLists()
+			AssertNoError(t, err)
+			want := []todo.List{{Id: todo.DefaultListId, Name: "Tasks"}}
+			if !reflect.DeepEqual(got, want) {
+				t.Errorf("got %+v, want %+v", got, want)
+			}
+
+			_, err = bobTasks.AddToList(2, "Sneaky")
+			var validationErr *todo.ValidationError
+			if !errors.As(err, &validationErr) {
+				t.Errorf("got error %v adding to alice's list, want a validation error", err)
+			}
+		})
+
+		t.Run(name+" users only see the history of their own tasks", func(t *testing.T) {
+			page, err := bobTasks.GetActivity(0, "")
+			AssertNoError(t, err)
+			if len(page.Events) != 1 || page.Events[0].Name != "Bob's task" {
+				t.Errorf("got %+v, want only the event of bob's task", page.Events)
+			}
+		})
+	}
+}
 
-		got, _ := storage.GetAll()
+func TestAPITokens(t *testing.T) {
+	storages := testStorages(t)
 
-		want := []todo.Task{
-			{Id: 1, Name: "Task 1", Complete: true},
-			{Id: 3, Name: "Task 3", Complete: false},
-			{Id: 4, Name: "Task 4", Complete: false},
+	for name, taskStorage := range storages {
+		accounts := todo.CreateAccounts(taskStorage)
+		alice, err := accounts.Register("alice", "correct horse")
+		AssertNoError(t, err)
+		var token string
+
+		t.Run(name+" a created token authenticates as its user", func(t *testing.T) {
+			var apiToken todo.APIToken
+			token, apiToken, err = accounts.CreateAPIToken(alice.Id, "CI", []todo.Scope{"write", "read", "write"})
+			AssertNoError(t, err)
+			if !strings.HasPrefix(token, todo.APITokenPrefix) {
+				t.Errorf("got token %q, want it to start with %q", token, todo.APITokenPrefix)
+			}
+
+			user, got, err := accounts.AuthenticateAPIToken(token)
+			AssertNoError(t, err)
+			want := []todo.Scope{todo.ScopeRead, todo.ScopeWrite}
+			if user.Id != alice.Id || got.Id != apiToken.Id || !reflect.DeepEqual(got.Scopes, want) {
+				t.Errorf("got user %d and token %+v, want alice and scopes %v", user.Id, got, want)
+			}
+		})
+
+		t.Run(name+" tokens need a name and known scopes", func(t *testing.T) {
+			for _, scopes := range [][]todo.Scope{nil, {"admin"}} {
+				_, _, err := accounts.CreateAPIToken(alice.Id, "", scopes)
+				var validationErr *todo.ValidationError
+				if !errors.As(err, &validationErr) || len(validationErr.Fields) != 2 {
+					t.Errorf("got error %v for scopes %v, want errors for the name and scopes", err, scopes)
+				}
+			}
+		})
+
+		t.Run(name+" a revoked token no longer authenticates", func(t *testing.T) {
+			tokens, err := accounts.GetAPITokens(alice.Id)
+			AssertNoError(t, err)
+			if len(tokens) != 1 || tokens[0].Name != "CI" {
+				t.Fatalf("got tokens %+v, want the CI token", tokens)
+			}
+
+			err = accounts.RevokeAPIToken(alice.Id+1, tokens[0].Id)
+			if !errors.Is(err, todo.ErrNotFound) {
+				t.Errorf("got error %v revoking another user's token, want %v", err, todo.ErrNotFound)
+			}
+			AssertNoError(t, accounts.RevokeAPIToken(alice.Id, tokens[0].Id))
+
+			_, _, err = accounts.AuthenticateAPIToken(token)
+			if !errors.Is(err, todo.ErrInvalidAPIToken) {
+				t.Errorf("got error %v, want %v", err, todo.ErrInvalidAPIToken)
+			}
+			tokens, err = accounts.GetAPITokens(alice.Id)
+			AssertNoError(t, err)
+			if len(tokens) != 0 {
+				t.Errorf("got tokens %+v after revoking, want none", tokens)
+			}
+		})
+	}
+}
+
+func TestSharedLists(t *testing.T) {
+	storages := testStorages(t)
+
+	for name, taskStorage := range storages {
+		taskList := todo.CreateTaskList(taskStorage)
+		accounts := todo.CreateAccounts(taskStorage)
+		users := map[string]todo.User{}
+		for _, username := range []string{"alice", "bob", "carol"} {
+			var err error
+			users[username], err = accounts.Register(username, "correct horse")
+			AssertNoError(t, err)
 		}
+		alice := taskList.ForUser(users["alice"].Id)
+		bob := taskList.ForUser(users["bob"].Id)
+		carol := taskList.ForUser(users["carol"].Id)
 
-		AssertTaskListsEqual(t, got, want)
+		team, err := alice.AddList("Team")
+		AssertNoError(t, err)
+		_, err = alice.AddToList(team, "Plan sprint")
+		AssertNoError(t, err)
+
+		t.Run(name+" only owners can share a list", func(t *testing.T) {
+			_, err := alice.ShareList(team, users["bob"], todo.RoleViewer)
+			AssertNoError(t, err)
+			_, err = alice.ShareList(team, users["carol"], todo.RoleEditor)
+			AssertNoError(t, err)
+
+			_, err = carol.ShareList(team, users["bob"], todo.RoleOwner)
+			if !errors.Is(err, todo.ErrForbidden) {
+				t.Errorf("got error %v sharing as an editor, want %v", err, todo.ErrForbidden)
+			}
+			_, err = alice.ShareList(todo.DefaultListId, users["bob"], todo.RoleViewer)
+			if !errors.Is(err, todo.ErrShareDefaultList) {
+				t.Errorf("got error %v sharing the default list, want %v", err, todo.ErrShareDefaultList)
+			}
+			_, err = alice.ShareList(team, users["bob"], "admin")
+			var validationErr *todo.ValidationError
+			if !errors.As(err, &validationErr) {
+				t.Errorf("got error %v for an unknown role, want a validation error", err)
+			}
+
+			members, err := bob.GetMembers(team)
+			AssertNoError(t, err)
+			want := []todo.Member{
+				{UserId: users["alice"].Id, Username: "alice", Role: todo.RoleOwner},
+				{UserId: users["bob"].Id, Username: "bob", Role: todo.RoleViewer},
+				{UserId: users["carol"].Id, Username: "carol", Role: todo.RoleEditor},
+			}
+			if !reflect.DeepEqual(members, want) {
+				t.Errorf("got members %+v, want %+v", members, want)
+			}
+		})
+
+		t.Run(name+" viewers can see a list's tasks but not change them", func(t *testing.T) {
+			tasks, err := bob.GetListTasks(team)
+			AssertNoError(t, err)
+			if len(tasks) != 1 || tasks[0].Name != "Plan sprint" {
+				t.Fatalf("got %+v, want the shared task", tasks)
+			}
+
+			_, err = bob.AddToList(team, "Skip sprint")
+			if !errors.Is(err, todo.ErrForbidden) {
+				t.Errorf("got error %v adding a task, want %v", err, todo.ErrForbidden)
+			}
+			err = bob.Complete(&tasks[0])
+			if !errors.Is(err, todo.ErrForbidden) {
+				t.Errorf("got error %v completing a task, want %v", err, todo.ErrForbidden)
+			}
+			err = bob.Delete(&tasks[0])
+			if !errors.Is(err, todo.ErrForbidden) {
+				t.Errorf("got error %v deleting a task, want %v", err, todo.ErrForbidden)
+			}
+		})
+
+		t.Run(name+" editors can change a list's tasks but not delete it", func(t *testing.T) {
+			_, err := carol.AddToList(team, "Write retro")
+			AssertNoError(t, err)
+			task, err := carol.GetOne(1)
+			AssertNoError(t, err)
+			AssertNoError(t, carol.Complete(&task))
+
+			page, err := alice.GetHistory(task.Id, 1, "")
+			AssertNoError(t, err)
+			if len(page.Events) != 1 || page.Events[0].ActorId != users["carol"].Id ||
+				page.Events[0].Actor != "carol" {
+				t.Errorf("got events %+v, want carol's completion", page.Events)
+			}
+
+			tasks, err := alice.GetListTasks(team)
+			AssertNoError(t, err)
+			if len(tasks) != 2 || !tasks[0].Complete {
+				t.Errorf("got %+v, want both tasks with the first complete", tasks)
+			}
+
+			list, err := carol.GetList(team)
+			AssertNoError(t, err)
+			err = carol.DeleteList(&list)
+			if !errors.Is(err, todo.ErrForbidden) {
+				t.Errorf("got error %v deleting the list, want %v", err, todo.ErrForbidden)
+			}
+		})
+
+		t.Run(name+" removed members no longer see the list", func(t *testing.T) {
+			AssertNoError(t, bob.Unshare(team, users["bob"].Id))
+			err := bob.Unshare(team, users["carol"].Id)
+			if !errors.Is(err, todo.ErrNotFound) {
+				t.Errorf("got error %v removing someone else after leaving, want %v", err, todo.ErrNotFound)
+			}
+			AssertNoError(t, alice.Unshare(team, users["carol"].Id))
+
+			for _, user := range []*todo.TaskList{bob, carol} {
+				tasks, err := user.GetAll()
+				AssertNoError(t, err)
+				lists, err := user.GetLists()
+				AssertNoError(t, err)
+				if len(tasks) != 0 || len(lists) != 1 {
+					t.Errorf("got tasks %+v and lists %+v, want only the default list", tasks, lists)
+				}
+			}
+		})
+	}
+}
+
+func TestPositionBetween(t *testing.T) {
+	cases := []struct {
+		lower, upper string
+	}{
+		{"", ""},
+		{"V", ""},
+		{"z", ""},
+		{"zz", ""},
+		{"", "V"},
+		{"", "1"},
+		{"", "01"},
+		{"V", "W"},
+		{"V", "V1"},
+		{"Vz", "W"},
+		{"00000000011", "00000000021"},
+		{"1", "2"},
+		{"19", "2"},
+	}
+
+	for _, c := range cases {
+		got := todo.PositionBetween(c.lower, c.upper)
+
+		if got <= c.lower || (c.upper != "" && got >= c.upper) {
+			t.Errorf("PositionBetween(%q, %q) = %q, which is out of order", c.lower, c.upper, got)
+		}
+		if strings.HasSuffix(got, "0") {
+			t.Errorf("PositionBetween(%q, %q) = %q, which ends in 0", c.lower, c.upper, got)
+		}
+	}
+
+	t.Run("Repeatedly inserting at the front", func(t *testing.T) {
+		upper := "V"
+		for i := 0; i < 100; i++ {
+			got := todo.PositionBetween("", upper)
+			if got >= upper || strings.HasSuffix(got, "0") {
+				t.Fatalf("PositionBetween(%q, %q) = %q", "", upper, got)
+			}
+			upper = got
+		}
+	})
+
+	t.Run("Repeatedly appending", func(t *testing.T) {
+		// Starting from keys older versions appended after a few dozen tasks
+		// as well as from nothing.
+		for _, lower := range []string{"", "zV", "zzW"} {
+			for i := 0; i < 100000; i++ {
+				got := todo.PositionBetween(lower, "")
+				if got <= lower || strings.HasSuffix(got, "0") {
+					t.Fatalf("PositionBetween(%q, %q) = %q", lower, "", got)
+				}
+				lower = got
+			}
+			if len(lower) > 7 {
+				t.Errorf("got %q after 100000 keys, want at most 7 characters", lower)
+			}
+		}
+	})
+}
+
+// TestAppendedPositions checks that the positions of tasks added one after
+// another stay short, and that each list's tasks start from the same key.
+func TestAppendedPositions(t *testing.T) {
+	for name, taskStorage := range testStorages(t) {
+		t.Run(name, func(t *testing.T) {
+			taskList := todo.CreateTaskList(taskStorage)
+			for i := 0; i < 3000; i++ {
+				_, err := taskList.Add(fmt.Sprintf("Task %d", i+1))
+				AssertNoError(t, err)
+			}
+			listId, err := taskList.AddList("Other")
+			AssertNoError(t, err)
+			other, err := taskList.AddToList(listId, "Other task")
+			AssertNoError(t, err)
+
+			tasks, err := taskList.GetAll()
+			AssertNoError(t, err)
+			last := ""
+			for _, task := range tasks {
+				if task.Id == other {
+					if task.Position != "V" {
+						t.Errorf("got position %q for the first task in a list, want %q", task.Position, "V")
+					}
+					continue
+				}
+				if task.Position <= last || len(task.Position) > 4 {
+					t.Fatalf("got position %q after %q, want a later one of at most 4 characters",
+						task.Position, last)
+				}
+				last = task.Position
+			}
+		})
+	}
+}
+
+func TestMove(t *testing.T) {
+	storages := testStorages(t)
+
+	cases := []struct {
+		name          string
+		id            todo.TaskId
+		before, after todo.TaskId
+		want          []todo.TaskId
+	}{
+		{"to the front", 3, 1, 0, []todo.TaskId{3, 1, 2, 4}},
+		{"to the end", 3, 0, 4, []todo.TaskId{1, 2, 4, 3}},
+		{"between two tasks", 4, 2, 1, []todo.TaskId{1, 4, 2, 3}},
+		{"after a task", 1, 0, 2, []todo.TaskId{2, 1, 3, 4}},
+		{"before a task", 4, 3, 0, []todo.TaskId{1, 2, 4, 3}},
+	}
+
+	for name, taskStorage := range storages {
+		taskList := todo.CreateTaskList(taskStorage)
+
+		for _, c := range cases {
+			t.Run(name+" "+c.name, func(t *testing.T) {
+				// Start each case from the same order.
+				tasks, _ := taskList.GetAll()
+				for _, task := range tasks {
+					taskList.Delete(&task)
+				}
+				for i := 0; i < 4; i++ {
+					taskList.Add(fmt.Sprintf("Task %d", i+1))
+				}
+				tasks, _ = taskList.GetAll()
+				ids := map[todo.TaskId]todo.TaskId{}
+				for i, task := range tasks {
+					ids[todo.TaskId(i+1)] = task.Id
+				}
+
+				err := taskList.Move(ids[c.id], ids[c.before], ids[c.after])
+				AssertNoError(t, err)
+
+				got, _ := taskList.GetAll()
+				gotIds := []todo.TaskId{}
+				for _, task := range got {
+					for i, id := range ids {
+						if id == task.Id {
+							gotIds = append(gotIds, i)
+						}
+					}
+				}
+
+				if !reflect.DeepEqual(gotIds, c.want) {
+					t.Errorf("got %v, want %v", gotIds, c.want)
+				}
+			})
+		}
+
+		t.Run(name+" after a task that comes later is a validation error", func(t *testing.T) {
+			tasks, _ := taskList.GetAll()
+
+			err := taskList.Move(tasks[0].Id, tasks[1].Id, tasks[2].Id)
+			if !errors.Is(err, todo.ErrValidation) {
+				t.Errorf("got error %v, want %v", err, todo.ErrValidation)
+			}
+		})
+	}
+
+	t.Run("Moving next to a missing task is a validation error", func(t *testing.T) {
+		taskList := todo.CreateTaskList(storages["memory"])
+		tasks, _ := taskList.GetAll()
+
+		err := taskList.Move(tasks[0].Id, 99, 0)
+		if !errors.Is(err, todo.ErrValidation) {
+			t.Errorf("got error %v, want %v", err, todo.ErrValidation)
+		}
+	})
+}
+
+func TestQuery(t *testing.T) {
+	created := time.Date(2022, 10, 1, 12, 0, 0, 0, time.UTC)
+	later := created.Add(time.Hour)
+	due := created.AddDate(0, 0, 1)
+
+	tasks := []todo.Task{
+		{Name: "banana", ListId: 1, CreatedAt: &later, Tags: []string{"fruit"}, Priority: todo.P2},
+		{Name: "Apple pie", ListId: 1, Complete: true, Due: &due, CreatedAt: &created, Tags: []string{"baking", "fruit"}, Priority: todo.P1},
+		{Name: "cherry", ListId: 2, Due: &created, Priority: todo.P1},
+		{Name: "apple_sauce", ListId: 1, CreatedAt: &created, Tags: []string{"fruit"}},
+	}
+
+	storages := testStorages(t)
+	for _, taskStorage := range storages {
+		taskStorage.AddList(&todo.List{Name: "Work"})
+		for _, task := range tasks {
+			task := task
+			taskStorage.Add(&task)
+		}
+	}
+
+	incomplete := false
+	cases := []struct {
+		name  string
+		query todo.TaskQuery
+		want  []todo.TaskId
+	}{
+		{"everything in id order", todo.TaskQuery{}, []todo.TaskId{1, 2, 3, 4}},
+		{"by name ignoring case", todo.TaskQuery{Sort: todo.SortByName}, []todo.TaskId{2, 4, 1, 3}},
+		{"by name descending", todo.TaskQuery{Sort: todo.SortByName, Descending: true}, []todo.TaskId{3, 1, 4, 2}},
+		{"by due with undated tasks last", todo.TaskQuery{Sort: todo.SortByDue}, []todo.TaskId{3, 2, 1, 4}},
+		{"by due descending with undated tasks last", todo.TaskQuery{Sort: todo.SortByDue, Descending: true}, []todo.TaskId{2, 3, 4, 1}},
+		{"by creation time", todo.TaskQuery{Sort: todo.SortByCreated}, []todo.TaskId{3, 2, 4, 1}},
+		{"by priority then due date", todo.TaskQuery{Sort: todo.SortByPriority}, []todo.TaskId{3, 2, 1, 4}},
+		{"by priority descending with no priority last", todo.TaskQuery{Sort: todo.SortByPriority, Descending: true}, []todo.TaskId{1, 2, 3, 4}},
+		{"incomplete only", todo.TaskQuery{Complete: &incomplete}, []todo.TaskId{1, 3, 4}},
+		{"name containing a wildcard character", todo.TaskQuery{NameContains: "E_S"}, []todo.TaskId{4}},
+		{"name containing a substring", todo.TaskQuery{NameContains: "apple"}, []todo.TaskId{2, 4}},
+		{"in a list", todo.TaskQuery{ListId: 2}, []todo.TaskId{3}},
+		{"with a tag", todo.TaskQuery{Tag: "fruit"}, []todo.TaskId{1, 2, 4}},
+		{"with a tag and a name", todo.TaskQuery{Tag: "baking", NameContains: "apple"}, []todo.TaskId{2}},
+		{"a page", todo.TaskQuery{Limit: 2, Offset: 1}, []todo.TaskId{2, 3}},
+		{"past the end", todo.TaskQuery{Limit: 2, Offset: 4}, []todo.TaskId{}},
+	}
+
+	for name, taskStorage := range storages {
+		for _, c := range cases {
+			t.Run(name+" "+c.name, func(t *testing.T) {
+				got, err := taskStorage.Query(c.query)
+				AssertNoError(t, err)
+
+				gotIds := []todo.TaskId{}
+				for _, task := range got {
+					gotIds = append(gotIds, task.Id)
+				}
+
+				if !reflect.DeepEqual(gotIds, c.want) {
+					t.Errorf("got %v, want %v", gotIds, c.want)
+				}
+			})
+		}
+	}
+}
+
+func TestSearch(t *testing.T) {
+	tasks := []todo.Task{
+		{Name: "Release version 1.2", ListId: 1},
+		{Name: "Write release notes", ListId: 1},
+		{Name: "apple_sauce recipe", ListId: 1},
+	}
+
+	storages := testStorages(t)
+	for _, taskStorage := range storages {
+		for _, task := range tasks {
+			task := task
+			taskStorage.Add(&task)
+		}
+		taskStorage.Update(&todo.Task{Id: 2, Name: "Write changelog", ListId: 1})
+	}
+
+	cases := []struct {
+		name  string
+		query string
+		want  map[todo.TaskId]string
+	}{
+		{"a whole word", "version", map[todo.TaskId]string{1: "Release <mark>version</mark> 1.2"}},
+		{"the start of a word ignoring case", "REL", map[todo.TaskId]string{1: "<mark>Release</mark> version 1.2"}},
+		{"every term", "rel 2", map[todo.TaskId]string{1: "<mark>Release</mark> version 1.<mark>2</mark>"}},
+		{"words split by punctuation", "sauce", map[todo.TaskId]string{3: "apple_<mark>sauce</mark> recipe"}},
+		{"query syntax is searched for literally", `"NOT" recipe*`, map[todo.TaskId]string{}},
+		{"renamed tasks", "changelog", map[todo.TaskId]string{2: "Write <mark>changelog</mark>"}},
+		{"no match in the middle of a word", "lease", map[todo.TaskId]string{}},
+	}
+
+	for name, taskStorage := range storages {
+		for _, c := range cases {
+			t.Run(name+" "+c.name, func(t *testing.T) {
+				results, err := taskStorage.Search(c.query)
+				AssertNoError(t, err)
+
+				// Ranking differs between storage, so only compare the matches.
+				got := map[todo.TaskId]string{}
+				for _, result := range results {
+					got[result.Id] = result.Snippet
+				}
+
+				if !reflect.DeepEqual(got, c.want) {
+					t.Errorf("got %v, want %v", got, c.want)
+				}
+			})
+		}
+	}
+
+	t.Run("Searching for nothing is a validation error", func(t *testing.T) {
+		taskList := todo.CreateTaskList(storages["memory"])
+
+		_, err := taskList.Search(" - ")
+		if !errors.Is(err, todo.ErrValidation) {
+			t.Errorf("got error %v, want %v", err, todo.ErrValidation)
+		}
+	})
+}
+
+func TestQueryPages(t *testing.T) {
+	storage := storage.CreateMemoryTaskStorage([]todo.Task{})
+	taskList := todo.CreateTaskList(storage)
+	for _, name := range []string{"Task 1", "Task 2", "Task 3"} {
+		taskList.Add(name)
+	}
+
+	first, err := taskList.Query(todo.TaskQuery{Limit: 2}, "")
+	AssertNoError(t, err)
+
+	if len(first.Tasks) != 2 || first.NextCursor == "" || first.PrevCursor != "" {
+		t.Fatalf("got first page %+v, want two tasks and only a next cursor", first)
+	}
+
+	second, err := taskList.Query(todo.TaskQuery{Limit: 2}, first.NextCursor)
+	AssertNoError(t, err)
+
+	if len(second.Tasks) != 1 || second.Tasks[0].Id != 3 || second.NextCursor != "" {
+		t.Fatalf("got second page %+v, want task 3 and no next cursor", second)
+	}
+
+	previous, err := taskList.Query(todo.TaskQuery{Limit: 2}, second.PrevCursor)
+	AssertNoError(t, err)
+
+	if !reflect.DeepEqual(previous, first) {
+		t.Errorf("got previous page %+v, want %+v", previous, first)
+	}
+
+	_, err = taskList.Query(todo.TaskQuery{Limit: 2}, "not a cursor")
+	if !errors.Is(err, todo.ErrValidation) {
+		t.Errorf("got error %v, want %v", err, todo.ErrValidation)
+	}
+}
+
+func TestMemoryStorageConformance(t *testing.T) {
+	storagetest.Run(t, func(t *testing.T) todo.TaskStorage {
+		return storage.CreateMemoryTaskStorage(nil)
+	})
+}
+
+func TestMemorySnapshotStorageConformance(t *testing.T) {
+	storagetest.Run(t, func(t *testing.T) todo.TaskStorage {
+		memoryStorage, err := storage.OpenMemoryTaskStorage(filepath.Join(t.TempDir(), "tasks.json"))
+		AssertNoError(t, err)
+		return memoryStorage
+	})
+}
+
+func TestMemorySnapshot(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "tasks.json")
+	saved, err := storage.OpenMemoryTaskStorage(path)
+	AssertNoError(t, err)
+
+	user := todo.User{Username: "alice", PasswordHash: []byte("hash"), CreatedAt: time.Unix(0, 0).UTC()}
+	_, err = saved.AddUser(&user)
+	AssertNoError(t, err)
+	alices := saved.ForUser(user.Id)
+	listId, err := alices.AddList(&todo.List{Name: "Shopping"})
+	AssertNoError(t, err)
+	due := time.Date(2030, 1, 2, 3, 4, 5, 0, time.UTC)
+	taskId, err := alices.Add(&todo.Task{Name: "Milk", ListId: listId, Due: &due, Tags: []string{"dairy"}})
+	AssertNoError(t, err)
+	trashed, err := alices.Add(&todo.Task{Name: "Bread"})
+	AssertNoError(t, err)
+	AssertNoError(t, alices.Trash(trashed, due))
+	_, err = saved.AddAPIToken(&todo.APIToken{UserId: user.Id, Name: "ci", Scopes: []todo.Scope{todo.ScopeRead},
+		TokenHash: "token"})
+	AssertNoError(t, err)
+	_, err = alices.AddEvent(&todo.Event{TaskId: taskId, Type: todo.EventCreated, Name: "Milk", At: due,
+		ActorId: user.Id})
+	AssertNoError(t, err)
+
+	loaded, err := storage.OpenMemoryTaskStorage(path)
+	AssertNoError(t, err)
+
+	for _, s := range []todo.TaskStorage{saved.ForUser(user.Id), loaded.ForUser(user.Id)} {
+		got, err := s.GetTask(taskId)
+		AssertNoError(t, err)
+		want, err := alices.GetTask(taskId)
+		AssertNoError(t, err)
+		if !reflect.DeepEqual(got, want) {
+			t.Errorf("got task %+v, want %+v", got, want)
+		}
+		trash, err := s.GetTrash()
+		AssertNoError(t, err)
+		if len(trash) != 1 || trash[0].Id != trashed {
+			t.Errorf("got trash %+v, want task %d", trash, trashed)
+		}
+	}
+	_, err = loaded.ForUser(user.Id + 1).GetTask(taskId)
+	if !errors.Is(err, todo.ErrNotFound) {
+		t.Errorf("got error %v getting another user's task, want todo.ErrNotFound", err)
+	}
+	gotUser, err := loaded.GetUserByName("alice")
+	AssertNoError(t, err)
+	if string(gotUser.PasswordHash) != "hash" {
+		t.Errorf("got password hash %q, want %q", gotUser.PasswordHash, "hash")
+	}
+	_, err = loaded.GetAPIToken("token")
+	AssertNoError(t, err)
+	events, err := loaded.GetEvents(todo.EventQuery{})
+	AssertNoError(t, err)
+	if len(events) != 1 || events[0].ActorId != user.Id || events[0].Actor != "alice" {
+		t.Errorf("got events %+v, want one by alice", events)
+	}
+
+	// Ids carry on from where they were.
+	id, err := loaded.Add(&todo.Task{Name: "New"})
+	AssertNoError(t, err)
+	if id != trashed+1 {
+		t.Errorf("got id %d, want %d", id, trashed+1)
+	}
+}
+
+// TestBoltStorageConformance also reopens the database after each test,
+// checking that everything was saved.
+func TestBoltStorageConformance(t *testing.T) {
+	storagetest.Run(t, func(t *testing.T) todo.TaskStorage {
+		path := filepath.Join(t.TempDir(), "tasks.bolt")
+		boltStorage, err := storage.OpenBoltTaskStorage(path)
+		AssertNoError(t, err)
+
+		t.Cleanup(func() {
+			lastId, err := boltStorage.Add(&todo.Task{Name: "Last"})
+			AssertNoError(t, err)
+			saved := dumpStorage(t, boltStorage)
+			boltStorage.Close()
+
+			reopened, err := storage.OpenBoltTaskStorage(path)
+			AssertNoError(t, err)
+			defer reopened.Close()
+			if loaded := dumpStorage(t, reopened); !reflect.DeepEqual(loaded, saved) {
+				t.Errorf("got %+v after reopening, want %+v", loaded, saved)
+			}
+			id, err := reopened.Add(&todo.Task{Name: "After reopening"})
+			AssertNoError(t, err)
+			if id != lastId+1 {
+				t.Errorf("got id %d after reopening, want %d", id, lastId+1)
+			}
+		})
+		return boltStorage
+	})
+}
+
+func TestBoltStorageIsLocked(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "tasks.bolt")
+	boltStorage, err := storage.OpenBoltTaskStorage(path)
+	AssertNoError(t, err)
+	defer boltStorage.Close()
+
+	_, err = storage.OpenBoltTaskStorage(path)
+	if err == nil || !strings.Contains(err.Error(), "in use by another process") {
+		t.Errorf("got error %v opening a database that is open, want it to say it is in use", err)
+	}
+}
+
+// storageDump is everything in storage that can be read back, for comparing.
+type storageDump struct {
+	Tasks     []todo.Task
+	Trash     []todo.Task
+	Lists     []todo.List
+	Members   map[todo.ListId][]todo.Member
+	Events    []todo.Event
+	Users     []todo.User
+	APITokens map[todo.UserId][]todo.APIToken
+}
+
+func dumpStorage(t testing.TB, s storage.Storage) storageDump {
+	t.Helper()
+	var dump storageDump
+	var err error
+	dump.Tasks, err = s.GetAll()
+	AssertNoError(t, err)
+	dump.Trash, err = s.GetTrash()
+	AssertNoError(t, err)
+	dump.Lists, err = s.GetLists()
+	AssertNoError(t, err)
+	dump.Events, err = s.GetEvents(todo.EventQuery{})
+	AssertNoError(t, err)
+
+	dump.Members = map[todo.ListId][]todo.Member{}
+	for _, list := range dump.Lists {
+		dump.Members[list.Id], err = s.GetMembers(list.Id)
+		AssertNoError(t, err)
+	}
+	dump.APITokens = map[todo.UserId][]todo.APIToken{}
+	for id := todo.UserId(1); ; id++ {
+		user, err := s.GetUser(id)
+		if errors.Is(err, todo.ErrNotFound) {
+			break
+		}
+		AssertNoError(t, err)
+		dump.Users = append(dump.Users, *user)
+		dump.APITokens[id], err = s.GetAPITokens(id)
+		AssertNoError(t, err)
+	}
+	return dump
+}
+
+// TestPostgresStorageConformance runs against the database in the
+// TODO_TEST_POSTGRES environment variable, giving each test its own schema.
+func TestPostgresStorageConformance(t *testing.T) {
+	dataSource := os.Getenv("TODO_TEST_POSTGRES")
+	if dataSource == "" {
+		t.Skip("TODO_TEST_POSTGRES is not set")
+	}
+	admin, err := sql.Open("postgres", dataSource)
+	AssertNoError(t, err)
+	defer admin.Close()
+
+	schemas := 0
+	storagetest.Run(t, func(t *testing.T) todo.TaskStorage {
+		schemas++
+		schema := fmt.Sprintf("todo_test_%d_%d", os.Getpid(), schemas)
+		_, err := admin.Exec("CREATE SCHEMA " + schema)
+		AssertNoError(t, err)
+		t.Cleanup(func() {
+			admin.Exec("DROP SCHEMA " + schema + " CASCADE")
+		})
+
+		location, err := url.Parse(dataSource)
+		AssertNoError(t, err)
+		query := location.Query()
+		query.Set("search_path", schema)
+		location.RawQuery = query.Encode()
+		postgresStorage, err := storage.CreatePostgresTaskStorage(location.String())
+		AssertNoError(t, err)
+		t.Cleanup(postgresStorage.Close)
+		return postgresStorage
 	})
 }
 
@@ -257,11 +1727,3 @@ func AssertTaskListsEqual(t testing.TB, got []todo.Task, want []todo.Task) {
 		t.Errorf("got %+v, want %+v", got, want)
 	}
 }
-
-func AddTaskToDB(t testing.TB, storage *storage.Sqlite3TaskStorage, name string, status bool) todo.TaskId {
-	t.Helper()
-	task := todo.Task{Name: name, Complete: status}
-	id, err := storage.Add(&task)
-	AssertNoError(t, err)
-	return id
-}
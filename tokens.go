@@ -0,0 +1,145 @@
+package todo
+
+import (
+	"errors"
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+)
+
+// Scope is something an API token is allowed to do.
+type Scope string
+
+const (
+	// ScopeRead allows reading tasks, lists, tags and history.
+	ScopeRead Scope = "read"
+	// ScopeWrite allows adding, changing and deleting them.
+	ScopeWrite Scope = "write"
+)
+
+type APITokenId int64
+
+// APIToken lets a script use the web API as a user without their password.
+// Unlike a session it lasts until it is revoked, and is limited to its
+// scopes.
+type APIToken struct {
+	Id        APITokenId `json:"id"`
+	UserId    UserId     `json:"-"`
+	Name      string     `json:"name" validate:"required,max=100"`
+	Scopes    []Scope    `json:"scopes" validate:"min=1,dive,oneof=read write"`
+	TokenHash string     `json:"-"`
+	CreatedAt time.Time  `json:"created_at"`
+}
+
+func (t *APIToken) Validate() error {
+	return validateStruct(t)
+}
+
+// HasScope reports whether the token is allowed to do what scope covers.
+func (t *APIToken) HasScope(scope Scope) bool {
+	for _, s := range t.Scopes {
+		if s == scope {
+			return true
+		}
+	}
+	return false
+}
+
+// APITokenPrefix starts every API token, telling them apart from session
+// tokens and making them easy to search for if they leak.
+const APITokenPrefix = "todo_"
+
+// ErrForbidden is returned when a request is authenticated but isn't
+// allowed to do what it asks.
+var ErrForbidden = errors.New("forbidden")
+
+var (
+	// ErrSessionRequired is returned when an API token is used for
+	// something only a logged in user can do, such as managing API tokens,
+	// so that a leaked token can't be used to mint more.
+	ErrSessionRequired = fmt.Errorf("%w: this requires logging in rather than an API token", ErrForbidden)
+	// ErrInvalidAPIToken is returned for API tokens that don't exist or have
+	// been revoked.
+	ErrInvalidAPIToken = fmt.Errorf("%w: the API token is invalid or has been revoked", ErrUnauthorized)
+)
+
+func errMissingScope(scope Scope) error {
+	return fmt.Errorf("%w: the token doesn't have the %s scope", ErrForbidden, scope)
+}
+
+// CreateAPIToken adds an API token for a user, returning the token itself,
+// which isn't stored and can't be seen again.
+func (a *Accounts) CreateAPIToken(userId UserId, name string, scopes []Scope) (string, APIToken, error) {
+	apiToken := APIToken{
+		UserId:    userId,
+		Name:      strings.TrimSpace(name),
+		Scopes:    normalizeScopes(scopes),
+		CreatedAt: a.now(),
+	}
+	err := apiToken.Validate()
+	if err != nil {
+		return "", APIToken{}, err
+	}
+
+	token, err := newToken()
+	if err != nil {
+		return "", APIToken{}, err
+	}
+	token = APITokenPrefix + token
+	apiToken.TokenHash = hashToken(token)
+	apiToken.Id, err = a.storage.AddAPIToken(&apiToken)
+	if err != nil {
+		return "", APIToken{}, err
+	}
+	return token, apiToken, nil
+}
+
+// normalizeScopes sorts scopes and removes duplicates.
+func normalizeScopes(scopes []Scope) []Scope {
+	normalized := []Scope{}
+	seen := map[Scope]bool{}
+	for _, scope := range scopes {
+		scope = Scope(strings.ToLower(strings.TrimSpace(string(scope))))
+		if !seen[scope] {
+			seen[scope] = true
+			normalized = append(normalized, scope)
+		}
+	}
+	sort.Slice(normalized, func(i, j int) bool {
+		return normalized[i] < normalized[j]
+	})
+	return normalized
+}
+
+// GetAPITokens returns a user's API tokens, oldest first.
+func (a *Accounts) GetAPITokens(userId UserId) ([]APIToken, error) {
+	return a.storage.GetAPITokens(userId)
+}
+
+// RevokeAPIToken deletes one of a user's API tokens so it can't be used
+// again.
+func (a *Accounts) RevokeAPIToken(userId UserId, id APITokenId) error {
+	return a.storage.DeleteAPIToken(userId, id)
+}
+
+// AuthenticateAPIToken returns the API token matching token, along with the
+// user it belongs to.
+func (a *Accounts) AuthenticateAPIToken(token string) (User, APIToken, error) {
+	apiToken, err := a.storage.GetAPIToken(hashToken(token))
+	if errors.Is(err, ErrNotFound) {
+		return User{}, APIToken{}, ErrInvalidAPIToken
+	}
+	if err != nil {
+		return User{}, APIToken{}, err
+	}
+
+	user, err := a.storage.GetUser(apiToken.UserId)
+	if errors.Is(err, ErrNotFound) {
+		return User{}, APIToken{}, ErrInvalidAPIToken
+	}
+	if err != nil {
+		return User{}, APIToken{}, err
+	}
+	return *user, *apiToken, nil
+}
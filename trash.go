@@ -0,0 +1,77 @@
+package todo
+
+import (
+	"errors"
+	"fmt"
+	"time"
+)
+
+// TrashRetention is how long deleted tasks are kept in the trash before they
+// are purged.
+const TrashRetention = 30 * 24 * time.Hour
+
+var ErrParentTrashed = fmt.Errorf("%w: the task's parent is in the trash", ErrConflict)
+
+// GetTrash returns the tasks in the trash, most recently deleted first.
+func (t *TaskList) GetTrash() ([]Task, error) {
+	return t.storage.GetTrash()
+}
+
+// Restore takes a task out of the trash, along with any subtasks deleted
+// with it. A subtask can't be restored while its parent is in the trash.
+func (t *TaskList) Restore(id TaskId) (Task, error) {
+	trash, err := t.storage.GetTrash()
+	if err != nil {
+		return Task{}, err
+	}
+	var trashed *Task
+	for i := range trash {
+		if trash[i].Id == id {
+			trashed = &trash[i]
+		}
+	}
+	if trashed == nil {
+		return Task{}, fmt.Errorf("task %d in trash: %w", id, ErrNotFound)
+	}
+	err = t.requireRole(trashed.ListId, RoleEditor)
+	if err != nil {
+		return Task{}, err
+	}
+
+	if trashed.ParentId != 0 {
+		_, err = t.storage.GetTask(trashed.ParentId)
+		if errors.Is(err, ErrNotFound) {
+			return Task{}, ErrParentTrashed
+		}
+		if err != nil {
+			return Task{}, err
+		}
+	}
+
+	err = t.storage.Restore(id)
+	if err != nil {
+		return Task{}, err
+	}
+	// Record every task that came back, including subtasks.
+	for _, task := range trash {
+		_, err = t.storage.GetTask(task.Id)
+		if errors.Is(err, ErrNotFound) {
+			continue
+		}
+		if err != nil {
+			return Task{}, err
+		}
+		err = t.record(EventRestored, &task, "")
+		if err != nil {
+			return Task{}, err
+		}
+	}
+	return t.GetOne(id)
+}
+
+// Purge permanently deletes tasks that have been in the trash for longer
+// than retention, returning how many were deleted. A retention of zero
+// empties the trash. Tasks in lists the user can only view are kept.
+func (t *TaskList) Purge(retention time.Duration) (int, error) {
+	return t.storage.Purge(t.now().Add(-retention))
+}
@@ -0,0 +1,108 @@
+const server = "http://localhost:5000";
+
+// api makes a request to the server as the logged in user, whose token is
+// kept in localStorage so that it survives reloading the page.
+export async function api(path, options = {}) {
+  const token = localStorage.getItem("token");
+  const headers = { ...options.headers };
+  if (token) {
+    headers.Authorization = `Bearer ${token}`;
+  }
+  const res = await fetch(server + path, { ...options, headers });
+  if (res.status === 401) {
+    localStorage.removeItem("token");
+  }
+  return res;
+}
+
+export async function login(username, password) {
+  const res = await api("/login", {
+    method: "POST",
+    body: JSON.stringify({ username, password }),
+  });
+  if (!res.ok) {
+    return false;
+  }
+  const { token } = await res.json();
+  localStorage.setItem("token", token);
+  return true;
+}
+
+export async function logout() {
+  await api("/logout", { method: "POST" });
+  localStorage.removeItem("token");
+}
+
+export function loggedIn() {
+  return localStorage.getItem("token") !== null;
+}
+
+// watchChanges calls onChange with the name and data of each change streamed
+// from /events until the returned function is called. EventSource can't send
+// the token, so the stream is read with fetch, reconnecting after a few
+// seconds with the id of the last change so that none are missed.
+export function watchChanges(onChange) {
+  const controller = new AbortController();
+  let lastEventId = "";
+
+  async function read() {
+    const headers = lastEventId ? { "Last-Event-ID": lastEventId } : {};
+    const res = await api("/events", { headers, signal: controller.signal });
+    if (!res.ok) {
+      return;
+    }
+    const reader = res.body.pipeThrough(new TextDecoderStream()).getReader();
+    let buffer = "";
+    for (;;) {
+      const { value, done } = await reader.read();
+      if (done) {
+        return;
+      }
+      buffer += value;
+      const messages = buffer.split("\n\n");
+      buffer = messages.pop();
+      for (const message of messages) {
+        const fields = {};
+        for (const line of message.split("\n")) {
+          const [field, ...rest] = line.split(": ");
+          fields[field] = rest.join(": ");
+        }
+        if (fields.event) {
+          lastEventId = fields.id;
+          onChange(fields.event, JSON.parse(fields.data));
+        }
+      }
+    }
+  }
+
+  async function watch() {
+    while (!controller.signal.aborted && loggedIn()) {
+      try {
+        await read();
+      } catch {
+        // Reconnect below unless stopped.
+      }
+      await new Promise((resolve) => setTimeout(resolve, 3000));
+    }
+  }
+
+  watch();
+  return () => controller.abort();
+}
+
+// joinList connects to a list's WebSocket, calling onMessage with each
+// message from the server. It returns a function that sends a message, such
+// as { type: "editing", task_id: 12 }. The token is sent as a subprotocol
+// since WebSockets can't be given an Authorization header.
+export function joinList(listId, onMessage) {
+  const url = server.replace(/^http/, "ws") + `/lists/${listId}/socket`;
+  const socket = new WebSocket(url, ["todo", "bearer." + localStorage.getItem("token")]);
+  socket.onmessage = (event) => onMessage(JSON.parse(event.data));
+  const send = (message) => {
+    if (socket.readyState === WebSocket.OPEN) {
+      socket.send(JSON.stringify(message));
+    }
+  };
+  send.close = () => socket.close();
+  return send;
+}